@@ -0,0 +1,433 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Rachatapon1994/assessment-tax/db"
+	"github.com/labstack/echo/v4"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	MIMEAPPLICATIONYAML = "application/yaml"
+	TAXLEVELSSECTION    = "taxLevels"
+	ALLOWANCESSECTION   = "allowances"
+)
+
+var errDryRun = errors.New("dry run")
+
+type (
+	ConfigDocument struct {
+		TaxLevels  []ConfigTaxLevel  `json:"taxLevels" yaml:"taxLevels" validate:"required,min=1,dive"`
+		Allowances []ConfigAllowance `json:"allowances" yaml:"allowances" validate:"required,min=1,dive"`
+	}
+
+	ConfigTaxLevel struct {
+		Name        string   `json:"name" yaml:"name" validate:"required"`
+		StartAmount float64  `json:"startAmount" yaml:"startAmount" validate:"gte=0"`
+		EndAmount   *float64 `json:"endAmount" yaml:"endAmount"`
+		Percentage  float64  `json:"percentage" yaml:"percentage" validate:"gte=0,lte=100"`
+	}
+
+	ConfigAllowance struct {
+		AllowanceType string  `json:"allowanceType" yaml:"allowanceType" validate:"required"`
+		Amount        float64 `json:"amount" yaml:"amount" validate:"gte=0"`
+		MinAmount     float64 `json:"minAmount" yaml:"minAmount" validate:"gte=0"`
+		MaxAmount     float64 `json:"maxAmount" yaml:"maxAmount" validate:"gtefield=MinAmount"`
+		MinExclusive  bool    `json:"minExclusive" yaml:"minExclusive"`
+		Version       int     `json:"version" yaml:"version" validate:"gte=0"`
+	}
+)
+
+type ConfigChange struct {
+	Section  string      `json:"section"`
+	Key      string      `json:"key"`
+	Field    string      `json:"field"`
+	Current  interface{} `json:"current"`
+	Proposed interface{} `json:"proposed"`
+}
+
+type ConfigImportResult struct {
+	DryRun        bool                    `json:"dryRun"`
+	Changes       []ConfigChange          `json:"changes"`
+	ImportRequest *db.ConfigImportRequest `json:"importRequest,omitempty"`
+}
+
+func isYaml(contentType string) bool {
+	return strings.Contains(contentType, "yaml")
+}
+
+func loadConfigDocument(ctx context.Context, repository db.AllowanceRepository) (ConfigDocument, error) {
+	taxLevels, err := repository.SearchAllTaxLevel(ctx)
+	if err != nil {
+		return ConfigDocument{}, err
+	}
+	limits, err := repository.SearchAllAllowanceLimit(ctx)
+	if err != nil {
+		return ConfigDocument{}, err
+	}
+	allowances, err := repository.SearchAllAllowance(ctx)
+	if err != nil {
+		return ConfigDocument{}, err
+	}
+	limitByType := make(map[string]db.AllowanceLimit)
+	for _, limit := range limits {
+		limitByType[limit.AllowanceType] = limit
+	}
+
+	document := ConfigDocument{TaxLevels: make([]ConfigTaxLevel, 0), Allowances: make([]ConfigAllowance, 0)}
+	for _, taxLevel := range taxLevels {
+		document.TaxLevels = append(document.TaxLevels, ConfigTaxLevel{Name: taxLevel.Name, StartAmount: taxLevel.StartAmount, EndAmount: taxLevel.EndAmount, Percentage: taxLevel.Percentage})
+	}
+	for _, allowance := range allowances {
+		limit := limitByType[allowance.AllowanceType]
+		document.Allowances = append(document.Allowances, ConfigAllowance{
+			AllowanceType: allowance.AllowanceType,
+			Amount:        allowance.Amount,
+			MinAmount:     limit.MinAmount,
+			MaxAmount:     limit.MaxAmount,
+			MinExclusive:  limit.MinExclusive,
+			Version:       allowance.Version,
+		})
+	}
+	return document, nil
+}
+
+func validateConfigDocument(document ConfigDocument) error {
+	names := make(map[string]bool)
+	for i, taxLevel := range document.TaxLevels {
+		if names[taxLevel.Name] {
+			return fmt.Errorf("Tax level %v is duplicated", taxLevel.Name)
+		}
+		names[taxLevel.Name] = true
+		if i == 0 && taxLevel.StartAmount != 0 {
+			return fmt.Errorf("Tax level %v must start at 0", taxLevel.Name)
+		}
+		if i > 0 {
+			previous := document.TaxLevels[i-1]
+			if previous.EndAmount == nil || taxLevel.StartAmount != *previous.EndAmount+1 {
+				return fmt.Errorf("Tax level %v must start right after tax level %v", taxLevel.Name, previous.Name)
+			}
+		}
+		if taxLevel.EndAmount == nil && i != len(document.TaxLevels)-1 {
+			return errors.New("Only the last tax level can be unbounded")
+		}
+		if taxLevel.EndAmount != nil && *taxLevel.EndAmount < taxLevel.StartAmount {
+			return fmt.Errorf("Tax level %v must end after it starts", taxLevel.Name)
+		}
+	}
+	if document.TaxLevels[len(document.TaxLevels)-1].EndAmount != nil {
+		return errors.New("The last tax level must be unbounded")
+	}
+
+	allowanceTypes := make(map[string]bool)
+	for _, allowance := range document.Allowances {
+		if allowanceTypes[allowance.AllowanceType] {
+			return fmt.Errorf("Allowance type %v is duplicated", allowance.AllowanceType)
+		}
+		allowanceTypes[allowance.AllowanceType] = true
+		limit := db.AllowanceLimit{MinAmount: allowance.MinAmount, MaxAmount: allowance.MaxAmount, MinExclusive: allowance.MinExclusive}
+		if !limit.Allows(allowance.Amount) {
+			return fmt.Errorf("Amount of allowance type %v is outside its limit", allowance.AllowanceType)
+		}
+	}
+	if !allowanceTypes[PERSONAL] {
+		return fmt.Errorf("Allowance type %v is required", PERSONAL)
+	}
+	return nil
+}
+
+func formatAmount(amount *float64) interface{} {
+	if amount == nil {
+		return nil
+	}
+	return *amount
+}
+
+func diffConfigDocument(current ConfigDocument, proposed ConfigDocument) []ConfigChange {
+	changes := make([]ConfigChange, 0)
+	currentTaxLevels := make(map[string]ConfigTaxLevel)
+	for _, taxLevel := range current.TaxLevels {
+		currentTaxLevels[taxLevel.Name] = taxLevel
+	}
+	proposedTaxLevels := make(map[string]bool)
+	for _, taxLevel := range proposed.TaxLevels {
+		proposedTaxLevels[taxLevel.Name] = true
+		existing, found := currentTaxLevels[taxLevel.Name]
+		if !found {
+			changes = append(changes, ConfigChange{Section: TAXLEVELSSECTION, Key: taxLevel.Name, Field: "*", Current: nil, Proposed: taxLevel})
+			continue
+		}
+		if existing.StartAmount != taxLevel.StartAmount {
+			changes = append(changes, ConfigChange{TAXLEVELSSECTION, taxLevel.Name, "startAmount", existing.StartAmount, taxLevel.StartAmount})
+		}
+		if formatAmount(existing.EndAmount) != formatAmount(taxLevel.EndAmount) {
+			changes = append(changes, ConfigChange{TAXLEVELSSECTION, taxLevel.Name, "endAmount", formatAmount(existing.EndAmount), formatAmount(taxLevel.EndAmount)})
+		}
+		if existing.Percentage != taxLevel.Percentage {
+			changes = append(changes, ConfigChange{TAXLEVELSSECTION, taxLevel.Name, "percentage", existing.Percentage, taxLevel.Percentage})
+		}
+	}
+	for _, taxLevel := range current.TaxLevels {
+		if !proposedTaxLevels[taxLevel.Name] {
+			changes = append(changes, ConfigChange{Section: TAXLEVELSSECTION, Key: taxLevel.Name, Field: "*", Current: taxLevel, Proposed: nil})
+		}
+	}
+
+	currentAllowances := make(map[string]ConfigAllowance)
+	for _, allowance := range current.Allowances {
+		currentAllowances[allowance.AllowanceType] = allowance
+	}
+	proposedAllowances := make(map[string]bool)
+	for _, allowance := range proposed.Allowances {
+		proposedAllowances[allowance.AllowanceType] = true
+		existing, found := currentAllowances[allowance.AllowanceType]
+		if !found {
+			changes = append(changes, ConfigChange{Section: ALLOWANCESSECTION, Key: allowance.AllowanceType, Field: "*", Current: nil, Proposed: allowance})
+			continue
+		}
+		if existing.Amount != allowance.Amount {
+			changes = append(changes, ConfigChange{ALLOWANCESSECTION, allowance.AllowanceType, "amount", existing.Amount, allowance.Amount})
+		}
+		if existing.MinAmount != allowance.MinAmount {
+			changes = append(changes, ConfigChange{ALLOWANCESSECTION, allowance.AllowanceType, "minAmount", existing.MinAmount, allowance.MinAmount})
+		}
+		if existing.MaxAmount != allowance.MaxAmount {
+			changes = append(changes, ConfigChange{ALLOWANCESSECTION, allowance.AllowanceType, "maxAmount", existing.MaxAmount, allowance.MaxAmount})
+		}
+		if existing.MinExclusive != allowance.MinExclusive {
+			changes = append(changes, ConfigChange{ALLOWANCESSECTION, allowance.AllowanceType, "minExclusive", existing.MinExclusive, allowance.MinExclusive})
+		}
+	}
+	for _, allowance := range current.Allowances {
+		if !proposedAllowances[allowance.AllowanceType] {
+			changes = append(changes, ConfigChange{Section: ALLOWANCESSECTION, Key: allowance.AllowanceType, Field: "*", Current: allowance, Proposed: nil})
+		}
+	}
+	return changes
+}
+
+func checkConfigVersions(current ConfigDocument, proposed ConfigDocument) error {
+	currentAllowances := make(map[string]ConfigAllowance)
+	for _, allowance := range current.Allowances {
+		currentAllowances[allowance.AllowanceType] = allowance
+	}
+	for _, allowance := range proposed.Allowances {
+		existing, found := currentAllowances[allowance.AllowanceType]
+		if !found {
+			continue
+		}
+		if allowance.Version == 0 {
+			return &statusErr{http.StatusPreconditionRequired, fmt.Sprintf("Version of allowance type %v is required", allowance.AllowanceType)}
+		}
+		if allowance.Version != existing.Version {
+			return &statusErr{http.StatusConflict, fmt.Sprintf("Allowance type %v has been modified, current version is %v", allowance.AllowanceType, existing.Version)}
+		}
+	}
+	return nil
+}
+
+func applyConfigDocument(ctx context.Context, repository db.AllowanceRepository, document ConfigDocument) error {
+	taxLevels := make([]db.TaxLevel, 0, len(document.TaxLevels))
+	for _, taxLevel := range document.TaxLevels {
+		taxLevels = append(taxLevels, db.TaxLevel{Name: taxLevel.Name, StartAmount: taxLevel.StartAmount, EndAmount: taxLevel.EndAmount, Percentage: taxLevel.Percentage})
+	}
+	if err := repository.ReplaceTaxLevels(ctx, taxLevels); err != nil {
+		return err
+	}
+	for _, allowance := range document.Allowances {
+		limit := db.AllowanceLimit{AllowanceType: allowance.AllowanceType, MinAmount: allowance.MinAmount, MaxAmount: allowance.MaxAmount, MinExclusive: allowance.MinExclusive}
+		if err := repository.UpsertAllowance(ctx, db.Allowance{AllowanceType: allowance.AllowanceType, Amount: allowance.Amount}, limit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func bindConfigDocument(c echo.Context, document *ConfigDocument) error {
+	if !isYaml(c.Request().Header.Get(echo.HeaderContentType)) {
+		if err := c.Bind(document); err != nil {
+			return &Err{Message: "Error when binding JSON"}
+		}
+		return nil
+	}
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return &Err{Message: "Error when reading YAML"}
+	}
+	if err := yaml.Unmarshal(body, document); err != nil {
+		return &Err{Message: "Error when binding YAML"}
+	}
+	return nil
+}
+
+func (h *Handler) ConfigExportHandler(c echo.Context) error {
+	document, err := loadConfigDocument(c.Request().Context(), h.Allowances)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+	}
+	if c.QueryParam("format") == "yaml" || isYaml(c.Request().Header.Get(echo.HeaderAccept)) {
+		body, err := yaml.Marshal(document)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+		}
+		return c.Blob(http.StatusOK, MIMEAPPLICATIONYAML, body)
+	}
+	return c.JSON(http.StatusOK, document)
+}
+
+func (h *Handler) ConfigImportHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dryRun"))
+	document := ConfigDocument{}
+	if err := bindConfigDocument(c, &document); err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+	}
+	if err := c.Validate(document); err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: "Validation fields does not pass"})
+	}
+	if err := validateConfigDocument(document); err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+	}
+
+	result := ConfigImportResult{DryRun: dryRun}
+	err := h.Allowances.Transaction(ctx, func(repository db.AllowanceRepository) error {
+		current, err := loadConfigDocument(ctx, repository)
+		if err != nil {
+			return err
+		}
+		if err := checkConfigVersions(current, document); err != nil {
+			return err
+		}
+		result.Changes = diffConfigDocument(current, document)
+		if dryRun {
+			return errDryRun
+		}
+		for _, change := range result.Changes {
+			if change.Section == ALLOWANCESSECTION && change.Proposed == nil {
+				return &statusErr{http.StatusBadRequest, fmt.Sprintf("Allowance type %v cannot be removed by import", change.Key)}
+			}
+		}
+		if len(result.Changes) == 0 {
+			return nil
+		}
+		body, err := json.Marshal(document)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		result.ImportRequest = &db.ConfigImportRequest{
+			Document:    body,
+			Status:      db.PENDING,
+			RequestedBy: getRequester(c),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(h.changeRequestTTL()),
+		}
+		return repository.InsertConfigImportRequest(ctx, result.ImportRequest)
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return writeStatusErr(c, err)
+	}
+	if result.ImportRequest != nil {
+		return c.JSON(http.StatusAccepted, result)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func (h *Handler) ConfigImportRequestListHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	if err := h.Allowances.ExpireConfigImportRequests(ctx, time.Now()); err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+	}
+	importRequests, err := h.Allowances.SearchConfigImportRequestByStatus(ctx, db.PENDING)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+	}
+	return c.JSON(http.StatusOK, importRequests)
+}
+
+func (h *Handler) ApproveConfigImportRequestHandler(c echo.Context) error {
+	return h.reviewConfigImportRequest(c, db.APPROVED)
+}
+
+func (h *Handler) RejectConfigImportRequestHandler(c echo.Context) error {
+	return h.reviewConfigImportRequest(c, db.REJECTED)
+}
+
+func (h *Handler) CancelConfigImportRequestHandler(c echo.Context) error {
+	return h.reviewConfigImportRequest(c, db.CANCELLED)
+}
+
+func (h *Handler) reviewConfigImportRequest(c echo.Context, status string) error {
+	ctx := c.Request().Context()
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: "Config import request id must be a number"})
+	}
+
+	importRequest := db.ConfigImportRequest{}
+	err = h.Allowances.Transaction(ctx, func(repository db.AllowanceRepository) error {
+		var err error
+		importRequest, err = repository.SearchConfigImportRequestById(ctx, id)
+		if errors.Is(err, db.ErrNotFound) {
+			return &statusErr{http.StatusNotFound, "Config import request not found"}
+		}
+		if err != nil {
+			return err
+		}
+		if importRequest.Status != db.PENDING {
+			return &statusErr{http.StatusConflict, "Config import request is already " + importRequest.Status}
+		}
+
+		now := time.Now()
+		importRequest.ReviewedBy = getRequester(c)
+		importRequest.ReviewedAt = &now
+		if now.After(importRequest.ExpiresAt) {
+			importRequest.Status = db.EXPIRED
+			return repository.UpdateConfigImportRequestStatus(ctx, &importRequest)
+		}
+		if err := checkReviewer(status, importRequest.RequestedBy, importRequest.ReviewedBy, "Config import request"); err != nil {
+			return err
+		}
+
+		importRequest.Status = status
+		if status == db.APPROVED {
+			document := ConfigDocument{}
+			if err := json.Unmarshal(importRequest.Document, &document); err != nil {
+				return err
+			}
+			current, err := loadConfigDocument(ctx, repository)
+			if err != nil {
+				return err
+			}
+			if checkConfigVersions(current, document) != nil {
+				importRequest.Status = db.SUPERSEDED
+			} else if err := applyConfigDocument(ctx, repository, document); err != nil {
+				return err
+			}
+		}
+		if err := repository.UpdateConfigImportRequestStatus(ctx, &importRequest); errors.Is(err, db.ErrChangeRequestNotPending) {
+			return &statusErr{http.StatusConflict, "Config import request is no longer pending"}
+		} else if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return writeStatusErr(c, err)
+	}
+	if importRequest.Status == db.EXPIRED {
+		return c.JSON(http.StatusConflict, Err{Message: "Config import request has expired"})
+	}
+	if importRequest.Status == db.SUPERSEDED {
+		return c.JSON(http.StatusConflict, Err{Message: "Allowance settings have been modified since the config import request was created"})
+	}
+	return c.JSON(http.StatusOK, importRequest)
+}
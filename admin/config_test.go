@@ -0,0 +1,348 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/Rachatapon1994/assessment-tax/db"
+	"gopkg.in/yaml.v3"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mockConfigDocument() ConfigDocument {
+	endAmounts := []float64{150000, 500000}
+	return ConfigDocument{
+		TaxLevels: []ConfigTaxLevel{
+			{Name: "0-150,000", StartAmount: 0, EndAmount: &endAmounts[0], Percentage: 0},
+			{Name: "150,001-500,000", StartAmount: 150001, EndAmount: &endAmounts[1], Percentage: 10},
+			{Name: "500,001 ขึ้นไป", StartAmount: 500001, EndAmount: nil, Percentage: 20},
+		},
+		Allowances: []ConfigAllowance{
+			{AllowanceType: PERSONAL, Amount: 60000, MinAmount: 10000, MaxAmount: 100000, Version: 1},
+			{AllowanceType: DONATION, Amount: 100000, MinAmount: 0, MaxAmount: 100000, MinExclusive: true, Version: 1},
+		},
+	}
+}
+
+func mockCurrentConfigDocument() ConfigDocument {
+	document := mockConfigDocument()
+	document.Allowances = append(document.Allowances,
+		ConfigAllowance{AllowanceType: KRECEIPT, Amount: 50000, MinAmount: 0, MaxAmount: 100000, MinExclusive: true, Version: 1},
+		ConfigAllowance{AllowanceType: "spouse", Amount: 60000, MinAmount: 0, MaxAmount: 100000, Version: 1},
+		ConfigAllowance{AllowanceType: "child", Amount: 30000, MinAmount: 0, MaxAmount: 100000, Version: 1},
+		ConfigAllowance{AllowanceType: "parent", Amount: 30000, MinAmount: 0, MaxAmount: 100000, Version: 1},
+		ConfigAllowance{AllowanceType: "disability", Amount: 60000, MinAmount: 0, MaxAmount: 200000, Version: 1},
+	)
+	return document
+}
+
+func mockConfigAllowances(t *testing.T) *db.MemoryAllowanceRepository {
+	return mockAllowances(t, func(ctx context.Context, repository db.AllowanceRepository) error {
+		return applyConfigDocument(ctx, repository, mockConfigDocument())
+	})
+}
+
+func Test_validateConfigDocument(t *testing.T) {
+	t.Parallel()
+	valid := mockConfigDocument()
+
+	gap := mockConfigDocument()
+	gap.TaxLevels[1].StartAmount = 160000
+
+	bounded := mockConfigDocument()
+	lastEnd := 1000000.0
+	bounded.TaxLevels[2].EndAmount = &lastEnd
+
+	missingPersonal := mockConfigDocument()
+	missingPersonal.Allowances = missingPersonal.Allowances[1:]
+
+	outsideLimit := mockConfigDocument()
+	outsideLimit.Allowances[0].Amount = 5000
+
+	tests := []struct {
+		name     string
+		document ConfigDocument
+		wantErr  string
+	}{
+		{"Should pass when document is consistent", valid, ""},
+		{"Should fail when tax levels are not contiguous", gap, "Tax level 150,001-500,000 must start right after tax level 0-150,000"},
+		{"Should fail when last tax level is bounded", bounded, "The last tax level must be unbounded"},
+		{"Should fail when personal allowance is missing", missingPersonal, "Allowance type personal is required"},
+		{"Should fail when allowance amount is outside its limit", outsideLimit, "Amount of allowance type personal is outside its limit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateConfigDocument(tt.document)
+			if (err == nil) != (tt.wantErr == "") || (err != nil && err.Error() != tt.wantErr) {
+				t.Errorf("validateConfigDocument() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_diffConfigDocument(t *testing.T) {
+	t.Parallel()
+	proposed := mockConfigDocument()
+	proposed.TaxLevels[2].Percentage = 25
+	proposed.Allowances[0].Amount = 70000
+	proposed.Allowances = append(proposed.Allowances, ConfigAllowance{AllowanceType: KRECEIPT, Amount: 50000, MinAmount: 0, MaxAmount: 100000, MinExclusive: true})
+
+	want := []ConfigChange{
+		{TAXLEVELSSECTION, "500,001 ขึ้นไป", "percentage", 20.0, 25.0},
+		{ALLOWANCESSECTION, PERSONAL, "amount", 60000.0, 70000.0},
+		{ALLOWANCESSECTION, KRECEIPT, "*", nil, proposed.Allowances[2]},
+	}
+	if got := diffConfigDocument(mockConfigDocument(), proposed); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffConfigDocument() = %v, want %v", got, want)
+	}
+	removed := mockConfigDocument()
+	removed.Allowances = removed.Allowances[:1]
+	wantRemoved := []ConfigChange{{ALLOWANCESSECTION, DONATION, "*", mockConfigDocument().Allowances[1], nil}}
+	if got := diffConfigDocument(mockConfigDocument(), removed); !reflect.DeepEqual(got, wantRemoved) {
+		t.Errorf("diffConfigDocument() = %v, want %v", got, wantRemoved)
+	}
+	if got := diffConfigDocument(mockConfigDocument(), mockConfigDocument()); len(got) != 0 {
+		t.Errorf("diffConfigDocument() = %v, want no changes", got)
+	}
+}
+
+func TestHandler_ConfigExportHandler(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		accept string
+	}{
+		{"Should export configuration as JSON by default", ""},
+		{"Should export configuration as YAML when requested by Accept header", MIMEAPPLICATIONYAML},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := mockAdminContext(http.MethodGet, "/admin/config/export", "admin", "")
+			c.c.Request().Header.Set("Accept", tt.accept)
+			h := &Handler{Allowances: mockConfigAllowances(t)}
+
+			if err := h.ConfigExportHandler(c.c); err != nil {
+				t.Errorf("Handler.ConfigExportHandler() error = %v", err)
+			}
+
+			result := ConfigDocument{}
+			var err error
+			if tt.accept == MIMEAPPLICATIONYAML {
+				err = yaml.Unmarshal(c.r.Body.Bytes(), &result)
+			} else {
+				err = json.Unmarshal(c.r.Body.Bytes(), &result)
+			}
+			if err != nil {
+				t.Errorf("unable to unmarshal document: %v", err)
+			}
+			if !reflect.DeepEqual(result, mockCurrentConfigDocument()) {
+				t.Errorf("expected (%v), got (%v)", mockCurrentConfigDocument(), result)
+			}
+			if c.r.Code != http.StatusOK {
+				t.Errorf("expected (%v), got (%v)", http.StatusOK, c.r.Code)
+			}
+		})
+	}
+}
+
+func TestHandler_ConfigImportHandler(t *testing.T) {
+	t.Parallel()
+	proposed := mockCurrentConfigDocument()
+	proposed.Allowances[0].Amount = 70000
+	body, _ := json.Marshal(proposed)
+	yamlBody, _ := yaml.Marshal(proposed)
+	unchangedBody, _ := json.Marshal(mockCurrentConfigDocument())
+	wantChanges := []ConfigChange{{ALLOWANCESSECTION, PERSONAL, "amount", 60000.0, 70000.0}}
+	wantImportRequest := &db.ConfigImportRequest{Id: 1, Document: body, Status: db.PENDING, RequestedBy: "admin"}
+	stale := mockCurrentConfigDocument()
+	stale.Allowances[1].Version = 2
+	staleBody, _ := json.Marshal(stale)
+	unversioned := mockCurrentConfigDocument()
+	unversioned.Allowances[0].Version = 0
+	unversionedBody, _ := json.Marshal(unversioned)
+	removed := mockCurrentConfigDocument()
+	removed.Allowances = slices.Delete(removed.Allowances, 1, 2)
+	removedBody, _ := json.Marshal(removed)
+
+	tests := []struct {
+		name               string
+		query              string
+		contentType        string
+		body               string
+		allowances         db.AllowanceRepository
+		wantResponseBody   interface{}
+		wantResponseStatus int
+	}{
+		{"Should return diff without requesting import when dry run is requested", "?dryRun=true", "application/json", string(body), mockConfigAllowances(t), ConfigImportResult{DryRun: true, Changes: wantChanges}, 200},
+		{"Should create pending import request for JSON document", "", "application/json", string(body), mockConfigAllowances(t), ConfigImportResult{DryRun: false, Changes: wantChanges, ImportRequest: wantImportRequest}, 202},
+		{"Should create pending import request for YAML document", "", MIMEAPPLICATIONYAML, string(yamlBody), mockConfigAllowances(t), ConfigImportResult{DryRun: false, Changes: wantChanges, ImportRequest: wantImportRequest}, 202},
+		{"Should not create import request when document has no changes", "", "application/json", string(unchangedBody), mockConfigAllowances(t), ConfigImportResult{DryRun: false, Changes: []ConfigChange{}}, 200},
+		{"Should roll back when import request cannot be created", "", "application/json", string(body), &failingAllowanceRepository{mockConfigAllowances(t), "InsertConfigImportRequest", sql.ErrConnDone}, Err{Message: sql.ErrConnDone.Error()}, 500},
+		{"Should return status 409 when allowance version is stale", "", "application/json", string(staleBody), mockConfigAllowances(t), Err{Message: "Allowance type donation has been modified, current version is 1"}, 409},
+		{"Should return status 428 when allowance version is missing", "", "application/json", string(unversionedBody), mockConfigAllowances(t), Err{Message: "Version of allowance type personal is required"}, 428},
+		{"Should report removed allowance on dry run", "?dryRun=true", "application/json", string(removedBody), mockConfigAllowances(t), ConfigImportResult{DryRun: true, Changes: []ConfigChange{{ALLOWANCESSECTION, DONATION, "*", map[string]interface{}{"allowanceType": DONATION, "amount": 100000.0, "minAmount": 0.0, "maxAmount": 100000.0, "minExclusive": true, "version": 1.0}, nil}}}, 200},
+		{"Should return status 400 when document removes an allowance", "", "application/json", string(removedBody), mockConfigAllowances(t), Err{Message: "Allowance type donation cannot be removed by import"}, 400},
+		{"Should return status 400 when document is inconsistent", "", "application/json", strings.Replace(string(body), `"startAmount":150001`, `"startAmount":150002`, 1), mockConfigAllowances(t), Err{Message: "Tax level 150,001-500,000 must start right after tax level 0-150,000"}, 400},
+		{"Should return status 400 when document has no tax levels", "", "application/json", `{"taxLevels": [], "allowances": []}`, mockConfigAllowances(t), Err{Message: "Validation fields does not pass"}, 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := mockAdminContext(http.MethodPost, "/admin/config/import"+tt.query, "admin", tt.body)
+			c.c.Request().Header.Set("Content-Type", tt.contentType)
+			h := &Handler{Allowances: tt.allowances}
+
+			if err := h.ConfigImportHandler(c.c); err != nil {
+				t.Errorf("Handler.ConfigImportHandler() error = %v", err)
+			}
+
+			var result interface{}
+			if tt.wantResponseStatus < 300 {
+				importResult := ConfigImportResult{}
+				if err := json.Unmarshal(c.r.Body.Bytes(), &importResult); err != nil {
+					t.Errorf("unable to unmarshal json: %v", err)
+				}
+				if importRequest := importResult.ImportRequest; importRequest != nil {
+					if importRequest.ExpiresAt.Sub(importRequest.CreatedAt) != DEFAULTCHANGEREQUESTTTL {
+						t.Errorf("expected import request to expire after %v, got (%v)", DEFAULTCHANGEREQUESTTTL, importRequest)
+					}
+					importRequest.CreatedAt = time.Time{}
+					importRequest.ExpiresAt = time.Time{}
+				}
+				result = importResult
+			} else {
+				errResult := Err{}
+				if err := json.Unmarshal(c.r.Body.Bytes(), &errResult); err != nil {
+					t.Errorf("unable to unmarshal json: %v", err)
+				}
+				result = errResult
+			}
+
+			if !reflect.DeepEqual(result, tt.wantResponseBody) {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseBody, result)
+			}
+
+			if c.r.Code != tt.wantResponseStatus {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseStatus, c.r.Code)
+			}
+			if current, _ := loadConfigDocument(context.Background(), tt.allowances); !reflect.DeepEqual(current, mockCurrentConfigDocument()) {
+				t.Errorf("expected configuration to be unchanged by import, got (%v)", current)
+			}
+		})
+	}
+}
+
+func mockConfigImportRequest(t *testing.T, h *Handler, document ConfigDocument) string {
+	body, _ := json.Marshal(document)
+	c := mockAdminContext(http.MethodPost, "/admin/config/import", "admin", string(body))
+	c.c.Request().Header.Set("Content-Type", "application/json")
+	if err := h.ConfigImportHandler(c.c); err != nil || c.r.Code != http.StatusAccepted {
+		t.Fatalf("Handler.ConfigImportHandler() status = %v, error = %v", c.r.Code, err)
+	}
+	result := ConfigImportResult{}
+	if err := json.Unmarshal(c.r.Body.Bytes(), &result); err != nil {
+		t.Fatalf("unable to unmarshal json: %v", err)
+	}
+	return strconv.Itoa(result.ImportRequest.Id)
+}
+
+func TestHandler_reviewConfigImportRequest(t *testing.T) {
+	t.Parallel()
+	proposed := mockConfigDocument()
+	proposed.TaxLevels[1].Percentage = 15
+	proposed.Allowances[0].Amount = 70000
+	proposed.Allowances[0].MaxAmount = 90000
+
+	t.Run("Should apply tax levels, limits and amounts only after another admin approves", func(t *testing.T) {
+		repository := db.NewMemoryAllowanceRepository()
+		h := &Handler{Allowances: repository}
+		current, _ := loadConfigDocument(context.Background(), repository)
+		document := current
+		document.TaxLevels = proposed.TaxLevels
+		document.Allowances = append([]ConfigAllowance{}, current.Allowances...)
+		document.Allowances[0].Amount, document.Allowances[0].MaxAmount = 70000, 90000
+		id := mockConfigImportRequest(t, h, document)
+
+		if pending, _ := loadConfigDocument(context.Background(), repository); !reflect.DeepEqual(pending, current) {
+			t.Fatalf("expected configuration to be unchanged before approval, got (%v)", pending)
+		}
+
+		own := mockReviewChangeRequestContext(id, "admin")
+		if err := h.ApproveConfigImportRequestHandler(own.c); err != nil || own.r.Code != http.StatusForbidden {
+			t.Fatalf("Handler.ApproveConfigImportRequestHandler() status = %v, error = %v", own.r.Code, err)
+		}
+
+		approve := mockReviewChangeRequestContext(id, "approver")
+		if err := h.ApproveConfigImportRequestHandler(approve.c); err != nil || approve.r.Code != http.StatusOK {
+			t.Fatalf("Handler.ApproveConfigImportRequestHandler() status = %v, error = %v", approve.r.Code, err)
+		}
+		applied, _ := loadConfigDocument(context.Background(), repository)
+		if !reflect.DeepEqual(applied.TaxLevels, proposed.TaxLevels) {
+			t.Errorf("expected tax levels (%v), got (%v)", proposed.TaxLevels, applied.TaxLevels)
+		}
+		if personal := applied.Allowances[0]; personal.Amount != 70000 || personal.MaxAmount != 90000 || personal.Version != 2 {
+			t.Errorf("expected personal allowance 70000 up to 90000 at version 2, got (%v)", personal)
+		}
+	})
+
+	t.Run("Should supersede import request when allowance was modified before approval", func(t *testing.T) {
+		repository := db.NewMemoryAllowanceRepository()
+		h := &Handler{Allowances: repository}
+		document, _ := loadConfigDocument(context.Background(), repository)
+		document.TaxLevels = proposed.TaxLevels
+		id := mockConfigImportRequest(t, h, document)
+		if err := repository.UpdateAllowanceAmount(context.Background(), PERSONAL, 70000, 1); err != nil {
+			t.Fatalf("MemoryAllowanceRepository.UpdateAllowanceAmount() error = %v", err)
+		}
+
+		approve := mockReviewChangeRequestContext(id, "approver")
+		if err := h.ApproveConfigImportRequestHandler(approve.c); err != nil || approve.r.Code != http.StatusConflict {
+			t.Fatalf("Handler.ApproveConfigImportRequestHandler() status = %v, error = %v", approve.r.Code, err)
+		}
+		importRequest, _ := repository.SearchConfigImportRequestById(context.Background(), 1)
+		if importRequest.Status != db.SUPERSEDED {
+			t.Errorf("expected import request to be %v, got (%v)", db.SUPERSEDED, importRequest.Status)
+		}
+		if applied, _ := loadConfigDocument(context.Background(), repository); reflect.DeepEqual(applied.TaxLevels, proposed.TaxLevels) {
+			t.Errorf("expected tax levels to be unchanged, got (%v)", applied.TaxLevels)
+		}
+	})
+
+	t.Run("Should let only the requester cancel import request", func(t *testing.T) {
+		repository := db.NewMemoryAllowanceRepository()
+		h := &Handler{Allowances: repository}
+		document, _ := loadConfigDocument(context.Background(), repository)
+		document.TaxLevels = proposed.TaxLevels
+		id := mockConfigImportRequest(t, h, document)
+
+		other := mockReviewChangeRequestContext(id, "approver")
+		if err := h.CancelConfigImportRequestHandler(other.c); err != nil || other.r.Code != http.StatusForbidden {
+			t.Fatalf("Handler.CancelConfigImportRequestHandler() status = %v, error = %v", other.r.Code, err)
+		}
+		cancel := mockReviewChangeRequestContext(id, "admin")
+		if err := h.CancelConfigImportRequestHandler(cancel.c); err != nil || cancel.r.Code != http.StatusOK {
+			t.Fatalf("Handler.CancelConfigImportRequestHandler() status = %v, error = %v", cancel.r.Code, err)
+		}
+
+		list := mockAdminContext(http.MethodGet, "/admin/config/import/requests", "admin", "")
+		if err := h.ConfigImportRequestListHandler(list.c); err != nil {
+			t.Fatalf("Handler.ConfigImportRequestListHandler() error = %v", err)
+		}
+		if got := strings.TrimSpace(list.r.Body.String()); got != "[]" {
+			t.Errorf("expected no pending import requests, got (%v)", got)
+		}
+	})
+
+	t.Run("Should return status 404 when import request does not exist", func(t *testing.T) {
+		h := &Handler{Allowances: db.NewMemoryAllowanceRepository()}
+		reject := mockReviewChangeRequestContext("99", "approver")
+		if err := h.RejectConfigImportRequestHandler(reject.c); err != nil || reject.r.Code != http.StatusNotFound {
+			t.Errorf("Handler.RejectConfigImportRequestHandler() status = %v, error = %v", reject.r.Code, err)
+		}
+	})
+}
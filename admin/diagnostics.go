@@ -0,0 +1,32 @@
+package admin
+
+import (
+	"github.com/Rachatapon1994/assessment-tax/db"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+var (
+	UP   = "up"
+	DOWN = "down"
+)
+
+type Diagnostics struct {
+	Storage string        `json:"storage"`
+	Status  string        `json:"status"`
+	Error   string        `json:"error,omitempty"`
+	Pool    *db.PoolStats `json:"pool,omitempty"`
+}
+
+func (h *Handler) DiagnosticsHandler(c echo.Context) error {
+	if h.DB == nil {
+		return c.JSON(http.StatusOK, Diagnostics{Storage: db.MEMORY.Name, Status: UP})
+	}
+	stats := db.NewPoolStats(h.DB.Stats())
+	diagnostics := Diagnostics{Storage: h.Dialect.Name, Status: UP, Pool: &stats}
+	if err := db.Ping(c.Request().Context(), h.DB); err != nil {
+		diagnostics.Status, diagnostics.Error = DOWN, err.Error()
+		return c.JSON(http.StatusServiceUnavailable, diagnostics)
+	}
+	return c.JSON(http.StatusOK, diagnostics)
+}
@@ -0,0 +1,62 @@
+package admin
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Rachatapon1994/assessment-tax/db"
+	"net/http"
+	"testing"
+)
+
+func TestHandler_DiagnosticsHandler(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name               string
+		setup              func(mock sqlmock.Sqlmock)
+		memory             bool
+		wantStatus         string
+		wantError          string
+		wantPool           bool
+		wantResponseStatus int
+	}{
+		{"Should report pool stats when database is reachable", func(mock sqlmock.Sqlmock) {
+			mock.ExpectPing()
+		}, false, UP, "", true, http.StatusOK},
+		{"Should return status 503 when database is unreachable", func(mock sqlmock.Sqlmock) {
+			mock.ExpectPing().WillReturnError(sql.ErrConnDone)
+		}, false, DOWN, sql.ErrConnDone.Error(), true, http.StatusServiceUnavailable},
+		{"Should report memory storage without pool stats", func(mock sqlmock.Sqlmock) {}, true, UP, "", false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DB, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer DB.Close()
+			tt.setup(mock)
+			h := &Handler{DB: DB, Dialect: db.POSTGRES}
+			wantStorage := "postgres"
+			if tt.memory {
+				h.DB, wantStorage = nil, "memory"
+			}
+			c := mockAdminContext(http.MethodGet, "/admin/diagnostics", "admin", "")
+
+			if err := h.DiagnosticsHandler(c.c); err != nil {
+				t.Errorf("Handler.DiagnosticsHandler() error = %v", err)
+			}
+
+			result := Diagnostics{}
+			if err := json.Unmarshal(c.r.Body.Bytes(), &result); err != nil {
+				t.Errorf("unable to unmarshal json: %v", err)
+			}
+			if result.Storage != wantStorage || result.Status != tt.wantStatus || result.Error != tt.wantError || (result.Pool != nil) != tt.wantPool {
+				t.Errorf("expected storage (%v) status (%v) error (%v) pool (%v), got (%v)", wantStorage, tt.wantStatus, tt.wantError, tt.wantPool, result)
+			}
+			if c.r.Code != tt.wantResponseStatus {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseStatus, c.r.Code)
+			}
+		})
+	}
+}
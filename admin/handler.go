@@ -1,21 +1,22 @@
 package admin
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"github.com/Rachatapon1994/assessment-tax/db"
 	"github.com/labstack/echo/v4"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
-type (
-	DeductionPersonal struct {
-		Amount *float64 `json:"amount" validate:"required,numeric,gte=10000,lte=100000"`
-	}
-
-	DeductionKReceipt struct {
-		Amount *float64 `json:"amount" validate:"required,numeric,gt=0,lte=100000"`
-	}
-)
+type Deduction struct {
+	Amount  *float64 `json:"amount" validate:"required,numeric"`
+	Version *int     `json:"version" validate:"omitempty,min=1"`
+}
 
 var (
 	PERSONAL = "personal"
@@ -23,8 +24,13 @@ var (
 	KRECEIPT = "k-receipt"
 )
 
+var DEFAULTCHANGEREQUESTTTL = 24 * time.Hour
+
 type Handler struct {
-	DB *sql.DB
+	DB               *sql.DB
+	Dialect          db.Dialect
+	Allowances       db.AllowanceRepository
+	ChangeRequestTTL time.Duration
 }
 
 type Err struct {
@@ -35,47 +41,283 @@ func (e *Err) Error() string {
 	return e.Message
 }
 
-type PersonalResult struct {
-	PersonalDeduction float64 `json:"personalDeduction"`
-}
-
-type KReceiptResult struct {
-	KReceipt float64 `json:"kReceipt"`
-}
-
 type TaxLevel struct {
 	Level string  `json:"level"`
 	Tax   float64 `json:"tax"`
 }
 
-func validateInput[T DeductionPersonal | DeductionKReceipt](c echo.Context, t *T) error {
-	if err := c.Bind(&t); err != nil {
+type DeductionSetting struct {
+	AllowanceType string  `json:"allowanceType"`
+	Amount        float64 `json:"amount"`
+	MinAmount     float64 `json:"minAmount"`
+	MaxAmount     float64 `json:"maxAmount"`
+	MinExclusive  bool    `json:"minExclusive"`
+	Version       int     `json:"version"`
+}
+
+func validateInput(c echo.Context, d *Deduction) error {
+	if err := c.Bind(&d); err != nil {
 		return &Err{Message: "Error when binding JSON"}
 	}
-	if err := c.Validate(t); err != nil {
+	if err := c.Validate(d); err != nil {
 		return &Err{Message: "Validation fields does not pass"}
 	}
 	return nil
 }
 
-func (h *Handler) DeductionPersonalHandler(c echo.Context) error {
-	dp := DeductionPersonal{}
-	if err := validateInput(c, &dp); err != nil {
-		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+func (h *Handler) changeRequestTTL() time.Duration {
+	if h.ChangeRequestTTL > 0 {
+		return h.ChangeRequestTTL
+	}
+	return DEFAULTCHANGEREQUESTTTL
+}
+
+func getRequester(c echo.Context) string {
+	username, _, _ := c.Request().BasicAuth()
+	return username
+}
+
+func getExpectedVersion(c echo.Context, d Deduction) (int, error) {
+	ifMatch := strings.TrimSpace(c.Request().Header.Get("If-Match"))
+	if ifMatch == "" {
+		if d.Version == nil {
+			return 0, &statusErr{http.StatusPreconditionRequired, "If-Match header or version is required"}
+		}
+		return *d.Version, nil
+	}
+	version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`))
+	if err != nil || version < 1 {
+		return 0, &statusErr{http.StatusBadRequest, "If-Match header must be an allowance version"}
+	}
+	if d.Version != nil && *d.Version != version {
+		return 0, &statusErr{http.StatusBadRequest, "If-Match header does not match version"}
 	}
-	if err := (&db.Allowance{AllowanceType: PERSONAL, Amount: *dp.Amount}).UpdateByType(h.DB); err != nil {
+	return version, nil
+}
+
+func (h *Handler) requestChange(ctx context.Context, changeRequest *db.ChangeRequest) error {
+	return h.Allowances.Transaction(ctx, func(repository db.AllowanceRepository) error {
+		allowance, err := repository.SearchAllowanceByType(ctx, changeRequest.AllowanceType)
+		if errors.Is(err, db.ErrNotFound) {
+			return &statusErr{http.StatusNotFound, fmt.Sprintf("Allowance type %v does not exist", changeRequest.AllowanceType)}
+		}
+		if err != nil {
+			return err
+		}
+		if allowance.Version != changeRequest.AllowanceVersion {
+			return &statusErr{http.StatusConflict, fmt.Sprintf("Allowance type %v has been modified, current version is %v", allowance.AllowanceType, allowance.Version)}
+		}
+		return repository.InsertChangeRequest(ctx, changeRequest)
+	})
+}
+
+func (h *Handler) updateDeduction(c echo.Context, allowanceType string) error {
+	limit, err := h.Allowances.SearchAllowanceLimitByType(c.Request().Context(), allowanceType)
+	if errors.Is(err, db.ErrNotFound) {
+		return c.JSON(http.StatusNotFound, Err{Message: fmt.Sprintf("Allowance type %v does not exist", allowanceType)})
+	}
+	if err != nil {
 		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
 	}
-	return c.JSON(http.StatusOK, PersonalResult{PersonalDeduction: *dp.Amount})
+	d := Deduction{}
+	if err := validateInput(c, &d); err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+	}
+	if !limit.Allows(*d.Amount) {
+		return c.JSON(http.StatusBadRequest, Err{Message: "Validation fields does not pass"})
+	}
+	version, err := getExpectedVersion(c, d)
+	if err != nil {
+		return writeStatusErr(c, err)
+	}
+
+	now := time.Now()
+	changeRequest := &db.ChangeRequest{
+		AllowanceType:    allowanceType,
+		Amount:           *d.Amount,
+		AllowanceVersion: version,
+		Status:           db.PENDING,
+		RequestedBy:      getRequester(c),
+		CreatedAt:        now,
+		ExpiresAt:        now.Add(h.changeRequestTTL()),
+	}
+	if err := h.requestChange(c.Request().Context(), changeRequest); err != nil {
+		return writeStatusErr(c, err)
+	}
+	return c.JSON(http.StatusAccepted, changeRequest)
+}
+
+func (h *Handler) DeductionHandler(c echo.Context) error {
+	return h.updateDeduction(c, c.Param("type"))
+}
+
+func (h *Handler) DeductionPersonalHandler(c echo.Context) error {
+	return h.updateDeduction(c, PERSONAL)
 }
 
 func (h *Handler) DeductionKReceiptHandler(c echo.Context) error {
-	dkr := DeductionKReceipt{}
-	if err := validateInput(c, &dkr); err != nil {
-		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+	return h.updateDeduction(c, KRECEIPT)
+}
+
+func (h *Handler) DeductionListHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	limits, err := h.Allowances.SearchAllAllowanceLimit(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+	}
+	allowances, err := h.Allowances.SearchAllAllowance(ctx)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+	}
+	amounts := make(map[string]float64)
+	versions := make(map[string]int)
+	for _, allowance := range allowances {
+		amounts[allowance.AllowanceType] = allowance.Amount
+		versions[allowance.AllowanceType] = allowance.Version
+	}
+	settings := make([]DeductionSetting, 0)
+	for _, limit := range limits {
+		settings = append(settings, DeductionSetting{
+			AllowanceType: limit.AllowanceType,
+			Amount:        amounts[limit.AllowanceType],
+			MinAmount:     limit.MinAmount,
+			MaxAmount:     limit.MaxAmount,
+			MinExclusive:  limit.MinExclusive,
+			Version:       versions[limit.AllowanceType],
+		})
+	}
+	return c.JSON(http.StatusOK, settings)
+}
+
+func (h *Handler) ChangeRequestListHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	if err := h.Allowances.ExpireChangeRequests(ctx, time.Now()); err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+	}
+	changeRequests, err := h.Allowances.SearchChangeRequestByStatus(ctx, db.PENDING)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
 	}
-	if err := (&db.Allowance{AllowanceType: KRECEIPT, Amount: *dkr.Amount}).UpdateByType(h.DB); err != nil {
+	allowances, err := h.Allowances.SearchAllAllowance(ctx)
+	if err != nil {
 		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
 	}
-	return c.JSON(http.StatusOK, KReceiptResult{KReceipt: *dkr.Amount})
+	versions := make(map[string]int)
+	for _, allowance := range allowances {
+		versions[allowance.AllowanceType] = allowance.Version
+	}
+	for i, changeRequest := range changeRequests {
+		version, ok := versions[changeRequest.AllowanceType]
+		changeRequests[i].Stale = !ok || version != changeRequest.AllowanceVersion
+	}
+	return c.JSON(http.StatusOK, changeRequests)
+}
+
+func (h *Handler) ApproveChangeRequestHandler(c echo.Context) error {
+	return h.reviewChangeRequest(c, db.APPROVED)
+}
+
+func (h *Handler) RejectChangeRequestHandler(c echo.Context) error {
+	return h.reviewChangeRequest(c, db.REJECTED)
+}
+
+func (h *Handler) CancelChangeRequestHandler(c echo.Context) error {
+	return h.reviewChangeRequest(c, db.CANCELLED)
+}
+
+type statusErr struct {
+	status  int
+	message string
+}
+
+func (e *statusErr) Error() string {
+	return e.message
+}
+
+func writeStatusErr(c echo.Context, err error) error {
+	var sErr *statusErr
+	if errors.As(err, &sErr) {
+		return c.JSON(sErr.status, Err{Message: sErr.message})
+	}
+	return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+}
+
+func checkReviewer(status string, requestedBy string, reviewedBy string, subject string) error {
+	if status == db.CANCELLED && reviewedBy != requestedBy {
+		return &statusErr{http.StatusForbidden, subject + " can only be cancelled by its requester"}
+	}
+	if status != db.CANCELLED && reviewedBy == requestedBy {
+		return &statusErr{http.StatusForbidden, subject + " must be reviewed by a different admin"}
+	}
+	return nil
+}
+
+func (h *Handler) reviewChangeRequest(c echo.Context, status string) error {
+	ctx := c.Request().Context()
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: "Change request id must be a number"})
+	}
+
+	changeRequest := db.ChangeRequest{}
+	err = h.Allowances.Transaction(ctx, func(repository db.AllowanceRepository) error {
+		var err error
+		changeRequest, err = repository.SearchChangeRequestById(ctx, id)
+		if errors.Is(err, db.ErrNotFound) {
+			return &statusErr{http.StatusNotFound, "Change request not found"}
+		}
+		if err != nil {
+			return err
+		}
+		if changeRequest.Status != db.PENDING {
+			return &statusErr{http.StatusConflict, "Change request is already " + changeRequest.Status}
+		}
+
+		now := time.Now()
+		changeRequest.ReviewedBy = getRequester(c)
+		changeRequest.ReviewedAt = &now
+		if now.After(changeRequest.ExpiresAt) {
+			changeRequest.Status = db.EXPIRED
+			return repository.UpdateChangeRequestStatus(ctx, &changeRequest)
+		}
+		if err := checkReviewer(status, changeRequest.RequestedBy, changeRequest.ReviewedBy, "Change request"); err != nil {
+			return err
+		}
+
+		changeRequest.Status = status
+		if status == db.APPROVED {
+			limit, err := repository.SearchAllowanceLimitByType(ctx, changeRequest.AllowanceType)
+			if err != nil {
+				return err
+			}
+			if !limit.Allows(changeRequest.Amount) {
+				return &statusErr{http.StatusConflict, "Change request amount is outside the current allowed range"}
+			}
+			err = repository.UpdateAllowanceAmount(ctx, changeRequest.AllowanceType, changeRequest.Amount, changeRequest.AllowanceVersion)
+			if errors.Is(err, db.ErrNotFound) {
+				return &statusErr{http.StatusNotFound, fmt.Sprintf("Allowance type %v does not exist", changeRequest.AllowanceType)}
+			}
+			if errors.Is(err, db.ErrAllowanceVersionConflict) {
+				changeRequest.Status = db.SUPERSEDED
+			} else if err != nil {
+				return err
+			}
+		}
+		if err := repository.UpdateChangeRequestStatus(ctx, &changeRequest); errors.Is(err, db.ErrChangeRequestNotPending) {
+			return &statusErr{http.StatusConflict, "Change request is no longer pending"}
+		} else if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return writeStatusErr(c, err)
+	}
+	if changeRequest.Status == db.EXPIRED {
+		return c.JSON(http.StatusConflict, Err{Message: "Change request has expired"})
+	}
+	if changeRequest.Status == db.SUPERSEDED {
+		return c.JSON(http.StatusConflict, Err{Message: fmt.Sprintf("Allowance type %v has been modified since the change request was created", changeRequest.AllowanceType)})
+	}
+	return c.JSON(http.StatusOK, changeRequest)
 }
@@ -1,11 +1,12 @@
 package admin
 
 import (
+	"context"
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
-	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/Rachatapon1994/assessment-tax/config"
+	"github.com/Rachatapon1994/assessment-tax/db"
 	mw "github.com/Rachatapon1994/assessment-tax/middleware"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
@@ -14,8 +15,10 @@ import (
 	"net/http/httptest"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 type mockHandlerContext struct {
@@ -23,15 +26,15 @@ type mockHandlerContext struct {
 	r *httptest.ResponseRecorder
 }
 
-func mockPostAdminDeductionContext(allowanceType string, body string) mockHandlerContext {
+func mockAdminContext(method string, path string, username string, body string) mockHandlerContext {
 	os.Setenv("ADMIN_USERNAME", "admin")
 	os.Setenv("ADMIN_PASSWORD", "secret")
 
 	e := echo.New()
 	e.Validator = &config.CustomValidator{Validator: validator.New(validator.WithRequiredStructEnabled())}
 	e.Use(middleware.BasicAuth(mw.Authenticate()))
-	req := httptest.NewRequest(http.MethodPost, "/admin/deductions/"+allowanceType, strings.NewReader(body))
-	auth := "basic " + base64.StdEncoding.EncodeToString([]byte("admin:secret"))
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	auth := "basic " + base64.StdEncoding.EncodeToString([]byte(username+":secret"))
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	req.Header.Set(echo.HeaderAuthorization, auth)
 	rec := httptest.NewRecorder()
@@ -41,23 +44,74 @@ func mockPostAdminDeductionContext(allowanceType string, body string) mockHandle
 	}
 }
 
-func mockHandlerDb(t *testing.T) *sql.DB {
-	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
-	mock.MatchExpectationsInOrder(false)
-	if err != nil {
-		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
-	}
-
-	updateAllowanceSql := "UPDATE allowance SET amount = $1 WHERE allowance_type = $2"
-	mock.ExpectExec(updateAllowanceSql).WithArgs(10000.0, PERSONAL).WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectExec(updateAllowanceSql).WithArgs(50000.0, PERSONAL).WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectExec(updateAllowanceSql).WithArgs(100000.0, PERSONAL).WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectExec(updateAllowanceSql).WithArgs(88888.0, PERSONAL).WillReturnError(sql.ErrConnDone)
-	mock.ExpectExec(updateAllowanceSql).WithArgs(1.0, KRECEIPT).WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectExec(updateAllowanceSql).WithArgs(50000.0, KRECEIPT).WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectExec(updateAllowanceSql).WithArgs(100000.0, KRECEIPT).WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectExec(updateAllowanceSql).WithArgs(88888.0, KRECEIPT).WillReturnError(sql.ErrConnDone)
-	return db
+func mockPostAdminDeductionContext(allowanceType string, body string) mockHandlerContext {
+	return mockAdminContext(http.MethodPost, "/admin/deductions/"+allowanceType, "admin", body)
+}
+
+func mockReviewChangeRequestContext(id string, username string) mockHandlerContext {
+	c := mockAdminContext(http.MethodPost, "/admin/deductions/requests/"+id, username, "")
+	c.c.SetParamNames("id")
+	c.c.SetParamValues(id)
+	return c
+}
+
+type failingAllowanceRepository struct {
+	db.AllowanceRepository
+	method string
+	err    error
+}
+
+func (r *failingAllowanceRepository) fail(method string) error {
+	if r.method == method {
+		return r.err
+	}
+	return nil
+}
+
+func (r *failingAllowanceRepository) UpdateAllowanceAmount(ctx context.Context, allowanceType string, amount float64, version int) error {
+	if err := r.fail("UpdateAllowanceAmount"); err != nil {
+		return err
+	}
+	return r.AllowanceRepository.UpdateAllowanceAmount(ctx, allowanceType, amount, version)
+}
+
+func (r *failingAllowanceRepository) InsertChangeRequest(ctx context.Context, changeRequest *db.ChangeRequest) error {
+	if err := r.fail("InsertChangeRequest"); err != nil {
+		return err
+	}
+	return r.AllowanceRepository.InsertChangeRequest(ctx, changeRequest)
+}
+
+func (r *failingAllowanceRepository) ExpireChangeRequests(ctx context.Context, now time.Time) error {
+	if err := r.fail("ExpireChangeRequests"); err != nil {
+		return err
+	}
+	return r.AllowanceRepository.ExpireChangeRequests(ctx, now)
+}
+
+func (r *failingAllowanceRepository) InsertConfigImportRequest(ctx context.Context, importRequest *db.ConfigImportRequest) error {
+	if err := r.fail("InsertConfigImportRequest"); err != nil {
+		return err
+	}
+	return r.AllowanceRepository.InsertConfigImportRequest(ctx, importRequest)
+}
+
+func (r *failingAllowanceRepository) Transaction(ctx context.Context, run func(repository db.AllowanceRepository) error) error {
+	return r.AllowanceRepository.Transaction(ctx, func(repository db.AllowanceRepository) error {
+		return run(&failingAllowanceRepository{repository, r.method, r.err})
+	})
+}
+
+func mockAllowances(t *testing.T, setup func(ctx context.Context, repository db.AllowanceRepository) error) *db.MemoryAllowanceRepository {
+	repository := db.NewMemoryAllowanceRepository()
+	if err := setup(context.Background(), repository); err != nil {
+		t.Fatalf("unable to set up allowance repository: %v", err)
+	}
+	return repository
+}
+
+func mockFailingAllowances(method string) db.AllowanceRepository {
+	return &failingAllowanceRepository{db.NewMemoryAllowanceRepository(), method, sql.ErrConnDone}
 }
 
 func TestErr_Error(t *testing.T) {
@@ -88,7 +142,7 @@ func Test_validateInput(t *testing.T) {
 	t.Parallel()
 	type args struct {
 		c  mockHandlerContext
-		tc *DeductionPersonal
+		tc *Deduction
 	}
 	tests := []struct {
 		name             string
@@ -96,12 +150,12 @@ func Test_validateInput(t *testing.T) {
 		wantErr          bool
 		wantErrorMessage string
 	}{
-		{"Should validate input failed when JSON is incorrect format", args{mockPostAdminDeductionContext(PERSONAL, `{  "amount": 60001.0 `), &DeductionPersonal{}}, true, "Error when binding JSON"},
-		{"Should validate input failed when JSON data is not meet validator setup", args{mockPostAdminDeductionContext(PERSONAL, `{  "amount": 9999.0}`), &DeductionPersonal{}}, true, "Validation fields does not pass"},
-		{"Should validate input success when JSON data is correctly and meet validator setup", args{mockPostAdminDeductionContext(PERSONAL, `{  "amount": 60001.0}`), &DeductionPersonal{}}, false, ""},
-		{"Should validate input failed when JSON is incorrect format", args{mockPostAdminDeductionContext(KRECEIPT, `{  "amount": 10000.0 `), &DeductionPersonal{}}, true, "Error when binding JSON"},
-		{"Should validate input failed when JSON data is not meet validator setup", args{mockPostAdminDeductionContext(KRECEIPT, `{  "amount": 9999.0}`), &DeductionPersonal{}}, true, "Validation fields does not pass"},
-		{"Should validate input success when JSON data is correctly and meet validator setup", args{mockPostAdminDeductionContext(KRECEIPT, `{  "amount": 50001.0}`), &DeductionPersonal{}}, false, ""},
+		{"Should validate input failed when JSON is incorrect format", args{mockPostAdminDeductionContext(PERSONAL, `{  "amount": 60001.0 `), &Deduction{}}, true, "Error when binding JSON"},
+		{"Should validate input failed when JSON data is not meet validator setup", args{mockPostAdminDeductionContext(PERSONAL, `{  "amount": null}`), &Deduction{}}, true, "Validation fields does not pass"},
+		{"Should validate input success when JSON data is correctly and meet validator setup", args{mockPostAdminDeductionContext(PERSONAL, `{  "amount": 60001.0, "version": 1}`), &Deduction{}}, false, ""},
+		{"Should validate input failed when JSON is incorrect format", args{mockPostAdminDeductionContext(KRECEIPT, `{  "amount": 10000.0 `), &Deduction{}}, true, "Error when binding JSON"},
+		{"Should validate input failed when JSON data is not meet validator setup", args{mockPostAdminDeductionContext(KRECEIPT, `{}`), &Deduction{}}, true, "Validation fields does not pass"},
+		{"Should validate input success when JSON data is correctly and meet validator setup", args{mockPostAdminDeductionContext(KRECEIPT, `{  "amount": 50001.0, "version": 1}`), &Deduction{}}, false, ""},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -119,18 +173,57 @@ func Test_validateInput(t *testing.T) {
 	}
 }
 
+func Test_getExpectedVersion(t *testing.T) {
+	t.Parallel()
+	version := 2
+	tests := []struct {
+		name        string
+		ifMatch     string
+		deduction   Deduction
+		want        int
+		wantStatus  int
+		wantMessage string
+	}{
+		{"Should return version from body when If-Match header is missing", "", Deduction{Version: &version}, 2, 0, ""},
+		{"Should return version from quoted If-Match header", `"3"`, Deduction{}, 3, 0, ""},
+		{"Should return version from weak If-Match header", `W/"3"`, Deduction{}, 3, 0, ""},
+		{"Should return status 428 when version is missing", "", Deduction{}, 0, http.StatusPreconditionRequired, "If-Match header or version is required"},
+		{"Should return status 400 when If-Match header is not a version", `"abc"`, Deduction{}, 0, http.StatusBadRequest, "If-Match header must be an allowance version"},
+		{"Should return status 400 when If-Match header and version differ", `"3"`, Deduction{Version: &version}, 0, http.StatusBadRequest, "If-Match header does not match version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := mockPostAdminDeductionContext(PERSONAL, "")
+			if tt.ifMatch != "" {
+				c.c.Request().Header.Set("If-Match", tt.ifMatch)
+			}
+			got, err := getExpectedVersion(c.c, tt.deduction)
+			if got != tt.want {
+				t.Errorf("getExpectedVersion() = %v, want %v", got, tt.want)
+			}
+			sErr, _ := err.(*statusErr)
+			if tt.wantStatus == 0 && err != nil {
+				t.Errorf("getExpectedVersion() error = %v", err)
+			}
+			if tt.wantStatus != 0 && (sErr == nil || sErr.status != tt.wantStatus || sErr.message != tt.wantMessage) {
+				t.Errorf("getExpectedVersion() error = %v, want (%v) %v", err, tt.wantStatus, tt.wantMessage)
+			}
+		})
+	}
+}
+
 func TestHandler_DeductionPersonalHandler(t *testing.T) {
 	t.Parallel()
 
-	mockContext400WhenAmount9999 := mockPostAdminDeductionContext(PERSONAL, `{  "amount": 9999.0}`)
-	mockContext200WhenAmount10000 := mockPostAdminDeductionContext(PERSONAL, `{  "amount": 10000.0}`)
-	mockContext200WhenAmount50000 := mockPostAdminDeductionContext(PERSONAL, `{  "amount": 50000.0}`)
-	mockContext200WhenAmount100000 := mockPostAdminDeductionContext(PERSONAL, `{  "amount": 100000.0}`)
-	mockContext200WhenAmount100001 := mockPostAdminDeductionContext(PERSONAL, `{  "amount": 100001.0}`)
-	mockContext500WhenAmount88888 := mockPostAdminDeductionContext(PERSONAL, `{  "amount": 88888.0}`)
+	mockContext400WhenAmount9999 := mockPostAdminDeductionContext(PERSONAL, `{  "amount": 9999.0, "version": 1}`)
+	mockContext202WhenAmount10000 := mockPostAdminDeductionContext(PERSONAL, `{  "amount": 10000.0, "version": 1}`)
+	mockContext202WhenAmount50000 := mockPostAdminDeductionContext(PERSONAL, `{  "amount": 50000.0, "version": 1}`)
+	mockContext202WhenAmount100000 := mockPostAdminDeductionContext(PERSONAL, `{  "amount": 100000.0, "version": 1}`)
+	mockContext400WhenAmount100001 := mockPostAdminDeductionContext(PERSONAL, `{  "amount": 100001.0, "version": 1}`)
+	mockContext500WhenAmount88888 := mockPostAdminDeductionContext(PERSONAL, `{  "amount": 88888.0, "version": 1}`)
 
 	type fields struct {
-		DB *sql.DB
+		Allowances db.AllowanceRepository
 	}
 	type args struct {
 		c mockHandlerContext
@@ -142,31 +235,34 @@ func TestHandler_DeductionPersonalHandler(t *testing.T) {
 		wantResponseBody   interface{}
 		wantResponseStatus int
 	}{
-		{"Should return response with status 400 when amount = 9999", fields{DB: mockHandlerDb(t)}, args{c: mockContext400WhenAmount9999}, Err{Message: "Validation fields does not pass"}, 400},
-		{"Should return successful response when amount = 10000", fields{DB: mockHandlerDb(t)}, args{c: mockContext200WhenAmount10000}, PersonalResult{10000}, 200},
-		{"Should return successful response when amount = 50000", fields{DB: mockHandlerDb(t)}, args{c: mockContext200WhenAmount50000}, PersonalResult{50000}, 200},
-		{"Should return successful response when amount = 100000", fields{DB: mockHandlerDb(t)}, args{c: mockContext200WhenAmount100000}, PersonalResult{100000}, 200},
-		{"Should return successful response when amount = 100001", fields{DB: mockHandlerDb(t)}, args{c: mockContext200WhenAmount100001}, Err{Message: "Validation fields does not pass"}, 400},
-		{"Should return unsuccessful response when amount = 88888 due to mock response error to ErrConnDone", fields{DB: mockHandlerDb(t)}, args{c: mockContext500WhenAmount88888}, Err{Message: sql.ErrConnDone.Error()}, 500},
+		{"Should return response with status 400 when amount = 9999", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContext400WhenAmount9999}, Err{Message: "Validation fields does not pass"}, 400},
+		{"Should return successful response when amount = 10000", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContext202WhenAmount10000}, db.ChangeRequest{Id: 1, AllowanceType: PERSONAL, Amount: 10000, AllowanceVersion: 1, Status: db.PENDING, RequestedBy: "admin"}, 202},
+		{"Should return successful response when amount = 50000", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContext202WhenAmount50000}, db.ChangeRequest{Id: 1, AllowanceType: PERSONAL, Amount: 50000, AllowanceVersion: 1, Status: db.PENDING, RequestedBy: "admin"}, 202},
+		{"Should return successful response when amount = 100000", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContext202WhenAmount100000}, db.ChangeRequest{Id: 1, AllowanceType: PERSONAL, Amount: 100000, AllowanceVersion: 1, Status: db.PENDING, RequestedBy: "admin"}, 202},
+		{"Should return response with status 400 when amount = 100001", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContext400WhenAmount100001}, Err{Message: "Validation fields does not pass"}, 400},
+		{"Should return unsuccessful response when amount = 88888 due to mock response error to ErrConnDone", fields{Allowances: mockFailingAllowances("InsertChangeRequest")}, args{c: mockContext500WhenAmount88888}, Err{Message: sql.ErrConnDone.Error()}, 500},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			defer tt.fields.DB.Close()
-
 			h := &Handler{
-				DB: tt.fields.DB,
+				Allowances: tt.fields.Allowances,
 			}
 
 			if err := h.DeductionPersonalHandler(tt.args.c.c); err != nil {
 				t.Errorf("Handler.CalculationHandler() error = %v", err)
 			}
 
-			if tt.wantResponseStatus == 200 {
-				result := PersonalResult{}
+			if tt.wantResponseStatus == 202 {
+				result := db.ChangeRequest{}
 				if err := json.Unmarshal(tt.args.c.r.Body.Bytes(), &result); err != nil {
 					t.Errorf("unable to unmarshal json: %v", err)
 				}
 
+				if result.ExpiresAt.Sub(result.CreatedAt) != DEFAULTCHANGEREQUESTTTL {
+					t.Errorf("expected change request to expire after (%v), got (%v)", DEFAULTCHANGEREQUESTTTL, result.ExpiresAt.Sub(result.CreatedAt))
+				}
+				result.CreatedAt, result.ExpiresAt = time.Time{}, time.Time{}
+
 				if !reflect.DeepEqual(result, tt.wantResponseBody) {
 					t.Errorf("expected (%v), got (%v)", tt.wantResponseBody, result)
 				}
@@ -191,15 +287,15 @@ func TestHandler_DeductionPersonalHandler(t *testing.T) {
 func TestHandler_DeductionKReceiptHandler(t *testing.T) {
 	t.Parallel()
 
-	mockContext400WhenAmount0 := mockPostAdminDeductionContext(KRECEIPT, `{  "amount": 0.0}`)
-	mockContext200WhenAmount1 := mockPostAdminDeductionContext(KRECEIPT, `{  "amount": 1.0}`)
-	mockContext200WhenAmount50000 := mockPostAdminDeductionContext(KRECEIPT, `{  "amount": 50000.0}`)
-	mockContext200WhenAmount100000 := mockPostAdminDeductionContext(KRECEIPT, `{  "amount": 100000.0}`)
-	mockContext200WhenAmount100001 := mockPostAdminDeductionContext(KRECEIPT, `{  "amount": 100001.0}`)
-	mockContext500WhenAmount88888 := mockPostAdminDeductionContext(KRECEIPT, `{  "amount": 88888.0}`)
+	mockContext400WhenAmount0 := mockPostAdminDeductionContext(KRECEIPT, `{  "amount": 0.0, "version": 1}`)
+	mockContext202WhenAmount1 := mockPostAdminDeductionContext(KRECEIPT, `{  "amount": 1.0, "version": 1}`)
+	mockContext202WhenAmount50000 := mockPostAdminDeductionContext(KRECEIPT, `{  "amount": 50000.0, "version": 1}`)
+	mockContext202WhenAmount100000 := mockPostAdminDeductionContext(KRECEIPT, `{  "amount": 100000.0, "version": 1}`)
+	mockContext400WhenAmount100001 := mockPostAdminDeductionContext(KRECEIPT, `{  "amount": 100001.0, "version": 1}`)
+	mockContext500WhenAmount88888 := mockPostAdminDeductionContext(KRECEIPT, `{  "amount": 88888.0, "version": 1}`)
 
 	type fields struct {
-		DB *sql.DB
+		Allowances db.AllowanceRepository
 	}
 	type args struct {
 		c mockHandlerContext
@@ -211,31 +307,34 @@ func TestHandler_DeductionKReceiptHandler(t *testing.T) {
 		wantResponseBody   interface{}
 		wantResponseStatus int
 	}{
-		{"Should return response with status 400 when amount = 0", fields{DB: mockHandlerDb(t)}, args{c: mockContext400WhenAmount0}, Err{Message: "Validation fields does not pass"}, 400},
-		{"Should return successful response when amount = 1", fields{DB: mockHandlerDb(t)}, args{c: mockContext200WhenAmount1}, KReceiptResult{1}, 200},
-		{"Should return successful response when amount = 50000", fields{DB: mockHandlerDb(t)}, args{c: mockContext200WhenAmount50000}, KReceiptResult{50000}, 200},
-		{"Should return successful response when amount = 100000", fields{DB: mockHandlerDb(t)}, args{c: mockContext200WhenAmount100000}, KReceiptResult{100000}, 200},
-		{"Should return successful response when amount = 100001", fields{DB: mockHandlerDb(t)}, args{c: mockContext200WhenAmount100001}, Err{Message: "Validation fields does not pass"}, 400},
-		{"Should return unsuccessful response when amount = 88888 due to mock response error to ErrConnDone", fields{DB: mockHandlerDb(t)}, args{c: mockContext500WhenAmount88888}, Err{Message: sql.ErrConnDone.Error()}, 500},
+		{"Should return response with status 400 when amount = 0", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContext400WhenAmount0}, Err{Message: "Validation fields does not pass"}, 400},
+		{"Should return successful response when amount = 1", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContext202WhenAmount1}, db.ChangeRequest{Id: 1, AllowanceType: KRECEIPT, Amount: 1, AllowanceVersion: 1, Status: db.PENDING, RequestedBy: "admin"}, 202},
+		{"Should return successful response when amount = 50000", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContext202WhenAmount50000}, db.ChangeRequest{Id: 1, AllowanceType: KRECEIPT, Amount: 50000, AllowanceVersion: 1, Status: db.PENDING, RequestedBy: "admin"}, 202},
+		{"Should return successful response when amount = 100000", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContext202WhenAmount100000}, db.ChangeRequest{Id: 1, AllowanceType: KRECEIPT, Amount: 100000, AllowanceVersion: 1, Status: db.PENDING, RequestedBy: "admin"}, 202},
+		{"Should return response with status 400 when amount = 100001", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContext400WhenAmount100001}, Err{Message: "Validation fields does not pass"}, 400},
+		{"Should return unsuccessful response when amount = 88888 due to mock response error to ErrConnDone", fields{Allowances: mockFailingAllowances("InsertChangeRequest")}, args{c: mockContext500WhenAmount88888}, Err{Message: sql.ErrConnDone.Error()}, 500},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			defer tt.fields.DB.Close()
-
 			h := &Handler{
-				DB: tt.fields.DB,
+				Allowances: tt.fields.Allowances,
 			}
 
 			if err := h.DeductionKReceiptHandler(tt.args.c.c); err != nil {
 				t.Errorf("Handler.CalculationHandler() error = %v", err)
 			}
 
-			if tt.wantResponseStatus == 200 {
-				result := KReceiptResult{}
+			if tt.wantResponseStatus == 202 {
+				result := db.ChangeRequest{}
 				if err := json.Unmarshal(tt.args.c.r.Body.Bytes(), &result); err != nil {
 					t.Errorf("unable to unmarshal json: %v", err)
 				}
 
+				if result.ExpiresAt.Sub(result.CreatedAt) != DEFAULTCHANGEREQUESTTTL {
+					t.Errorf("expected change request to expire after (%v), got (%v)", DEFAULTCHANGEREQUESTTTL, result.ExpiresAt.Sub(result.CreatedAt))
+				}
+				result.CreatedAt, result.ExpiresAt = time.Time{}, time.Time{}
+
 				if !reflect.DeepEqual(result, tt.wantResponseBody) {
 					t.Errorf("expected (%v), got (%v)", tt.wantResponseBody, result)
 				}
@@ -256,3 +355,329 @@ func TestHandler_DeductionKReceiptHandler(t *testing.T) {
 		})
 	}
 }
+
+func mockChangeRequest(status string, requestedBy string, expiresAt time.Time) *db.ChangeRequest {
+	return &db.ChangeRequest{AllowanceType: PERSONAL, Amount: 70000, AllowanceVersion: 1, Status: status, RequestedBy: requestedBy, CreatedAt: expiresAt.Add(-DEFAULTCHANGEREQUESTTTL), ExpiresAt: expiresAt}
+}
+
+func TestHandler_ChangeRequestListHandler(t *testing.T) {
+	t.Parallel()
+	createdAt := time.Now().UTC().Truncate(time.Second)
+
+	tests := []struct {
+		name               string
+		allowances         db.AllowanceRepository
+		wantResponseBody   interface{}
+		wantResponseStatus int
+	}{
+		{"Should return pending change requests after expiring stale ones", mockAllowances(t, func(ctx context.Context, repository db.AllowanceRepository) error {
+			if err := repository.InsertChangeRequest(ctx, mockChangeRequest(db.PENDING, "admin", createdAt.Add(-time.Hour))); err != nil {
+				return err
+			}
+			return repository.InsertChangeRequest(ctx, mockChangeRequest(db.PENDING, "admin", createdAt.Add(DEFAULTCHANGEREQUESTTTL)))
+		}), []db.ChangeRequest{{Id: 2, AllowanceType: PERSONAL, Amount: 70000, AllowanceVersion: 1, Status: "pending", RequestedBy: "admin", CreatedAt: createdAt, ExpiresAt: createdAt.Add(DEFAULTCHANGEREQUESTTTL)}}, 200},
+		{"Should mark change request as stale when allowance version has changed", mockAllowances(t, func(ctx context.Context, repository db.AllowanceRepository) error {
+			if err := repository.InsertChangeRequest(ctx, mockChangeRequest(db.PENDING, "admin", createdAt.Add(DEFAULTCHANGEREQUESTTTL))); err != nil {
+				return err
+			}
+			return repository.UpdateAllowanceAmount(ctx, PERSONAL, 80000, 1)
+		}), []db.ChangeRequest{{Id: 1, AllowanceType: PERSONAL, Amount: 70000, AllowanceVersion: 1, Status: "pending", RequestedBy: "admin", CreatedAt: createdAt, ExpiresAt: createdAt.Add(DEFAULTCHANGEREQUESTTTL), Stale: true}}, 200},
+		{"Should return status 500 when expiring stale change requests fails", mockFailingAllowances("ExpireChangeRequests"), Err{Message: sql.ErrConnDone.Error()}, 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := mockAdminContext(http.MethodGet, "/admin/deductions/requests", "admin", "")
+			h := &Handler{Allowances: tt.allowances}
+			if err := h.ChangeRequestListHandler(c.c); err != nil {
+				t.Errorf("Handler.ChangeRequestListHandler() error = %v", err)
+			}
+
+			var result interface{}
+			if tt.wantResponseStatus == 200 {
+				changeRequests := make([]db.ChangeRequest, 0)
+				if err := json.Unmarshal(c.r.Body.Bytes(), &changeRequests); err != nil {
+					t.Errorf("unable to unmarshal json: %v", err)
+				}
+				result = changeRequests
+			} else {
+				errResult := Err{}
+				if err := json.Unmarshal(c.r.Body.Bytes(), &errResult); err != nil {
+					t.Errorf("unable to unmarshal json: %v", err)
+				}
+				result = errResult
+			}
+
+			if !reflect.DeepEqual(result, tt.wantResponseBody) {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseBody, result)
+			}
+
+			if c.r.Code != tt.wantResponseStatus {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseStatus, c.r.Code)
+			}
+		})
+	}
+}
+
+func TestHandler_reviewChangeRequest(t *testing.T) {
+	t.Parallel()
+	notExpired := time.Now().Add(time.Hour)
+	insertChangeRequest := func(status string, expiresAt time.Time, setup ...func(ctx context.Context, repository db.AllowanceRepository) error) db.AllowanceRepository {
+		return mockAllowances(t, func(ctx context.Context, repository db.AllowanceRepository) error {
+			if err := repository.InsertChangeRequest(ctx, mockChangeRequest(status, "admin", expiresAt)); err != nil {
+				return err
+			}
+			for _, run := range setup {
+				if err := run(ctx, repository); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	tests := []struct {
+		name               string
+		id                 string
+		reviewer           string
+		status             string
+		allowances         db.AllowanceRepository
+		wantResponseStatus int
+		wantStatus         string
+		wantErrorMessage   string
+		wantStoredStatus   string
+	}{
+		{"Should approve and apply change request when reviewed by a different admin", "1", "approver", db.APPROVED, insertChangeRequest(db.PENDING, notExpired), 200, db.APPROVED, "", db.APPROVED},
+		{"Should return status 409 and supersede change request when allowance was modified after it was created", "1", "approver", db.APPROVED, insertChangeRequest(db.PENDING, notExpired, func(ctx context.Context, repository db.AllowanceRepository) error {
+			return repository.UpdateAllowanceAmount(ctx, PERSONAL, 80000, 1)
+		}), 409, "", "Allowance type personal has been modified since the change request was created", db.SUPERSEDED},
+		{"Should return status 404 when allowance no longer exists at approval time", "1", "approver", db.APPROVED, &failingAllowanceRepository{insertChangeRequest(db.PENDING, notExpired), "UpdateAllowanceAmount", db.ErrNotFound}, 404, "", "Allowance type personal does not exist", db.PENDING},
+		{"Should reject change request without applying it", "1", "approver", db.REJECTED, insertChangeRequest(db.PENDING, notExpired), 200, db.REJECTED, "", db.REJECTED},
+		{"Should return status 409 when amount is outside the allowed range at approval time", "1", "approver", db.APPROVED, insertChangeRequest(db.PENDING, notExpired, func(ctx context.Context, repository db.AllowanceRepository) error {
+			return repository.UpsertAllowance(ctx, db.Allowance{AllowanceType: PERSONAL, Amount: 60000}, db.AllowanceLimit{MinAmount: 10000, MaxAmount: 60000})
+		}), 409, "", "Change request amount is outside the current allowed range", db.PENDING},
+		{"Should cancel change request when requested by its requester", "1", "admin", db.CANCELLED, insertChangeRequest(db.PENDING, notExpired), 200, db.CANCELLED, "", db.CANCELLED},
+		{"Should return status 403 when another admin cancels change request", "1", "approver", db.CANCELLED, insertChangeRequest(db.PENDING, notExpired), 403, "", "Change request can only be cancelled by its requester", db.PENDING},
+		{"Should return status 409 when cancelling change request that is already approved", "1", "admin", db.CANCELLED, insertChangeRequest(db.APPROVED, notExpired), 409, "", "Change request is already approved", db.APPROVED},
+		{"Should return status 403 when requester approves their own change request", "1", "admin", db.APPROVED, insertChangeRequest(db.PENDING, notExpired), 403, "", "Change request must be reviewed by a different admin", db.PENDING},
+		{"Should return status 409 when change request is already approved", "1", "approver", db.APPROVED, insertChangeRequest(db.APPROVED, notExpired), 409, "", "Change request is already approved", db.APPROVED},
+		{"Should return status 409 and expire change request when it is stale", "1", "approver", db.APPROVED, insertChangeRequest(db.PENDING, time.Now().Add(-time.Hour)), 409, "", "Change request has expired", db.EXPIRED},
+		{"Should return status 404 when change request does not exist", "2", "approver", db.APPROVED, insertChangeRequest(db.PENDING, notExpired), 404, "", "Change request not found", db.PENDING},
+		{"Should return status 400 when change request id is not a number", "abc", "approver", db.APPROVED, insertChangeRequest(db.PENDING, notExpired), 400, "", "Change request id must be a number", db.PENDING},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := mockReviewChangeRequestContext(tt.id, tt.reviewer)
+			h := &Handler{Allowances: tt.allowances}
+
+			if err := h.reviewChangeRequest(c.c, tt.status); err != nil {
+				t.Errorf("Handler.reviewChangeRequest() error = %v", err)
+			}
+
+			if tt.wantResponseStatus == 200 {
+				result := db.ChangeRequest{}
+				if err := json.Unmarshal(c.r.Body.Bytes(), &result); err != nil {
+					t.Errorf("unable to unmarshal json: %v", err)
+				}
+				if result.Status != tt.wantStatus || result.ReviewedBy != tt.reviewer || result.ReviewedAt == nil {
+					t.Errorf("expected status (%v) reviewed by (%v), got (%v)", tt.wantStatus, tt.reviewer, result)
+				}
+			} else {
+				result := Err{}
+				if err := json.Unmarshal(c.r.Body.Bytes(), &result); err != nil {
+					t.Errorf("unable to unmarshal json: %v", err)
+				}
+				if result.Message != tt.wantErrorMessage {
+					t.Errorf("expected (%v), got (%v)", tt.wantErrorMessage, result.Message)
+				}
+			}
+
+			if c.r.Code != tt.wantResponseStatus {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseStatus, c.r.Code)
+			}
+			if stored, _ := tt.allowances.SearchChangeRequestById(context.Background(), 1); stored.Status != tt.wantStoredStatus {
+				t.Errorf("expected stored change request to be (%v), got (%v)", tt.wantStoredStatus, stored.Status)
+			}
+		})
+	}
+}
+
+func TestHandler_DeductionHandler(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name               string
+		allowanceType      string
+		body               string
+		wantResponseBody   interface{}
+		wantResponseStatus int
+	}{
+		{"Should create change request for any allowance type within its limit", KRECEIPT, `{  "amount": 50000.0, "version": 1}`, db.ChangeRequest{Id: 1, AllowanceType: KRECEIPT, Amount: 50000, AllowanceVersion: 1, Status: db.PENDING, RequestedBy: "admin"}, 202},
+		{"Should return response with status 400 when amount is outside the limit", PERSONAL, `{  "amount": 100001.0}`, Err{Message: "Validation fields does not pass"}, 400},
+		{"Should return response with status 404 when allowance type does not exist", "insurance", `{  "amount": 1000.0}`, Err{Message: "Allowance type insurance does not exist"}, 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := mockAdminContext(http.MethodPut, "/admin/deductions/"+tt.allowanceType, "admin", tt.body)
+			c.c.SetParamNames("type")
+			c.c.SetParamValues(tt.allowanceType)
+			h := &Handler{Allowances: db.NewMemoryAllowanceRepository()}
+
+			if err := h.DeductionHandler(c.c); err != nil {
+				t.Errorf("Handler.DeductionHandler() error = %v", err)
+			}
+
+			var result interface{}
+			if tt.wantResponseStatus == 202 {
+				changeRequest := db.ChangeRequest{}
+				if err := json.Unmarshal(c.r.Body.Bytes(), &changeRequest); err != nil {
+					t.Errorf("unable to unmarshal json: %v", err)
+				}
+				changeRequest.CreatedAt, changeRequest.ExpiresAt = time.Time{}, time.Time{}
+				result = changeRequest
+			} else {
+				errResult := Err{}
+				if err := json.Unmarshal(c.r.Body.Bytes(), &errResult); err != nil {
+					t.Errorf("unable to unmarshal json: %v", err)
+				}
+				result = errResult
+			}
+
+			if !reflect.DeepEqual(result, tt.wantResponseBody) {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseBody, result)
+			}
+
+			if c.r.Code != tt.wantResponseStatus {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseStatus, c.r.Code)
+			}
+		})
+	}
+}
+
+func TestHandler_DeductionListHandler(t *testing.T) {
+	t.Parallel()
+	allowances := mockAllowances(t, func(ctx context.Context, repository db.AllowanceRepository) error {
+		return repository.UpdateAllowanceAmount(ctx, PERSONAL, 60000, 1)
+	})
+	c := mockAdminContext(http.MethodGet, "/admin/deductions", "admin", "")
+	h := &Handler{Allowances: allowances}
+
+	if err := h.DeductionListHandler(c.c); err != nil {
+		t.Errorf("Handler.DeductionListHandler() error = %v", err)
+	}
+
+	result := make([]DeductionSetting, 0)
+	if err := json.Unmarshal(c.r.Body.Bytes(), &result); err != nil {
+		t.Errorf("unable to unmarshal json: %v", err)
+	}
+	want := []DeductionSetting{
+		{AllowanceType: PERSONAL, Amount: 60000, MinAmount: 10000, MaxAmount: 100000, Version: 2},
+		{AllowanceType: DONATION, Amount: 100000, MinAmount: 0, MaxAmount: 100000, MinExclusive: true, Version: 1},
+		{AllowanceType: KRECEIPT, Amount: 50000, MinAmount: 0, MaxAmount: 100000, MinExclusive: true, Version: 1},
+		{AllowanceType: "spouse", Amount: 60000, MinAmount: 0, MaxAmount: 100000, Version: 1},
+		{AllowanceType: "child", Amount: 30000, MinAmount: 0, MaxAmount: 100000, Version: 1},
+		{AllowanceType: "parent", Amount: 30000, MinAmount: 0, MaxAmount: 100000, Version: 1},
+		{AllowanceType: "disability", Amount: 60000, MinAmount: 0, MaxAmount: 200000, Version: 1},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("expected (%v), got (%v)", want, result)
+	}
+	if c.r.Code != http.StatusOK {
+		t.Errorf("expected (%v), got (%v)", http.StatusOK, c.r.Code)
+	}
+}
+
+func TestHandler_memoryAllowanceRepository(t *testing.T) {
+	t.Parallel()
+	h := &Handler{Allowances: db.NewMemoryAllowanceRepository()}
+
+	t.Run("Should apply deduction after change request is approved by another admin", func(t *testing.T) {
+		create := mockPostAdminDeductionContext(PERSONAL, `{"amount": 70000.0, "version": 1}`)
+		if err := h.DeductionPersonalHandler(create.c); err != nil || create.r.Code != http.StatusAccepted {
+			t.Fatalf("Handler.DeductionPersonalHandler() status = %v, error = %v", create.r.Code, err)
+		}
+		changeRequest := db.ChangeRequest{}
+		if err := json.Unmarshal(create.r.Body.Bytes(), &changeRequest); err != nil {
+			t.Fatalf("unable to unmarshal json: %v", err)
+		}
+
+		approve := mockReviewChangeRequestContext(strconv.Itoa(changeRequest.Id), "approver")
+		if err := h.ApproveChangeRequestHandler(approve.c); err != nil || approve.r.Code != http.StatusOK {
+			t.Fatalf("Handler.ApproveChangeRequestHandler() status = %v, error = %v", approve.r.Code, err)
+		}
+
+		list := mockAdminContext(http.MethodGet, "/admin/deductions", "admin", "")
+		if err := h.DeductionListHandler(list.c); err != nil {
+			t.Fatalf("Handler.DeductionListHandler() error = %v", err)
+		}
+		settings := make([]DeductionSetting, 0)
+		if err := json.Unmarshal(list.r.Body.Bytes(), &settings); err != nil {
+			t.Fatalf("unable to unmarshal json: %v", err)
+		}
+		if settings[0].AllowanceType != PERSONAL || settings[0].Amount != 70000 || settings[0].Version != 2 {
+			t.Errorf("expected personal deduction 70000 at version 2, got (%v)", settings[0])
+		}
+	})
+
+	t.Run("Should return status 409 when second change request is approved after allowance was modified", func(t *testing.T) {
+		ids := make([]string, 0)
+		for _, amount := range []string{"80000.0", "90000.0"} {
+			create := mockPostAdminDeductionContext(PERSONAL, `{"amount": `+amount+`}`)
+			create.c.Request().Header.Set("If-Match", `"2"`)
+			if err := h.DeductionPersonalHandler(create.c); err != nil || create.r.Code != http.StatusAccepted {
+				t.Fatalf("Handler.DeductionPersonalHandler() status = %v, error = %v", create.r.Code, err)
+			}
+			changeRequest := db.ChangeRequest{}
+			if err := json.Unmarshal(create.r.Body.Bytes(), &changeRequest); err != nil {
+				t.Fatalf("unable to unmarshal json: %v", err)
+			}
+			ids = append(ids, strconv.Itoa(changeRequest.Id))
+		}
+
+		first := mockReviewChangeRequestContext(ids[0], "approver")
+		if err := h.ApproveChangeRequestHandler(first.c); err != nil || first.r.Code != http.StatusOK {
+			t.Fatalf("Handler.ApproveChangeRequestHandler() status = %v, error = %v", first.r.Code, err)
+		}
+		second := mockReviewChangeRequestContext(ids[1], "approver")
+		if err := h.ApproveChangeRequestHandler(second.c); err != nil {
+			t.Fatalf("Handler.ApproveChangeRequestHandler() error = %v", err)
+		}
+		if second.r.Code != http.StatusConflict {
+			t.Errorf("expected (%v), got (%v)", http.StatusConflict, second.r.Code)
+		}
+	})
+
+	t.Run("Should return status 409 when requested version is stale", func(t *testing.T) {
+		create := mockPostAdminDeductionContext(PERSONAL, `{"amount": 75000.0, "version": 1}`)
+		if err := h.DeductionPersonalHandler(create.c); err != nil {
+			t.Fatalf("Handler.DeductionPersonalHandler() error = %v", err)
+		}
+		result := Err{}
+		if err := json.Unmarshal(create.r.Body.Bytes(), &result); err != nil {
+			t.Fatalf("unable to unmarshal json: %v", err)
+		}
+		if create.r.Code != http.StatusConflict || result.Message != "Allowance type personal has been modified, current version is 3" {
+			t.Errorf("expected (%v) with current version, got (%v) %v", http.StatusConflict, create.r.Code, result.Message)
+		}
+	})
+
+	t.Run("Should return status 404 when change request does not exist", func(t *testing.T) {
+		reject := mockReviewChangeRequestContext("99", "approver")
+		if err := h.RejectChangeRequestHandler(reject.c); err != nil {
+			t.Errorf("Handler.RejectChangeRequestHandler() error = %v", err)
+		}
+		if reject.r.Code != http.StatusNotFound {
+			t.Errorf("expected (%v), got (%v)", http.StatusNotFound, reject.r.Code)
+		}
+	})
+
+	t.Run("Should return status 404 when allowance type does not exist", func(t *testing.T) {
+		update := mockAdminContext(http.MethodPut, "/admin/deductions/insurance", "admin", `{"amount": 1000.0, "version": 1}`)
+		update.c.SetParamNames("type")
+		update.c.SetParamValues("insurance")
+		if err := h.DeductionHandler(update.c); err != nil {
+			t.Errorf("Handler.DeductionHandler() error = %v", err)
+		}
+		if update.r.Code != http.StatusNotFound {
+			t.Errorf("expected (%v), got (%v)", http.StatusNotFound, update.r.Code)
+		}
+	})
+}
@@ -1,80 +1,103 @@
 package db
 
 import (
-	"database/sql"
-	"log"
+	"context"
+	"errors"
+)
+
+var (
+	ErrAllowanceVersionConflict = errors.New("allowance version does not match")
 )
 
 type Allowance struct {
 	Id            int     `json:"id"`
 	AllowanceType string  `json:"allowanceType"`
 	Amount        float64 `json:"amount"`
+	Version       int     `json:"version"`
 }
 
-func getAllowanceDefaultValues() []Allowance {
-	return []Allowance{
-		{AllowanceType: "personal", Amount: 60000.00},
-		{AllowanceType: "donation", Amount: 100000.00},
-		{AllowanceType: "k-receipt", Amount: 50000.00},
-	}
-}
-
-func createAllowanceTable(db *sql.DB) error {
-	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS allowance ( id SERIAL PRIMARY KEY, allowance_type TEXT UNIQUE, amount float)`); err != nil {
+func (a *Allowance) Insert(ctx context.Context, db Executor) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, "INSERT INTO allowance (allowance_type, amount) VALUES ($1,$2)", a.AllowanceType, a.Amount); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (a *Allowance) Insert(db *sql.DB) error {
-	if _, err := db.Exec("INSERT INTO allowance (allowance_type, amount) VALUES ($1,$2)", a.AllowanceType, a.Amount); err != nil {
+func (a *Allowance) UpdateByType(ctx context.Context, db Executor) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	updateAllowance := "UPDATE allowance SET amount = $1, version = version + 1 WHERE allowance_type = $2 AND version = $3"
+	result, err := db.ExecContext(ctx, updateAllowance, a.Amount, a.AllowanceType, a.Version)
+	if err != nil {
 		return err
 	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		if _, err := a.SearchByType(ctx, db); err != nil {
+			return err
+		}
+		return ErrAllowanceVersionConflict
+	}
+	a.Version++
 	return nil
 }
 
-func (a *Allowance) UpdateByType(db *sql.DB) error {
-	if _, err := db.Exec("UPDATE allowance SET amount = $1 WHERE allowance_type = $2", a.Amount, a.AllowanceType); err != nil {
+func (a *Allowance) Upsert(ctx context.Context, db Executor) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, "INSERT INTO allowance (allowance_type, amount) VALUES ($1,$2) ON CONFLICT (allowance_type) DO UPDATE SET amount = EXCLUDED.amount, version = allowance.version + 1 WHERE allowance.amount IS DISTINCT FROM EXCLUDED.amount", a.AllowanceType, a.Amount); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (a *Allowance) SearchByType(db *sql.DB) Allowance {
+func (a *Allowance) SearchByType(ctx context.Context, db Executor) (Allowance, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	result := Allowance{}
-	selectAllowance := "SELECT id, allowance_type, amount FROM allowance WHERE allowance_type = $1"
-	rows, err := db.Query(selectAllowance, a.AllowanceType)
+	selectAllowance := "SELECT id, allowance_type, amount, version FROM allowance WHERE allowance_type = $1"
+	rows, err := db.QueryContext(ctx, selectAllowance, a.AllowanceType)
 	if err != nil {
-		log.Fatal("can't select allowance list", err)
+		return Allowance{}, err
 	}
 
 	defer rows.Close()
 
-	if rows.Next() {
-		if err := rows.Scan(&result.Id, &result.AllowanceType, &result.Amount); err != nil {
-			log.Fatal("can't Scan row into variable", err)
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return Allowance{}, err
 		}
+		return Allowance{}, ErrNotFound
 	}
-
-	return result
+	if err := rows.Scan(&result.Id, &result.AllowanceType, &result.Amount, &result.Version); err != nil {
+		return Allowance{}, err
+	}
+	return result, nil
 }
 
-func SearchAllAllowance(db *sql.DB) []Allowance {
+func SearchAllAllowance(ctx context.Context, db Executor) ([]Allowance, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
 	results := make([]Allowance, 0)
-	selectAllAllowance := "SELECT id, allowance_type, amount FROM allowance"
-	rows, err := db.Query(selectAllAllowance)
+	selectAllAllowance := "SELECT id, allowance_type, amount, version FROM allowance"
+	rows, err := db.QueryContext(ctx, selectAllAllowance)
 	if err != nil {
-		log.Fatal("can't select allowance list", err)
+		return nil, err
 	}
 
 	defer rows.Close()
 
 	for rows.Next() {
 		allowance := Allowance{}
-		if err := rows.Scan(&allowance.Id, &allowance.AllowanceType, &allowance.Amount); err != nil {
-			log.Fatal("can't Scan row into variable", err)
+		if err := rows.Scan(&allowance.Id, &allowance.AllowanceType, &allowance.Amount, &allowance.Version); err != nil {
+			return nil, err
 		}
 		results = append(results, allowance)
 	}
-	return results
+	return results, rows.Err()
 }
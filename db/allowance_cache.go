@@ -0,0 +1,157 @@
+package db
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var (
+	DEFAULTALLOWANCECACHETTL = 30 * time.Second
+	ALLOWANCESETTINGSCHANNEL = "allowance_settings"
+	LISTENERMINRECONNECT     = time.Second
+	LISTENERMAXRECONNECT     = time.Minute
+	LISTENERPINGINTERVAL     = 90 * time.Second
+)
+
+type CachedAllowanceRepository struct {
+	AllowanceRepository
+	TTL        time.Duration
+	mu         *sync.Mutex
+	loaded     bool
+	generation int
+	expiresAt  time.Time
+	allowances []Allowance
+	taxLevels  []TaxLevel
+	now        func() time.Time
+}
+
+type invalidatingAllowanceRepository struct {
+	AllowanceRepository
+	changed *bool
+}
+
+func NewCachedAllowanceRepository(repository AllowanceRepository, ttl time.Duration) *CachedAllowanceRepository {
+	if ttl <= 0 {
+		ttl = DEFAULTALLOWANCECACHETTL
+	}
+	return &CachedAllowanceRepository{AllowanceRepository: repository, TTL: ttl, mu: &sync.Mutex{}, now: time.Now}
+}
+
+func (r *CachedAllowanceRepository) Invalidate() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.loaded = false
+	r.generation++
+}
+
+func (r *CachedAllowanceRepository) Settings(ctx context.Context) ([]Allowance, []TaxLevel, error) {
+	r.mu.Lock()
+	if r.loaded && r.now().Before(r.expiresAt) {
+		allowances, taxLevels := slices.Clone(r.allowances), slices.Clone(r.taxLevels)
+		r.mu.Unlock()
+		return allowances, taxLevels, nil
+	}
+	generation := r.generation
+	r.mu.Unlock()
+
+	allowances, taxLevels, err := r.AllowanceRepository.Settings(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if generation == r.generation {
+		r.loaded = true
+		r.expiresAt = r.now().Add(r.TTL)
+		r.allowances, r.taxLevels = slices.Clone(allowances), slices.Clone(taxLevels)
+	}
+	return allowances, taxLevels, nil
+}
+
+func (r *CachedAllowanceRepository) UpdateAllowanceAmount(ctx context.Context, allowanceType string, amount float64, version int) error {
+	defer r.Invalidate()
+	return r.AllowanceRepository.UpdateAllowanceAmount(ctx, allowanceType, amount, version)
+}
+
+func (r *CachedAllowanceRepository) UpsertAllowance(ctx context.Context, allowance Allowance, limit AllowanceLimit) error {
+	defer r.Invalidate()
+	return r.AllowanceRepository.UpsertAllowance(ctx, allowance, limit)
+}
+
+func (r *CachedAllowanceRepository) ReplaceTaxLevels(ctx context.Context, taxLevels []TaxLevel) error {
+	defer r.Invalidate()
+	return r.AllowanceRepository.ReplaceTaxLevels(ctx, taxLevels)
+}
+
+func (r *CachedAllowanceRepository) Transaction(ctx context.Context, run func(repository AllowanceRepository) error) error {
+	changed := false
+	err := r.AllowanceRepository.Transaction(ctx, func(repository AllowanceRepository) error {
+		return run(&invalidatingAllowanceRepository{AllowanceRepository: repository, changed: &changed})
+	})
+	if changed {
+		r.Invalidate()
+	}
+	return err
+}
+
+func (r *CachedAllowanceRepository) Listen(ctx context.Context, dataSourceName string) error {
+	listener := pq.NewListener(dataSourceName, LISTENERMINRECONNECT, LISTENERMAXRECONNECT, r.listenerEvent)
+	if err := listener.Listen(ALLOWANCESETTINGSCHANNEL); err != nil {
+		listener.Close()
+		return err
+	}
+	go func() {
+		defer listener.Close()
+		ticker := time.NewTicker(LISTENERPINGINTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-listener.Notify:
+				r.Invalidate()
+			case <-ticker.C:
+				if err := listener.Ping(); err != nil {
+					log.Println("allowance settings listener ping failed", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (r *CachedAllowanceRepository) listenerEvent(event pq.ListenerEventType, err error) {
+	if err != nil {
+		log.Println("allowance settings listener error", err)
+	}
+	switch event {
+	case pq.ListenerEventDisconnected, pq.ListenerEventReconnected, pq.ListenerEventConnectionAttemptFailed:
+		r.Invalidate()
+	}
+}
+
+func (r *invalidatingAllowanceRepository) UpdateAllowanceAmount(ctx context.Context, allowanceType string, amount float64, version int) error {
+	*r.changed = true
+	return r.AllowanceRepository.UpdateAllowanceAmount(ctx, allowanceType, amount, version)
+}
+
+func (r *invalidatingAllowanceRepository) UpsertAllowance(ctx context.Context, allowance Allowance, limit AllowanceLimit) error {
+	*r.changed = true
+	return r.AllowanceRepository.UpsertAllowance(ctx, allowance, limit)
+}
+
+func (r *invalidatingAllowanceRepository) ReplaceTaxLevels(ctx context.Context, taxLevels []TaxLevel) error {
+	*r.changed = true
+	return r.AllowanceRepository.ReplaceTaxLevels(ctx, taxLevels)
+}
+
+func (r *invalidatingAllowanceRepository) Transaction(ctx context.Context, run func(repository AllowanceRepository) error) error {
+	return r.AllowanceRepository.Transaction(ctx, func(repository AllowanceRepository) error {
+		return run(&invalidatingAllowanceRepository{AllowanceRepository: repository, changed: r.changed})
+	})
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type countingAllowanceRepository struct {
+	AllowanceRepository
+	loads int
+	err   error
+}
+
+func (r *countingAllowanceRepository) Settings(ctx context.Context) ([]Allowance, []TaxLevel, error) {
+	r.loads++
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+	return r.AllowanceRepository.Settings(ctx)
+}
+
+func mockCachedAllowanceRepository() (*CachedAllowanceRepository, *countingAllowanceRepository, *time.Time) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	counting := &countingAllowanceRepository{AllowanceRepository: NewMemoryAllowanceRepository()}
+	cache := NewCachedAllowanceRepository(counting, time.Minute)
+	cache.now = func() time.Time { return now }
+	return cache, counting, &now
+}
+
+func TestNewCachedAllowanceRepository(t *testing.T) {
+	t.Parallel()
+	if got := NewCachedAllowanceRepository(NewMemoryAllowanceRepository(), 0); got.TTL != DEFAULTALLOWANCECACHETTL {
+		t.Errorf("NewCachedAllowanceRepository() TTL = %v, want %v", got.TTL, DEFAULTALLOWANCECACHETTL)
+	}
+}
+
+func TestCachedAllowanceRepository_Settings(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		run        func(cache *CachedAllowanceRepository, now *time.Time) error
+		wantLoads  int
+		wantAmount float64
+	}{
+		{"Should load settings once while cache is fresh", func(cache *CachedAllowanceRepository, now *time.Time) error {
+			*now = now.Add(59 * time.Second)
+			return nil
+		}, 1, 60000},
+		{"Should reload settings when TTL expires", func(cache *CachedAllowanceRepository, now *time.Time) error {
+			*now = now.Add(time.Minute)
+			return nil
+		}, 2, 60000},
+		{"Should reload settings when invalidated", func(cache *CachedAllowanceRepository, now *time.Time) error {
+			cache.Invalidate()
+			return nil
+		}, 2, 60000},
+		{"Should reload settings after allowance amount is updated", func(cache *CachedAllowanceRepository, now *time.Time) error {
+			return cache.UpdateAllowanceAmount(context.Background(), "personal", 70000, 1)
+		}, 2, 70000},
+		{"Should reload settings after transaction updates allowance amount", func(cache *CachedAllowanceRepository, now *time.Time) error {
+			return cache.Transaction(context.Background(), func(repository AllowanceRepository) error {
+				return repository.Transaction(context.Background(), func(nested AllowanceRepository) error {
+					return nested.UpdateAllowanceAmount(context.Background(), "personal", 80000, 1)
+				})
+			})
+		}, 2, 80000},
+		{"Should keep cached settings after read only transaction", func(cache *CachedAllowanceRepository, now *time.Time) error {
+			return cache.Transaction(context.Background(), func(repository AllowanceRepository) error {
+				_, err := repository.SearchAllAllowanceLimit(context.Background())
+				return err
+			})
+		}, 1, 60000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache, counting, now := mockCachedAllowanceRepository()
+			if _, _, err := cache.Settings(context.Background()); err != nil {
+				t.Fatalf("CachedAllowanceRepository.Settings() error = %v", err)
+			}
+			if err := tt.run(cache, now); err != nil {
+				t.Fatalf("run() error = %v", err)
+			}
+			allowances, _, err := cache.Settings(context.Background())
+			if err != nil {
+				t.Errorf("CachedAllowanceRepository.Settings() error = %v", err)
+			}
+			if counting.loads != tt.wantLoads {
+				t.Errorf("CachedAllowanceRepository.Settings() loads = %v, want %v", counting.loads, tt.wantLoads)
+			}
+			if allowances[0].Amount != tt.wantAmount {
+				t.Errorf("CachedAllowanceRepository.Settings() personal = %v, want %v", allowances[0].Amount, tt.wantAmount)
+			}
+		})
+	}
+
+	t.Run("Should not cache errors", func(t *testing.T) {
+		cache, counting, _ := mockCachedAllowanceRepository()
+		counting.err = sql.ErrConnDone
+		if _, _, err := cache.Settings(context.Background()); !errors.Is(err, sql.ErrConnDone) {
+			t.Errorf("CachedAllowanceRepository.Settings() error = %v, want %v", err, sql.ErrConnDone)
+		}
+		counting.err = nil
+		if _, _, err := cache.Settings(context.Background()); err != nil || counting.loads != 2 {
+			t.Errorf("CachedAllowanceRepository.Settings() loads = %v, error = %v, want reload", counting.loads, err)
+		}
+	})
+}
+
+func TestCachedAllowanceRepository_listenerEvent(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		event     pq.ListenerEventType
+		wantLoads int
+	}{
+		{"Should keep cached settings when listener connects", pq.ListenerEventConnected, 1},
+		{"Should reload settings when listener disconnects", pq.ListenerEventDisconnected, 2},
+		{"Should reload settings when listener reconnects", pq.ListenerEventReconnected, 2},
+		{"Should reload settings when listener reconnect attempt fails", pq.ListenerEventConnectionAttemptFailed, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache, counting, _ := mockCachedAllowanceRepository()
+			if _, _, err := cache.Settings(context.Background()); err != nil {
+				t.Fatalf("CachedAllowanceRepository.Settings() error = %v", err)
+			}
+
+			cache.listenerEvent(tt.event, nil)
+			if _, _, err := cache.Settings(context.Background()); err != nil {
+				t.Fatalf("CachedAllowanceRepository.Settings() error = %v", err)
+			}
+			if counting.loads != tt.wantLoads {
+				t.Errorf("CachedAllowanceRepository.Settings() loads = %v, want %v", counting.loads, tt.wantLoads)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+type AllowanceLimit struct {
+	Id            int     `json:"id"`
+	AllowanceType string  `json:"allowanceType"`
+	MinAmount     float64 `json:"minAmount"`
+	MaxAmount     float64 `json:"maxAmount"`
+	MinExclusive  bool    `json:"minExclusive"`
+}
+
+func (l *AllowanceLimit) Allows(amount float64) bool {
+	if amount > l.MaxAmount {
+		return false
+	}
+	if l.MinExclusive {
+		return amount > l.MinAmount
+	}
+	return amount >= l.MinAmount
+}
+
+func (l *AllowanceLimit) Insert(ctx context.Context, db Executor) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, "INSERT INTO allowance_limit (allowance_type, min_amount, max_amount, min_exclusive) VALUES ($1,$2,$3,$4)", l.AllowanceType, l.MinAmount, l.MaxAmount, l.MinExclusive); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (l *AllowanceLimit) Upsert(ctx context.Context, db Executor) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, "INSERT INTO allowance_limit (allowance_type, min_amount, max_amount, min_exclusive) VALUES ($1,$2,$3,$4) ON CONFLICT (allowance_type) DO UPDATE SET min_amount = EXCLUDED.min_amount, max_amount = EXCLUDED.max_amount, min_exclusive = EXCLUDED.min_exclusive", l.AllowanceType, l.MinAmount, l.MaxAmount, l.MinExclusive); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (l *AllowanceLimit) SearchByType(ctx context.Context, db Executor) (AllowanceLimit, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	result := AllowanceLimit{}
+	selectAllowanceLimit := "SELECT id, allowance_type, min_amount, max_amount, min_exclusive FROM allowance_limit WHERE allowance_type = $1"
+	err := db.QueryRowContext(ctx, selectAllowanceLimit, l.AllowanceType).Scan(&result.Id, &result.AllowanceType, &result.MinAmount, &result.MaxAmount, &result.MinExclusive)
+	if errors.Is(err, sql.ErrNoRows) {
+		return AllowanceLimit{}, ErrNotFound
+	}
+	if err != nil {
+		return AllowanceLimit{}, err
+	}
+	return result, nil
+}
+
+func SearchAllAllowanceLimit(ctx context.Context, db Executor) ([]AllowanceLimit, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	results := make([]AllowanceLimit, 0)
+	selectAllAllowanceLimit := "SELECT id, allowance_type, min_amount, max_amount, min_exclusive FROM allowance_limit ORDER BY id"
+	rows, err := db.QueryContext(ctx, selectAllAllowanceLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		limit := AllowanceLimit{}
+		if err := rows.Scan(&limit.Id, &limit.AllowanceType, &limit.MinAmount, &limit.MaxAmount, &limit.MinExclusive); err != nil {
+			return nil, err
+		}
+		results = append(results, limit)
+	}
+	return results, rows.Err()
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func mockAllowanceLimitDb(t *testing.T) *sql.DB {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	mock.MatchExpectationsInOrder(false)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	columns := []string{"id", "allowance_type", "min_amount", "max_amount", "min_exclusive"}
+	rowsPersonal := mock.NewRows(columns).
+		AddRow(1, "personal", 10000.00, 100000.00, false)
+	rowsAll := mock.NewRows(columns).
+		AddRow(1, "personal", 10000.00, 100000.00, false).
+		AddRow(3, "k-receipt", 0.00, 100000.00, true)
+	insertAllowanceLimitSql := "INSERT INTO allowance_limit (allowance_type, min_amount, max_amount, min_exclusive) VALUES ($1,$2,$3,$4)"
+	searchByTypeSql := "SELECT id, allowance_type, min_amount, max_amount, min_exclusive FROM allowance_limit WHERE allowance_type = $1"
+	searchAllSql := "SELECT id, allowance_type, min_amount, max_amount, min_exclusive FROM allowance_limit ORDER BY id"
+
+	mock.ExpectQuery(searchByTypeSql).WithArgs("personal").WillReturnRows(rowsPersonal)
+	mock.ExpectQuery(searchByTypeSql).WithArgs("insurance").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(searchAllSql).WillReturnRows(rowsAll)
+	mock.ExpectExec(insertAllowanceLimitSql).WithArgs("donation", 0.00, 100000.00, true).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(insertAllowanceLimitSql).WithArgs("mockError", 0.00, 100000.00, true).WillReturnError(sql.ErrConnDone)
+	upsertAllowanceLimitSql := "INSERT INTO allowance_limit (allowance_type, min_amount, max_amount, min_exclusive) VALUES ($1,$2,$3,$4) ON CONFLICT (allowance_type) DO UPDATE SET min_amount = EXCLUDED.min_amount, max_amount = EXCLUDED.max_amount, min_exclusive = EXCLUDED.min_exclusive"
+	mock.ExpectExec(upsertAllowanceLimitSql).WithArgs("personal", 10000.00, 200000.00, false).WillReturnResult(sqlmock.NewResult(1, 1))
+	return db
+}
+
+func TestAllowanceLimit_Allows(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name   string
+		limit  AllowanceLimit
+		amount float64
+		want   bool
+	}{
+		{"Should allow amount equal to inclusive minimum", AllowanceLimit{MinAmount: 10000, MaxAmount: 100000}, 10000, true},
+		{"Should not allow amount below inclusive minimum", AllowanceLimit{MinAmount: 10000, MaxAmount: 100000}, 9999, false},
+		{"Should not allow amount equal to exclusive minimum", AllowanceLimit{MinAmount: 0, MaxAmount: 100000, MinExclusive: true}, 0, false},
+		{"Should allow amount above exclusive minimum", AllowanceLimit{MinAmount: 0, MaxAmount: 100000, MinExclusive: true}, 1, true},
+		{"Should allow amount equal to maximum", AllowanceLimit{MinAmount: 0, MaxAmount: 100000}, 100000, true},
+		{"Should not allow amount above maximum", AllowanceLimit{MinAmount: 0, MaxAmount: 100000}, 100001, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.limit.Allows(tt.amount); got != tt.want {
+				t.Errorf("AllowanceLimit.Allows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowanceLimit_SearchByType(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name          string
+		allowanceType string
+		want          AllowanceLimit
+		wantErr       error
+	}{
+		{"Should return limit for 'personal' type correctly", "personal", AllowanceLimit{Id: 1, AllowanceType: "personal", MinAmount: 10000, MaxAmount: 100000}, nil},
+		{"Should return ErrNotFound for any type that does not exist in database", "insurance", AllowanceLimit{}, ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := (&AllowanceLimit{AllowanceType: tt.allowanceType}).SearchByType(context.Background(), mockAllowanceLimitDb(t))
+			if err != tt.wantErr {
+				t.Errorf("AllowanceLimit.SearchByType() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AllowanceLimit.SearchByType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchAllAllowanceLimit(t *testing.T) {
+	t.Parallel()
+	want := []AllowanceLimit{{Id: 1, AllowanceType: "personal", MinAmount: 10000, MaxAmount: 100000}, {Id: 3, AllowanceType: "k-receipt", MinAmount: 0, MaxAmount: 100000, MinExclusive: true}}
+	got, err := SearchAllAllowanceLimit(context.Background(), mockAllowanceLimitDb(t))
+	if err != nil {
+		t.Errorf("SearchAllAllowanceLimit() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SearchAllAllowanceLimit() = %v, want %v", got, want)
+	}
+}
+
+func TestAllowanceLimit_Insert(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name  string
+		limit AllowanceLimit
+		want  error
+	}{
+		{"Should return nil when inserting allowance limit successfully", AllowanceLimit{AllowanceType: "donation", MinAmount: 0, MaxAmount: 100000, MinExclusive: true}, nil},
+		{"Should return error when inserting allowance limit unsuccessfully", AllowanceLimit{AllowanceType: "mockError", MinAmount: 0, MaxAmount: 100000, MinExclusive: true}, sql.ErrConnDone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.limit.Insert(context.Background(), mockAllowanceLimitDb(t)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AllowanceLimit.Insert() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowanceLimit_Upsert(t *testing.T) {
+	t.Parallel()
+	limit := AllowanceLimit{AllowanceType: "personal", MinAmount: 10000, MaxAmount: 200000}
+	if got := limit.Upsert(context.Background(), mockAllowanceLimitDb(t)); got != nil {
+		t.Errorf("AllowanceLimit.Upsert() = %v, want nil", got)
+	}
+}
@@ -0,0 +1,177 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrNotFound = errors.New("record does not exist")
+
+type AllowanceRepository interface {
+	Settings(ctx context.Context) ([]Allowance, []TaxLevel, error)
+	SearchAllAllowance(ctx context.Context) ([]Allowance, error)
+	SearchAllAllowanceLimit(ctx context.Context) ([]AllowanceLimit, error)
+	SearchAllowanceLimitByType(ctx context.Context, allowanceType string) (AllowanceLimit, error)
+	SearchAllowanceByType(ctx context.Context, allowanceType string) (Allowance, error)
+	SearchAllTaxLevel(ctx context.Context) ([]TaxLevel, error)
+	UpdateAllowanceAmount(ctx context.Context, allowanceType string, amount float64, version int) error
+	UpsertAllowance(ctx context.Context, allowance Allowance, limit AllowanceLimit) error
+	ReplaceTaxLevels(ctx context.Context, taxLevels []TaxLevel) error
+	InsertChangeRequest(ctx context.Context, changeRequest *ChangeRequest) error
+	SearchChangeRequestById(ctx context.Context, id int) (ChangeRequest, error)
+	SearchChangeRequestByStatus(ctx context.Context, status string) ([]ChangeRequest, error)
+	ExpireChangeRequests(ctx context.Context, now time.Time) error
+	UpdateChangeRequestStatus(ctx context.Context, changeRequest *ChangeRequest) error
+	InsertConfigImportRequest(ctx context.Context, importRequest *ConfigImportRequest) error
+	SearchConfigImportRequestById(ctx context.Context, id int) (ConfigImportRequest, error)
+	SearchConfigImportRequestByStatus(ctx context.Context, status string) ([]ConfigImportRequest, error)
+	ExpireConfigImportRequests(ctx context.Context, now time.Time) error
+	UpdateConfigImportRequestStatus(ctx context.Context, importRequest *ConfigImportRequest) error
+	Transaction(ctx context.Context, run func(repository AllowanceRepository) error) error
+}
+
+type SQLAllowanceRepository struct {
+	DB       *sql.DB
+	executor Executor
+}
+
+func NewSQLAllowanceRepository(db *sql.DB) *SQLAllowanceRepository {
+	return &SQLAllowanceRepository{DB: db, executor: db}
+}
+
+func (r *SQLAllowanceRepository) inTransaction() bool {
+	_, ok := r.executor.(*sql.Tx)
+	return ok
+}
+
+func (r *SQLAllowanceRepository) Settings(ctx context.Context) ([]Allowance, []TaxLevel, error) {
+	if r.inTransaction() {
+		allowances, err := SearchAllAllowance(ctx, r.executor)
+		if err != nil {
+			return nil, nil, err
+		}
+		taxLevels, err := SearchAllTaxLevel(ctx, r.executor)
+		if err != nil {
+			return nil, nil, err
+		}
+		return allowances, taxLevels, nil
+	}
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	allowances, err := SearchAllAllowance(ctx, tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	taxLevels, err := SearchAllTaxLevel(ctx, tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return allowances, taxLevels, nil
+}
+
+func (r *SQLAllowanceRepository) SearchAllAllowance(ctx context.Context) ([]Allowance, error) {
+	return SearchAllAllowance(ctx, r.executor)
+}
+
+func (r *SQLAllowanceRepository) SearchAllAllowanceLimit(ctx context.Context) ([]AllowanceLimit, error) {
+	return SearchAllAllowanceLimit(ctx, r.executor)
+}
+
+func (r *SQLAllowanceRepository) SearchAllowanceLimitByType(ctx context.Context, allowanceType string) (AllowanceLimit, error) {
+	return (&AllowanceLimit{AllowanceType: allowanceType}).SearchByType(ctx, r.executor)
+}
+
+func (r *SQLAllowanceRepository) SearchAllowanceByType(ctx context.Context, allowanceType string) (Allowance, error) {
+	return (&Allowance{AllowanceType: allowanceType}).SearchByType(ctx, r.executor)
+}
+
+func (r *SQLAllowanceRepository) SearchAllTaxLevel(ctx context.Context) ([]TaxLevel, error) {
+	return SearchAllTaxLevel(ctx, r.executor)
+}
+
+func (r *SQLAllowanceRepository) UpdateAllowanceAmount(ctx context.Context, allowanceType string, amount float64, version int) error {
+	return (&Allowance{AllowanceType: allowanceType, Amount: amount, Version: version}).UpdateByType(ctx, r.executor)
+}
+
+func (r *SQLAllowanceRepository) UpsertAllowance(ctx context.Context, allowance Allowance, limit AllowanceLimit) error {
+	if err := allowance.Upsert(ctx, r.executor); err != nil {
+		return err
+	}
+	return limit.Upsert(ctx, r.executor)
+}
+
+func (r *SQLAllowanceRepository) ReplaceTaxLevels(ctx context.Context, taxLevels []TaxLevel) error {
+	if err := DeleteAllTaxLevel(ctx, r.executor); err != nil {
+		return err
+	}
+	for _, taxLevel := range taxLevels {
+		if err := taxLevel.Insert(ctx, r.executor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *SQLAllowanceRepository) InsertChangeRequest(ctx context.Context, changeRequest *ChangeRequest) error {
+	return changeRequest.Insert(ctx, r.executor)
+}
+
+func (r *SQLAllowanceRepository) SearchChangeRequestById(ctx context.Context, id int) (ChangeRequest, error) {
+	return SearchChangeRequestById(ctx, r.executor, id)
+}
+
+func (r *SQLAllowanceRepository) SearchChangeRequestByStatus(ctx context.Context, status string) ([]ChangeRequest, error) {
+	return SearchChangeRequestByStatus(ctx, r.executor, status)
+}
+
+func (r *SQLAllowanceRepository) ExpireChangeRequests(ctx context.Context, now time.Time) error {
+	return ExpireChangeRequests(ctx, r.executor, now)
+}
+
+func (r *SQLAllowanceRepository) UpdateChangeRequestStatus(ctx context.Context, changeRequest *ChangeRequest) error {
+	return changeRequest.UpdateStatus(ctx, r.executor)
+}
+
+func (r *SQLAllowanceRepository) InsertConfigImportRequest(ctx context.Context, importRequest *ConfigImportRequest) error {
+	return importRequest.Insert(ctx, r.executor)
+}
+
+func (r *SQLAllowanceRepository) SearchConfigImportRequestById(ctx context.Context, id int) (ConfigImportRequest, error) {
+	return SearchConfigImportRequestById(ctx, r.executor, id)
+}
+
+func (r *SQLAllowanceRepository) SearchConfigImportRequestByStatus(ctx context.Context, status string) ([]ConfigImportRequest, error) {
+	return SearchConfigImportRequestByStatus(ctx, r.executor, status)
+}
+
+func (r *SQLAllowanceRepository) ExpireConfigImportRequests(ctx context.Context, now time.Time) error {
+	return ExpireConfigImportRequests(ctx, r.executor, now)
+}
+
+func (r *SQLAllowanceRepository) UpdateConfigImportRequestStatus(ctx context.Context, importRequest *ConfigImportRequest) error {
+	return importRequest.UpdateStatus(ctx, r.executor)
+}
+
+func (r *SQLAllowanceRepository) Transaction(ctx context.Context, run func(repository AllowanceRepository) error) error {
+	if r.inTransaction() {
+		return run(r)
+	}
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := run(&SQLAllowanceRepository{DB: r.DB, executor: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSQLAllowanceRepository_Settings(t *testing.T) {
+	t.Parallel()
+	db := mockJobDb(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id, allowance_type, amount, version FROM allowance").WillReturnRows(mock.NewRows([]string{"id", "allowance_type", "amount", "version"}).AddRow(1, "personal", 60000.00, 1))
+		mock.ExpectQuery("SELECT id, name, start_amount, end_amount, percentage FROM tax_level ORDER BY start_amount").WillReturnError(sql.ErrConnDone)
+		mock.ExpectRollback()
+	})
+	defer db.Close()
+
+	t.Run("Should return error when loading tax levels fails", func(t *testing.T) {
+		if _, _, err := NewSQLAllowanceRepository(db).Settings(context.Background()); err != sql.ErrConnDone {
+			t.Errorf("SQLAllowanceRepository.Settings() error = %v, want %v", err, sql.ErrConnDone)
+		}
+	})
+}
+
+func TestSQLAllowanceRepository_Transaction(t *testing.T) {
+	t.Parallel()
+	updateAllowanceSql := "UPDATE allowance SET amount = $1, version = version + 1 WHERE allowance_type = $2 AND version = $3"
+	tests := []struct {
+		name    string
+		setup   func(mock sqlmock.Sqlmock)
+		run     func(repository AllowanceRepository) error
+		wantErr error
+	}{
+		{"Should commit when every statement succeeds", func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectExec(updateAllowanceSql).WithArgs(70000.00, "personal", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		}, func(repository AllowanceRepository) error {
+			return repository.Transaction(context.Background(), func(nested AllowanceRepository) error {
+				return nested.UpdateAllowanceAmount(context.Background(), "personal", 70000, 1)
+			})
+		}, nil},
+		{"Should rollback when a statement fails", func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectExec(updateAllowanceSql).WithArgs(70000.00, "personal", 1).WillReturnError(sql.ErrConnDone)
+			mock.ExpectRollback()
+		}, func(repository AllowanceRepository) error {
+			return repository.UpdateAllowanceAmount(context.Background(), "personal", 70000, 1)
+		}, sql.ErrConnDone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := mockMigrationDb(t, tt.setup)
+			defer db.Close()
+
+			err := NewSQLAllowanceRepository(db).Transaction(context.Background(), tt.run)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("SQLAllowanceRepository.Transaction() error = %v, want %v", err, tt.wantErr)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"github.com/DATA-DOG/go-sqlmock"
 	"reflect"
@@ -14,46 +15,43 @@ func mockAllowanceDb(t *testing.T) *sql.DB {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 
-	rowsDonation := mock.NewRows([]string{"id", "allowance_type", "amount"}).
-		AddRow(2, "donation", 100000.00)
-	rowsPersonal := mock.NewRows([]string{"id", "allowance_type", "amount"}).
-		AddRow(1, "personal", 60000.00)
-	rowsAll := mock.NewRows([]string{"id", "allowance_type", "amount"}).
-		AddRow(1, "personal", 60000.00).
-		AddRow(2, "donation", 100000.00)
+	rowsDonation := mock.NewRows([]string{"id", "allowance_type", "amount", "version"}).
+		AddRow(2, "donation", 100000.00, 1)
+	rowsPersonal := mock.NewRows([]string{"id", "allowance_type", "amount", "version"}).
+		AddRow(1, "personal", 60000.00, 1)
+	rowsAll := mock.NewRows([]string{"id", "allowance_type", "amount", "version"}).
+		AddRow(1, "personal", 60000.00, 1).
+		AddRow(2, "donation", 100000.00, 1)
 	insertAllowanceSql := "INSERT INTO allowance (allowance_type, amount) VALUES ($1,$2)"
-	updateAllowanceSql := "UPDATE allowance SET amount = $1 WHERE allowance_type = $2"
-	createTableSql := "CREATE TABLE IF NOT EXISTS allowance ( id SERIAL PRIMARY KEY, allowance_type TEXT UNIQUE, amount float)"
+	updateAllowanceSql := "UPDATE allowance SET amount = $1, version = version + 1 WHERE allowance_type = $2 AND version = $3"
 
-	SearchByTypeSql := "SELECT id, allowance_type, amount FROM allowance WHERE allowance_type = $1"
-	searchAllAllowanceSql := "SELECT id, allowance_type, amount FROM allowance"
+	SearchByTypeSql := "SELECT id, allowance_type, amount, version FROM allowance WHERE allowance_type = $1"
+	searchAllAllowanceSql := "SELECT id, allowance_type, amount, version FROM allowance"
 	mock.ExpectQuery(SearchByTypeSql).WithArgs("personal").WillReturnRows(rowsPersonal)
 	mock.ExpectQuery(SearchByTypeSql).WithArgs("donation").WillReturnRows(rowsDonation)
-	mock.ExpectQuery(SearchByTypeSql).WithArgs("insurance").WillReturnRows(mock.NewRows([]string{"id", "allowance_type", "amount"}))
+	mock.ExpectQuery(SearchByTypeSql).WithArgs("insurance").WillReturnRows(mock.NewRows([]string{"id", "allowance_type", "amount", "version"}))
 	mock.ExpectQuery(searchAllAllowanceSql).WillReturnRows(rowsAll)
 	mock.ExpectExec(insertAllowanceSql).WithArgs("donation", 60000.00).WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectExec(insertAllowanceSql).WithArgs("mockError", 60000.00).WillReturnError(sql.ErrConnDone)
-	mock.ExpectExec(updateAllowanceSql).WithArgs(70000.00, "personal").WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectExec(updateAllowanceSql).WithArgs(80000.00, "mockError").WillReturnError(sql.ErrConnDone)
-	mock.ExpectExec(createTableSql).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(updateAllowanceSql).WithArgs(70000.00, "personal", 1).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(updateAllowanceSql).WithArgs(75000.00, "personal", 2).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(updateAllowanceSql).WithArgs(70000.00, "insurance", 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(updateAllowanceSql).WithArgs(80000.00, "mockError", 1).WillReturnError(sql.ErrConnDone)
+	upsertAllowanceSql := "INSERT INTO allowance (allowance_type, amount) VALUES ($1,$2) ON CONFLICT (allowance_type) DO UPDATE SET amount = EXCLUDED.amount, version = allowance.version + 1 WHERE allowance.amount IS DISTINCT FROM EXCLUDED.amount"
+	mock.ExpectExec(upsertAllowanceSql).WithArgs("personal", 70000.00).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(upsertAllowanceSql).WithArgs("mockError", 70000.00).WillReturnError(sql.ErrConnDone)
 	return db
 }
 
-func Test_getAllowanceDefaultValues(t *testing.T) {
-	t.Parallel()
-	tests := []struct {
-		name string
-		want []Allowance
-	}{
-		{"Should return list of allowance correctly", []Allowance{{AllowanceType: "personal", Amount: 60000}, {AllowanceType: "donation", Amount: 100000.00}, {AllowanceType: "k-receipt", Amount: 50000.00}}},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := getAllowanceDefaultValues(); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("getAllowanceDefaultValues() = %v, want %v", got, tt.want)
-			}
-		})
+func mockAllowanceErrorDb(t *testing.T) *sql.DB {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery("SELECT id, allowance_type, amount, version FROM allowance").WillReturnError(sql.ErrConnDone)
+	mock.ExpectQuery("SELECT id, allowance_type, amount, version FROM allowance WHERE allowance_type = $1").WithArgs("personal").WillReturnError(sql.ErrConnDone)
+	return db
 }
 
 func TestSearchAllAllowance(t *testing.T) {
@@ -62,15 +60,21 @@ func TestSearchAllAllowance(t *testing.T) {
 		db *sql.DB
 	}
 	tests := []struct {
-		name string
-		args args
-		want []Allowance
+		name    string
+		args    args
+		want    []Allowance
+		wantErr error
 	}{
-		{"Should return all allowances correctly", args{db: mockAllowanceDb(t)}, []Allowance{{Id: 1, AllowanceType: "personal", Amount: 60000}, {Id: 2, AllowanceType: "donation", Amount: 100000.00}}},
+		{"Should return all allowances correctly", args{db: mockAllowanceDb(t)}, []Allowance{{Id: 1, AllowanceType: "personal", Amount: 60000, Version: 1}, {Id: 2, AllowanceType: "donation", Amount: 100000.00, Version: 1}}, nil},
+		{"Should return error when query fails", args{db: mockAllowanceErrorDb(t)}, nil, sql.ErrConnDone},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := SearchAllAllowance(tt.args.db); !reflect.DeepEqual(got, tt.want) {
+			got, err := SearchAllAllowance(context.Background(), tt.args.db)
+			if err != tt.wantErr {
+				t.Errorf("SearchAllAllowance() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("SearchAllAllowance() = %v, want %v", got, tt.want)
 			}
 		})
@@ -88,14 +92,16 @@ func TestAllowance_SearchByType(t *testing.T) {
 		db *sql.DB
 	}
 	tests := []struct {
-		name   string
-		fields fields
-		args   args
-		want   Allowance
+		name    string
+		fields  fields
+		args    args
+		want    Allowance
+		wantErr error
 	}{
-		{"Should return empty allowance for any type that does not exist in database", fields{AllowanceType: "insurance"}, args{db: mockAllowanceDb(t)}, Allowance{}},
-		{"Should return allowance for 'personal' type correctly", fields{AllowanceType: "personal"}, args{db: mockAllowanceDb(t)}, Allowance{Id: 1, AllowanceType: "personal", Amount: 60000}},
-		{"Should return allowance for 'donation' type correctly", fields{AllowanceType: "donation"}, args{db: mockAllowanceDb(t)}, Allowance{Id: 2, AllowanceType: "donation", Amount: 100000}},
+		{"Should return ErrNotFound for any type that does not exist in database", fields{AllowanceType: "insurance"}, args{db: mockAllowanceDb(t)}, Allowance{}, ErrNotFound},
+		{"Should return allowance for 'personal' type correctly", fields{AllowanceType: "personal"}, args{db: mockAllowanceDb(t)}, Allowance{Id: 1, AllowanceType: "personal", Amount: 60000, Version: 1}, nil},
+		{"Should return allowance for 'donation' type correctly", fields{AllowanceType: "donation"}, args{db: mockAllowanceDb(t)}, Allowance{Id: 2, AllowanceType: "donation", Amount: 100000, Version: 1}, nil},
+		{"Should return error when query fails", fields{AllowanceType: "personal"}, args{db: mockAllowanceErrorDb(t)}, Allowance{}, sql.ErrConnDone},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -104,7 +110,11 @@ func TestAllowance_SearchByType(t *testing.T) {
 				AllowanceType: tt.fields.AllowanceType,
 				Amount:        tt.fields.Amount,
 			}
-			if got := a.SearchByType(tt.args.db); !reflect.DeepEqual(got, tt.want) {
+			got, err := a.SearchByType(context.Background(), tt.args.db)
+			if err != tt.wantErr {
+				t.Errorf("Allowance.SearchByType() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("Allowance.SearchByType() = %v, want %v", got, tt.want)
 			}
 		})
@@ -137,62 +147,53 @@ func TestAllowance_insert(t *testing.T) {
 				AllowanceType: tt.fields.AllowanceType,
 				Amount:        tt.fields.Amount,
 			}
-			if got := a.Insert(tt.args.db); !reflect.DeepEqual(got, tt.want) {
+			if got := a.Insert(context.Background(), tt.args.db); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("Allowance.Insert() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func TestAllowance_createAllowanceTable(t *testing.T) {
+func TestAllowance_UpdateByType(t *testing.T) {
 	t.Parallel()
-	type args struct {
-		db *sql.DB
-	}
 	tests := []struct {
-		name string
-		args args
-		want error
+		name        string
+		allowance   Allowance
+		want        error
+		wantVersion int
 	}{
-		{"Should return nil when creating allowance table successfully", args{db: mockAllowanceDb(t)}, nil},
+		{"Should return nil and increase version when updating allowance successfully", Allowance{AllowanceType: "personal", Amount: 70000.00, Version: 1}, nil, 2},
+		{"Should return ErrAllowanceVersionConflict when version is stale", Allowance{AllowanceType: "personal", Amount: 75000.00, Version: 2}, ErrAllowanceVersionConflict, 2},
+		{"Should return ErrNotFound when allowance type does not exist", Allowance{AllowanceType: "insurance", Amount: 70000.00, Version: 1}, ErrNotFound, 1},
+		{"Should return error when updating allowance unsuccessfully", Allowance{AllowanceType: "mockError", Amount: 80000.00, Version: 1}, sql.ErrConnDone, 1},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := createAllowanceTable(tt.args.db); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("Allowance.createAllowanceTable() = %v, want %v", got, tt.want)
+			a := tt.allowance
+			if got := a.UpdateByType(context.Background(), mockAllowanceDb(t)); got != tt.want {
+				t.Errorf("Allowance.UpdateByType() = %v, want %v", got, tt.want)
+			}
+			if a.Version != tt.wantVersion {
+				t.Errorf("Allowance.UpdateByType() version = %v, want %v", a.Version, tt.wantVersion)
 			}
 		})
 	}
 }
 
-func TestAllowance_UpdateByType(t *testing.T) {
+func TestAllowance_Upsert(t *testing.T) {
 	t.Parallel()
-	type fields struct {
-		Id            int
-		AllowanceType string
-		Amount        float64
-	}
-	type args struct {
-		db *sql.DB
-	}
 	tests := []struct {
-		name   string
-		fields fields
-		args   args
-		want   error
+		name      string
+		allowance Allowance
+		want      error
 	}{
-		{"Should return nil when updating allowance successfully", fields{AllowanceType: "personal", Amount: 70000.00}, args{db: mockAllowanceDb(t)}, nil},
-		{"Should return error when updating allowance unsuccessfully", fields{AllowanceType: "mockError", Amount: 80000.00}, args{db: mockAllowanceDb(t)}, sql.ErrConnDone},
+		{"Should return nil when upserting allowance successfully", Allowance{AllowanceType: "personal", Amount: 70000.00}, nil},
+		{"Should return error when upserting allowance unsuccessfully", Allowance{AllowanceType: "mockError", Amount: 70000.00}, sql.ErrConnDone},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			a := &Allowance{
-				Id:            tt.fields.Id,
-				AllowanceType: tt.fields.AllowanceType,
-				Amount:        tt.fields.Amount,
-			}
-			if got := a.UpdateByType(tt.args.db); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("Allowance.UpdateByType() = %v, want %v", got, tt.want)
+			if got := tt.allowance.Upsert(context.Background(), mockAllowanceDb(t)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Allowance.Upsert() = %v, want %v", got, tt.want)
 			}
 		})
 	}
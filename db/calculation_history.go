@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type CalculationHistory struct {
+	Id            int             `json:"id"`
+	TaxpayerId    string          `json:"taxpayerId,omitempty"`
+	TaxYear       int             `json:"taxYear"`
+	Source        string          `json:"source"`
+	Input         json.RawMessage `json:"input"`
+	Output        json.RawMessage `json:"output"`
+	ConfigVersion string          `json:"configVersion"`
+	CreatedAt     time.Time       `json:"createdAt"`
+}
+
+type CalculationHistoryFilter struct {
+	TaxpayerId string
+	TaxYear    int
+	Limit      int
+	Offset     int
+}
+
+func scanCalculationHistory(scanner interface{ Scan(dest ...any) error }) (CalculationHistory, error) {
+	result := CalculationHistory{}
+	var input, output []byte
+	if err := scanner.Scan(&result.Id, &result.TaxpayerId, &result.TaxYear, &result.Source, &input, &output, &result.ConfigVersion, &result.CreatedAt); err != nil {
+		return CalculationHistory{}, err
+	}
+	result.Input, result.Output = input, output
+	return result, nil
+}
+
+func (ch *CalculationHistory) Insert(ctx context.Context, db Executor) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	insertCalculationHistory := "INSERT INTO calculation_history (taxpayer_id, tax_year, source, input, output, config_version, created_at) VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING id"
+	if err := db.QueryRowContext(ctx, insertCalculationHistory, ch.TaxpayerId, ch.TaxYear, ch.Source, string(ch.Input), string(ch.Output), ch.ConfigVersion, ch.CreatedAt).Scan(&ch.Id); err != nil {
+		return err
+	}
+	return nil
+}
+
+func SearchCalculationHistoryById(ctx context.Context, db Executor, id int) (CalculationHistory, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	selectCalculationHistory := "SELECT id, taxpayer_id, tax_year, source, input, output, config_version, created_at FROM calculation_history WHERE id = $1"
+	history, err := scanCalculationHistory(db.QueryRowContext(ctx, selectCalculationHistory, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return CalculationHistory{}, ErrNotFound
+	}
+	return history, err
+}
+
+func SearchCalculationHistory(ctx context.Context, db Executor, filter CalculationHistoryFilter) ([]CalculationHistory, int, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	conditions := make([]string, 0)
+	args := make([]any, 0)
+	if filter.TaxpayerId != "" {
+		args = append(args, filter.TaxpayerId)
+		conditions = append(conditions, fmt.Sprintf("taxpayer_id = $%d", len(args)))
+	}
+	if filter.TaxYear != 0 {
+		args = append(args, filter.TaxYear)
+		conditions = append(conditions, fmt.Sprintf("tax_year = $%d", len(args)))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	total := 0
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM calculation_history"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	args = append(args, filter.Limit, filter.Offset)
+	selectCalculationHistory := fmt.Sprintf("SELECT id, taxpayer_id, tax_year, source, input, output, config_version, created_at FROM calculation_history%s ORDER BY id DESC LIMIT $%d OFFSET $%d", where, len(args)-1, len(args))
+	rows, err := db.QueryContext(ctx, selectCalculationHistory, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	result := make([]CalculationHistory, 0)
+	for rows.Next() {
+		history, err := scanCalculationHistory(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		result = append(result, history)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return result, total, nil
+}
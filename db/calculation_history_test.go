@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var calculationHistoryColumns = []string{"id", "taxpayer_id", "tax_year", "source", "input", "output", "config_version", "created_at"}
+
+func mockCalculationHistory(id int, now time.Time) CalculationHistory {
+	return CalculationHistory{Id: id, TaxpayerId: "T001", TaxYear: 2024, Source: "api", Input: json.RawMessage(`{"totalIncome":500000}`), Output: json.RawMessage(`{"tax":29000}`), ConfigVersion: "abc", CreatedAt: now}
+}
+
+func TestCalculationHistory_Insert(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	db := mockJobDb(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("INSERT INTO calculation_history (taxpayer_id, tax_year, source, input, output, config_version, created_at) VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING id").
+			WithArgs("T001", 2024, "api", `{"totalIncome":500000}`, `{"tax":29000}`, "abc", now).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	})
+	defer db.Close()
+
+	history := mockCalculationHistory(0, now)
+	if err := history.Insert(context.Background(), db); err != nil {
+		t.Errorf("CalculationHistory.Insert() error = %v", err)
+	}
+	if history.Id != 5 {
+		t.Errorf("CalculationHistory.Insert() id = %v, want %v", history.Id, 5)
+	}
+}
+
+func TestSearchCalculationHistoryById(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	selectCalculationHistory := "SELECT id, taxpayer_id, tax_year, source, input, output, config_version, created_at FROM calculation_history WHERE id = $1"
+	tests := []struct {
+		name    string
+		id      int
+		want    CalculationHistory
+		wantErr error
+	}{
+		{"Should return calculation when id exists", 1, mockCalculationHistory(1, now), nil},
+		{"Should return ErrNotFound when id does not exist", 2, CalculationHistory{}, ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := mockJobDb(t, func(mock sqlmock.Sqlmock) {
+				rows := mock.NewRows(calculationHistoryColumns)
+				if tt.wantErr == nil {
+					rows.AddRow(1, "T001", 2024, "api", []byte(`{"totalIncome":500000}`), []byte(`{"tax":29000}`), "abc", now)
+				}
+				mock.ExpectQuery(selectCalculationHistory).WithArgs(tt.id).WillReturnRows(rows)
+			})
+			defer db.Close()
+
+			got, err := SearchCalculationHistoryById(context.Background(), db, tt.id)
+			if err != tt.wantErr {
+				t.Errorf("SearchCalculationHistoryById() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SearchCalculationHistoryById() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchCalculationHistory(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		filter     CalculationHistoryFilter
+		countSql   string
+		selectSql  string
+		filterArgs []any
+	}{
+		{"Should search all calculations when filter is empty", CalculationHistoryFilter{Limit: 20},
+			"SELECT COUNT(*) FROM calculation_history",
+			"SELECT id, taxpayer_id, tax_year, source, input, output, config_version, created_at FROM calculation_history ORDER BY id DESC LIMIT $1 OFFSET $2", nil},
+		{"Should search calculations by taxpayer and year", CalculationHistoryFilter{TaxpayerId: "T001", TaxYear: 2024, Limit: 10, Offset: 10},
+			"SELECT COUNT(*) FROM calculation_history WHERE taxpayer_id = $1 AND tax_year = $2",
+			"SELECT id, taxpayer_id, tax_year, source, input, output, config_version, created_at FROM calculation_history WHERE taxpayer_id = $1 AND tax_year = $2 ORDER BY id DESC LIMIT $3 OFFSET $4", []any{"T001", 2024}},
+		{"Should search calculations by year only", CalculationHistoryFilter{TaxYear: 2024, Limit: 5},
+			"SELECT COUNT(*) FROM calculation_history WHERE tax_year = $1",
+			"SELECT id, taxpayer_id, tax_year, source, input, output, config_version, created_at FROM calculation_history WHERE tax_year = $1 ORDER BY id DESC LIMIT $2 OFFSET $3", []any{2024}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := mockJobDb(t, func(mock sqlmock.Sqlmock) {
+				countArgs := make([]driver.Value, 0)
+				for _, arg := range tt.filterArgs {
+					countArgs = append(countArgs, arg)
+				}
+				mock.ExpectQuery(tt.countSql).WithArgs(countArgs...).WillReturnRows(mock.NewRows([]string{"count"}).AddRow(21))
+				mock.ExpectQuery(tt.selectSql).WithArgs(append(countArgs, tt.filter.Limit, tt.filter.Offset)...).
+					WillReturnRows(mock.NewRows(calculationHistoryColumns).AddRow(1, "T001", 2024, "api", []byte(`{"totalIncome":500000}`), []byte(`{"tax":29000}`), "abc", now))
+			})
+			defer db.Close()
+
+			got, total, err := SearchCalculationHistory(context.Background(), db, tt.filter)
+			if err != nil {
+				t.Errorf("SearchCalculationHistory() error = %v", err)
+			}
+			if total != 21 {
+				t.Errorf("SearchCalculationHistory() total = %v, want %v", total, 21)
+			}
+			if want := []CalculationHistory{mockCalculationHistory(1, now)}; !reflect.DeepEqual(got, want) {
+				t.Errorf("SearchCalculationHistory() = %v, want %v", got, want)
+			}
+		})
+	}
+}
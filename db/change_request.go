@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	PENDING    = "pending"
+	APPROVED   = "approved"
+	REJECTED   = "rejected"
+	EXPIRED    = "expired"
+	SUPERSEDED = "superseded"
+	CANCELLED  = "cancelled"
+)
+
+var ErrChangeRequestNotPending = errors.New("change request is no longer pending")
+
+type ChangeRequest struct {
+	Id               int        `json:"id"`
+	AllowanceType    string     `json:"allowanceType"`
+	Amount           float64    `json:"amount"`
+	AllowanceVersion int        `json:"allowanceVersion"`
+	Status           string     `json:"status"`
+	RequestedBy      string     `json:"requestedBy"`
+	ReviewedBy       string     `json:"reviewedBy,omitempty"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	ExpiresAt        time.Time  `json:"expiresAt"`
+	ReviewedAt       *time.Time `json:"reviewedAt,omitempty"`
+	Stale            bool       `json:"stale"`
+}
+
+func scanChangeRequest(scanner interface{ Scan(dest ...any) error }) (ChangeRequest, error) {
+	result := ChangeRequest{}
+	var reviewedBy sql.NullString
+	var reviewedAt sql.NullTime
+	if err := scanner.Scan(&result.Id, &result.AllowanceType, &result.Amount, &result.AllowanceVersion, &result.Status, &result.RequestedBy, &reviewedBy, &result.CreatedAt, &result.ExpiresAt, &reviewedAt); err != nil {
+		return ChangeRequest{}, err
+	}
+	result.ReviewedBy = reviewedBy.String
+	if reviewedAt.Valid {
+		result.ReviewedAt = &reviewedAt.Time
+	}
+	return result, nil
+}
+
+func (cr *ChangeRequest) Insert(ctx context.Context, db Executor) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	insertChangeRequest := "INSERT INTO allowance_change_request (allowance_type, amount, allowance_version, status, requested_by, created_at, expires_at) VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING id"
+	if err := db.QueryRowContext(ctx, insertChangeRequest, cr.AllowanceType, cr.Amount, cr.AllowanceVersion, cr.Status, cr.RequestedBy, cr.CreatedAt, cr.ExpiresAt).Scan(&cr.Id); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (cr *ChangeRequest) UpdateStatus(ctx context.Context, db Executor) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	updateStatus := "UPDATE allowance_change_request SET status = $1, reviewed_by = $2, reviewed_at = $3 WHERE id = $4 AND status = $5"
+	result, err := db.ExecContext(ctx, updateStatus, cr.Status, cr.ReviewedBy, cr.ReviewedAt, cr.Id, PENDING)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrChangeRequestNotPending
+	}
+	return nil
+}
+
+func SearchChangeRequestById(ctx context.Context, db Executor, id int) (ChangeRequest, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	selectChangeRequest := "SELECT id, allowance_type, amount, allowance_version, status, requested_by, reviewed_by, created_at, expires_at, reviewed_at FROM allowance_change_request WHERE id = $1"
+	changeRequest, err := scanChangeRequest(db.QueryRowContext(ctx, selectChangeRequest, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ChangeRequest{}, ErrNotFound
+	}
+	return changeRequest, err
+}
+
+func SearchChangeRequestByStatus(ctx context.Context, db Executor, status string) ([]ChangeRequest, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	results := make([]ChangeRequest, 0)
+	selectChangeRequest := "SELECT id, allowance_type, amount, allowance_version, status, requested_by, reviewed_by, created_at, expires_at, reviewed_at FROM allowance_change_request WHERE status = $1 ORDER BY id"
+	rows, err := db.QueryContext(ctx, selectChangeRequest, status)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		changeRequest, err := scanChangeRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, changeRequest)
+	}
+	return results, rows.Err()
+}
+
+func ExpireChangeRequests(ctx context.Context, db Executor, now time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	expireChangeRequest := "UPDATE allowance_change_request SET status = $1, reviewed_at = $2 WHERE status = $3 AND expires_at < $2"
+	if _, err := db.ExecContext(ctx, expireChangeRequest, EXPIRED, now, PENDING); err != nil {
+		return err
+	}
+	return nil
+}
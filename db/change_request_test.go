@@ -0,0 +1,143 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func mockChangeRequestDb(t *testing.T) *sql.DB {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	mock.MatchExpectationsInOrder(false)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	reviewedAt := time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)
+	columns := []string{"id", "allowance_type", "amount", "allowance_version", "status", "requested_by", "reviewed_by", "created_at", "expires_at", "reviewed_at"}
+	rowsPending := mock.NewRows(columns).
+		AddRow(1, "personal", 70000.00, 1, "pending", "admin", nil, createdAt, createdAt.Add(24*time.Hour), nil)
+	rowsApproved := mock.NewRows(columns).
+		AddRow(2, "k-receipt", 80000.00, 1, "approved", "admin", "approver", createdAt, createdAt.Add(24*time.Hour), reviewedAt)
+
+	insertChangeRequestSql := "INSERT INTO allowance_change_request (allowance_type, amount, allowance_version, status, requested_by, created_at, expires_at) VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING id"
+	updateStatusSql := "UPDATE allowance_change_request SET status = $1, reviewed_by = $2, reviewed_at = $3 WHERE id = $4 AND status = $5"
+	selectByIdSql := "SELECT id, allowance_type, amount, allowance_version, status, requested_by, reviewed_by, created_at, expires_at, reviewed_at FROM allowance_change_request WHERE id = $1"
+	selectByStatusSql := "SELECT id, allowance_type, amount, allowance_version, status, requested_by, reviewed_by, created_at, expires_at, reviewed_at FROM allowance_change_request WHERE status = $1 ORDER BY id"
+	expireSql := "UPDATE allowance_change_request SET status = $1, reviewed_at = $2 WHERE status = $3 AND expires_at < $2"
+
+	mock.ExpectQuery(insertChangeRequestSql).WithArgs("personal", 70000.00, 1, "pending", "admin", createdAt, createdAt.Add(24*time.Hour)).WillReturnRows(mock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(insertChangeRequestSql).WithArgs("mockError", 70000.00, 1, "pending", "admin", createdAt, createdAt.Add(24*time.Hour)).WillReturnError(sql.ErrConnDone)
+	mock.ExpectExec(updateStatusSql).WithArgs("approved", "approver", reviewedAt, 1, "pending").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(updateStatusSql).WithArgs("approved", "approver", reviewedAt, 2, "pending").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(selectByIdSql).WithArgs(2).WillReturnRows(rowsApproved)
+	mock.ExpectQuery(selectByIdSql).WithArgs(3).WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(selectByStatusSql).WithArgs("pending").WillReturnRows(rowsPending)
+	mock.ExpectExec(expireSql).WithArgs("expired", reviewedAt, "pending").WillReturnResult(sqlmock.NewResult(0, 1))
+	return db
+}
+
+func TestChangeRequest_Insert(t *testing.T) {
+	t.Parallel()
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		changeRequest ChangeRequest
+		wantId        int
+		want          error
+	}{
+		{"Should return nil and set id when inserting change request successfully", ChangeRequest{AllowanceType: "personal", Amount: 70000.00, AllowanceVersion: 1, Status: PENDING, RequestedBy: "admin", CreatedAt: createdAt, ExpiresAt: createdAt.Add(24 * time.Hour)}, 1, nil},
+		{"Should return error when inserting change request unsuccessfully", ChangeRequest{AllowanceType: "mockError", Amount: 70000.00, AllowanceVersion: 1, Status: PENDING, RequestedBy: "admin", CreatedAt: createdAt, ExpiresAt: createdAt.Add(24 * time.Hour)}, 0, sql.ErrConnDone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := tt.changeRequest
+			if got := cr.Insert(context.Background(), mockChangeRequestDb(t)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ChangeRequest.Insert() = %v, want %v", got, tt.want)
+			}
+			if cr.Id != tt.wantId {
+				t.Errorf("ChangeRequest.Insert() id = %v, want %v", cr.Id, tt.wantId)
+			}
+		})
+	}
+}
+
+func TestChangeRequest_UpdateStatus(t *testing.T) {
+	t.Parallel()
+	reviewedAt := time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		changeRequest ChangeRequest
+		want          error
+	}{
+		{"Should return nil when updating pending change request", ChangeRequest{Id: 1, Status: APPROVED, ReviewedBy: "approver", ReviewedAt: &reviewedAt}, nil},
+		{"Should return ErrChangeRequestNotPending when change request was already reviewed", ChangeRequest{Id: 2, Status: APPROVED, ReviewedBy: "approver", ReviewedAt: &reviewedAt}, ErrChangeRequestNotPending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.changeRequest.UpdateStatus(context.Background(), mockChangeRequestDb(t)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ChangeRequest.UpdateStatus() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchChangeRequestById(t *testing.T) {
+	t.Parallel()
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	reviewedAt := time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		id      int
+		want    ChangeRequest
+		wantErr error
+	}{
+		{"Should return change request for existing id", 2, ChangeRequest{Id: 2, AllowanceType: "k-receipt", Amount: 80000.00, AllowanceVersion: 1, Status: APPROVED, RequestedBy: "admin", ReviewedBy: "approver", CreatedAt: createdAt, ExpiresAt: createdAt.Add(24 * time.Hour), ReviewedAt: &reviewedAt}, nil},
+		{"Should return ErrNotFound for unknown id", 3, ChangeRequest{}, ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SearchChangeRequestById(context.Background(), mockChangeRequestDb(t), tt.id)
+			if err != tt.wantErr {
+				t.Errorf("SearchChangeRequestById() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SearchChangeRequestById() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchChangeRequestByStatus(t *testing.T) {
+	t.Parallel()
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		want []ChangeRequest
+	}{
+		{"Should return pending change requests correctly", []ChangeRequest{{Id: 1, AllowanceType: "personal", Amount: 70000.00, AllowanceVersion: 1, Status: PENDING, RequestedBy: "admin", CreatedAt: createdAt, ExpiresAt: createdAt.Add(24 * time.Hour)}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SearchChangeRequestByStatus(context.Background(), mockChangeRequestDb(t), PENDING)
+			if err != nil {
+				t.Errorf("SearchChangeRequestByStatus() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SearchChangeRequestByStatus() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpireChangeRequests(t *testing.T) {
+	t.Parallel()
+	if got := ExpireChangeRequests(context.Background(), mockChangeRequestDb(t), time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)); got != nil {
+		t.Errorf("ExpireChangeRequests() = %v, want nil", got)
+	}
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+type ConfigImportRequest struct {
+	Id          int             `json:"id"`
+	Document    json.RawMessage `json:"document"`
+	Status      string          `json:"status"`
+	RequestedBy string          `json:"requestedBy"`
+	ReviewedBy  string          `json:"reviewedBy,omitempty"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	ExpiresAt   time.Time       `json:"expiresAt"`
+	ReviewedAt  *time.Time      `json:"reviewedAt,omitempty"`
+}
+
+func scanConfigImportRequest(scanner interface{ Scan(dest ...any) error }) (ConfigImportRequest, error) {
+	result := ConfigImportRequest{}
+	var document string
+	var reviewedBy sql.NullString
+	var reviewedAt sql.NullTime
+	if err := scanner.Scan(&result.Id, &document, &result.Status, &result.RequestedBy, &reviewedBy, &result.CreatedAt, &result.ExpiresAt, &reviewedAt); err != nil {
+		return ConfigImportRequest{}, err
+	}
+	result.Document = json.RawMessage(document)
+	result.ReviewedBy = reviewedBy.String
+	if reviewedAt.Valid {
+		result.ReviewedAt = &reviewedAt.Time
+	}
+	return result, nil
+}
+
+func (ir *ConfigImportRequest) Insert(ctx context.Context, db Executor) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	insertImportRequest := "INSERT INTO config_import_request (document, status, requested_by, created_at, expires_at) VALUES ($1,$2,$3,$4,$5) RETURNING id"
+	if err := db.QueryRowContext(ctx, insertImportRequest, string(ir.Document), ir.Status, ir.RequestedBy, ir.CreatedAt, ir.ExpiresAt).Scan(&ir.Id); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (ir *ConfigImportRequest) UpdateStatus(ctx context.Context, db Executor) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	updateStatus := "UPDATE config_import_request SET status = $1, reviewed_by = $2, reviewed_at = $3 WHERE id = $4 AND status = $5"
+	result, err := db.ExecContext(ctx, updateStatus, ir.Status, ir.ReviewedBy, ir.ReviewedAt, ir.Id, PENDING)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrChangeRequestNotPending
+	}
+	return nil
+}
+
+func SearchConfigImportRequestById(ctx context.Context, db Executor, id int) (ConfigImportRequest, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	selectImportRequest := "SELECT id, document, status, requested_by, reviewed_by, created_at, expires_at, reviewed_at FROM config_import_request WHERE id = $1"
+	importRequest, err := scanConfigImportRequest(db.QueryRowContext(ctx, selectImportRequest, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ConfigImportRequest{}, ErrNotFound
+	}
+	return importRequest, err
+}
+
+func SearchConfigImportRequestByStatus(ctx context.Context, db Executor, status string) ([]ConfigImportRequest, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	results := make([]ConfigImportRequest, 0)
+	selectImportRequest := "SELECT id, document, status, requested_by, reviewed_by, created_at, expires_at, reviewed_at FROM config_import_request WHERE status = $1 ORDER BY id"
+	rows, err := db.QueryContext(ctx, selectImportRequest, status)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		importRequest, err := scanConfigImportRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, importRequest)
+	}
+	return results, rows.Err()
+}
+
+func ExpireConfigImportRequests(ctx context.Context, db Executor, now time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	expireImportRequest := "UPDATE config_import_request SET status = $1, reviewed_at = $2 WHERE status = $3 AND expires_at < $2"
+	if _, err := db.ExecContext(ctx, expireImportRequest, EXPIRED, now, PENDING); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestConfigImportRequest(t *testing.T) {
+	t.Parallel()
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	reviewedAt := time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC)
+	document := `{"taxLevels":[],"allowances":[]}`
+	columns := []string{"id", "document", "status", "requested_by", "reviewed_by", "created_at", "expires_at", "reviewed_at"}
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("INSERT INTO config_import_request (document, status, requested_by, created_at, expires_at) VALUES ($1,$2,$3,$4,$5) RETURNING id").
+		WithArgs(document, "pending", "admin", createdAt, createdAt.Add(24*time.Hour)).WillReturnRows(mock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery("SELECT id, document, status, requested_by, reviewed_by, created_at, expires_at, reviewed_at FROM config_import_request WHERE id = $1").
+		WithArgs(1).WillReturnRows(mock.NewRows(columns).AddRow(1, document, "pending", "admin", nil, createdAt, createdAt.Add(24*time.Hour), nil))
+	mock.ExpectExec("UPDATE config_import_request SET status = $1, reviewed_by = $2, reviewed_at = $3 WHERE id = $4 AND status = $5").
+		WithArgs("approved", "approver", &reviewedAt, 1, "pending").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT id, document, status, requested_by, reviewed_by, created_at, expires_at, reviewed_at FROM config_import_request WHERE id = $1").
+		WithArgs(2).WillReturnError(sql.ErrNoRows)
+
+	importRequest := ConfigImportRequest{Document: json.RawMessage(document), Status: PENDING, RequestedBy: "admin", CreatedAt: createdAt, ExpiresAt: createdAt.Add(24 * time.Hour)}
+	if err := importRequest.Insert(context.Background(), db); err != nil || importRequest.Id != 1 {
+		t.Errorf("ConfigImportRequest.Insert() id = %v, error = %v", importRequest.Id, err)
+	}
+	if got, err := SearchConfigImportRequestById(context.Background(), db, 1); err != nil || !reflect.DeepEqual(got, importRequest) {
+		t.Errorf("SearchConfigImportRequestById() = %v, error = %v, want %v", got, err, importRequest)
+	}
+	importRequest.Status, importRequest.ReviewedBy, importRequest.ReviewedAt = APPROVED, "approver", &reviewedAt
+	if err := importRequest.UpdateStatus(context.Background(), db); err != ErrChangeRequestNotPending {
+		t.Errorf("ConfigImportRequest.UpdateStatus() error = %v, want %v", err, ErrChangeRequestNotPending)
+	}
+	if _, err := SearchConfigImportRequestById(context.Background(), db, 2); err != ErrNotFound {
+		t.Errorf("SearchConfigImportRequestById() error = %v, want %v", err, ErrNotFound)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -1,40 +1,87 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"os"
-
-	_ "github.com/lib/pq"
+	"time"
 )
 
-func dbPreparation(db *sql.DB) {
-	createAllowanceTable(db)
+var DEFAULTQUERYTIMEOUT = 10 * time.Second
+
+type queryTimeoutKey struct{}
+
+type Executor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func WithQueryTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	if timeout <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, queryTimeoutKey{}, timeout)
+}
 
-	for _, aw := range getAllowanceDefaultValues() {
-		allowance := (&Allowance{AllowanceType: aw.AllowanceType}).SearchByType(db)
-		if (Allowance{}) == allowance {
-			allowance := &Allowance{AllowanceType: aw.AllowanceType, Amount: aw.Amount}
-			if err := allowance.Insert(db); err != nil {
-				log.Fatal("can't initialize data", err)
-			}
-		}
+func getQueryTimeout(ctx context.Context) time.Duration {
+	if timeout, ok := ctx.Value(queryTimeoutKey{}).(time.Duration); ok {
+		return timeout
+	}
+	return DEFAULTQUERYTIMEOUT
+}
+
+func withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, getQueryTimeout(ctx))
+}
+
+func dbPreparation(db *sql.DB, dialect Dialect) error {
+	migrator, err := NewMigrator(db, dialect)
+	if err != nil {
+		return err
 	}
+	applied, err := migrator.Up()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Applied %d database migrations\n", applied)
 
-	allowances := SearchAllAllowance(db)
+	allowances, err := SearchAllAllowance(context.Background(), db)
+	if err != nil {
+		return err
+	}
 	fmt.Println(`Starting Tax calculate application with default fields as below: `)
 	for _, allowance := range allowances {
 		fmt.Printf("ID: %d, TYPE: %v, AMOUNT: %0.2f\n", allowance.Id, allowance.AllowanceType, allowance.Amount)
 	}
+	return nil
 }
 
-func InitDB() *sql.DB {
-	var err error
-	db, err := sql.Open("postgres", os.Getenv("DATABASE_URL"))
+func OpenDB() (*sql.DB, Dialect) {
+	d, dataSourceName, err := ParseDatabaseURL(os.Getenv("DATABASE_URL"))
 	if err != nil {
 		log.Fatal("Connect to database error", err)
 	}
-	dbPreparation(db)
+	if d.DriverName == "" {
+		log.Fatal("Connect to database error", fmt.Errorf("%v storage does not use a SQL database", d.Name))
+	}
+	db, err := sql.Open(d.DriverName, dataSourceName)
+	if err != nil {
+		log.Fatal("Connect to database error", err)
+	}
+	return db, d
+}
+
+func InitDB(pool PoolConfig) *sql.DB {
+	db, dialect := OpenDB()
+	pool.Apply(db)
+	if err := PingWithRetry(context.Background(), db, pool); err != nil {
+		log.Fatal("Connect to database error", err)
+	}
+	if err := dbPreparation(db, dialect); err != nil {
+		log.Fatal("can't migrate database", err)
+	}
 	return db
 }
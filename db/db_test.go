@@ -1,8 +1,11 @@
 package db
 
 import (
+	"context"
+	"errors"
 	"github.com/DATA-DOG/go-sqlmock"
 	"testing"
+	"time"
 
 	_ "github.com/lib/pq"
 )
@@ -14,24 +17,76 @@ func TestInitDB(t *testing.T) {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 
-	createTableSql := "CREATE TABLE IF NOT EXISTS allowance ( id SERIAL PRIMARY KEY, allowance_type TEXT UNIQUE, amount float)"
-	insertAllowanceSql := "INSERT INTO allowance (allowance_type, amount) VALUES ($1,$2)"
-	SearchByTypeSql := "SELECT id, allowance_type, amount FROM allowance WHERE allowance_type = $1"
-	searchAllAllowanceSql := "SELECT id, allowance_type, amount FROM allowance"
-	rowsAll := mock.NewRows([]string{"id", "allowance_type", "amount"}).
-		AddRow(1, "personal", 60000.00).
-		AddRow(2, "donation", 100000.00)
-
-	mock.ExpectExec(createTableSql).WillReturnResult(sqlmock.NewResult(0, 0))
-	mock.ExpectQuery(SearchByTypeSql).WithArgs("personal").WillReturnRows(mock.NewRows([]string{"id", "allowance_type", "amount"}))
-	mock.ExpectExec(insertAllowanceSql).WithArgs("personal", 60000.00).WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectQuery(SearchByTypeSql).WithArgs("donation").WillReturnRows(mock.NewRows([]string{"id", "allowance_type", "amount"}))
-	mock.ExpectExec(insertAllowanceSql).WithArgs("donation", 100000.00).WillReturnResult(sqlmock.NewResult(1, 1))
-	mock.ExpectQuery(SearchByTypeSql).WithArgs("k-receipt").WillReturnRows(mock.NewRows([]string{"id", "allowance_type", "amount"}))
-	mock.ExpectExec(insertAllowanceSql).WithArgs("k-receipt", 50000.00).WillReturnResult(sqlmock.NewResult(1, 1))
+	searchAllAllowanceSql := "SELECT id, allowance_type, amount, version FROM allowance"
+	rowsAll := mock.NewRows([]string{"id", "allowance_type", "amount", "version"}).
+		AddRow(1, "personal", 60000.00, 1).
+		AddRow(2, "donation", 100000.00, 1)
+	migrations, err := LoadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		t.Fatalf("LoadMigrations() error = %v", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(lockMigrationsSql).WithArgs(MIGRATIONLOCKID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(createSchemaMigrationsTableSql).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(selectSchemaMigrationsSql).WillReturnRows(mock.NewRows(schemaMigrationColumns))
+	for _, migration := range migrations {
+		mock.ExpectExec(migration.Up).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(insertSchemaMigrationSql).WithArgs(migration.Version, migration.Name, migration.Checksum, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
 	mock.ExpectQuery(searchAllAllowanceSql).WillReturnRows(rowsAll)
 
 	t.Run("Should run dbPreparation correctly", func(t *testing.T) {
-		dbPreparation(db)
+		if err := dbPreparation(db, POSTGRES); err != nil {
+			t.Errorf("dbPreparation() error = %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
 	})
 }
+
+func TestWithQueryTimeout(t *testing.T) {
+	t.Parallel()
+	selectAllTaxLevelSql := "SELECT id, name, start_amount, end_amount, percentage FROM tax_level ORDER BY start_amount"
+	tests := []struct {
+		name        string
+		timeout     time.Duration
+		ctx         func() (context.Context, context.CancelFunc)
+		wantTimeout time.Duration
+		wantErr     error
+	}{
+		{"Should use default timeout when timeout is not positive", 0, func() (context.Context, context.CancelFunc) {
+			return context.WithCancel(context.Background())
+		}, DEFAULTQUERYTIMEOUT, nil},
+		{"Should cancel query when it runs longer than timeout", 10 * time.Millisecond, func() (context.Context, context.CancelFunc) {
+			return context.WithCancel(context.Background())
+		}, 10 * time.Millisecond, sqlmock.ErrCancelled},
+		{"Should cancel query when request context is cancelled", time.Minute, func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			return ctx, cancel
+		}, time.Minute, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+			mock.ExpectQuery(selectAllTaxLevelSql).WillDelayFor(50 * time.Millisecond).WillReturnRows(mock.NewRows([]string{"id", "name", "start_amount", "end_amount", "percentage"}))
+
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			ctx = WithQueryTimeout(ctx, tt.timeout)
+			if got := getQueryTimeout(ctx); got != tt.wantTimeout {
+				t.Errorf("WithQueryTimeout() timeout = %v, want %v", got, tt.wantTimeout)
+			}
+			if _, err := SearchAllTaxLevel(ctx, db); !errors.Is(err, tt.wantErr) {
+				t.Errorf("SearchAllTaxLevel() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
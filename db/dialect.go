@@ -0,0 +1,70 @@
+package db
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var SQLITEDEFAULTOPTIONS = "_busy_timeout=5000&_journal_mode=WAL&_foreign_keys=on"
+
+type Dialect struct {
+	Name                  string
+	DriverName            string
+	MigrationsDir         string
+	MigrationLock         string
+	SchemaMigrationsTable string
+	SkipLocked            string
+}
+
+var (
+	POSTGRES = Dialect{
+		Name:                  "postgres",
+		DriverName:            "postgres",
+		MigrationsDir:         "migrations",
+		MigrationLock:         "SELECT pg_advisory_xact_lock($1)",
+		SchemaMigrationsTable: "CREATE TABLE IF NOT EXISTS schema_migrations ( version INT PRIMARY KEY, name TEXT NOT NULL, checksum TEXT NOT NULL, applied_at TIMESTAMPTZ NOT NULL)",
+		SkipLocked:            " FOR UPDATE SKIP LOCKED",
+	}
+	SQLITE = Dialect{
+		Name:                  "sqlite",
+		DriverName:            "sqlite3",
+		MigrationsDir:         "migrations/sqlite",
+		SchemaMigrationsTable: "CREATE TABLE IF NOT EXISTS schema_migrations ( version INTEGER PRIMARY KEY, name TEXT NOT NULL, checksum TEXT NOT NULL, applied_at TIMESTAMP NOT NULL)",
+	}
+	MEMORY = Dialect{Name: "memory"}
+)
+
+func ParseDatabaseURL(databaseURL string) (Dialect, string, error) {
+	scheme, rest, found := strings.Cut(databaseURL, "://")
+	if !found {
+		return POSTGRES, databaseURL, nil
+	}
+	switch scheme {
+	case "postgres", "postgresql":
+		return POSTGRES, databaseURL, nil
+	case "sqlite", "sqlite3":
+		if rest == "" {
+			return Dialect{}, "", fmt.Errorf("sqlite database url must include a file path : %v", databaseURL)
+		}
+		path, query, _ := strings.Cut(rest, "?")
+		options, err := url.ParseQuery(SQLITEDEFAULTOPTIONS)
+		if err != nil {
+			return Dialect{}, "", err
+		}
+		overrides, err := url.ParseQuery(query)
+		if err != nil {
+			return Dialect{}, "", err
+		}
+		for key, values := range overrides {
+			options[key] = values
+		}
+		return SQLITE, "file:" + path + "?" + options.Encode(), nil
+	case "memory":
+		return MEMORY, "", nil
+	}
+	return Dialect{}, "", fmt.Errorf("unsupported database url scheme : %v", scheme)
+}
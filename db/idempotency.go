@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+type IdempotencyKey struct {
+	Key         string
+	Endpoint    string
+	RequestHash string
+	Status      string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+func (k *IdempotencyKey) Reserve(ctx context.Context, db Executor) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	k.Status = RUNNING
+	reserveIdempotencyKey := "INSERT INTO idempotency_key (key, endpoint, request_hash, status, status_code, content_type, body, created_at, expires_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9) ON CONFLICT (key, endpoint) DO UPDATE SET request_hash = EXCLUDED.request_hash, status = EXCLUDED.status, status_code = EXCLUDED.status_code, content_type = EXCLUDED.content_type, body = EXCLUDED.body, created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at WHERE idempotency_key.expires_at <= EXCLUDED.created_at"
+	result, err := db.ExecContext(ctx, reserveIdempotencyKey, k.Key, k.Endpoint, k.RequestHash, k.Status, 0, "", []byte{}, k.CreatedAt, k.ExpiresAt)
+	if err != nil {
+		return false, err
+	}
+	reserved, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return reserved > 0, nil
+}
+
+func (k *IdempotencyKey) Complete(ctx context.Context, db Executor) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	completeIdempotencyKey := "UPDATE idempotency_key SET status = $1, status_code = $2, content_type = $3, body = $4, expires_at = $5 WHERE key = $6 AND endpoint = $7 AND status = $8"
+	if _, err := db.ExecContext(ctx, completeIdempotencyKey, COMPLETED, k.StatusCode, k.ContentType, k.Body, k.ExpiresAt, k.Key, k.Endpoint, RUNNING); err != nil {
+		return err
+	}
+	k.Status = COMPLETED
+	return nil
+}
+
+func ReleaseIdempotencyKey(ctx context.Context, db Executor, endpoint string, key string) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, "DELETE FROM idempotency_key WHERE key = $1 AND endpoint = $2 AND status = $3", key, endpoint, RUNNING); err != nil {
+		return err
+	}
+	return nil
+}
+
+func SearchIdempotencyKey(ctx context.Context, db Executor, endpoint string, key string, now time.Time) (IdempotencyKey, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	result := IdempotencyKey{}
+	selectIdempotencyKey := "SELECT key, endpoint, request_hash, status, status_code, content_type, body, created_at, expires_at FROM idempotency_key WHERE key = $1 AND endpoint = $2 AND expires_at > $3"
+	if err := db.QueryRowContext(ctx, selectIdempotencyKey, key, endpoint, now).Scan(&result.Key, &result.Endpoint, &result.RequestHash, &result.Status, &result.StatusCode, &result.ContentType, &result.Body, &result.CreatedAt, &result.ExpiresAt); err != nil {
+		return IdempotencyKey{}, err
+	}
+	return result, nil
+}
+
+func DeleteExpiredIdempotencyKeys(ctx context.Context, db Executor, now time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, "DELETE FROM idempotency_key WHERE expires_at <= $1", now); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestIdempotencyKey_Reserve(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	expiresAt := now.Add(10 * time.Minute)
+	reserveIdempotencyKey := "INSERT INTO idempotency_key (key, endpoint, request_hash, status, status_code, content_type, body, created_at, expires_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9) ON CONFLICT (key, endpoint) DO UPDATE SET request_hash = EXCLUDED.request_hash, status = EXCLUDED.status, status_code = EXCLUDED.status_code, content_type = EXCLUDED.content_type, body = EXCLUDED.body, created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at WHERE idempotency_key.expires_at <= EXCLUDED.created_at"
+	tests := []struct {
+		name         string
+		rowsAffected int64
+		want         bool
+	}{
+		{"Should reserve key when it is new or expired", 1, true},
+		{"Should not reserve key when it is already in use", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := mockJobDb(t, func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(reserveIdempotencyKey).
+					WithArgs("key-1", "/tax/calculations", "abc", "running", 0, "", []byte{}, now, expiresAt).
+					WillReturnResult(sqlmock.NewResult(0, tt.rowsAffected))
+			})
+			defer db.Close()
+
+			key := &IdempotencyKey{Key: "key-1", Endpoint: "/tax/calculations", RequestHash: "abc", CreatedAt: now, ExpiresAt: expiresAt}
+			got, err := key.Reserve(context.Background(), db)
+			if err != nil || got != tt.want {
+				t.Errorf("IdempotencyKey.Reserve() = %v, error = %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdempotencyKey_Complete(t *testing.T) {
+	t.Parallel()
+	expiresAt := time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)
+	db := mockJobDb(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectExec("UPDATE idempotency_key SET status = $1, status_code = $2, content_type = $3, body = $4, expires_at = $5 WHERE key = $6 AND endpoint = $7 AND status = $8").
+			WithArgs("completed", 200, "application/json", []byte(`{"tax":0}`), expiresAt, "key-1", "/tax/calculations", "running").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("DELETE FROM idempotency_key WHERE key = $1 AND endpoint = $2 AND status = $3").
+			WithArgs("key-2", "/tax/calculations", "running").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	})
+	defer db.Close()
+
+	key := &IdempotencyKey{Key: "key-1", Endpoint: "/tax/calculations", Status: RUNNING, StatusCode: 200, ContentType: "application/json", Body: []byte(`{"tax":0}`), ExpiresAt: expiresAt}
+	if err := key.Complete(context.Background(), db); err != nil || key.Status != COMPLETED {
+		t.Errorf("IdempotencyKey.Complete() status = %v, error = %v", key.Status, err)
+	}
+	if err := ReleaseIdempotencyKey(context.Background(), db, "/tax/calculations", "key-2"); err != nil {
+		t.Errorf("ReleaseIdempotencyKey() error = %v", err)
+	}
+}
+
+func TestSearchIdempotencyKey(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	selectIdempotencyKey := "SELECT key, endpoint, request_hash, status, status_code, content_type, body, created_at, expires_at FROM idempotency_key WHERE key = $1 AND endpoint = $2 AND expires_at > $3"
+	columns := []string{"key", "endpoint", "request_hash", "status", "status_code", "content_type", "body", "created_at", "expires_at"}
+	tests := []struct {
+		name    string
+		row     []driver.Value
+		want    IdempotencyKey
+		wantErr error
+	}{
+		{"Should return stored response when key is not expired", []driver.Value{"key-1", "/tax/calculations", "abc", "completed", 200, "application/json", []byte(`{"tax":0}`), now, now.Add(time.Hour)},
+			IdempotencyKey{Key: "key-1", Endpoint: "/tax/calculations", RequestHash: "abc", Status: "completed", StatusCode: 200, ContentType: "application/json", Body: []byte(`{"tax":0}`), CreatedAt: now, ExpiresAt: now.Add(time.Hour)}, nil},
+		{"Should return ErrNoRows when key is unknown or expired", nil, IdempotencyKey{}, sql.ErrNoRows},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := mockJobDb(t, func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows(columns)
+				if tt.row != nil {
+					rows.AddRow(tt.row...)
+				}
+				mock.ExpectQuery(selectIdempotencyKey).WithArgs("key-1", "/tax/calculations", now).WillReturnRows(rows)
+			})
+			defer db.Close()
+
+			got, err := SearchIdempotencyKey(context.Background(), db, "/tax/calculations", "key-1", now)
+			if err != tt.wantErr {
+				t.Errorf("SearchIdempotencyKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SearchIdempotencyKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteExpiredIdempotencyKeys(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	db := mockJobDb(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectExec("DELETE FROM idempotency_key WHERE expires_at <= $1").WithArgs(now).WillReturnResult(sqlmock.NewResult(0, 3))
+	})
+	defer db.Close()
+	if err := DeleteExpiredIdempotencyKeys(context.Background(), db, now); err != nil {
+		t.Errorf("DeleteExpiredIdempotencyKeys() error = %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+var (
+	RUNNING   = "running"
+	COMPLETED = "completed"
+	FAILED    = "failed"
+)
+
+type Job struct {
+	Id            int        `json:"-"`
+	PublicId      string     `json:"id"`
+	RequestedBy   string     `json:"requestedBy,omitempty"`
+	Status        string     `json:"status"`
+	Strict        bool       `json:"strict"`
+	Format        string     `json:"format"`
+	Delimiter     string     `json:"delimiter,omitempty"`
+	Encoding      string     `json:"encoding,omitempty"`
+	FileType      string     `json:"fileType"`
+	Sheet         string     `json:"sheet,omitempty"`
+	File          []byte     `json:"-"`
+	TotalRows     int        `json:"totalRows"`
+	ProcessedRows int        `json:"processedRows"`
+	Error         string     `json:"error,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	StartedAt     *time.Time `json:"startedAt,omitempty"`
+	CompletedAt   *time.Time `json:"completedAt,omitempty"`
+}
+
+func (j *Job) Insert(ctx context.Context, db Executor) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	insertJob := "INSERT INTO calculation_job (public_id, requested_by, status, strict, format, delimiter, encoding, file_type, sheet, file, created_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11) RETURNING id"
+	if err := db.QueryRowContext(ctx, insertJob, j.PublicId, j.RequestedBy, j.Status, j.Strict, j.Format, j.Delimiter, j.Encoding, j.FileType, j.Sheet, j.File, j.CreatedAt).Scan(&j.Id); err != nil {
+		return err
+	}
+	return nil
+}
+
+func SearchJobByPublicId(ctx context.Context, db Executor, publicId string) (Job, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	result := Job{}
+	var jobError sql.NullString
+	var startedAt, completedAt sql.NullTime
+	selectJob := "SELECT id, public_id, requested_by, status, strict, format, delimiter, encoding, file_type, sheet, total_rows, processed_rows, error, created_at, started_at, completed_at FROM calculation_job WHERE public_id = $1"
+	if err := db.QueryRowContext(ctx, selectJob, publicId).Scan(&result.Id, &result.PublicId, &result.RequestedBy, &result.Status, &result.Strict, &result.Format, &result.Delimiter, &result.Encoding, &result.FileType, &result.Sheet, &result.TotalRows, &result.ProcessedRows, &jobError, &result.CreatedAt, &startedAt, &completedAt); err != nil {
+		return Job{}, err
+	}
+	result.Error = jobError.String
+	if startedAt.Valid {
+		result.StartedAt = &startedAt.Time
+	}
+	if completedAt.Valid {
+		result.CompletedAt = &completedAt.Time
+	}
+	return result, nil
+}
+
+func SearchJobResult(ctx context.Context, db Executor, id int) (string, []byte, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	var contentType sql.NullString
+	var result []byte
+	if err := db.QueryRowContext(ctx, "SELECT content_type, result FROM calculation_job WHERE id = $1", id).Scan(&contentType, &result); err != nil {
+		return "", nil, err
+	}
+	return contentType.String, result, nil
+}
+
+func ClaimJob(ctx context.Context, db Executor, dialect Dialect, workerId string, now time.Time, lockedUntil time.Time) (Job, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	result := Job{Status: RUNNING, StartedAt: &now}
+	claimJob := "UPDATE calculation_job SET status = $1, worker_id = $2, started_at = $3, locked_until = $4 WHERE id = (SELECT id FROM calculation_job WHERE status = $5 OR (status = $1 AND locked_until < $3) ORDER BY id LIMIT 1" + dialect.SkipLocked + ") RETURNING id, strict, format, delimiter, encoding, file_type, sheet, file, created_at"
+	if err := db.QueryRowContext(ctx, claimJob, RUNNING, workerId, now, lockedUntil, PENDING).Scan(&result.Id, &result.Strict, &result.Format, &result.Delimiter, &result.Encoding, &result.FileType, &result.Sheet, &result.File, &result.CreatedAt); err != nil {
+		return Job{}, err
+	}
+	return result, nil
+}
+
+func RenewJobLease(ctx context.Context, db Executor, id int, workerId string, lockedUntil time.Time) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	result, err := db.ExecContext(ctx, "UPDATE calculation_job SET locked_until = $1 WHERE id = $2 AND worker_id = $3 AND status = $4", lockedUntil, id, workerId, RUNNING)
+	if err != nil {
+		return false, err
+	}
+	renewed, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return renewed > 0, nil
+}
+
+func UpdateJobProgress(ctx context.Context, db Executor, id int, totalRows int, processedRows int) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, "UPDATE calculation_job SET total_rows = $1, processed_rows = $2 WHERE id = $3", totalRows, processedRows, id); err != nil {
+		return err
+	}
+	return nil
+}
+
+func CompleteJob(ctx context.Context, db Executor, id int, workerId string, contentType string, result []byte, now time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	completeJob := "UPDATE calculation_job SET status = $1, content_type = $2, result = $3, completed_at = $4, locked_until = NULL WHERE id = $5 AND worker_id = $6"
+	if _, err := db.ExecContext(ctx, completeJob, COMPLETED, contentType, result, now, id, workerId); err != nil {
+		return err
+	}
+	return nil
+}
+
+func FailJob(ctx context.Context, db Executor, id int, workerId string, message string, now time.Time) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	failJob := "UPDATE calculation_job SET status = $1, error = $2, completed_at = $3, locked_until = NULL WHERE id = $4 AND worker_id = $5"
+	if _, err := db.ExecContext(ctx, failJob, FAILED, message, now, id, workerId); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func mockJobDb(t *testing.T, setup func(mock sqlmock.Sqlmock)) *sql.DB {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	setup(mock)
+	return db
+}
+
+func TestJob_Insert(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	db := mockJobDb(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("INSERT INTO calculation_job (public_id, requested_by, status, strict, format, delimiter, encoding, file_type, sheet, file, created_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11) RETURNING id").
+			WithArgs("f3a9", "admin", "pending", false, "json", ";", "", "csv", "", []byte("totalIncome,wht\n"), now).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	})
+	defer db.Close()
+
+	job := &Job{PublicId: "f3a9", RequestedBy: "admin", Status: PENDING, Format: "json", Delimiter: ";", FileType: "csv", File: []byte("totalIncome,wht\n"), CreatedAt: now}
+	if err := job.Insert(context.Background(), db); err != nil {
+		t.Errorf("Job.Insert() error = %v", err)
+	}
+	if job.Id != 7 {
+		t.Errorf("Job.Insert() id = %v, want %v", job.Id, 7)
+	}
+}
+
+func TestSearchJobByPublicId(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	selectJob := "SELECT id, public_id, requested_by, status, strict, format, delimiter, encoding, file_type, sheet, total_rows, processed_rows, error, created_at, started_at, completed_at FROM calculation_job WHERE public_id = $1"
+	tests := []struct {
+		name    string
+		id      string
+		want    Job
+		wantErr error
+	}{
+		{"Should return job when id exists", "f3a9", Job{Id: 1, PublicId: "f3a9", RequestedBy: "admin", Status: "running", Format: "csv", FileType: "xlsx", Sheet: "taxes", TotalRows: 10, ProcessedRows: 5, CreatedAt: now, StartedAt: &now}, nil},
+		{"Should return sql.ErrNoRows when id does not exist", "b7c2", Job{}, sql.ErrNoRows},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := mockJobDb(t, func(mock sqlmock.Sqlmock) {
+				rows := mock.NewRows([]string{"id", "public_id", "requested_by", "status", "strict", "format", "delimiter", "encoding", "file_type", "sheet", "total_rows", "processed_rows", "error", "created_at", "started_at", "completed_at"})
+				if tt.wantErr == nil {
+					rows.AddRow(1, "f3a9", "admin", "running", false, "csv", "", "", "xlsx", "taxes", 10, 5, nil, now, now, nil)
+				}
+				mock.ExpectQuery(selectJob).WithArgs(tt.id).WillReturnRows(rows)
+			})
+			defer db.Close()
+
+			got, err := SearchJobByPublicId(context.Background(), db, tt.id)
+			if err != tt.wantErr {
+				t.Errorf("SearchJobByPublicId() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SearchJobByPublicId() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClaimJob(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	lockedUntil := now.Add(time.Minute)
+	claimJob := "UPDATE calculation_job SET status = $1, worker_id = $2, started_at = $3, locked_until = $4 WHERE id = (SELECT id FROM calculation_job WHERE status = $5 OR (status = $1 AND locked_until < $3) ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED) RETURNING id, strict, format, delimiter, encoding, file_type, sheet, file, created_at"
+	db := mockJobDb(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(claimJob).WithArgs("running", "worker-1", now, lockedUntil, "pending").
+			WillReturnRows(mock.NewRows([]string{"id", "strict", "format", "delimiter", "encoding", "file_type", "sheet", "file", "created_at"}).AddRow(3, true, "xlsx", "", "windows-874", "csv", "", []byte("file"), now))
+		mock.ExpectQuery(claimJob).WithArgs("running", "worker-1", now, lockedUntil, "pending").
+			WillReturnRows(mock.NewRows([]string{"id", "strict", "format", "delimiter", "encoding", "file_type", "sheet", "file", "created_at"}))
+	})
+	defer db.Close()
+
+	want := Job{Id: 3, Status: RUNNING, Strict: true, Format: "xlsx", Encoding: "windows-874", FileType: "csv", File: []byte("file"), CreatedAt: now, StartedAt: &now}
+	if got, err := ClaimJob(context.Background(), db, POSTGRES, "worker-1", now, lockedUntil); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ClaimJob() = %v, %v, want %v", got, err, want)
+	}
+	if _, err := ClaimJob(context.Background(), db, POSTGRES, "worker-1", now, lockedUntil); err != sql.ErrNoRows {
+		t.Errorf("ClaimJob() error = %v, want %v", err, sql.ErrNoRows)
+	}
+}
+
+func TestRenewJobLease(t *testing.T) {
+	t.Parallel()
+	lockedUntil := time.Date(2024, 5, 1, 10, 1, 0, 0, time.UTC)
+	renewLease := "UPDATE calculation_job SET locked_until = $1 WHERE id = $2 AND worker_id = $3 AND status = $4"
+	tests := []struct {
+		name    string
+		setup   func(mock sqlmock.Sqlmock)
+		want    bool
+		wantErr error
+	}{
+		{"Should renew lease when worker still owns job", func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(renewLease).WithArgs(lockedUntil, 1, "worker-1", "running").WillReturnResult(sqlmock.NewResult(0, 1))
+		}, true, nil},
+		{"Should report lost lease when job was taken over", func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(renewLease).WithArgs(lockedUntil, 1, "worker-1", "running").WillReturnResult(sqlmock.NewResult(0, 0))
+		}, false, nil},
+		{"Should return error when lease cannot be renewed", func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(renewLease).WithArgs(lockedUntil, 1, "worker-1", "running").WillReturnError(sql.ErrConnDone)
+		}, false, sql.ErrConnDone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := mockJobDb(t, tt.setup)
+			defer db.Close()
+
+			got, err := RenewJobLease(context.Background(), db, 1, "worker-1", lockedUntil)
+			if got != tt.want || err != tt.wantErr {
+				t.Errorf("RenewJobLease() = %v, %v, want %v, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJob_statusUpdates(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	db := mockJobDb(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectExec("UPDATE calculation_job SET total_rows = $1, processed_rows = $2 WHERE id = $3").WithArgs(10, 5, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE calculation_job SET status = $1, content_type = $2, result = $3, completed_at = $4, locked_until = NULL WHERE id = $5 AND worker_id = $6").WithArgs("completed", "text/csv", []byte("result"), now, 1, "worker-1").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE calculation_job SET status = $1, error = $2, completed_at = $3, locked_until = NULL WHERE id = $4 AND worker_id = $5").WithArgs("failed", "boom", now, 2, "worker-1").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT content_type, result FROM calculation_job WHERE id = $1").WithArgs(1).
+			WillReturnRows(mock.NewRows([]string{"content_type", "result"}).AddRow("text/csv", []byte("result")))
+	})
+	defer db.Close()
+
+	if err := UpdateJobProgress(context.Background(), db, 1, 10, 5); err != nil {
+		t.Errorf("UpdateJobProgress() error = %v", err)
+	}
+	if err := CompleteJob(context.Background(), db, 1, "worker-1", "text/csv", []byte("result"), now); err != nil {
+		t.Errorf("CompleteJob() error = %v", err)
+	}
+	if err := FailJob(context.Background(), db, 2, "worker-1", "boom", now); err != nil {
+		t.Errorf("FailJob() error = %v", err)
+	}
+	contentType, result, err := SearchJobResult(context.Background(), db, 1)
+	if err != nil || contentType != "text/csv" || string(result) != "result" {
+		t.Errorf("SearchJobResult() = %v, %v, %v", contentType, string(result), err)
+	}
+}
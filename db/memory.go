@@ -0,0 +1,342 @@
+package db
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memoryAllowanceState struct {
+	allowances     []Allowance
+	limits         []AllowanceLimit
+	taxLevels      []TaxLevel
+	changeRequests []ChangeRequest
+	importRequests []ConfigImportRequest
+	lastIds        map[string]int
+}
+
+type MemoryAllowanceRepository struct {
+	mu    *sync.Mutex
+	state *memoryAllowanceState
+	tx    bool
+}
+
+func getMemoryAllowanceDefaultValues() ([]Allowance, []AllowanceLimit, []TaxLevel) {
+	endAmounts := []float64{150000, 500000, 1000000, 2000000}
+	allowances := []Allowance{
+		{AllowanceType: "personal", Amount: 60000.00},
+		{AllowanceType: "donation", Amount: 100000.00},
+		{AllowanceType: "k-receipt", Amount: 50000.00},
+		{AllowanceType: "spouse", Amount: 60000.00},
+		{AllowanceType: "child", Amount: 30000.00},
+		{AllowanceType: "parent", Amount: 30000.00},
+		{AllowanceType: "disability", Amount: 60000.00},
+	}
+	limits := []AllowanceLimit{
+		{AllowanceType: "personal", MinAmount: 10000.00, MaxAmount: 100000.00},
+		{AllowanceType: "donation", MinAmount: 0, MaxAmount: 100000.00, MinExclusive: true},
+		{AllowanceType: "k-receipt", MinAmount: 0, MaxAmount: 100000.00, MinExclusive: true},
+		{AllowanceType: "spouse", MinAmount: 0, MaxAmount: 100000.00},
+		{AllowanceType: "child", MinAmount: 0, MaxAmount: 100000.00},
+		{AllowanceType: "parent", MinAmount: 0, MaxAmount: 100000.00},
+		{AllowanceType: "disability", MinAmount: 0, MaxAmount: 200000.00},
+	}
+	taxLevels := []TaxLevel{
+		{Name: "0-150,000", StartAmount: 0, EndAmount: &endAmounts[0], Percentage: 0},
+		{Name: "150,001-500,000", StartAmount: 150001, EndAmount: &endAmounts[1], Percentage: 10},
+		{Name: "500,001-1,000,000", StartAmount: 500001, EndAmount: &endAmounts[2], Percentage: 15},
+		{Name: "1,000,001-2,000,000", StartAmount: 1000001, EndAmount: &endAmounts[3], Percentage: 20},
+		{Name: "2,000,001 ขึ้นไป", StartAmount: 2000001, EndAmount: nil, Percentage: 35},
+	}
+	return allowances, limits, taxLevels
+}
+
+func NewMemoryAllowanceRepository() *MemoryAllowanceRepository {
+	r := &MemoryAllowanceRepository{mu: &sync.Mutex{}, state: &memoryAllowanceState{lastIds: make(map[string]int)}}
+	allowances, limits, taxLevels := getMemoryAllowanceDefaultValues()
+	for i := range allowances {
+		r.state.upsertAllowance(allowances[i], limits[i])
+	}
+	r.state.replaceTaxLevels(taxLevels)
+	return r
+}
+
+func (s *memoryAllowanceState) clone() *memoryAllowanceState {
+	return &memoryAllowanceState{
+		allowances:     slices.Clone(s.allowances),
+		limits:         slices.Clone(s.limits),
+		taxLevels:      slices.Clone(s.taxLevels),
+		changeRequests: slices.Clone(s.changeRequests),
+		importRequests: slices.Clone(s.importRequests),
+		lastIds:        maps.Clone(s.lastIds),
+	}
+}
+
+func (s *memoryAllowanceState) nextId(table string) int {
+	s.lastIds[table]++
+	return s.lastIds[table]
+}
+
+func (s *memoryAllowanceState) upsertAllowance(allowance Allowance, limit AllowanceLimit) {
+	allowanceIndex := slices.IndexFunc(s.allowances, func(a Allowance) bool { return a.AllowanceType == allowance.AllowanceType })
+	if allowanceIndex < 0 {
+		s.allowances = append(s.allowances, Allowance{Id: s.nextId("allowance"), AllowanceType: allowance.AllowanceType, Amount: allowance.Amount, Version: 1})
+	} else if s.allowances[allowanceIndex].Amount != allowance.Amount {
+		s.allowances[allowanceIndex].Amount = allowance.Amount
+		s.allowances[allowanceIndex].Version++
+	}
+	limit.AllowanceType = allowance.AllowanceType
+	limitIndex := slices.IndexFunc(s.limits, func(l AllowanceLimit) bool { return l.AllowanceType == allowance.AllowanceType })
+	if limitIndex < 0 {
+		limit.Id = s.nextId("allowance_limit")
+		s.limits = append(s.limits, limit)
+	} else {
+		limit.Id = s.limits[limitIndex].Id
+		s.limits[limitIndex] = limit
+	}
+}
+
+func (s *memoryAllowanceState) replaceTaxLevels(taxLevels []TaxLevel) {
+	s.taxLevels = make([]TaxLevel, 0, len(taxLevels))
+	for _, taxLevel := range taxLevels {
+		taxLevel.Id = s.nextId("tax_level")
+		s.taxLevels = append(s.taxLevels, taxLevel)
+	}
+	sort.SliceStable(s.taxLevels, func(i, j int) bool { return s.taxLevels[i].StartAmount < s.taxLevels[j].StartAmount })
+}
+
+func (r *MemoryAllowanceRepository) with(ctx context.Context, run func(state *memoryAllowanceState) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !r.tx {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+	}
+	return run(r.state)
+}
+
+func (r *MemoryAllowanceRepository) Settings(ctx context.Context) ([]Allowance, []TaxLevel, error) {
+	var allowances []Allowance
+	var taxLevels []TaxLevel
+	err := r.with(ctx, func(state *memoryAllowanceState) error {
+		allowances = slices.Clone(state.allowances)
+		taxLevels = slices.Clone(state.taxLevels)
+		return nil
+	})
+	return allowances, taxLevels, err
+}
+
+func (r *MemoryAllowanceRepository) SearchAllAllowance(ctx context.Context) ([]Allowance, error) {
+	allowances, _, err := r.Settings(ctx)
+	return allowances, err
+}
+
+func (r *MemoryAllowanceRepository) SearchAllAllowanceLimit(ctx context.Context) ([]AllowanceLimit, error) {
+	var limits []AllowanceLimit
+	err := r.with(ctx, func(state *memoryAllowanceState) error {
+		limits = slices.Clone(state.limits)
+		return nil
+	})
+	return limits, err
+}
+
+func (r *MemoryAllowanceRepository) SearchAllowanceLimitByType(ctx context.Context, allowanceType string) (AllowanceLimit, error) {
+	result := AllowanceLimit{}
+	err := r.with(ctx, func(state *memoryAllowanceState) error {
+		index := slices.IndexFunc(state.limits, func(l AllowanceLimit) bool { return l.AllowanceType == allowanceType })
+		if index < 0 {
+			return ErrNotFound
+		}
+		result = state.limits[index]
+		return nil
+	})
+	return result, err
+}
+
+func (r *MemoryAllowanceRepository) SearchAllowanceByType(ctx context.Context, allowanceType string) (Allowance, error) {
+	result := Allowance{}
+	err := r.with(ctx, func(state *memoryAllowanceState) error {
+		index := slices.IndexFunc(state.allowances, func(a Allowance) bool { return a.AllowanceType == allowanceType })
+		if index < 0 {
+			return ErrNotFound
+		}
+		result = state.allowances[index]
+		return nil
+	})
+	return result, err
+}
+
+func (r *MemoryAllowanceRepository) SearchAllTaxLevel(ctx context.Context) ([]TaxLevel, error) {
+	_, taxLevels, err := r.Settings(ctx)
+	return taxLevels, err
+}
+
+func (r *MemoryAllowanceRepository) UpdateAllowanceAmount(ctx context.Context, allowanceType string, amount float64, version int) error {
+	return r.with(ctx, func(state *memoryAllowanceState) error {
+		index := slices.IndexFunc(state.allowances, func(a Allowance) bool { return a.AllowanceType == allowanceType })
+		if index < 0 {
+			return ErrNotFound
+		}
+		if state.allowances[index].Version != version {
+			return ErrAllowanceVersionConflict
+		}
+		state.allowances[index].Amount = amount
+		state.allowances[index].Version++
+		return nil
+	})
+}
+
+func (r *MemoryAllowanceRepository) UpsertAllowance(ctx context.Context, allowance Allowance, limit AllowanceLimit) error {
+	return r.with(ctx, func(state *memoryAllowanceState) error {
+		state.upsertAllowance(allowance, limit)
+		return nil
+	})
+}
+
+func (r *MemoryAllowanceRepository) ReplaceTaxLevels(ctx context.Context, taxLevels []TaxLevel) error {
+	return r.with(ctx, func(state *memoryAllowanceState) error {
+		state.replaceTaxLevels(taxLevels)
+		return nil
+	})
+}
+
+func (r *MemoryAllowanceRepository) InsertChangeRequest(ctx context.Context, changeRequest *ChangeRequest) error {
+	return r.with(ctx, func(state *memoryAllowanceState) error {
+		changeRequest.Id = state.nextId("allowance_change_request")
+		state.changeRequests = append(state.changeRequests, *changeRequest)
+		return nil
+	})
+}
+
+func (r *MemoryAllowanceRepository) SearchChangeRequestById(ctx context.Context, id int) (ChangeRequest, error) {
+	result := ChangeRequest{}
+	err := r.with(ctx, func(state *memoryAllowanceState) error {
+		index := slices.IndexFunc(state.changeRequests, func(cr ChangeRequest) bool { return cr.Id == id })
+		if index < 0 {
+			return ErrNotFound
+		}
+		result = state.changeRequests[index]
+		return nil
+	})
+	return result, err
+}
+
+func (r *MemoryAllowanceRepository) SearchChangeRequestByStatus(ctx context.Context, status string) ([]ChangeRequest, error) {
+	results := make([]ChangeRequest, 0)
+	err := r.with(ctx, func(state *memoryAllowanceState) error {
+		for _, changeRequest := range state.changeRequests {
+			if changeRequest.Status == status {
+				results = append(results, changeRequest)
+			}
+		}
+		return nil
+	})
+	return results, err
+}
+
+func (r *MemoryAllowanceRepository) ExpireChangeRequests(ctx context.Context, now time.Time) error {
+	return r.with(ctx, func(state *memoryAllowanceState) error {
+		for i, changeRequest := range state.changeRequests {
+			if changeRequest.Status == PENDING && changeRequest.ExpiresAt.Before(now) {
+				reviewedAt := now
+				state.changeRequests[i].Status = EXPIRED
+				state.changeRequests[i].ReviewedAt = &reviewedAt
+			}
+		}
+		return nil
+	})
+}
+
+func (r *MemoryAllowanceRepository) UpdateChangeRequestStatus(ctx context.Context, changeRequest *ChangeRequest) error {
+	return r.with(ctx, func(state *memoryAllowanceState) error {
+		index := slices.IndexFunc(state.changeRequests, func(cr ChangeRequest) bool { return cr.Id == changeRequest.Id })
+		if index < 0 || state.changeRequests[index].Status != PENDING {
+			return ErrChangeRequestNotPending
+		}
+		state.changeRequests[index].Status = changeRequest.Status
+		state.changeRequests[index].ReviewedBy = changeRequest.ReviewedBy
+		state.changeRequests[index].ReviewedAt = changeRequest.ReviewedAt
+		return nil
+	})
+}
+
+func (r *MemoryAllowanceRepository) InsertConfigImportRequest(ctx context.Context, importRequest *ConfigImportRequest) error {
+	return r.with(ctx, func(state *memoryAllowanceState) error {
+		importRequest.Id = state.nextId("config_import_request")
+		state.importRequests = append(state.importRequests, *importRequest)
+		return nil
+	})
+}
+
+func (r *MemoryAllowanceRepository) SearchConfigImportRequestById(ctx context.Context, id int) (ConfigImportRequest, error) {
+	result := ConfigImportRequest{}
+	err := r.with(ctx, func(state *memoryAllowanceState) error {
+		index := slices.IndexFunc(state.importRequests, func(ir ConfigImportRequest) bool { return ir.Id == id })
+		if index < 0 {
+			return ErrNotFound
+		}
+		result = state.importRequests[index]
+		return nil
+	})
+	return result, err
+}
+
+func (r *MemoryAllowanceRepository) SearchConfigImportRequestByStatus(ctx context.Context, status string) ([]ConfigImportRequest, error) {
+	results := make([]ConfigImportRequest, 0)
+	err := r.with(ctx, func(state *memoryAllowanceState) error {
+		for _, importRequest := range state.importRequests {
+			if importRequest.Status == status {
+				results = append(results, importRequest)
+			}
+		}
+		return nil
+	})
+	return results, err
+}
+
+func (r *MemoryAllowanceRepository) ExpireConfigImportRequests(ctx context.Context, now time.Time) error {
+	return r.with(ctx, func(state *memoryAllowanceState) error {
+		for i, importRequest := range state.importRequests {
+			if importRequest.Status == PENDING && importRequest.ExpiresAt.Before(now) {
+				reviewedAt := now
+				state.importRequests[i].Status = EXPIRED
+				state.importRequests[i].ReviewedAt = &reviewedAt
+			}
+		}
+		return nil
+	})
+}
+
+func (r *MemoryAllowanceRepository) UpdateConfigImportRequestStatus(ctx context.Context, importRequest *ConfigImportRequest) error {
+	return r.with(ctx, func(state *memoryAllowanceState) error {
+		index := slices.IndexFunc(state.importRequests, func(ir ConfigImportRequest) bool { return ir.Id == importRequest.Id })
+		if index < 0 || state.importRequests[index].Status != PENDING {
+			return ErrChangeRequestNotPending
+		}
+		state.importRequests[index].Status = importRequest.Status
+		state.importRequests[index].ReviewedBy = importRequest.ReviewedBy
+		state.importRequests[index].ReviewedAt = importRequest.ReviewedAt
+		return nil
+	})
+}
+
+func (r *MemoryAllowanceRepository) Transaction(ctx context.Context, run func(repository AllowanceRepository) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if r.tx {
+		return run(r)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state := r.state.clone()
+	if err := run(&MemoryAllowanceRepository{mu: r.mu, state: state, tx: true}); err != nil {
+		return err
+	}
+	*r.state = *state
+	return nil
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewMemoryAllowanceRepository(t *testing.T) {
+	t.Parallel()
+	r := NewMemoryAllowanceRepository()
+	allowances, taxLevels, err := r.Settings(context.Background())
+	if err != nil {
+		t.Fatalf("MemoryAllowanceRepository.Settings() error = %v", err)
+	}
+	if len(allowances) != 7 || allowances[0] != (Allowance{Id: 1, AllowanceType: "personal", Amount: 60000, Version: 1}) {
+		t.Errorf("MemoryAllowanceRepository.Settings() allowances = %v, want defaults", allowances)
+	}
+	if len(taxLevels) != 5 || taxLevels[4].EndAmount != nil {
+		t.Errorf("MemoryAllowanceRepository.Settings() taxLevels = %v, want defaults", taxLevels)
+	}
+	limits, _ := r.SearchAllAllowanceLimit(context.Background())
+	for i, limit := range limits {
+		if !limit.Allows(allowances[i].Amount) {
+			t.Errorf("default amount of %v is outside its limit", limit.AllowanceType)
+		}
+	}
+}
+
+func TestMemoryAllowanceRepository_SearchAllowanceLimitByType(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name          string
+		allowanceType string
+		want          AllowanceLimit
+		wantErr       error
+	}{
+		{"Should return allowance limit when allowance type exists", "k-receipt", AllowanceLimit{Id: 3, AllowanceType: "k-receipt", MinAmount: 0, MaxAmount: 100000, MinExclusive: true}, nil},
+		{"Should return ErrNotFound when allowance type does not exist", "insurance", AllowanceLimit{}, ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewMemoryAllowanceRepository().SearchAllowanceLimitByType(context.Background(), tt.allowanceType)
+			if err != tt.wantErr {
+				t.Errorf("MemoryAllowanceRepository.SearchAllowanceLimitByType() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("MemoryAllowanceRepository.SearchAllowanceLimitByType() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryAllowanceRepository_UpdateAllowanceAmount(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name          string
+		allowanceType string
+		version       int
+		wantErr       error
+		wantVersion   int
+	}{
+		{"Should update amount and increase version when version matches", "personal", 1, nil, 2},
+		{"Should return ErrAllowanceVersionConflict when version is stale", "personal", 2, ErrAllowanceVersionConflict, 1},
+		{"Should return ErrNotFound when allowance type does not exist", "insurance", 1, ErrNotFound, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewMemoryAllowanceRepository()
+			if err := r.UpdateAllowanceAmount(context.Background(), tt.allowanceType, 70000, tt.version); err != tt.wantErr {
+				t.Errorf("MemoryAllowanceRepository.UpdateAllowanceAmount() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got, _ := r.SearchAllowanceByType(context.Background(), tt.allowanceType)
+			if got.Version != tt.wantVersion {
+				t.Errorf("MemoryAllowanceRepository.SearchAllowanceByType() version = %v, want %v", got.Version, tt.wantVersion)
+			}
+		})
+	}
+}
+
+func TestMemoryAllowanceRepository_Transaction(t *testing.T) {
+	t.Parallel()
+	t.Run("Should keep changes when transaction succeeds", func(t *testing.T) {
+		r := NewMemoryAllowanceRepository()
+		err := r.Transaction(context.Background(), func(repository AllowanceRepository) error {
+			if err := repository.UpdateAllowanceAmount(context.Background(), "personal", 70000, 1); err != nil {
+				return err
+			}
+			return repository.UpsertAllowance(context.Background(), Allowance{AllowanceType: "insurance", Amount: 10000}, AllowanceLimit{MaxAmount: 100000})
+		})
+		if err != nil {
+			t.Errorf("MemoryAllowanceRepository.Transaction() error = %v", err)
+		}
+		allowances, _ := r.SearchAllAllowance(context.Background())
+		if allowances[0].Amount != 70000 || allowances[len(allowances)-1].AllowanceType != "insurance" {
+			t.Errorf("MemoryAllowanceRepository.Transaction() allowances = %v, want committed changes", allowances)
+		}
+	})
+
+	t.Run("Should return error when context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		r := NewMemoryAllowanceRepository()
+		if err := r.Transaction(ctx, func(repository AllowanceRepository) error { return nil }); err != context.Canceled {
+			t.Errorf("MemoryAllowanceRepository.Transaction() error = %v, want %v", err, context.Canceled)
+		}
+		if _, _, err := r.Settings(ctx); err != context.Canceled {
+			t.Errorf("MemoryAllowanceRepository.Settings() error = %v, want %v", err, context.Canceled)
+		}
+	})
+
+	t.Run("Should discard changes when transaction fails", func(t *testing.T) {
+		r := NewMemoryAllowanceRepository()
+		wantErr := errors.New("rollback")
+		err := r.Transaction(context.Background(), func(repository AllowanceRepository) error {
+			if err := repository.ReplaceTaxLevels(context.Background(), make([]TaxLevel, 0)); err != nil {
+				return err
+			}
+			return wantErr
+		})
+		if err != wantErr {
+			t.Errorf("MemoryAllowanceRepository.Transaction() error = %v, want %v", err, wantErr)
+		}
+		if taxLevels, _ := r.SearchAllTaxLevel(context.Background()); len(taxLevels) != 5 {
+			t.Errorf("MemoryAllowanceRepository.Transaction() taxLevels = %v, want defaults", taxLevels)
+		}
+	})
+}
+
+func TestMemoryAllowanceRepository_ChangeRequest(t *testing.T) {
+	t.Parallel()
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	reviewedAt := createdAt.Add(time.Hour)
+	r := NewMemoryAllowanceRepository()
+	first := &ChangeRequest{AllowanceType: "personal", Amount: 70000, Status: PENDING, RequestedBy: "admin", CreatedAt: createdAt, ExpiresAt: createdAt.Add(24 * time.Hour)}
+	second := &ChangeRequest{AllowanceType: "k-receipt", Amount: 80000, Status: PENDING, RequestedBy: "admin", CreatedAt: createdAt, ExpiresAt: createdAt.Add(time.Minute)}
+	if err := r.InsertChangeRequest(context.Background(), first); err != nil || first.Id != 1 {
+		t.Fatalf("MemoryAllowanceRepository.InsertChangeRequest() id = %v, error = %v", first.Id, err)
+	}
+	if err := r.InsertChangeRequest(context.Background(), second); err != nil || second.Id != 2 {
+		t.Fatalf("MemoryAllowanceRepository.InsertChangeRequest() id = %v, error = %v", second.Id, err)
+	}
+
+	t.Run("Should expire overdue pending change requests", func(t *testing.T) {
+		if err := r.ExpireChangeRequests(context.Background(), reviewedAt); err != nil {
+			t.Errorf("MemoryAllowanceRepository.ExpireChangeRequests() error = %v", err)
+		}
+		got, _ := r.SearchChangeRequestByStatus(context.Background(), PENDING)
+		if len(got) != 1 || got[0].Id != 1 {
+			t.Errorf("MemoryAllowanceRepository.SearchChangeRequestByStatus() = %v, want only change request 1", got)
+		}
+	})
+
+	t.Run("Should update status only once", func(t *testing.T) {
+		approved := *first
+		approved.Status, approved.ReviewedBy, approved.ReviewedAt = APPROVED, "approver", &reviewedAt
+		if err := r.UpdateChangeRequestStatus(context.Background(), &approved); err != nil {
+			t.Errorf("MemoryAllowanceRepository.UpdateChangeRequestStatus() error = %v", err)
+		}
+		if err := r.UpdateChangeRequestStatus(context.Background(), &approved); err != ErrChangeRequestNotPending {
+			t.Errorf("MemoryAllowanceRepository.UpdateChangeRequestStatus() error = %v, want %v", err, ErrChangeRequestNotPending)
+		}
+		got, err := r.SearchChangeRequestById(context.Background(), 1)
+		if err != nil || !reflect.DeepEqual(got, approved) {
+			t.Errorf("MemoryAllowanceRepository.SearchChangeRequestById() = %v, want %v", got, approved)
+		}
+	})
+
+	t.Run("Should return ErrNotFound when change request does not exist", func(t *testing.T) {
+		if _, err := r.SearchChangeRequestById(context.Background(), 3); err != ErrNotFound {
+			t.Errorf("MemoryAllowanceRepository.SearchChangeRequestById() error = %v, want %v", err, ErrNotFound)
+		}
+	})
+}
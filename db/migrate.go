@@ -0,0 +1,248 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed migrations/*.sql migrations/sqlite/*.sql
+var migrationFiles embed.FS
+
+var (
+	MIGRATIONLOCKID  = int64(5723119046)
+	migrationPattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+)
+
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+}
+
+type appliedMigration struct {
+	version   int
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+type Migrator struct {
+	DB         *sql.DB
+	Dialect    Dialect
+	Migrations []Migration
+}
+
+func LoadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationPattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %v and %v", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%v has no up script", migration.Version, migration.Name)
+		}
+		sum := sha256.Sum256([]byte(migration.Up))
+		migration.Checksum = hex.EncodeToString(sum[:])
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func NewMigrator(db *sql.DB, dialect Dialect) (*Migrator, error) {
+	migrations, err := LoadMigrations(migrationFiles, dialect.MigrationsDir)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Dialect: dialect, Migrations: migrations}, nil
+}
+
+func createSchemaMigrationsTable(ctx context.Context, db Executor, d Dialect) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, d.SchemaMigrationsTable); err != nil {
+		return err
+	}
+	return nil
+}
+
+func searchAppliedMigrations(ctx context.Context, db Executor) ([]appliedMigration, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	results := make([]appliedMigration, 0)
+	rows, err := db.QueryContext(ctx, "SELECT version, name, checksum, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		applied := appliedMigration{}
+		if err := rows.Scan(&applied.version, &applied.name, &applied.checksum, &applied.appliedAt); err != nil {
+			return nil, err
+		}
+		results = append(results, applied)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (m *Migrator) find(version int) (Migration, bool) {
+	for _, migration := range m.Migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+func (m *Migrator) locked(run func(tx *sql.Tx, applied []appliedMigration) error) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if m.Dialect.MigrationLock != "" {
+		if _, err := tx.Exec(m.Dialect.MigrationLock, MIGRATIONLOCKID); err != nil {
+			return err
+		}
+	}
+	if err := createSchemaMigrationsTable(context.Background(), tx, m.Dialect); err != nil {
+		return err
+	}
+	applied, err := searchAppliedMigrations(context.Background(), tx)
+	if err != nil {
+		return err
+	}
+	for _, a := range applied {
+		migration, ok := m.find(a.version)
+		if ok && migration.Checksum != a.checksum {
+			return fmt.Errorf("migration %d_%v was modified after it was applied", a.version, a.name)
+		}
+	}
+	if err := run(tx, applied); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *Migrator) Up() (int, error) {
+	count := 0
+	err := m.locked(func(tx *sql.Tx, applied []appliedMigration) error {
+		done := make(map[int]bool)
+		for _, a := range applied {
+			done[a.version] = true
+		}
+		for _, migration := range m.Migrations {
+			if done[migration.Version] {
+				continue
+			}
+			if _, err := tx.Exec(migration.Up); err != nil {
+				return fmt.Errorf("migration %d_%v failed : %v", migration.Version, migration.Name, err)
+			}
+			if _, err := tx.Exec("INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES ($1,$2,$3,$4)", migration.Version, migration.Name, migration.Checksum, time.Now()); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (m *Migrator) Down(steps int) (int, error) {
+	count := 0
+	err := m.locked(func(tx *sql.Tx, applied []appliedMigration) error {
+		for i := len(applied) - 1; i >= 0 && count < steps; i-- {
+			migration, ok := m.find(applied[i].version)
+			if !ok {
+				return fmt.Errorf("migration %d_%v is not known to this build", applied[i].version, applied[i].name)
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%v has no down script", migration.Version, migration.Name)
+			}
+			if _, err := tx.Exec(migration.Down); err != nil {
+				return fmt.Errorf("migration %d_%v rollback failed : %v", migration.Version, migration.Name, err)
+			}
+			if _, err := tx.Exec("DELETE FROM schema_migrations WHERE version = $1", migration.Version); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	results := make([]MigrationStatus, 0)
+	err := m.locked(func(tx *sql.Tx, applied []appliedMigration) error {
+		appliedAt := make(map[int]time.Time)
+		for _, a := range applied {
+			appliedAt[a.version] = a.appliedAt
+		}
+		for _, migration := range m.Migrations {
+			status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+			if at, ok := appliedAt[migration.Version]; ok {
+				status.AppliedAt = &at
+			}
+			results = append(results, status)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
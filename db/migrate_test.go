@@ -0,0 +1,212 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var (
+	lockMigrationsSql              = "SELECT pg_advisory_xact_lock($1)"
+	createSchemaMigrationsTableSql = "CREATE TABLE IF NOT EXISTS schema_migrations ( version INT PRIMARY KEY, name TEXT NOT NULL, checksum TEXT NOT NULL, applied_at TIMESTAMPTZ NOT NULL)"
+	selectSchemaMigrationsSql      = "SELECT version, name, checksum, applied_at FROM schema_migrations ORDER BY version"
+	insertSchemaMigrationSql       = "INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES ($1,$2,$3,$4)"
+	deleteSchemaMigrationSql       = "DELETE FROM schema_migrations WHERE version = $1"
+	schemaMigrationColumns         = []string{"version", "name", "checksum", "applied_at"}
+)
+
+func mockMigrations(t *testing.T) []Migration {
+	migrations, err := LoadMigrations(fstest.MapFS{
+		"migrations/0001_create_a.up.sql":   {Data: []byte("CREATE TABLE a (id INT)")},
+		"migrations/0001_create_a.down.sql": {Data: []byte("DROP TABLE a")},
+		"migrations/0002_create_b.up.sql":   {Data: []byte("CREATE TABLE b (id INT)")},
+		"migrations/0002_create_b.down.sql": {Data: []byte("DROP TABLE b")},
+	}, "migrations")
+	if err != nil {
+		t.Fatalf("LoadMigrations() error = %v", err)
+	}
+	return migrations
+}
+
+func mockMigrationDb(t *testing.T, setup func(mock sqlmock.Sqlmock)) (*sql.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	setup(mock)
+	return db, mock
+}
+
+func mockMigrationLock(mock sqlmock.Sqlmock, applied *sqlmock.Rows) {
+	mock.ExpectBegin()
+	mock.ExpectExec(lockMigrationsSql).WithArgs(MIGRATIONLOCKID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(createSchemaMigrationsTableSql).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(selectSchemaMigrationsSql).WillReturnRows(applied)
+}
+
+func TestLoadMigrations(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		want    []int
+		wantErr string
+	}{
+		{"Should load migrations ordered by version", fstest.MapFS{
+			"migrations/0010_c.up.sql": {Data: []byte("C")},
+			"migrations/0002_b.up.sql": {Data: []byte("B")},
+			"migrations/0001_a.up.sql": {Data: []byte("A")},
+			"migrations/README.md":     {Data: []byte("ignored")},
+		}, []int{1, 2, 10}, ""},
+		{"Should return error when migration has no up script", fstest.MapFS{
+			"migrations/0001_a.down.sql": {Data: []byte("A")},
+		}, nil, "migration 1_a has no up script"},
+		{"Should return error when migration versions have different names", fstest.MapFS{
+			"migrations/0001_a.up.sql":   {Data: []byte("A")},
+			"migrations/0001_b.down.sql": {Data: []byte("B")},
+		}, nil, "migration 1 has conflicting names a and b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadMigrations(tt.fsys, "migrations")
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("LoadMigrations() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			versions := make([]int, 0)
+			for _, migration := range got {
+				versions = append(versions, migration.Version)
+				if len(migration.Checksum) != 64 {
+					t.Errorf("LoadMigrations() checksum = %v, want sha256 hex", migration.Checksum)
+				}
+			}
+			if !reflect.DeepEqual(versions, tt.want) {
+				t.Errorf("LoadMigrations() versions = %v, want %v", versions, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewMigrator(t *testing.T) {
+	t.Parallel()
+	migrator, err := NewMigrator(nil, POSTGRES)
+	if err != nil {
+		t.Fatalf("NewMigrator() error = %v", err)
+	}
+	for i, migration := range migrator.Migrations {
+		if migration.Version != i+1 {
+			t.Errorf("NewMigrator() migration %v has version %v, want %v", migration.Name, migration.Version, i+1)
+		}
+		if migration.Down == "" {
+			t.Errorf("NewMigrator() migration %v has no down script", migration.Name)
+		}
+	}
+}
+
+func TestMigrator_Up(t *testing.T) {
+	t.Parallel()
+	appliedAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		setup     func(mock sqlmock.Sqlmock, migrations []Migration)
+		wantCount int
+		wantErr   string
+	}{
+		{"Should apply only pending migrations", func(mock sqlmock.Sqlmock, migrations []Migration) {
+			mockMigrationLock(mock, mock.NewRows(schemaMigrationColumns).AddRow(1, "create_a", migrations[0].Checksum, appliedAt))
+			mock.ExpectExec("CREATE TABLE b (id INT)").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(insertSchemaMigrationSql).WithArgs(2, "create_b", migrations[1].Checksum, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		}, 1, ""},
+		{"Should return error when applied migration checksum changed", func(mock sqlmock.Sqlmock, migrations []Migration) {
+			mockMigrationLock(mock, mock.NewRows(schemaMigrationColumns).AddRow(1, "create_a", "changed", appliedAt))
+			mock.ExpectRollback()
+		}, 0, "migration 1_create_a was modified after it was applied"},
+		{"Should rollback when migration fails", func(mock sqlmock.Sqlmock, migrations []Migration) {
+			mockMigrationLock(mock, mock.NewRows(schemaMigrationColumns))
+			mock.ExpectExec("CREATE TABLE a (id INT)").WillReturnError(errors.New("syntax error"))
+			mock.ExpectRollback()
+		}, 0, "migration 1_create_a failed : syntax error"},
+		{"Should return error when lock cannot be acquired", func(mock sqlmock.Sqlmock, migrations []Migration) {
+			mock.ExpectBegin()
+			mock.ExpectExec(lockMigrationsSql).WithArgs(MIGRATIONLOCKID).WillReturnError(sql.ErrConnDone)
+			mock.ExpectRollback()
+		}, 0, sql.ErrConnDone.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations := mockMigrations(t)
+			db, mock := mockMigrationDb(t, func(mock sqlmock.Sqlmock) { tt.setup(mock, migrations) })
+			defer db.Close()
+
+			got, err := (&Migrator{DB: db, Dialect: POSTGRES, Migrations: migrations}).Up()
+			if (err != nil || tt.wantErr != "") && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Migrator.Up() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.wantCount {
+				t.Errorf("Migrator.Up() = %v, want %v", got, tt.wantCount)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestMigrator_Down(t *testing.T) {
+	t.Parallel()
+	appliedAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	migrations := mockMigrations(t)
+	db, mock := mockMigrationDb(t, func(mock sqlmock.Sqlmock) {
+		mockMigrationLock(mock, mock.NewRows(schemaMigrationColumns).
+			AddRow(1, "create_a", migrations[0].Checksum, appliedAt).
+			AddRow(2, "create_b", migrations[1].Checksum, appliedAt))
+		mock.ExpectExec("DROP TABLE b").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(deleteSchemaMigrationSql).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	})
+	defer db.Close()
+
+	t.Run("Should rollback the latest applied migration", func(t *testing.T) {
+		got, err := (&Migrator{DB: db, Dialect: POSTGRES, Migrations: migrations}).Down(1)
+		if err != nil {
+			t.Errorf("Migrator.Down() error = %v", err)
+		}
+		if got != 1 {
+			t.Errorf("Migrator.Down() = %v, want 1", got)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestMigrator_Status(t *testing.T) {
+	t.Parallel()
+	appliedAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	migrations := mockMigrations(t)
+	db, _ := mockMigrationDb(t, func(mock sqlmock.Sqlmock) {
+		mockMigrationLock(mock, mock.NewRows(schemaMigrationColumns).AddRow(1, "create_a", migrations[0].Checksum, appliedAt))
+		mock.ExpectCommit()
+	})
+	defer db.Close()
+
+	t.Run("Should report applied and pending migrations", func(t *testing.T) {
+		want := []MigrationStatus{{Version: 1, Name: "create_a", AppliedAt: &appliedAt}, {Version: 2, Name: "create_b"}}
+		got, err := (&Migrator{DB: db, Dialect: POSTGRES, Migrations: migrations}).Status()
+		if err != nil {
+			t.Errorf("Migrator.Status() error = %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Migrator.Status() = %v, want %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS allowance ( id SERIAL PRIMARY KEY, allowance_type TEXT UNIQUE, amount float);
+CREATE TABLE IF NOT EXISTS allowance_limit ( id SERIAL PRIMARY KEY, allowance_type TEXT UNIQUE, min_amount float, max_amount float, min_exclusive BOOLEAN);
+CREATE TABLE IF NOT EXISTS allowance_change_request ( id SERIAL PRIMARY KEY, allowance_type TEXT NOT NULL, amount float NOT NULL, status TEXT NOT NULL, requested_by TEXT NOT NULL, reviewed_by TEXT, created_at TIMESTAMPTZ NOT NULL, expires_at TIMESTAMPTZ NOT NULL, reviewed_at TIMESTAMPTZ);
+CREATE TABLE IF NOT EXISTS tax_level ( id SERIAL PRIMARY KEY, name TEXT UNIQUE, start_amount float, end_amount float, percentage float);
@@ -0,0 +1,3 @@
+DELETE FROM allowance_limit WHERE allowance_type IN ('personal', 'donation', 'k-receipt', 'spouse', 'child', 'parent', 'disability');
+DELETE FROM allowance WHERE allowance_type IN ('personal', 'donation', 'k-receipt', 'spouse', 'child', 'parent', 'disability');
+DELETE FROM tax_level;
@@ -0,0 +1,27 @@
+INSERT INTO allowance (allowance_type, amount) VALUES
+    ('personal', 60000.00),
+    ('donation', 100000.00),
+    ('k-receipt', 50000.00),
+    ('spouse', 60000.00),
+    ('child', 30000.00),
+    ('parent', 30000.00),
+    ('disability', 60000.00)
+ON CONFLICT (allowance_type) DO NOTHING;
+INSERT INTO allowance_limit (allowance_type, min_amount, max_amount, min_exclusive) VALUES
+    ('personal', 10000.00, 100000.00, FALSE),
+    ('donation', 0.00, 100000.00, TRUE),
+    ('k-receipt', 0.00, 100000.00, TRUE),
+    ('spouse', 0.00, 100000.00, FALSE),
+    ('child', 0.00, 100000.00, FALSE),
+    ('parent', 0.00, 100000.00, FALSE),
+    ('disability', 0.00, 200000.00, FALSE)
+ON CONFLICT (allowance_type) DO NOTHING;
+INSERT INTO tax_level (name, start_amount, end_amount, percentage)
+SELECT name, start_amount, end_amount, percentage FROM (VALUES
+    ('0-150,000', 0.00, 150000.00, 0.00),
+    ('150,001-500,000', 150001.00, 500000.00, 10.00),
+    ('500,001-1,000,000', 500001.00, 1000000.00, 15.00),
+    ('1,000,001-2,000,000', 1000001.00, 2000000.00, 20.00),
+    ('2,000,001 ขึ้นไป', 2000001.00, NULL, 35.00)
+) AS defaults (name, start_amount, end_amount, percentage)
+WHERE NOT EXISTS (SELECT 1 FROM tax_level);
@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS calculation_job ( id SERIAL PRIMARY KEY, status TEXT NOT NULL, strict BOOLEAN NOT NULL, format TEXT NOT NULL, delimiter TEXT NOT NULL DEFAULT '', encoding TEXT NOT NULL DEFAULT '', file_type TEXT NOT NULL DEFAULT 'csv', sheet TEXT NOT NULL DEFAULT '', file BYTEA NOT NULL, total_rows INT NOT NULL DEFAULT 0, processed_rows INT NOT NULL DEFAULT 0, result BYTEA, content_type TEXT, error TEXT, created_at TIMESTAMPTZ NOT NULL, started_at TIMESTAMPTZ, completed_at TIMESTAMPTZ);
+ALTER TABLE calculation_job ADD COLUMN IF NOT EXISTS delimiter TEXT NOT NULL DEFAULT '', ADD COLUMN IF NOT EXISTS encoding TEXT NOT NULL DEFAULT '', ADD COLUMN IF NOT EXISTS file_type TEXT NOT NULL DEFAULT 'csv', ADD COLUMN IF NOT EXISTS sheet TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+CREATE TABLE IF NOT EXISTS idempotency_key ( key TEXT NOT NULL, endpoint TEXT NOT NULL, request_hash TEXT NOT NULL, status_code INT NOT NULL, content_type TEXT NOT NULL, body BYTEA NOT NULL, created_at TIMESTAMPTZ NOT NULL, expires_at TIMESTAMPTZ NOT NULL, PRIMARY KEY (key, endpoint));
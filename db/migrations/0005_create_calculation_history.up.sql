@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS calculation_history ( id SERIAL PRIMARY KEY, taxpayer_id TEXT NOT NULL DEFAULT '', tax_year INT NOT NULL, source TEXT NOT NULL, input JSONB NOT NULL, output JSONB NOT NULL, config_version TEXT NOT NULL, created_at TIMESTAMPTZ NOT NULL);
+CREATE INDEX IF NOT EXISTS calculation_history_taxpayer_idx ON calculation_history (taxpayer_id, tax_year, id);
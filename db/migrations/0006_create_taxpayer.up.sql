@@ -0,0 +1 @@
+CREATE TABLE IF NOT EXISTS taxpayer ( id TEXT PRIMARY KEY, name TEXT NOT NULL DEFAULT '', marital_status TEXT NOT NULL, children INT NOT NULL DEFAULT 0, disabled BOOLEAN NOT NULL DEFAULT FALSE, parents_supported INT NOT NULL DEFAULT 0, created_at TIMESTAMPTZ NOT NULL, updated_at TIMESTAMPTZ NOT NULL);
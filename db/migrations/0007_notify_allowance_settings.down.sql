@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS allowance_settings_changed ON tax_level;
+DROP TRIGGER IF EXISTS allowance_settings_changed ON allowance;
+DROP FUNCTION IF EXISTS notify_allowance_settings();
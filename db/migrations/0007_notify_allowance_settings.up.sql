@@ -0,0 +1,10 @@
+CREATE OR REPLACE FUNCTION notify_allowance_settings() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_notify('allowance_settings', TG_TABLE_NAME);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+DROP TRIGGER IF EXISTS allowance_settings_changed ON allowance;
+CREATE TRIGGER allowance_settings_changed AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON allowance FOR EACH STATEMENT EXECUTE FUNCTION notify_allowance_settings();
+DROP TRIGGER IF EXISTS allowance_settings_changed ON tax_level;
+CREATE TRIGGER allowance_settings_changed AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON tax_level FOR EACH STATEMENT EXECUTE FUNCTION notify_allowance_settings();
@@ -0,0 +1,2 @@
+ALTER TABLE allowance_change_request DROP COLUMN IF EXISTS allowance_version;
+ALTER TABLE allowance DROP COLUMN IF EXISTS version;
@@ -0,0 +1,2 @@
+ALTER TABLE allowance ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+ALTER TABLE allowance_change_request ADD COLUMN IF NOT EXISTS allowance_version INT NOT NULL DEFAULT 1;
@@ -0,0 +1 @@
+ALTER TABLE calculation_job DROP COLUMN IF EXISTS locked_until, DROP COLUMN IF EXISTS worker_id;
@@ -0,0 +1 @@
+ALTER TABLE calculation_job ADD COLUMN IF NOT EXISTS worker_id TEXT, ADD COLUMN IF NOT EXISTS locked_until TIMESTAMPTZ;
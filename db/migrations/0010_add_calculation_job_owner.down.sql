@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS calculation_job_public_id_idx;
+ALTER TABLE calculation_job DROP COLUMN IF EXISTS requested_by, DROP COLUMN IF EXISTS public_id;
@@ -0,0 +1,2 @@
+ALTER TABLE calculation_job ADD COLUMN IF NOT EXISTS public_id TEXT, ADD COLUMN IF NOT EXISTS requested_by TEXT NOT NULL DEFAULT '';
+CREATE UNIQUE INDEX IF NOT EXISTS calculation_job_public_id_idx ON calculation_job (public_id);
@@ -0,0 +1 @@
+ALTER TABLE idempotency_key ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'completed';
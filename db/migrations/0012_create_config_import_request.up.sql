@@ -0,0 +1 @@
+CREATE TABLE IF NOT EXISTS config_import_request ( id SERIAL PRIMARY KEY, document TEXT NOT NULL, status TEXT NOT NULL, requested_by TEXT NOT NULL, reviewed_by TEXT, created_at TIMESTAMPTZ NOT NULL, expires_at TIMESTAMPTZ NOT NULL, reviewed_at TIMESTAMPTZ);
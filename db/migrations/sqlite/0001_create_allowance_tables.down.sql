@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS tax_level;
+DROP TABLE IF EXISTS allowance_change_request;
+DROP TABLE IF EXISTS allowance_limit;
+DROP TABLE IF EXISTS allowance;
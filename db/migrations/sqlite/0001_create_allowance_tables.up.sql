@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS allowance ( id INTEGER PRIMARY KEY AUTOINCREMENT, allowance_type TEXT UNIQUE, amount REAL);
+CREATE TABLE IF NOT EXISTS allowance_limit ( id INTEGER PRIMARY KEY AUTOINCREMENT, allowance_type TEXT UNIQUE, min_amount REAL, max_amount REAL, min_exclusive BOOLEAN);
+CREATE TABLE IF NOT EXISTS allowance_change_request ( id INTEGER PRIMARY KEY AUTOINCREMENT, allowance_type TEXT NOT NULL, amount REAL NOT NULL, status TEXT NOT NULL, requested_by TEXT NOT NULL, reviewed_by TEXT, created_at TIMESTAMP NOT NULL, expires_at TIMESTAMP NOT NULL, reviewed_at TIMESTAMP);
+CREATE TABLE IF NOT EXISTS tax_level ( id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT UNIQUE, start_amount REAL, end_amount REAL, percentage REAL);
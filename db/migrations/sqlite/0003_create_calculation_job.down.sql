@@ -0,0 +1 @@
+DROP TABLE IF EXISTS calculation_job;
@@ -0,0 +1 @@
+CREATE TABLE IF NOT EXISTS calculation_job ( id INTEGER PRIMARY KEY AUTOINCREMENT, status TEXT NOT NULL, strict BOOLEAN NOT NULL, format TEXT NOT NULL, delimiter TEXT NOT NULL DEFAULT '', encoding TEXT NOT NULL DEFAULT '', file_type TEXT NOT NULL DEFAULT 'csv', sheet TEXT NOT NULL DEFAULT '', file BLOB NOT NULL, total_rows INTEGER NOT NULL DEFAULT 0, processed_rows INTEGER NOT NULL DEFAULT 0, result BLOB, content_type TEXT, error TEXT, created_at TIMESTAMP NOT NULL, started_at TIMESTAMP, completed_at TIMESTAMP);
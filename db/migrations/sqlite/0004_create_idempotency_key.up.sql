@@ -0,0 +1 @@
+CREATE TABLE IF NOT EXISTS idempotency_key ( key TEXT NOT NULL, endpoint TEXT NOT NULL, request_hash TEXT NOT NULL, status_code INTEGER NOT NULL, content_type TEXT NOT NULL, body BLOB NOT NULL, created_at TIMESTAMP NOT NULL, expires_at TIMESTAMP NOT NULL, PRIMARY KEY (key, endpoint));
@@ -0,0 +1,2 @@
+CREATE TABLE IF NOT EXISTS calculation_history ( id INTEGER PRIMARY KEY AUTOINCREMENT, taxpayer_id TEXT NOT NULL DEFAULT '', tax_year INTEGER NOT NULL, source TEXT NOT NULL, input TEXT NOT NULL, output TEXT NOT NULL, config_version TEXT NOT NULL, created_at TIMESTAMP NOT NULL);
+CREATE INDEX IF NOT EXISTS calculation_history_taxpayer_idx ON calculation_history (taxpayer_id, tax_year, id);
@@ -0,0 +1 @@
+CREATE TABLE IF NOT EXISTS taxpayer ( id TEXT PRIMARY KEY, name TEXT NOT NULL DEFAULT '', marital_status TEXT NOT NULL, children INTEGER NOT NULL DEFAULT 0, disabled BOOLEAN NOT NULL DEFAULT FALSE, parents_supported INTEGER NOT NULL DEFAULT 0, created_at TIMESTAMP NOT NULL, updated_at TIMESTAMP NOT NULL);
@@ -0,0 +1,2 @@
+ALTER TABLE allowance_change_request DROP COLUMN allowance_version;
+ALTER TABLE allowance DROP COLUMN version;
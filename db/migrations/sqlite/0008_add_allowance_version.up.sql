@@ -0,0 +1,2 @@
+ALTER TABLE allowance ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE allowance_change_request ADD COLUMN allowance_version INTEGER NOT NULL DEFAULT 1;
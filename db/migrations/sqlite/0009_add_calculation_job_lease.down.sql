@@ -0,0 +1,2 @@
+ALTER TABLE calculation_job DROP COLUMN locked_until;
+ALTER TABLE calculation_job DROP COLUMN worker_id;
@@ -0,0 +1,2 @@
+ALTER TABLE calculation_job ADD COLUMN worker_id TEXT;
+ALTER TABLE calculation_job ADD COLUMN locked_until TIMESTAMP;
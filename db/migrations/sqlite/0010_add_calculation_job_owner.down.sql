@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS calculation_job_public_id_idx;
+ALTER TABLE calculation_job DROP COLUMN requested_by;
+ALTER TABLE calculation_job DROP COLUMN public_id;
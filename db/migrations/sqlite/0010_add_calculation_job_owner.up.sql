@@ -0,0 +1,3 @@
+ALTER TABLE calculation_job ADD COLUMN public_id TEXT;
+ALTER TABLE calculation_job ADD COLUMN requested_by TEXT NOT NULL DEFAULT '';
+CREATE UNIQUE INDEX IF NOT EXISTS calculation_job_public_id_idx ON calculation_job (public_id);
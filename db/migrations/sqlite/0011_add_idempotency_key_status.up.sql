@@ -0,0 +1 @@
+ALTER TABLE idempotency_key ADD COLUMN status TEXT NOT NULL DEFAULT 'completed';
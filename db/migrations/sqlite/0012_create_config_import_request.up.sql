@@ -0,0 +1 @@
+CREATE TABLE IF NOT EXISTS config_import_request ( id INTEGER PRIMARY KEY AUTOINCREMENT, document TEXT NOT NULL, status TEXT NOT NULL, requested_by TEXT NOT NULL, reviewed_by TEXT, created_at TIMESTAMP NOT NULL, expires_at TIMESTAMP NOT NULL, reviewed_at TIMESTAMP);
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+var (
+	DEFAULTCONNECTRETRIES = 5
+	DEFAULTCONNECTBACKOFF = time.Second
+	MAXCONNECTBACKOFF     = 30 * time.Second
+)
+
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnectRetries  int
+	ConnectBackoff  time.Duration
+}
+
+type PoolStats struct {
+	MaxOpenConnections int    `json:"maxOpenConnections"`
+	OpenConnections    int    `json:"openConnections"`
+	InUse              int    `json:"inUse"`
+	Idle               int    `json:"idle"`
+	WaitCount          int64  `json:"waitCount"`
+	WaitDuration       string `json:"waitDuration"`
+	MaxIdleClosed      int64  `json:"maxIdleClosed"`
+	MaxIdleTimeClosed  int64  `json:"maxIdleTimeClosed"`
+	MaxLifetimeClosed  int64  `json:"maxLifetimeClosed"`
+}
+
+func (c PoolConfig) Apply(db *sql.DB) {
+	if c.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(c.MaxOpenConns)
+	}
+	if c.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(c.MaxIdleConns)
+	}
+	if c.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	}
+}
+
+func (c PoolConfig) connectRetries() int {
+	if c.ConnectRetries > 0 {
+		return c.ConnectRetries
+	}
+	return DEFAULTCONNECTRETRIES
+}
+
+func (c PoolConfig) connectBackoff() time.Duration {
+	if c.ConnectBackoff > 0 {
+		return c.ConnectBackoff
+	}
+	return DEFAULTCONNECTBACKOFF
+}
+
+func Ping(ctx context.Context, db *sql.DB) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	return db.PingContext(ctx)
+}
+
+func PingWithRetry(ctx context.Context, db *sql.DB, pool PoolConfig) error {
+	backoff := pool.connectBackoff()
+	retries := pool.connectRetries()
+	for attempt := 1; ; attempt++ {
+		err := Ping(ctx, db)
+		if err == nil {
+			return nil
+		}
+		if attempt >= retries {
+			return err
+		}
+		log.Printf("database is not reachable (attempt %d/%d), retrying in %v : %v\n", attempt, retries, backoff, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, MAXCONNECTBACKOFF)
+	}
+}
+
+func NewPoolStats(stats sql.DBStats) PoolStats {
+	return PoolStats{
+		MaxOpenConnections: stats.MaxOpenConnections,
+		OpenConnections:    stats.OpenConnections,
+		InUse:              stats.InUse,
+		Idle:               stats.Idle,
+		WaitCount:          stats.WaitCount,
+		WaitDuration:       stats.WaitDuration.String(),
+		MaxIdleClosed:      stats.MaxIdleClosed,
+		MaxIdleTimeClosed:  stats.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  stats.MaxLifetimeClosed,
+	}
+}
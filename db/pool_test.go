@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestPoolConfig_Apply(t *testing.T) {
+	t.Parallel()
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	PoolConfig{MaxOpenConns: 7, MaxIdleConns: 3, ConnMaxLifetime: time.Minute}.Apply(db)
+	if got := db.Stats().MaxOpenConnections; got != 7 {
+		t.Errorf("PoolConfig.Apply() MaxOpenConnections = %v, want %v", got, 7)
+	}
+}
+
+func TestPingWithRetry(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		pool    PoolConfig
+		setup   func(mock sqlmock.Sqlmock)
+		ctx     func() (context.Context, context.CancelFunc)
+		wantErr error
+	}{
+		{"Should return nil when database becomes reachable before retries run out", PoolConfig{ConnectRetries: 3, ConnectBackoff: time.Millisecond}, func(mock sqlmock.Sqlmock) {
+			mock.ExpectPing().WillReturnError(sql.ErrConnDone)
+			mock.ExpectPing().WillReturnError(sql.ErrConnDone)
+			mock.ExpectPing()
+		}, func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) }, nil},
+		{"Should return last error when retries run out", PoolConfig{ConnectRetries: 2, ConnectBackoff: time.Millisecond}, func(mock sqlmock.Sqlmock) {
+			mock.ExpectPing().WillReturnError(sql.ErrConnDone)
+			mock.ExpectPing().WillReturnError(sql.ErrConnDone)
+		}, func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) }, sql.ErrConnDone},
+		{"Should stop retrying when context is cancelled", PoolConfig{ConnectRetries: 3, ConnectBackoff: time.Hour}, func(mock sqlmock.Sqlmock) {
+			mock.ExpectPing().WillReturnError(sql.ErrConnDone)
+		}, func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 10*time.Millisecond)
+		}, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+			tt.setup(mock)
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			if err := PingWithRetry(ctx, db, tt.pool); err != tt.wantErr {
+				t.Errorf("PingWithRetry() error = %v, want %v", err, tt.wantErr)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestNewPoolStats(t *testing.T) {
+	t.Parallel()
+	got := NewPoolStats(sql.DBStats{MaxOpenConnections: 10, OpenConnections: 3, InUse: 1, Idle: 2, WaitCount: 4, WaitDuration: 1500 * time.Millisecond})
+	want := PoolStats{MaxOpenConnections: 10, OpenConnections: 3, InUse: 1, Idle: 2, WaitCount: 4, WaitDuration: "1.5s"}
+	if got != want {
+		t.Errorf("NewPoolStats() = %v, want %v", got, want)
+	}
+}
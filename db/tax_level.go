@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+)
+
+type TaxLevel struct {
+	Id          int      `json:"id"`
+	Name        string   `json:"name"`
+	StartAmount float64  `json:"startAmount"`
+	EndAmount   *float64 `json:"endAmount"`
+	Percentage  float64  `json:"percentage"`
+}
+
+func (l *TaxLevel) Insert(ctx context.Context, db Executor) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, "INSERT INTO tax_level (name, start_amount, end_amount, percentage) VALUES ($1,$2,$3,$4)", l.Name, l.StartAmount, l.EndAmount, l.Percentage); err != nil {
+		return err
+	}
+	return nil
+}
+
+func DeleteAllTaxLevel(ctx context.Context, db Executor) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, "DELETE FROM tax_level"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func SearchAllTaxLevel(ctx context.Context, db Executor) ([]TaxLevel, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	results := make([]TaxLevel, 0)
+	selectAllTaxLevel := "SELECT id, name, start_amount, end_amount, percentage FROM tax_level ORDER BY start_amount"
+	rows, err := db.QueryContext(ctx, selectAllTaxLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		taxLevel := TaxLevel{}
+		if err := rows.Scan(&taxLevel.Id, &taxLevel.Name, &taxLevel.StartAmount, &taxLevel.EndAmount, &taxLevel.Percentage); err != nil {
+			return nil, err
+		}
+		results = append(results, taxLevel)
+	}
+	return results, rows.Err()
+}
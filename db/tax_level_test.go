@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func mockTaxLevelDb(t *testing.T) *sql.DB {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	mock.MatchExpectationsInOrder(false)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	rowsAll := mock.NewRows([]string{"id", "name", "start_amount", "end_amount", "percentage"}).
+		AddRow(1, "0-150,000", 0.00, 150000.00, 0.00).
+		AddRow(2, "150,001 ขึ้นไป", 150001.00, nil, 10.00)
+	insertTaxLevelSql := "INSERT INTO tax_level (name, start_amount, end_amount, percentage) VALUES ($1,$2,$3,$4)"
+
+	mock.ExpectQuery("SELECT id, name, start_amount, end_amount, percentage FROM tax_level ORDER BY start_amount").WillReturnRows(rowsAll)
+	mock.ExpectExec(insertTaxLevelSql).WithArgs("0-150,000", 0.00, 150000.00, 0.00).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(insertTaxLevelSql).WithArgs("mockError", 0.00, nil, 0.00).WillReturnError(sql.ErrConnDone)
+	mock.ExpectExec("DELETE FROM tax_level").WillReturnResult(sqlmock.NewResult(0, 5))
+	return db
+}
+
+func TestSearchAllTaxLevel(t *testing.T) {
+	t.Parallel()
+	endAmount := 150000.00
+	want := []TaxLevel{{Id: 1, Name: "0-150,000", StartAmount: 0, EndAmount: &endAmount, Percentage: 0}, {Id: 2, Name: "150,001 ขึ้นไป", StartAmount: 150001, EndAmount: nil, Percentage: 10}}
+	got, err := SearchAllTaxLevel(context.Background(), mockTaxLevelDb(t))
+	if err != nil {
+		t.Errorf("SearchAllTaxLevel() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SearchAllTaxLevel() = %v, want %v", got, want)
+	}
+}
+
+func TestTaxLevel_Insert(t *testing.T) {
+	t.Parallel()
+	endAmount := 150000.00
+	tests := []struct {
+		name     string
+		taxLevel TaxLevel
+		want     error
+	}{
+		{"Should return nil when inserting tax level successfully", TaxLevel{Name: "0-150,000", StartAmount: 0, EndAmount: &endAmount, Percentage: 0}, nil},
+		{"Should return error when inserting tax level unsuccessfully", TaxLevel{Name: "mockError", StartAmount: 0, Percentage: 0}, sql.ErrConnDone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.taxLevel.Insert(context.Background(), mockTaxLevelDb(t)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TaxLevel.Insert() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteAllTaxLevel(t *testing.T) {
+	t.Parallel()
+	if got := DeleteAllTaxLevel(context.Background(), mockTaxLevelDb(t)); got != nil {
+		t.Errorf("DeleteAllTaxLevel() = %v, want nil", got)
+	}
+}
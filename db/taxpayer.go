@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrTaxpayerExists = errors.New("taxpayer already exists")
+
+type Taxpayer struct {
+	Id               string    `json:"id"`
+	Name             string    `json:"name,omitempty"`
+	MaritalStatus    string    `json:"maritalStatus"`
+	Children         int       `json:"children"`
+	Disabled         bool      `json:"disabled"`
+	ParentsSupported int       `json:"parentsSupported"`
+	CreatedAt        time.Time `json:"createdAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+}
+
+func scanTaxpayer(scanner interface{ Scan(dest ...any) error }) (Taxpayer, error) {
+	result := Taxpayer{}
+	if err := scanner.Scan(&result.Id, &result.Name, &result.MaritalStatus, &result.Children, &result.Disabled, &result.ParentsSupported, &result.CreatedAt, &result.UpdatedAt); err != nil {
+		return Taxpayer{}, err
+	}
+	return result, nil
+}
+
+func (tp *Taxpayer) Insert(ctx context.Context, db Executor) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	insertTaxpayer := "INSERT INTO taxpayer (id, name, marital_status, children, disabled, parents_supported, created_at, updated_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8) ON CONFLICT (id) DO NOTHING"
+	result, err := db.ExecContext(ctx, insertTaxpayer, tp.Id, tp.Name, tp.MaritalStatus, tp.Children, tp.Disabled, tp.ParentsSupported, tp.CreatedAt, tp.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrTaxpayerExists
+	}
+	return nil
+}
+
+func (tp *Taxpayer) Update(ctx context.Context, db Executor) error {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	updateTaxpayer := "UPDATE taxpayer SET name = $1, marital_status = $2, children = $3, disabled = $4, parents_supported = $5, updated_at = $6 WHERE id = $7 RETURNING created_at"
+	if err := db.QueryRowContext(ctx, updateTaxpayer, tp.Name, tp.MaritalStatus, tp.Children, tp.Disabled, tp.ParentsSupported, tp.UpdatedAt, tp.Id).Scan(&tp.CreatedAt); errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func DeleteTaxpayer(ctx context.Context, db Executor, id string) (bool, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	result, err := db.ExecContext(ctx, "DELETE FROM taxpayer WHERE id = $1", id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+func SearchTaxpayerById(ctx context.Context, db Executor, id string) (Taxpayer, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	selectTaxpayer := "SELECT id, name, marital_status, children, disabled, parents_supported, created_at, updated_at FROM taxpayer WHERE id = $1"
+	taxpayer, err := scanTaxpayer(db.QueryRowContext(ctx, selectTaxpayer, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Taxpayer{}, ErrNotFound
+	}
+	return taxpayer, err
+}
+
+func SearchAllTaxpayer(ctx context.Context, db Executor) ([]Taxpayer, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	results := make([]Taxpayer, 0)
+	rows, err := db.QueryContext(ctx, "SELECT id, name, marital_status, children, disabled, parents_supported, created_at, updated_at FROM taxpayer ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		taxpayer, err := scanTaxpayer(rows)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, taxpayer)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
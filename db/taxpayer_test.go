@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var taxpayerColumns = []string{"id", "name", "marital_status", "children", "disabled", "parents_supported", "created_at", "updated_at"}
+
+func mockTaxpayer(now time.Time) Taxpayer {
+	return Taxpayer{Id: "T001", Name: "Somchai", MaritalStatus: "married", Children: 2, Disabled: false, ParentsSupported: 1, CreatedAt: now, UpdatedAt: now}
+}
+
+func TestTaxpayer_Insert(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	insertTaxpayer := "INSERT INTO taxpayer (id, name, marital_status, children, disabled, parents_supported, created_at, updated_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8) ON CONFLICT (id) DO NOTHING"
+	tests := []struct {
+		name     string
+		affected int64
+		wantErr  error
+	}{
+		{"Should insert taxpayer when id is new", 1, nil},
+		{"Should return ErrTaxpayerExists when id already exists", 0, ErrTaxpayerExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := mockJobDb(t, func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(insertTaxpayer).WithArgs("T001", "Somchai", "married", 2, false, 1, now, now).WillReturnResult(sqlmock.NewResult(0, tt.affected))
+			})
+			defer db.Close()
+			taxpayer := mockTaxpayer(now)
+			if err := taxpayer.Insert(context.Background(), db); err != tt.wantErr {
+				t.Errorf("Taxpayer.Insert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTaxpayer_Update(t *testing.T) {
+	t.Parallel()
+	createdAt := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	updateTaxpayer := "UPDATE taxpayer SET name = $1, marital_status = $2, children = $3, disabled = $4, parents_supported = $5, updated_at = $6 WHERE id = $7 RETURNING created_at"
+	tests := []struct {
+		name    string
+		rows    *sqlmock.Rows
+		wantErr error
+	}{
+		{"Should update taxpayer and return created time", sqlmock.NewRows([]string{"created_at"}).AddRow(createdAt), nil},
+		{"Should return ErrNotFound when taxpayer does not exist", sqlmock.NewRows([]string{"created_at"}), ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := mockJobDb(t, func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(updateTaxpayer).WithArgs("Somchai", "married", 2, false, 1, now, "T001").WillReturnRows(tt.rows)
+			})
+			defer db.Close()
+			taxpayer := Taxpayer{Id: "T001", Name: "Somchai", MaritalStatus: "married", Children: 2, ParentsSupported: 1, UpdatedAt: now}
+			if err := taxpayer.Update(context.Background(), db); err != tt.wantErr {
+				t.Errorf("Taxpayer.Update() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && taxpayer.CreatedAt != createdAt {
+				t.Errorf("Taxpayer.Update() created at = %v, want %v", taxpayer.CreatedAt, createdAt)
+			}
+		})
+	}
+}
+
+func TestDeleteTaxpayer(t *testing.T) {
+	t.Parallel()
+	db := mockJobDb(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectExec("DELETE FROM taxpayer WHERE id = $1").WithArgs("T001").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("DELETE FROM taxpayer WHERE id = $1").WithArgs("T002").WillReturnResult(sqlmock.NewResult(0, 0))
+	})
+	defer db.Close()
+	if deleted, err := DeleteTaxpayer(context.Background(), db, "T001"); err != nil || !deleted {
+		t.Errorf("DeleteTaxpayer() = %v, %v, want true", deleted, err)
+	}
+	if deleted, err := DeleteTaxpayer(context.Background(), db, "T002"); err != nil || deleted {
+		t.Errorf("DeleteTaxpayer() = %v, %v, want false", deleted, err)
+	}
+}
+
+func TestSearchTaxpayer(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	db := mockJobDb(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery("SELECT id, name, marital_status, children, disabled, parents_supported, created_at, updated_at FROM taxpayer WHERE id = $1").WithArgs("T001").
+			WillReturnRows(mock.NewRows(taxpayerColumns).AddRow("T001", "Somchai", "married", 2, false, 1, now, now))
+		mock.ExpectQuery("SELECT id, name, marital_status, children, disabled, parents_supported, created_at, updated_at FROM taxpayer WHERE id = $1").WithArgs("T404").
+			WillReturnRows(mock.NewRows(taxpayerColumns))
+		mock.ExpectQuery("SELECT id, name, marital_status, children, disabled, parents_supported, created_at, updated_at FROM taxpayer ORDER BY id").
+			WillReturnRows(mock.NewRows(taxpayerColumns).AddRow("T001", "Somchai", "married", 2, false, 1, now, now))
+	})
+	defer db.Close()
+
+	if got, err := SearchTaxpayerById(context.Background(), db, "T001"); err != nil || !reflect.DeepEqual(got, mockTaxpayer(now)) {
+		t.Errorf("SearchTaxpayerById() = %v, %v, want %v", got, err, mockTaxpayer(now))
+	}
+	if _, err := SearchTaxpayerById(context.Background(), db, "T404"); err != ErrNotFound {
+		t.Errorf("SearchTaxpayerById() error = %v, want %v", err, ErrNotFound)
+	}
+	if got, err := SearchAllTaxpayer(context.Background(), db); err != nil || !reflect.DeepEqual(got, []Taxpayer{mockTaxpayer(now)}) {
+		t.Errorf("SearchAllTaxpayer() = %v, %v, want %v", got, err, []Taxpayer{mockTaxpayer(now)})
+	}
+}
@@ -2,42 +2,195 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"github.com/Rachatapon1994/assessment-tax/admin"
 	mw "github.com/Rachatapon1994/assessment-tax/middleware"
 	"github.com/labstack/echo/v4/middleware"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/Rachatapon1994/assessment-tax/config"
 	"github.com/Rachatapon1994/assessment-tax/db"
 	"github.com/Rachatapon1994/assessment-tax/tax"
+	"github.com/Rachatapon1994/assessment-tax/taxpayer"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
 )
 
+var DEFAULTSHUTDOWNTIMEOUT = 10 * time.Second
+
+func runMigrate(args []string) error {
+	migrator, err := db.NewMigrator(db.OpenDB())
+	if err != nil {
+		return err
+	}
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	case "up":
+		applied, err := migrator.Up()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied %d database migrations\n", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("Migration steps must be a positive number : %v", args[1])
+			}
+		}
+		reverted, err := migrator.Down(steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Reverted %d database migrations\n", reverted)
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d %v %v\n", status.Version, status.Name, appliedAt)
+		}
+	default:
+		return fmt.Errorf("Unknown migrate command : %v, expected up, down or status", command)
+	}
+	return nil
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			log.Fatal("can't migrate database ", err)
+		}
+		return
+	}
+
 	if os.Getenv("PORT") != "8080" {
 		log.Fatal(fmt.Sprintf("Port :%v could not be run, this program allow only port :8080", os.Getenv("PORT")))
 	}
 
-	db := db.InitDB()
+	queryTimeout, _ := time.ParseDuration(os.Getenv("DB_QUERY_TIMEOUT"))
+	storage, _, err := db.ParseDatabaseURL(os.Getenv("DATABASE_URL"))
+	if err != nil {
+		log.Fatal("can't select storage backend ", err)
+	}
+	var DB *sql.DB
+	var allowances db.AllowanceRepository
+	if storage == db.MEMORY {
+		allowances = db.NewMemoryAllowanceRepository()
+	} else {
+		maxOpenConns, _ := strconv.Atoi(os.Getenv("DB_MAX_OPEN_CONNS"))
+		maxIdleConns, _ := strconv.Atoi(os.Getenv("DB_MAX_IDLE_CONNS"))
+		connMaxLifetime, _ := time.ParseDuration(os.Getenv("DB_CONN_MAX_LIFETIME"))
+		connectRetries, _ := strconv.Atoi(os.Getenv("DB_CONNECT_RETRIES"))
+		connectBackoff, _ := time.ParseDuration(os.Getenv("DB_CONNECT_BACKOFF"))
+		DB = db.InitDB(db.PoolConfig{MaxOpenConns: maxOpenConns, MaxIdleConns: maxIdleConns, ConnMaxLifetime: connMaxLifetime, ConnectRetries: connectRetries, ConnectBackoff: connectBackoff})
+		allowanceCacheTTL, _ := time.ParseDuration(os.Getenv("ALLOWANCE_CACHE_TTL"))
+		cache := db.NewCachedAllowanceRepository(db.NewSQLAllowanceRepository(DB), allowanceCacheTTL)
+		if storage == db.POSTGRES {
+			listenCtx, stopListening := context.WithCancel(db.WithQueryTimeout(context.Background(), queryTimeout))
+			defer stopListening()
+			if err := cache.Listen(listenCtx, os.Getenv("DATABASE_URL")); err != nil {
+				log.Println("can't listen for allowance settings changes", err)
+			}
+		}
+		allowances = cache
+	}
 	e := echo.New()
+	requestsCtx, stopRequests := context.WithCancel(context.Background())
+	defer stopRequests()
+	e.Server.BaseContext = func(net.Listener) context.Context { return requestsCtx }
 	e.Validator = &config.CustomValidator{Validator: validator.New(validator.WithRequiredStructEnabled())}
+	e.Use(mw.QueryTimeout(queryTimeout))
 
 	tg := e.Group("/tax")
-	taxHandler := tax.Handler{DB: db}
+	jobWorkers, _ := strconv.Atoi(os.Getenv("JOB_WORKERS"))
+	batchWorkers, _ := strconv.Atoi(os.Getenv("BATCH_WORKERS"))
+	history, _ := strconv.ParseBool(os.Getenv("CALCULATION_HISTORY"))
+	history = history && DB != nil
+	maxUploadSize, _ := strconv.ParseInt(os.Getenv("MAX_UPLOAD_SIZE"), 10, 64)
+	maxJobUploadSize, _ := strconv.ParseInt(os.Getenv("MAX_JOB_UPLOAD_SIZE"), 10, 64)
+	maxJobResultSize, _ := strconv.ParseInt(os.Getenv("MAX_JOB_RESULT_SIZE"), 10, 64)
+	maxCsvRows, _ := strconv.Atoi(os.Getenv("MAX_CSV_ROWS"))
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	var jobs *tax.JobQueue
+	if DB != nil {
+		jobs = tax.NewJobQueue(DB, storage, allowances, jobWorkers)
+		jobs.BatchWorkers = batchWorkers
+		jobs.History = history
+		jobs.QueryTimeout = queryTimeout
+		if maxJobUploadSize > 0 {
+			jobs.MaxUploadSize = maxJobUploadSize
+		}
+		if maxUploadSize > 0 && maxUploadSize < jobs.MaxUploadSize {
+			jobs.MaxUploadSize = maxUploadSize
+		}
+		if maxJobResultSize > 0 {
+			jobs.MaxResultSize = maxJobResultSize
+		}
+		if maxCsvRows > 0 {
+			jobs.MaxCsvRows = maxCsvRows
+		}
+		if jobLease, _ := time.ParseDuration(os.Getenv("JOB_LEASE")); jobLease > 0 {
+			jobs.Lease = jobLease
+		}
+		jobs.Start(jobsCtx)
+	}
+	maxBatchSize, _ := strconv.Atoi(os.Getenv("MAX_BATCH_SIZE"))
+	idempotencyTTL, _ := time.ParseDuration(os.Getenv("IDEMPOTENCY_TTL"))
+	taxHandler := tax.Handler{DB: DB, Allowances: allowances, Jobs: jobs, MaxUploadSize: maxUploadSize, MaxJobUploadSize: maxJobUploadSize, MaxCsvRows: maxCsvRows, MaxBatchSize: maxBatchSize, BatchWorkers: batchWorkers, IdempotencyTTL: idempotencyTTL, History: history}
 	tg.POST("/calculations", taxHandler.CalculationHandler)
-	tg.POST("/calculations/upload-csv", taxHandler.CalculationCsvHandler)
+	tg.POST("/calculations/upload-csv", taxHandler.CalculationCsvHandler, tax.AsyncCsvAuth())
+	tg.POST("/calculations/batch", taxHandler.BatchCalculationHandler)
+	tg.GET("/config", taxHandler.ConfigHandler)
+	if DB != nil {
+		taxHandler.RegisterHistory(tg)
+		taxHandler.RegisterJobs(tg)
+
+		taxpayerHandler := taxpayer.Handler{DB: DB}
+		taxpayerHandler.Register(e.Group("/taxpayers"))
+	}
 
 	ag := e.Group("/admin")
-	adminHandler := admin.Handler{DB: db}
+	var changeRequestTTL time.Duration
+	if value := os.Getenv("CHANGE_REQUEST_TTL"); value != "" {
+		if changeRequestTTL, err = time.ParseDuration(value); err != nil || changeRequestTTL <= 0 {
+			log.Fatal("CHANGE_REQUEST_TTL must be a positive duration ", value)
+		}
+	}
+	adminHandler := admin.Handler{DB: DB, Dialect: storage, Allowances: allowances, ChangeRequestTTL: changeRequestTTL}
 	ag.Use(middleware.BasicAuth(mw.Authenticate()))
 	ag.POST("/deductions/personal", adminHandler.DeductionPersonalHandler)
 	ag.POST("/deductions/k-receipt", adminHandler.DeductionKReceiptHandler)
+	ag.GET("/deductions", adminHandler.DeductionListHandler)
+	ag.PUT("/deductions/:type", adminHandler.DeductionHandler)
+	ag.GET("/config/export", adminHandler.ConfigExportHandler)
+	ag.POST("/config/import", adminHandler.ConfigImportHandler)
+	ag.GET("/config/import/requests", adminHandler.ConfigImportRequestListHandler)
+	ag.POST("/config/import/requests/:id/approve", adminHandler.ApproveConfigImportRequestHandler)
+	ag.POST("/config/import/requests/:id/reject", adminHandler.RejectConfigImportRequestHandler)
+	ag.POST("/config/import/requests/:id/cancel", adminHandler.CancelConfigImportRequestHandler)
+	ag.GET("/deductions/requests", adminHandler.ChangeRequestListHandler)
+	ag.POST("/deductions/requests/:id/approve", adminHandler.ApproveChangeRequestHandler)
+	ag.POST("/deductions/requests/:id/reject", adminHandler.RejectChangeRequestHandler)
+	ag.POST("/deductions/requests/:id/cancel", adminHandler.CancelChangeRequestHandler)
+	ag.GET("/diagnostics", adminHandler.DiagnosticsHandler)
 
 	go func() {
 		if err := e.Start(fmt.Sprintf(":%v", os.Getenv("PORT"))); err != nil && err != http.ErrServerClosed { // Start server
@@ -49,7 +202,18 @@ func main() {
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 	<-shutdown
 	fmt.Println("Graceful shutting down the server process")
-	if err := e.Shutdown(context.Background()); err != nil {
-		e.Logger.Fatal(err)
+	stopJobs()
+	shutdownTimeout, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT"))
+	if err != nil || shutdownTimeout <= 0 {
+		shutdownTimeout = DEFAULTSHUTDOWNTIMEOUT
+	}
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		log.Println("cancelling in-flight requests", err)
+	}
+	stopRequests()
+	if DB != nil {
+		DB.Close()
 	}
 }
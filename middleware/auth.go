@@ -3,16 +3,34 @@ package middleware
 import (
 	"crypto/subtle"
 	"os"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 )
 
+func getAdminCredentials() map[string]string {
+	credentials := make(map[string]string)
+	if os.Getenv("ADMIN_USERNAME") != "" {
+		credentials[os.Getenv("ADMIN_USERNAME")] = os.Getenv("ADMIN_PASSWORD")
+	}
+	for _, credential := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+		username, password, found := strings.Cut(strings.TrimSpace(credential), ":")
+		if found && username != "" {
+			credentials[username] = password
+		}
+	}
+	return credentials
+}
+
 func Authenticate() func(username, password string, c echo.Context) (bool, error) {
 	return func(username, password string, c echo.Context) (bool, error) {
-		if subtle.ConstantTimeCompare([]byte(username), []byte(os.Getenv("ADMIN_USERNAME"))) == 1 &&
-			subtle.ConstantTimeCompare([]byte(password), []byte(os.Getenv("ADMIN_PASSWORD"))) == 1 {
-			return true, nil
+		authenticated := false
+		for adminUsername, adminPassword := range getAdminCredentials() {
+			if subtle.ConstantTimeCompare([]byte(username), []byte(adminUsername)) == 1 &&
+				subtle.ConstantTimeCompare([]byte(password), []byte(adminPassword)) == 1 {
+				authenticated = true
+			}
 		}
-		return false, nil
+		return authenticated, nil
 	}
 }
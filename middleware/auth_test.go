@@ -16,6 +16,7 @@ func TestAuthMiddleware(t *testing.T) {
 	t.Parallel()
 	os.Setenv("ADMIN_USERNAME", "admin")
 	os.Setenv("ADMIN_PASSWORD", "secret")
+	os.Setenv("ADMIN_USERS", "approver:approver-secret, reviewer:reviewer-secret")
 
 	tests := []struct {
 		auth           string
@@ -23,6 +24,9 @@ func TestAuthMiddleware(t *testing.T) {
 	}{
 		{"admin:secret", http.StatusOK},
 		{"admin:wrong-secret", http.StatusUnauthorized},
+		{"approver:approver-secret", http.StatusOK},
+		{"reviewer:reviewer-secret", http.StatusOK},
+		{"reviewer:approver-secret", http.StatusUnauthorized},
 	}
 
 	for _, tc := range tests {
@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/Rachatapon1994/assessment-tax/db"
+	"github.com/labstack/echo/v4"
+)
+
+func QueryTimeout(timeout time.Duration) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx := db.WithQueryTimeout(c.Request().Context(), timeout)
+			c.SetRequest(c.Request().WithContext(ctx))
+			return next(c)
+		}
+	}
+}
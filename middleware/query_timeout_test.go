@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Rachatapon1994/assessment-tax/db"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryTimeoutMiddleware(t *testing.T) {
+	t.Parallel()
+	selectAllTaxLevelSql := "SELECT id, name, start_amount, end_amount, percentage FROM tax_level ORDER BY start_amount"
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		wantErr error
+	}{
+		{"Should cancel query when it runs longer than configured timeout", 10 * time.Millisecond, sqlmock.ErrCancelled},
+		{"Should use default timeout when timeout is not positive", 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DB, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer DB.Close()
+			mock.ExpectQuery(selectAllTaxLevelSql).WillDelayFor(50 * time.Millisecond).WillReturnRows(mock.NewRows([]string{"id", "name", "start_amount", "end_amount", "percentage"}))
+
+			e := echo.New()
+			e.Use(QueryTimeout(tt.timeout))
+			var queryErr error
+			e.GET("/tax-levels", func(c echo.Context) error {
+				_, queryErr = db.SearchAllTaxLevel(c.Request().Context(), DB)
+				return c.NoContent(http.StatusOK)
+			})
+			e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/tax-levels", nil))
+
+			assert.True(t, errors.Is(queryErr, tt.wantErr), "SearchAllTaxLevel() error = %v, want %v", queryErr, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package tax
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Rachatapon1994/assessment-tax/db"
+	"github.com/labstack/echo/v4"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type BatchItem struct {
+	Id string `json:"id" validate:"required"`
+	Calculation
+}
+
+type BatchResult struct {
+	Id     string  `json:"id"`
+	Result *Result `json:"result,omitempty"`
+	Error  *Err    `json:"error,omitempty"`
+	err    error
+}
+
+type BatchResponse struct {
+	Results       []BatchResult `json:"results"`
+	ConfigVersion string        `json:"configVersion"`
+}
+
+var MAXBATCHITEMSIZE = 64 << 10
+
+var errBatchItemTooLarge = errors.New("batch item too large")
+
+type batchReader struct {
+	buffered *bufio.Reader
+	scanner  *bufio.Scanner
+	decoder  *json.Decoder
+}
+
+func newBatchReader(body io.Reader) (*batchReader, error) {
+	buffered := bufio.NewReader(body)
+	for {
+		b, err := buffered.Peek(1)
+		if err == io.EOF {
+			return &batchReader{buffered: buffered}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(string(b)) != "" {
+			break
+		}
+		buffered.Discard(1)
+	}
+	reader := &batchReader{buffered: buffered}
+	if b, _ := buffered.Peek(1); b[0] == '[' {
+		reader.decoder = json.NewDecoder(buffered)
+		if _, err := reader.decoder.Token(); err != nil {
+			return nil, err
+		}
+		return reader, nil
+	}
+	reader.scanner = bufio.NewScanner(buffered)
+	reader.scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), MAXBATCHITEMSIZE)
+	return reader, nil
+}
+
+func (r *batchReader) next() ([]byte, error) {
+	if r.decoder != nil {
+		if !r.decoder.More() {
+			if _, err := r.decoder.Token(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		var raw json.RawMessage
+		if err := r.decoder.Decode(&raw); err != nil {
+			return nil, err
+		}
+		if len(raw) > MAXBATCHITEMSIZE {
+			return nil, errBatchItemTooLarge
+		}
+		return raw, nil
+	}
+	if r.scanner == nil {
+		return nil, io.EOF
+	}
+	for r.scanner.Scan() {
+		if line := r.scanner.Bytes(); len(bytes.TrimSpace(line)) > 0 {
+			return line, nil
+		}
+	}
+	if errors.Is(r.scanner.Err(), bufio.ErrTooLong) {
+		return nil, errBatchItemTooLarge
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func batchReadError(c echo.Context, err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return c.JSON(http.StatusRequestEntityTooLarge, Err{Message: fmt.Sprintf("Request body exceeds maximum of %d bytes", maxBytesErr.Limit)})
+	}
+	if errors.Is(err, errBatchItemTooLarge) {
+		return c.JSON(http.StatusRequestEntityTooLarge, Err{Message: fmt.Sprintf("Batch item exceeds maximum of %d bytes", MAXBATCHITEMSIZE)})
+	}
+	return c.JSON(http.StatusBadRequest, Err{Message: "Error when binding JSON"})
+}
+
+func validateBatchItem(c echo.Context, item *BatchItem, decodeErr error) *Err {
+	if decodeErr != nil {
+		return &Err{Message: "Error when binding JSON"}
+	}
+	if err := c.Validate(item); err != nil {
+		return &Err{Message: "Validation fields does not pass"}
+	}
+	return nil
+}
+
+func (h *Handler) BatchCalculationHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	maxBatchSize := h.getMaxBatchSize()
+	body := http.MaxBytesReader(c.Response(), c.Request().Body, int64(maxBatchSize)*int64(MAXBATCHITEMSIZE))
+	reader, err := newBatchReader(body)
+	if err != nil {
+		return batchReadError(c, err)
+	}
+	items := make([]BatchItem, 0)
+	itemErrors := make([]*Err, 0)
+	ids := make(map[string]bool)
+	for {
+		raw, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return batchReadError(c, err)
+		}
+		if len(items) == maxBatchSize {
+			return c.JSON(http.StatusRequestEntityTooLarge, Err{Message: fmt.Sprintf("Batch exceeds maximum of %d items", maxBatchSize)})
+		}
+		item := BatchItem{}
+		err = json.Unmarshal(raw, &item)
+		if item.Id != "" {
+			if ids[item.Id] {
+				return c.JSON(http.StatusBadRequest, Err{Message: fmt.Sprintf("Batch item id %v is duplicated", item.Id)})
+			}
+			ids[item.Id] = true
+		}
+		items = append(items, item)
+		itemErrors = append(itemErrors, validateBatchItem(c, &item, err))
+	}
+
+	taxpayers := make([]*db.Taxpayer, len(items))
+	cache := make(map[string]*db.Taxpayer)
+	for i, item := range items {
+		if itemErrors[i] != nil || item.TaxpayerId == "" {
+			continue
+		}
+		if taxpayer, ok := cache[item.TaxpayerId]; ok {
+			taxpayers[i] = taxpayer
+			continue
+		}
+		taxpayer, status, err := h.searchTaxpayer(ctx, item.TaxpayerId)
+		if status >= http.StatusInternalServerError {
+			return c.JSON(status, Err{Message: err.Error()})
+		}
+		if err != nil {
+			itemErrors[i] = &Err{Message: err.Error()}
+			continue
+		}
+		cache[item.TaxpayerId] = taxpayer
+		taxpayers[i] = taxpayer
+	}
+
+	snapshot, err := LoadSnapshot(ctx, h.Allowances)
+	if err != nil {
+		return settingsUnavailable(c, err)
+	}
+	response := BatchResponse{Results: make([]BatchResult, 0, len(items)), ConfigVersion: snapshot.Version}
+	index := 0
+	next := func() (int, error) {
+		if index >= len(items) {
+			return 0, io.EOF
+		}
+		index++
+		return index - 1, nil
+	}
+	work := func(i int) BatchResult {
+		batchResult := BatchResult{Id: items[i].Id, Error: itemErrors[i]}
+		if batchResult.Error == nil {
+			result, err := calculateTax(items[i].Calculation, snapshot, taxpayers[i])
+			if err != nil {
+				batchResult.err = err
+				return batchResult
+			}
+			batchResult.Result = &result
+		}
+		return batchResult
+	}
+	emit := func(batchResult BatchResult) error {
+		if batchResult.err != nil {
+			return batchResult.err
+		}
+		response.Results = append(response.Results, batchResult)
+		return nil
+	}
+	if err := runOrdered(c.Request().Context(), h.BatchWorkers, next, work, emit); err != nil {
+		var missingErr *MissingAllowanceErr
+		if errors.As(err, &missingErr) {
+			return settingsUnavailable(c, err)
+		}
+		return err
+	}
+	if strings.Contains(c.Request().Header.Get(echo.HeaderAccept), MIMENDJSON) {
+		c.Response().Header().Set(echo.HeaderContentType, MIMENDJSON)
+		c.Response().WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(c.Response())
+		for _, batchResult := range response.Results {
+			if err := encoder.Encode(batchResult); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return c.JSON(http.StatusOK, response)
+}
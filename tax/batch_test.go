@@ -0,0 +1,146 @@
+package tax
+
+import (
+	"encoding/json"
+	"github.com/Rachatapon1994/assessment-tax/config"
+	"github.com/Rachatapon1994/assessment-tax/db"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mockPostBatchCalculationContext(contentType string, accept string, body string) mockHandlerContext {
+	e := echo.New()
+	e.Validator = &config.CustomValidator{Validator: validator.New(validator.WithRequiredStructEnabled())}
+	req := httptest.NewRequest(http.MethodPost, "/tax/calculations/batch", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, contentType)
+	req.Header.Set(echo.HeaderAccept, accept)
+	rec := httptest.NewRecorder()
+	return mockHandlerContext{
+		e.NewContext(req, rec),
+		rec,
+	}
+}
+
+func TestHandler_BatchCalculationHandler(t *testing.T) {
+	t.Parallel()
+	levels := func(tax float64) []TaxLevel {
+		return []TaxLevel{{"0-150,000", 0}, {"150,001-500,000", tax}, {"500,001-1,000,000", 0}, {"1,000,001-2,000,000", 0}, {"2,000,001 ขึ้นไป", 0}}
+	}
+	version := mockSnapshot().Version
+	wantResults := []BatchResult{
+		{Id: "a1", Result: &Result{Tax: 29000, TaxLevel: levels(29000), ConfigVersion: version}},
+		{Id: "a2", Error: &Err{Message: "Validation fields does not pass"}},
+		{Id: "a3", Error: &Err{Message: "Error when binding JSON"}},
+		{Id: "a4", Result: &Result{TaxRefund: 2000, TaxLevel: levels(26000), ConfigVersion: version}},
+	}
+	items := []string{
+		`{"id":"a1","totalIncome":500000,"wht":0}`,
+		`{"id":"a2","totalIncome":500000,"wht":600000}`,
+		`{"id":"a3","totalIncome":"500000","wht":0}`,
+		`{"id":"a4","totalIncome":500000,"wht":28000,"allowances":[{"allowanceType":"donation","amount":10000},{"allowanceType":"k-receipt","amount":20000}]}`,
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"Should calculate every item of a JSON array in order", echo.MIMEApplicationJSON, "[" + strings.Join(items, ",") + "]"},
+		{"Should calculate every item of an NDJSON stream in order", MIMENDJSON, strings.Join(items, "\n") + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := mockPostBatchCalculationContext(tt.contentType, "", tt.body)
+			h := &Handler{Allowances: db.NewMemoryAllowanceRepository()}
+
+			if err := h.BatchCalculationHandler(c.c); err != nil {
+				t.Errorf("Handler.BatchCalculationHandler() error = %v", err)
+			}
+			result := BatchResponse{}
+			if err := json.Unmarshal(c.r.Body.Bytes(), &result); err != nil {
+				t.Errorf("unable to unmarshal json: %v", err)
+			}
+			want := BatchResponse{Results: wantResults, ConfigVersion: version}
+			if !reflect.DeepEqual(result, want) {
+				t.Errorf("expected (%v), got (%v)", want, result)
+			}
+			if c.r.Code != http.StatusOK {
+				t.Errorf("expected (%v), got (%v)", http.StatusOK, c.r.Code)
+			}
+		})
+	}
+
+	t.Run("Should return NDJSON results when requested by Accept header", func(t *testing.T) {
+		c := mockPostBatchCalculationContext(MIMENDJSON, MIMENDJSON, items[1]+"\n"+items[2])
+		h := &Handler{Allowances: db.NewMemoryAllowanceRepository()}
+
+		if err := h.BatchCalculationHandler(c.c); err != nil {
+			t.Errorf("Handler.BatchCalculationHandler() error = %v", err)
+		}
+		want := `{"id":"a2","error":{"message":"Validation fields does not pass"}}` + "\n" + `{"id":"a3","error":{"message":"Error when binding JSON"}}` + "\n"
+		if got := c.r.Body.String(); got != want {
+			t.Errorf("expected (%v), got (%v)", want, got)
+		}
+	})
+
+	t.Run("Should record broken NDJSON line against its item and continue", func(t *testing.T) {
+		c := mockPostBatchCalculationContext(MIMENDJSON, MIMENDJSON, `{"id":"a1",`+"\n"+items[0])
+		h := &Handler{Allowances: db.NewMemoryAllowanceRepository()}
+
+		if err := h.BatchCalculationHandler(c.c); err != nil {
+			t.Errorf("Handler.BatchCalculationHandler() error = %v", err)
+		}
+		want := `{"id":"","error":{"message":"Error when binding JSON"}}` + "\n" + `{"id":"a1","result":{"tax":29000,"taxRefund":0,"taxLevel":[{"level":"0-150,000","tax":0},{"level":"150,001-500,000","tax":29000},{"level":"500,001-1,000,000","tax":0},{"level":"1,000,001-2,000,000","tax":0},{"level":"2,000,001 ขึ้นไป","tax":0}],"configVersion":"` + version + `"}}` + "\n"
+		if got := c.r.Body.String(); got != want {
+			t.Errorf("expected (%v), got (%v)", want, got)
+		}
+	})
+
+	largeItem := `{"id":"a9","totalIncome":500000.0,"wht":0.0,"taxpayerId":"` + strings.Repeat("1", MAXBATCHITEMSIZE) + `"}`
+	limits := []struct {
+		name               string
+		body               string
+		maxBatchSize       int
+		wantResponseBody   string
+		wantResponseStatus int
+	}{
+		{"Should return status 400 when item ids are duplicated", "[" + items[0] + "," + items[0] + "]", 0, "{\"message\":\"Batch item id a1 is duplicated\"}\n", 400},
+		{"Should return status 413 when batch exceeds maximum size", items[0] + "\n" + items[1] + "\n" + items[2], 2, "{\"message\":\"Batch exceeds maximum of 2 items\"}\n", 413},
+		{"Should return status 413 when NDJSON line exceeds maximum item size", items[0] + "\n" + largeItem + "\n", 0, "{\"message\":\"Batch item exceeds maximum of 65536 bytes\"}\n", 413},
+		{"Should return status 413 when JSON array item exceeds maximum item size", "[" + items[0] + "," + largeItem + "]", 0, "{\"message\":\"Batch item exceeds maximum of 65536 bytes\"}\n", 413},
+		{"Should return status 413 when body exceeds maximum size", items[0] + strings.Repeat("\n", MAXBATCHITEMSIZE), 1, "{\"message\":\"Request body exceeds maximum of 65536 bytes\"}\n", 413},
+	}
+	for _, tt := range limits {
+		t.Run(tt.name, func(t *testing.T) {
+			c := mockPostBatchCalculationContext(echo.MIMEApplicationJSON, "", tt.body)
+			h := &Handler{MaxBatchSize: tt.maxBatchSize}
+
+			if err := h.BatchCalculationHandler(c.c); err != nil {
+				t.Errorf("Handler.BatchCalculationHandler() error = %v", err)
+			}
+			if got := c.r.Body.String(); got != tt.wantResponseBody {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseBody, got)
+			}
+			if c.r.Code != tt.wantResponseStatus {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseStatus, c.r.Code)
+			}
+		})
+	}
+
+	t.Run("Should return status 400 when body is not valid JSON", func(t *testing.T) {
+		c := mockPostBatchCalculationContext(echo.MIMEApplicationJSON, "", `[{"id":"a1",`)
+		h := &Handler{}
+
+		if err := h.BatchCalculationHandler(c.c); err != nil {
+			t.Errorf("Handler.BatchCalculationHandler() error = %v", err)
+		}
+		if c.r.Code != http.StatusBadRequest {
+			t.Errorf("expected (%v), got (%v)", http.StatusBadRequest, c.r.Code)
+		}
+	})
+}
@@ -1,88 +1,162 @@
 package tax
 
 import (
-	"database/sql"
 	"github.com/Rachatapon1994/assessment-tax/db"
 	"github.com/shopspring/decimal"
 )
 
 var (
-	PERSONAL = "personal"
-	DONATION = "donation"
-	KRECEIPT = "k-receipt"
+	PERSONAL   = "personal"
+	DONATION   = "donation"
+	KRECEIPT   = "k-receipt"
+	SPOUSE     = "spouse"
+	CHILD      = "child"
+	PARENT     = "parent"
+	DISABILITY = "disability"
+	MARRIED    = "married"
+
+	PROFILEALLOWANCES = []string{SPOUSE, CHILD, PARENT, DISABILITY}
 )
 
 type Deductor interface {
-	get() float64
+	get(snapshot *Snapshot) (float64, error)
 }
 type Calculator struct {
 	TotalIncome float64
 	Wht         float64
 	Deductors   []Deductor
+	Snapshot    *Snapshot
 }
 
 type Personal struct {
-	DB *sql.DB
 }
 
 type Donation struct {
-	DB     *sql.DB
 	amount float64
 }
 
 type KReceipt struct {
-	DB     *sql.DB
 	amount float64
 }
 
-func (p *Personal) get() float64 {
-	return (&db.Allowance{AllowanceType: PERSONAL}).SearchByType(p.DB).Amount
+type Spouse struct {
+}
+
+type Child struct {
+	count int
+}
+
+type Parent struct {
+	count int
+}
+
+type Disability struct {
+}
+
+func (p *Personal) get(snapshot *Snapshot) (float64, error) {
+	return snapshot.Amount(PERSONAL)
 }
 
-func (d *Donation) get() float64 {
-	maximumDonationAmount := (&db.Allowance{AllowanceType: DONATION}).SearchByType(d.DB).Amount
+func (d *Donation) get(snapshot *Snapshot) (float64, error) {
+	maximumDonationAmount, err := snapshot.Amount(DONATION)
+	if err != nil {
+		return 0, err
+	}
 	if d.amount > maximumDonationAmount {
-		return maximumDonationAmount
+		return maximumDonationAmount, nil
 	}
-	return d.amount
+	return d.amount, nil
 }
 
-func (d *KReceipt) get() float64 {
-	maximumDonationAmount := (&db.Allowance{AllowanceType: KRECEIPT}).SearchByType(d.DB).Amount
+func (d *KReceipt) get(snapshot *Snapshot) (float64, error) {
+	maximumDonationAmount, err := snapshot.Amount(KRECEIPT)
+	if err != nil {
+		return 0, err
+	}
 	if d.amount > maximumDonationAmount {
-		return maximumDonationAmount
+		return maximumDonationAmount, nil
+	}
+	return d.amount, nil
+}
+
+func (s *Spouse) get(snapshot *Snapshot) (float64, error) {
+	return snapshot.Amount(SPOUSE)
+}
+
+func (c *Child) get(snapshot *Snapshot) (float64, error) {
+	amount, err := snapshot.Amount(CHILD)
+	if err != nil {
+		return 0, err
 	}
-	return d.amount
+	result, _ := decimal.NewFromFloat(amount).Mul(decimal.NewFromInt(int64(c.count))).Float64()
+	return result, nil
 }
 
-func setDeductors(allowances []Allowance, DB *sql.DB) []Deductor {
+func (p *Parent) get(snapshot *Snapshot) (float64, error) {
+	amount, err := snapshot.Amount(PARENT)
+	if err != nil {
+		return 0, err
+	}
+	result, _ := decimal.NewFromFloat(amount).Mul(decimal.NewFromInt(int64(p.count))).Float64()
+	return result, nil
+}
+
+func (d *Disability) get(snapshot *Snapshot) (float64, error) {
+	return snapshot.Amount(DISABILITY)
+}
+
+func setDeductors(allowances []Allowance) []Deductor {
 	deductors := make([]Deductor, 0)
 	for _, allowance := range allowances {
 		switch allowance.AllowanceType {
 		case DONATION:
-			deductors = append(deductors, &Donation{amount: *allowance.Amount, DB: DB})
+			deductors = append(deductors, &Donation{amount: *allowance.Amount})
 		case PERSONAL:
-			deductors = append(deductors, &Personal{DB: DB})
+			deductors = append(deductors, &Personal{})
 		case KRECEIPT:
-			deductors = append(deductors, &KReceipt{amount: *allowance.Amount, DB: DB})
+			deductors = append(deductors, &KReceipt{amount: *allowance.Amount})
 		}
 	}
 	return deductors
 }
 
-func (c *Calculator) sumDeduction() float64 {
+func setProfileDeductors(taxpayer *db.Taxpayer) []Deductor {
+	deductors := make([]Deductor, 0)
+	if taxpayer == nil {
+		return deductors
+	}
+	if taxpayer.MaritalStatus == MARRIED {
+		deductors = append(deductors, &Spouse{})
+	}
+	if taxpayer.Children > 0 {
+		deductors = append(deductors, &Child{count: taxpayer.Children})
+	}
+	if taxpayer.ParentsSupported > 0 {
+		deductors = append(deductors, &Parent{count: taxpayer.ParentsSupported})
+	}
+	if taxpayer.Disabled {
+		deductors = append(deductors, &Disability{})
+	}
+	return deductors
+}
+
+func (c *Calculator) sumDeduction() (float64, error) {
 	result := 0.0
 	for _, deduction := range c.Deductors {
-		deductionValue, _ := decimal.NewFromFloat(deduction.get()).Add(decimal.NewFromFloat(result)).Float64()
+		amount, err := deduction.get(c.Snapshot)
+		if err != nil {
+			return 0, err
+		}
+		deductionValue, _ := decimal.NewFromFloat(amount).Add(decimal.NewFromFloat(result)).Float64()
 		result = deductionValue
 	}
-	return result
+	return result, nil
 }
 
-func calculateTaxLevels(income float64) []TaxLevel {
+func calculateTaxLevels(income float64, levels []Level) []TaxLevel {
 	result := make([]TaxLevel, 0)
 	passLastTaxLevel := false
-	for _, taxLevel := range getLevels() {
+	for _, taxLevel := range levels {
 		if income > taxLevel.EndAmount {
 			result = append(result, TaxLevel{Tax: taxLevel.MaxDeduction, Level: taxLevel.Name})
 		} else {
@@ -100,12 +174,16 @@ func calculateTaxLevels(income float64) []TaxLevel {
 	return result
 }
 
-func (c *Calculator) calculate() (float64, []TaxLevel) {
+func (c *Calculator) calculate() (float64, []TaxLevel, error) {
+	deduction, err := c.sumDeduction()
+	if err != nil {
+		return 0, nil, err
+	}
 	result := 0.0
-	taxLevels := calculateTaxLevels(c.TotalIncome - c.sumDeduction())
+	taxLevels := calculateTaxLevels(c.TotalIncome-deduction, c.Snapshot.Levels())
 	for _, taxLevel := range taxLevels {
 		result += taxLevel.Tax
 	}
 	tax, _ := decimal.NewFromFloat(result).Sub(decimal.NewFromFloat(c.Wht)).Float64()
-	return tax, taxLevels
+	return tax, taxLevels, nil
 }
@@ -1,53 +1,28 @@
 package tax
 
 import (
-	"database/sql"
+	"errors"
+	"github.com/Rachatapon1994/assessment-tax/db"
 	"reflect"
 	"testing"
-
-	"github.com/DATA-DOG/go-sqlmock"
 )
 
-func mockCalculatorDb(t *testing.T) *sql.DB {
-	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
-	mock.MatchExpectationsInOrder(false)
-	if err != nil {
-		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
-	}
-
-	rowsPersonal := mock.NewRows([]string{"id", "allowance_type", "amount"}).
-		AddRow(1, "personal", 60000.00)
-	rowsDonation := mock.NewRows([]string{"id", "allowance_type", "amount"}).
-		AddRow(2, "donation", 100000.00)
-	rowsKReceipt := mock.NewRows([]string{"id", "allowance_type", "amount"}).
-		AddRow(3, "k-receipt", 50000.00)
-
-	SearchByTypeSql := "SELECT id, allowance_type, amount FROM allowance WHERE allowance_type = $1"
-	mock.ExpectQuery(SearchByTypeSql).WithArgs("personal").WillReturnRows(rowsPersonal)
-	mock.ExpectQuery(SearchByTypeSql).WithArgs("donation").WillReturnRows(rowsDonation)
-	mock.ExpectQuery(SearchByTypeSql).WithArgs("k-receipt").WillReturnRows(rowsKReceipt)
-	return db
-}
-
 func TestPersonal_get(t *testing.T) {
 	t.Parallel()
 	type fields struct {
-		DB *sql.DB
+		snapshot *Snapshot
 	}
 	tests := []struct {
 		name   string
 		fields fields
 		want   float64
 	}{
-		{"Personal should get allowance correctly", fields{mockCalculatorDb(t)}, 60000.00},
+		{"Personal should get allowance correctly", fields{mockSnapshot()}, 60000.00},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			defer tt.fields.DB.Close()
-			p := &Personal{
-				DB: tt.fields.DB,
-			}
-			if got := p.get(); got != tt.want {
+			p := &Personal{}
+			if got, _ := p.get(tt.fields.snapshot); got != tt.want {
 				t.Errorf("Personal.get() = %v, want %v", got, tt.want)
 			}
 		})
@@ -57,25 +32,23 @@ func TestPersonal_get(t *testing.T) {
 func TestDonation_get(t *testing.T) {
 	t.Parallel()
 	type fields struct {
-		DB     *sql.DB
-		amount float64
+		snapshot *Snapshot
+		amount   float64
 	}
 	tests := []struct {
 		name   string
 		fields fields
 		want   float64
 	}{
-		{"Donation should get allowance correctly when input amount < max value", fields{mockCalculatorDb(t), 50000.00}, 50000.00},
-		{"Donation should get allowance correctly when input amount > max value", fields{mockCalculatorDb(t), 110000.00}, 100000.00},
+		{"Donation should get allowance correctly when input amount < max value", fields{mockSnapshot(), 50000.00}, 50000.00},
+		{"Donation should get allowance correctly when input amount > max value", fields{mockSnapshot(), 110000.00}, 100000.00},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			defer tt.fields.DB.Close()
 			d := &Donation{
-				DB:     tt.fields.DB,
 				amount: tt.fields.amount,
 			}
-			if got := d.get(); got != tt.want {
+			if got, _ := d.get(tt.fields.snapshot); got != tt.want {
 				t.Errorf("Donation.get() = %v, want %v", got, tt.want)
 			}
 		})
@@ -85,25 +58,23 @@ func TestDonation_get(t *testing.T) {
 func TestKReceipt_get(t *testing.T) {
 	t.Parallel()
 	type fields struct {
-		DB     *sql.DB
-		amount float64
+		snapshot *Snapshot
+		amount   float64
 	}
 	tests := []struct {
 		name   string
 		fields fields
 		want   float64
 	}{
-		{"Donation should get allowance correctly when input amount < max value", fields{mockCalculatorDb(t), 30000.00}, 30000.00},
-		{"Donation should get allowance correctly when input amount > max value", fields{mockCalculatorDb(t), 60000.00}, 50000.00},
+		{"Donation should get allowance correctly when input amount < max value", fields{mockSnapshot(), 30000.00}, 30000.00},
+		{"Donation should get allowance correctly when input amount > max value", fields{mockSnapshot(), 60000.00}, 50000.00},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			defer tt.fields.DB.Close()
 			d := &KReceipt{
-				DB:     tt.fields.DB,
 				amount: tt.fields.amount,
 			}
-			if got := d.get(); got != tt.want {
+			if got, _ := d.get(tt.fields.snapshot); got != tt.want {
 				t.Errorf("Donation.get() = %v, want %v", got, tt.want)
 			}
 		})
@@ -116,21 +87,60 @@ func Test_setDeductors(t *testing.T) {
 
 	type args struct {
 		allowances []Allowance
-		DB         *sql.DB
 	}
 	tests := []struct {
 		name string
 		args args
 		want []Deductor
 	}{
-		{"Should return list of Deductor correctly when Allowance is empty", args{make([]Allowance, 0), mockCalculatorDb(t)}, make([]Deductor, 0)},
-		{"Should return list of Deductor correctly when Allowance is not empty", args{[]Allowance{{AllowanceType: "donation", Amount: &mockDonationAmount}, {AllowanceType: "personal"}}, mockCalculatorDb(t)}, []Deductor{&Donation{amount: 100000.00, DB: mockCalculatorDb(t)}, &Personal{DB: mockCalculatorDb(t)}}},
+		{"Should return list of Deductor correctly when Allowance is empty", args{make([]Allowance, 0)}, make([]Deductor, 0)},
+		{"Should return list of Deductor correctly when Allowance is not empty", args{[]Allowance{{AllowanceType: "donation", Amount: &mockDonationAmount}, {AllowanceType: "personal"}}}, []Deductor{&Donation{amount: 100000.00}, &Personal{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := setDeductors(tt.args.allowances); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("setDeductors() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProfile_get(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		deductor Deductor
+		want     float64
+	}{
+		{"Spouse should get allowance correctly", &Spouse{}, 60000.00},
+		{"Child should multiply allowance by number of children", &Child{count: 3}, 90000.00},
+		{"Parent should multiply allowance by number of parents", &Parent{count: 2}, 60000.00},
+		{"Disability should get allowance correctly", &Disability{}, 60000.00},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			defer tt.args.DB.Close()
-			if got := setDeductors(tt.args.allowances, tt.args.DB); len(got) != len(tt.want) {
-				t.Errorf("setDeductors() = %v, want %v", len(got), len(tt.want))
+			if got, _ := tt.deductor.get(mockSnapshot()); got != tt.want {
+				t.Errorf("Deductor.get() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_setProfileDeductors(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		taxpayer *db.Taxpayer
+		want     []Deductor
+	}{
+		{"Should return empty list when taxpayer is nil", nil, make([]Deductor, 0)},
+		{"Should return empty list when taxpayer is single without dependants", &db.Taxpayer{MaritalStatus: "single"}, make([]Deductor, 0)},
+		{"Should return profile deductors when taxpayer has dependants", &db.Taxpayer{MaritalStatus: "married", Children: 2, ParentsSupported: 1, Disabled: true}, []Deductor{&Spouse{}, &Child{count: 2}, &Parent{count: 1}, &Disability{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := setProfileDeductors(tt.taxpayer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("setProfileDeductors() = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -148,9 +158,9 @@ func TestCalculator_sumDeduction(t *testing.T) {
 		fields fields
 		want   float64
 	}{
-		{"Should return sum of deduction = 60000 when allowance has only personal deduction", fields{500000.0, 0.0, []Deductor{&Personal{DB: mockCalculatorDb(t)}}}, 60000},
-		{"Should return sum of deduction = 80000 when allowance has personal deduction and donation = 20000", fields{500000.0, 0.0, []Deductor{&Donation{amount: 20000.0, DB: mockCalculatorDb(t)}, &Personal{DB: mockCalculatorDb(t)}}}, 80000},
-		{"Should return sum of deduction = 160000 when allowance has personal deduction and donation = 1000000", fields{500000.0, 0.0, []Deductor{&Donation{amount: 1000000.0, DB: mockCalculatorDb(t)}, &Personal{DB: mockCalculatorDb(t)}}}, 160000},
+		{"Should return sum of deduction = 60000 when allowance has only personal deduction", fields{500000.0, 0.0, []Deductor{&Personal{}}}, 60000},
+		{"Should return sum of deduction = 80000 when allowance has personal deduction and donation = 20000", fields{500000.0, 0.0, []Deductor{&Donation{amount: 20000.0}, &Personal{}}}, 80000},
+		{"Should return sum of deduction = 160000 when allowance has personal deduction and donation = 1000000", fields{500000.0, 0.0, []Deductor{&Donation{amount: 1000000.0}, &Personal{}}}, 160000},
 		{"Should return sum of deduction = 0 when Deduction is empty", fields{500000.0, 0.0, make([]Deductor, 0)}, 0},
 	}
 	for _, tt := range tests {
@@ -159,14 +169,42 @@ func TestCalculator_sumDeduction(t *testing.T) {
 				TotalIncome: tt.fields.TotalIncome,
 				Wht:         tt.fields.Wht,
 				Deductors:   tt.fields.Deductors,
+				Snapshot:    mockSnapshot(),
 			}
-			if got := c.sumDeduction(); got != tt.want {
+			if got, _ := c.sumDeduction(); got != tt.want {
 				t.Errorf("Calculator.sumDeduction() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
+func TestDeductor_get_missingAllowance(t *testing.T) {
+	t.Parallel()
+	snapshot := newSnapshot(make([]db.Allowance, 0), mockDbTaxLevels())
+	tests := []struct {
+		name     string
+		deductor Deductor
+		want     string
+	}{
+		{"Personal should return error when allowance is not configured", &Personal{}, PERSONAL},
+		{"Donation should return error when allowance is not configured", &Donation{amount: 1000}, DONATION},
+		{"KReceipt should return error when allowance is not configured", &KReceipt{amount: 1000}, KRECEIPT},
+		{"Child should return error when allowance is not configured", &Child{count: 1}, CHILD},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.deductor.get(snapshot)
+			var missingErr *MissingAllowanceErr
+			if !errors.As(err, &missingErr) || missingErr.AllowanceType != tt.want {
+				t.Errorf("Deductor.get() error = %v, want missing allowance %v", err, tt.want)
+			}
+			if !errors.Is(err, db.ErrNotFound) {
+				t.Errorf("Deductor.get() error = %v, want %v", err, db.ErrNotFound)
+			}
+		})
+	}
+}
+
 func Test_calculateTaxLevels(t *testing.T) {
 	t.Parallel()
 	type args struct {
@@ -189,7 +227,7 @@ func Test_calculateTaxLevels(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := calculateTaxLevels(tt.args.income); !reflect.DeepEqual(got, tt.want) {
+			if got := calculateTaxLevels(tt.args.income, mockLevels()); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("calculateTaxLevels() = %v, want %v", got, tt.want)
 			}
 		})
@@ -209,9 +247,9 @@ func TestCalculator_calculate(t *testing.T) {
 		want         float64
 		wantTaxLevel []TaxLevel
 	}{
-		{"Should return tax = 29000 when income = 500000 and allowance has only personal deduction", fields{500000.0, 0.0, []Deductor{&Personal{DB: mockCalculatorDb(t)}}}, 29000, []TaxLevel{{"0-150,000", 0}, {"150,001-500,000", 29000}, {"500,001-1,000,000", 0}, {"1,000,001-2,000,000", 0}, {"2,000,001 ขึ้นไป", 0}}},
-		{"Should return tax = 4000 when income = 500000, wht = 25000 and allowance has only personal deduction", fields{500000.0, 25000.0, []Deductor{&Personal{DB: mockCalculatorDb(t)}}}, 4000, []TaxLevel{{"0-150,000", 0}, {"150,001-500,000", 29000}, {"500,001-1,000,000", 0}, {"1,000,001-2,000,000", 0}, {"2,000,001 ขึ้นไป", 0}}},
-		{"Should return tax = 16500 when income = 500000, wht = 25000 and allowance has personal deduction donation = 20000", fields{500000.0, 2500.0, []Deductor{&Donation{amount: 200000.0, DB: mockCalculatorDb(t)}, &Personal{DB: mockCalculatorDb(t)}}}, 16500, []TaxLevel{{"0-150,000", 0}, {"150,001-500,000", 19000}, {"500,001-1,000,000", 0}, {"1,000,001-2,000,000", 0}, {"2,000,001 ขึ้นไป", 0}}},
+		{"Should return tax = 29000 when income = 500000 and allowance has only personal deduction", fields{500000.0, 0.0, []Deductor{&Personal{}}}, 29000, []TaxLevel{{"0-150,000", 0}, {"150,001-500,000", 29000}, {"500,001-1,000,000", 0}, {"1,000,001-2,000,000", 0}, {"2,000,001 ขึ้นไป", 0}}},
+		{"Should return tax = 4000 when income = 500000, wht = 25000 and allowance has only personal deduction", fields{500000.0, 25000.0, []Deductor{&Personal{}}}, 4000, []TaxLevel{{"0-150,000", 0}, {"150,001-500,000", 29000}, {"500,001-1,000,000", 0}, {"1,000,001-2,000,000", 0}, {"2,000,001 ขึ้นไป", 0}}},
+		{"Should return tax = 16500 when income = 500000, wht = 25000 and allowance has personal deduction donation = 20000", fields{500000.0, 2500.0, []Deductor{&Donation{amount: 200000.0}, &Personal{}}}, 16500, []TaxLevel{{"0-150,000", 0}, {"150,001-500,000", 19000}, {"500,001-1,000,000", 0}, {"1,000,001-2,000,000", 0}, {"2,000,001 ขึ้นไป", 0}}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -219,8 +257,12 @@ func TestCalculator_calculate(t *testing.T) {
 				TotalIncome: tt.fields.TotalIncome,
 				Wht:         tt.fields.Wht,
 				Deductors:   tt.fields.Deductors,
+				Snapshot:    mockSnapshot(),
+			}
+			got, taxLevel, err := c.calculate()
+			if err != nil {
+				t.Errorf("Calculator.calculate() error = %v", err)
 			}
-			got, taxLevel := c.calculate()
 			if tt.want != got {
 				t.Errorf("Calculator.calculate() = %v, want %v", got, tt.want)
 			}
@@ -231,3 +273,15 @@ func TestCalculator_calculate(t *testing.T) {
 		})
 	}
 }
+
+func TestCalculator_calculate_missingAllowance(t *testing.T) {
+	t.Parallel()
+	c := &Calculator{TotalIncome: 500000, Deductors: []Deductor{&Donation{amount: 1000}, &Personal{}}, Snapshot: newSnapshot([]db.Allowance{{AllowanceType: PERSONAL, Amount: 60000}}, mockDbTaxLevels())}
+	got, taxLevel, err := c.calculate()
+	if want := (&MissingAllowanceErr{AllowanceType: DONATION}).Error(); err == nil || err.Error() != want {
+		t.Errorf("Calculator.calculate() error = %v, want %v", err, want)
+	}
+	if got != 0 || taxLevel != nil {
+		t.Errorf("Calculator.calculate() = %v, %v, want no result", got, taxLevel)
+	}
+}
@@ -0,0 +1,87 @@
+package tax
+
+import (
+	"github.com/labstack/echo/v4"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+type ConfigTaxLevel struct {
+	Level       string   `json:"level"`
+	StartAmount float64  `json:"startAmount"`
+	EndAmount   *float64 `json:"endAmount"`
+	Percentage  float64  `json:"percentage"`
+}
+
+type ConfigAllowance struct {
+	AllowanceType string   `json:"allowanceType"`
+	DefaultAmount *float64 `json:"defaultAmount,omitempty"`
+	MaximumAmount *float64 `json:"maximumAmount,omitempty"`
+}
+
+type ConfigResult struct {
+	TaxLevels     []ConfigTaxLevel  `json:"taxLevels"`
+	Allowances    []ConfigAllowance `json:"allowances"`
+	ConfigVersion string            `json:"configVersion"`
+}
+
+func getConfigTaxLevels(levels []Level) []ConfigTaxLevel {
+	result := make([]ConfigTaxLevel, 0)
+	for _, level := range levels {
+		configTaxLevel := ConfigTaxLevel{Level: level.Name, StartAmount: level.StartAmount, Percentage: level.Percentage}
+		if level.EndAmount != math.MaxFloat64 {
+			endAmount := level.EndAmount
+			configTaxLevel.EndAmount = &endAmount
+		}
+		result = append(result, configTaxLevel)
+	}
+	return result
+}
+
+func getConfigAllowances(snapshot *Snapshot) []ConfigAllowance {
+	result := make([]ConfigAllowance, 0)
+	for _, allowanceType := range snapshot.AllowanceTypes() {
+		amount, _ := snapshot.Amount(allowanceType)
+		if allowanceType == PERSONAL || slices.Contains(PROFILEALLOWANCES, allowanceType) {
+			result = append(result, ConfigAllowance{AllowanceType: allowanceType, DefaultAmount: &amount})
+		} else {
+			result = append(result, ConfigAllowance{AllowanceType: allowanceType, MaximumAmount: &amount})
+		}
+	}
+	return result
+}
+
+func getETag(snapshot *Snapshot) string {
+	return `"` + snapshot.Version + `"`
+}
+
+func matchETag(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) ConfigHandler(c echo.Context) error {
+	snapshot, err := LoadSnapshot(c.Request().Context(), h.Allowances)
+	if err != nil {
+		return settingsUnavailable(c, err)
+	}
+	config := ConfigResult{
+		TaxLevels:     getConfigTaxLevels(snapshot.Levels()),
+		Allowances:    getConfigAllowances(snapshot),
+		ConfigVersion: snapshot.Version,
+	}
+	etag := getETag(snapshot)
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-cache")
+	c.Response().Header().Set("ETag", etag)
+	if matchETag(c.Request().Header.Get("If-None-Match"), etag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.JSON(http.StatusOK, config)
+}
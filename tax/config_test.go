@@ -0,0 +1,114 @@
+package tax
+
+import (
+	"encoding/json"
+	"github.com/Rachatapon1994/assessment-tax/db"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func mockGetConfigContext(ifNoneMatch string) mockHandlerContext {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/tax/config", nil)
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	rec := httptest.NewRecorder()
+	return mockHandlerContext{
+		e.NewContext(req, rec),
+		rec,
+	}
+}
+
+func Test_matchETag(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		etag        string
+		want        bool
+	}{
+		{"Should match identical ETag", `"abc"`, `"abc"`, true},
+		{"Should match weak ETag in a list", `"xyz", W/"abc"`, `"abc"`, true},
+		{"Should match wildcard", `*`, `"abc"`, true},
+		{"Should not match different ETag", `"xyz"`, `"abc"`, false},
+		{"Should not match empty header", ``, `"abc"`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchETag(tt.ifNoneMatch, tt.etag); got != tt.want {
+				t.Errorf("matchETag() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler_ConfigHandler(t *testing.T) {
+	t.Parallel()
+	personal, donation, kReceipt := 60000.0, 100000.0, 50000.0
+	spouse, child, parent, disability := 60000.0, 30000.0, 30000.0, 60000.0
+	end1, end2, end3, end4 := 150000.0, 500000.0, 1000000.0, 2000000.0
+	wantConfig := ConfigResult{
+		TaxLevels: []ConfigTaxLevel{
+			{"0-150,000", 0, &end1, 0},
+			{"150,001-500,000", 150001, &end2, 10},
+			{"500,001-1,000,000", 500001, &end3, 15},
+			{"1,000,001-2,000,000", 1000001, &end4, 20},
+			{"2,000,001 ขึ้นไป", 2000001, nil, 35},
+		},
+		Allowances: []ConfigAllowance{
+			{AllowanceType: "child", DefaultAmount: &child},
+			{AllowanceType: "disability", DefaultAmount: &disability},
+			{AllowanceType: "donation", MaximumAmount: &donation},
+			{AllowanceType: "k-receipt", MaximumAmount: &kReceipt},
+			{AllowanceType: "parent", DefaultAmount: &parent},
+			{AllowanceType: "personal", DefaultAmount: &personal},
+			{AllowanceType: "spouse", DefaultAmount: &spouse},
+		},
+		ConfigVersion: mockSnapshot().Version,
+	}
+	wantETag := getETag(mockSnapshot())
+
+	tests := []struct {
+		name               string
+		ifNoneMatch        string
+		wantResponseStatus int
+	}{
+		{"Should return configuration with ETag when no If-None-Match is sent", "", 200},
+		{"Should return configuration when If-None-Match does not match", `"stale"`, 200},
+		{"Should return status 304 when If-None-Match matches current ETag", wantETag, 304},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := mockGetConfigContext(tt.ifNoneMatch)
+			h := &Handler{Allowances: db.NewMemoryAllowanceRepository()}
+
+			if err := h.ConfigHandler(c.c); err != nil {
+				t.Errorf("Handler.ConfigHandler() error = %v", err)
+			}
+
+			if got := c.r.Header().Get("ETag"); got != wantETag {
+				t.Errorf("expected ETag (%v), got (%v)", wantETag, got)
+			}
+
+			if tt.wantResponseStatus == 200 {
+				result := ConfigResult{}
+				if err := json.Unmarshal(c.r.Body.Bytes(), &result); err != nil {
+					t.Errorf("unable to unmarshal json: %v", err)
+				}
+				if !reflect.DeepEqual(result, wantConfig) {
+					t.Errorf("expected (%v), got (%v)", wantConfig, result)
+				}
+			} else if c.r.Body.Len() != 0 {
+				t.Errorf("expected empty body, got (%v)", c.r.Body.String())
+			}
+
+			if c.r.Code != tt.wantResponseStatus {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseStatus, c.r.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package tax
+
+import (
+	"context"
+	"fmt"
+	"github.com/Rachatapon1994/assessment-tax/util"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	CSVEMPLOYEEID  = "employeeId"
+	CSVTAXYEAR     = "taxYear"
+	CSVTOTALINCOME = "totalIncome"
+	CSVWHT         = "wht"
+	CSVSCHEMA      = util.CsvSchema{
+		Required: []string{CSVTOTALINCOME, CSVWHT},
+		Optional: []string{CSVEMPLOYEEID, CSVTAXYEAR, DONATION, KRECEIPT},
+	}
+	CSVTHOUSANDSPATTERN = regexp.MustCompile(`^\d{1,3}(,\d{3})+(\.\d+)?$`)
+)
+
+type csvSettings struct {
+	strict   bool
+	options  util.CsvOptions
+	format   string
+	workers  int
+	fileType string
+	sheet    string
+	xlsx     util.XlsxLimits
+	history  func(header []string, row csvExportRow)
+}
+
+type CsvRow struct {
+	EmployeeId  string
+	TaxYear     int
+	TotalIncome float64
+	Wht         float64
+	Allowances  []Allowance
+}
+
+func parseCsvAmount(value string) (float64, error) {
+	if strings.Contains(value, ",") {
+		if !CSVTHOUSANDSPATTERN.MatchString(value) {
+			return 0, fmt.Errorf("invalid thousands separator in %q", value)
+		}
+		value = strings.ReplaceAll(value, ",", "")
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+func parseCsvRow(header []string, record util.CsvRecord) (CsvRow, []CsvRowError) {
+	row := CsvRow{Allowances: []Allowance{{AllowanceType: PERSONAL}}}
+	if len(record.Fields) != len(header) {
+		reason := fmt.Sprintf("Expected %v columns but got %v", len(header), len(record.Fields))
+		return row, []CsvRowError{{Row: record.Line, Value: strings.Join(record.Fields, ","), Reason: reason, message: reason}}
+	}
+	rowErrors := make([]CsvRowError, 0)
+	for i, column := range header {
+		value := strings.TrimSpace(record.Fields[i])
+		switch column {
+		case CSVEMPLOYEEID:
+			row.EmployeeId = value
+		case CSVTAXYEAR:
+			if value == "" {
+				continue
+			}
+			taxYear, err := strconv.Atoi(value)
+			if err != nil {
+				rowErrors = append(rowErrors, CsvRowError{Row: record.Line, Column: column, Value: value, Reason: "Value must be an integer", message: fmt.Sprintf("Cannot convert CSV data to int : %v", err)})
+				continue
+			}
+			row.TaxYear = taxYear
+		default:
+			if value == "" && column != CSVTOTALINCOME && column != CSVWHT {
+				continue
+			}
+			amount, err := parseCsvAmount(value)
+			if err != nil {
+				rowErrors = append(rowErrors, CsvRowError{Row: record.Line, Column: column, Value: value, Reason: "Value must be a number", message: fmt.Sprintf("Cannot convert CSV data to float64 : %v", err)})
+				continue
+			}
+			switch column {
+			case CSVTOTALINCOME:
+				row.TotalIncome = amount
+			case CSVWHT:
+				row.Wht = amount
+			default:
+				row.Allowances = append(row.Allowances, Allowance{AllowanceType: column, Amount: &amount})
+			}
+		}
+	}
+	return row, rowErrors
+}
+
+func calculateCsvTaxes(row CsvRow, snapshot *Snapshot) (CsvTaxesResult, []TaxLevel, error) {
+	calculator := &Calculator{TotalIncome: row.TotalIncome, Wht: row.Wht, Deductors: setDeductors(row.Allowances), Snapshot: snapshot}
+	taxAmount, taxLevels, err := calculator.calculate()
+	if err != nil {
+		return CsvTaxesResult{}, nil, err
+	}
+	result := CsvTaxesResult{EmployeeId: row.EmployeeId, TaxYear: row.TaxYear, TotalIncome: row.TotalIncome}
+	if math.Signbit(taxAmount) {
+		result.TaxRefund = math.Abs(taxAmount)
+	} else {
+		result.Tax = taxAmount
+	}
+	return result, taxLevels, nil
+}
+
+func newRecordReader(reader io.Reader, settings csvSettings) (*util.CsvReader, error) {
+	if settings.fileType == UPLOADXLSX {
+		return util.NewXlsxReader(reader, CSVSCHEMA, settings.sheet, settings.xlsx)
+	}
+	return util.NewCsvReader(reader, CSVSCHEMA, settings.strict, settings.options)
+}
+
+func scanCsv(reader io.Reader, settings csvSettings, maxRows int) (int, error) {
+	csvReader, err := newRecordReader(reader, settings)
+	if err != nil {
+		return 0, err
+	}
+	defer csvReader.Close()
+	rows := 0
+	for {
+		record, err := csvReader.Next()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return rows, err
+		}
+		rows++
+		if maxRows > 0 && rows > maxRows {
+			return rows, &CsvLimitErr{Message: fmt.Sprintf("CSV file exceeds maximum of %d rows", maxRows)}
+		}
+		if settings.strict {
+			if _, rowErrors := parseCsvRow(csvReader.Header, record); len(rowErrors) > 0 {
+				return rows, &Err{Message: rowErrors[0].message}
+			}
+		}
+	}
+}
+
+func streamCsv(ctx context.Context, reader io.Reader, settings csvSettings, snapshot *Snapshot, w io.Writer, progress func(processed int)) error {
+	csvReader, err := newRecordReader(reader, settings)
+	if err != nil {
+		return err
+	}
+	defer csvReader.Close()
+	table := &csvTable{header: csvReader.Header, levels: snapshot.Levels(), withErrors: !settings.strict}
+	output, err := newCsvOutput(w, settings.format, settings.strict, table, snapshot.Version)
+	if err != nil {
+		return err
+	}
+	summary := csvSummary{}
+	work := func(record util.CsvRecord) csvExportRow {
+		exportRow := csvExportRow{line: record.Line, fields: record.Fields}
+		row, rowErrors := parseCsvRow(csvReader.Header, record)
+		if len(rowErrors) > 0 {
+			exportRow.errors = rowErrors
+			return exportRow
+		}
+		csvTaxesResult, taxLevels, err := calculateCsvTaxes(row, snapshot)
+		if err != nil {
+			exportRow.err = err
+			return exportRow
+		}
+		exportRow.result = &csvTaxesResult
+		exportRow.taxLevels = taxLevels
+		return exportRow
+	}
+	emit := func(exportRow csvExportRow) error {
+		if exportRow.err != nil {
+			return exportRow.err
+		}
+		if len(exportRow.errors) > 0 {
+			if settings.strict {
+				return &Err{Message: exportRow.errors[0].message}
+			}
+			summary.errorCount++
+		} else {
+			summary.successCount++
+			if settings.history != nil {
+				settings.history(csvReader.Header, exportRow)
+			}
+		}
+		if err := output.writeRow(exportRow); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(summary.errorCount + summary.successCount)
+		}
+		return nil
+	}
+	if err := runOrdered(ctx, settings.workers, csvReader.Next, work, emit); err != nil {
+		return err
+	}
+	return output.close(summary)
+}
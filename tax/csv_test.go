@@ -0,0 +1,60 @@
+package tax
+
+import (
+	"github.com/Rachatapon1994/assessment-tax/util"
+	"reflect"
+	"testing"
+)
+
+func Test_parseCsvAmount(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		value   string
+		want    float64
+		wantErr bool
+	}{
+		{"Should parse plain number", "500000.50", 500000.50, false},
+		{"Should parse number with thousands separators", "1,500,000.25", 1500000.25, false},
+		{"Should return error for decimal comma", "500000,50", 0, true},
+		{"Should return error for short comma group", "1,5", 0, true},
+		{"Should return error for misplaced thousands separator", "1500,000", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCsvAmount(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseCsvAmount() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseCsvAmount() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseCsvRow(t *testing.T) {
+	t.Parallel()
+	header := []string{CSVTOTALINCOME, CSVWHT}
+	tests := []struct {
+		name       string
+		fields     []string
+		wantErrors []CsvRowError
+	}{
+		{"Should accept thousands separators", []string{"500,000.50", "1,000"}, []CsvRowError{}},
+		{"Should report decimal comma as row error", []string{"500000,50", "0"}, []CsvRowError{
+			{Row: 2, Column: CSVTOTALINCOME, Value: "500000,50", Reason: "Value must be a number", message: `Cannot convert CSV data to float64 : invalid thousands separator in "500000,50"`},
+		}},
+		{"Should report short comma group as row error", []string{"500000", "1,5"}, []CsvRowError{
+			{Row: 2, Column: CSVWHT, Value: "1,5", Reason: "Value must be a number", message: `Cannot convert CSV data to float64 : invalid thousands separator in "1,5"`},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, rowErrors := parseCsvRow(header, util.CsvRecord{Line: 2, Fields: tt.fields})
+			if !reflect.DeepEqual(rowErrors, tt.wantErrors) {
+				t.Errorf("parseCsvRow() errors = %v, want %v", rowErrors, tt.wantErrors)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package tax
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/shopspring/decimal"
+	"github.com/xuri/excelize/v2"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	EXPORTCSV       = "csv"
+	EXPORTXLSX      = "xlsx"
+	EXPORTJSON      = "json"
+	EXPORTNDJSON    = "ndjson"
+	MIMETEXTCSV     = "text/csv"
+	MIMEXLSX        = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	MIMENDJSON      = "application/x-ndjson"
+	EXPORTFILENAME  = "taxes-result"
+	EXPORTSHEETNAME = "taxes"
+)
+
+type csvExportRow struct {
+	line      int
+	fields    []string
+	result    *CsvTaxesResult
+	taxLevels []TaxLevel
+	errors    []CsvRowError
+	err       error
+}
+
+type csvSummary struct {
+	successCount int
+	errorCount   int
+}
+
+type csvOutput interface {
+	writeRow(row csvExportRow) error
+	close(summary csvSummary) error
+}
+
+type csvTable struct {
+	header     []string
+	levels     []Level
+	withErrors bool
+}
+
+type jsonOutput struct {
+	w      *bufio.Writer
+	strict bool
+	count  int
+}
+
+type ndjsonOutput struct {
+	w       *bufio.Writer
+	encoder *json.Encoder
+	strict  bool
+}
+
+type csvFileOutput struct {
+	table  *csvTable
+	writer *csv.Writer
+}
+
+type xlsxOutput struct {
+	table  *csvTable
+	w      io.Writer
+	file   *excelize.File
+	stream *excelize.StreamWriter
+	line   int
+}
+
+func getExportFormat(c echo.Context) (string, error) {
+	switch format := strings.ToLower(c.QueryParam("format")); format {
+	case "":
+	case EXPORTCSV, EXPORTXLSX, EXPORTJSON, EXPORTNDJSON:
+		return format, nil
+	default:
+		return "", &Err{Message: fmt.Sprintf("Format must be one of %v, %v, %v, %v", EXPORTJSON, EXPORTNDJSON, EXPORTCSV, EXPORTXLSX)}
+	}
+	accept := c.Request().Header.Get(echo.HeaderAccept)
+	if strings.Contains(accept, MIMEXLSX) {
+		return EXPORTXLSX, nil
+	}
+	if strings.Contains(accept, MIMETEXTCSV) {
+		return EXPORTCSV, nil
+	}
+	if strings.Contains(accept, MIMENDJSON) {
+		return EXPORTNDJSON, nil
+	}
+	return EXPORTJSON, nil
+}
+
+func getContentType(format string) string {
+	switch format {
+	case EXPORTXLSX:
+		return MIMEXLSX
+	case EXPORTCSV:
+		return MIMETEXTCSV + "; charset=utf-8"
+	case EXPORTNDJSON:
+		return MIMENDJSON
+	default:
+		return echo.MIMEApplicationJSON
+	}
+}
+
+func setAttachment(c echo.Context, format string) {
+	if format == EXPORTCSV || format == EXPORTXLSX {
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", EXPORTFILENAME+"."+format))
+	}
+}
+
+func newCsvOutput(w io.Writer, format string, strict bool, table *csvTable, configVersion string) (csvOutput, error) {
+	switch format {
+	case EXPORTCSV:
+		output := &csvFileOutput{table: table, writer: csv.NewWriter(w)}
+		return output, output.writer.Write(table.columns())
+	case EXPORTXLSX:
+		return newXlsxOutput(w, table)
+	case EXPORTNDJSON:
+		buffer := bufio.NewWriter(w)
+		return &ndjsonOutput{w: buffer, encoder: json.NewEncoder(buffer), strict: strict}, nil
+	default:
+		buffer := bufio.NewWriter(w)
+		version, _ := json.Marshal(configVersion)
+		field := "rows"
+		if strict {
+			field = "taxes"
+		}
+		_, err := fmt.Fprintf(buffer, `{"configVersion":%s,"%s":[`, version, field)
+		return &jsonOutput{w: buffer, strict: strict}, err
+	}
+}
+
+func (row csvExportRow) jsonValue(strict bool) interface{} {
+	if strict {
+		return row.result
+	}
+	return CsvRowResult{Row: row.line, Result: row.result, Errors: row.errors}
+}
+
+func (o *jsonOutput) writeRow(row csvExportRow) error {
+	if o.count > 0 {
+		if err := o.w.WriteByte(','); err != nil {
+			return err
+		}
+	}
+	o.count++
+	body, err := json.Marshal(row.jsonValue(o.strict))
+	if err != nil {
+		return err
+	}
+	_, err = o.w.Write(body)
+	return err
+}
+
+func (o *jsonOutput) close(summary csvSummary) error {
+	if o.strict {
+		o.w.WriteString("]}\n")
+	} else {
+		fmt.Fprintf(o.w, `],"successCount":%d,"errorCount":%d}`+"\n", summary.successCount, summary.errorCount)
+	}
+	return o.w.Flush()
+}
+
+func (o *ndjsonOutput) writeRow(row csvExportRow) error {
+	return o.encoder.Encode(row.jsonValue(o.strict))
+}
+
+func (o *ndjsonOutput) close(summary csvSummary) error {
+	return o.w.Flush()
+}
+
+func (t *csvTable) columns() []string {
+	columns := append(make([]string, 0), t.header...)
+	columns = append(columns, "tax", "taxRefund", "netIncome")
+	for _, level := range t.levels {
+		columns = append(columns, level.Name)
+	}
+	if t.withErrors {
+		columns = append(columns, "errors")
+	}
+	return columns
+}
+
+func (t *csvTable) values(row csvExportRow) []interface{} {
+	values := make([]interface{}, 0)
+	for i := range t.header {
+		if i >= len(row.fields) {
+			values = append(values, "")
+		} else {
+			values = append(values, row.fields[i])
+		}
+	}
+	if row.result == nil {
+		for i := 0; i < len(t.levels)+3; i++ {
+			values = append(values, "")
+		}
+	} else {
+		totalTax := decimal.Zero
+		for _, taxLevel := range row.taxLevels {
+			totalTax = totalTax.Add(decimal.NewFromFloat(taxLevel.Tax))
+		}
+		netIncome, _ := decimal.NewFromFloat(row.result.TotalIncome).Sub(totalTax).Float64()
+		values = append(values, row.result.Tax, row.result.TaxRefund, netIncome)
+		for _, taxLevel := range row.taxLevels {
+			values = append(values, taxLevel.Tax)
+		}
+	}
+	if t.withErrors {
+		reasons := make([]string, 0)
+		for _, rowError := range row.errors {
+			if rowError.Column == "" {
+				reasons = append(reasons, rowError.Reason)
+			} else {
+				reasons = append(reasons, fmt.Sprintf("%v: %v", rowError.Column, rowError.Reason))
+			}
+		}
+		values = append(values, strings.Join(reasons, "; "))
+	}
+	return values
+}
+
+func (o *csvFileOutput) writeRow(row csvExportRow) error {
+	record := make([]string, 0)
+	for _, value := range o.table.values(row) {
+		switch v := value.(type) {
+		case float64:
+			record = append(record, strconv.FormatFloat(v, 'f', -1, 64))
+		default:
+			record = append(record, fmt.Sprint(v))
+		}
+	}
+	return o.writer.Write(record)
+}
+
+func (o *csvFileOutput) close(summary csvSummary) error {
+	o.writer.Flush()
+	return o.writer.Error()
+}
+
+func newXlsxOutput(w io.Writer, table *csvTable) (*xlsxOutput, error) {
+	file := excelize.NewFile()
+	if err := file.SetSheetName("Sheet1", EXPORTSHEETNAME); err != nil {
+		file.Close()
+		return nil, err
+	}
+	stream, err := file.NewStreamWriter(EXPORTSHEETNAME)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	header := make([]interface{}, 0)
+	for _, column := range table.columns() {
+		header = append(header, column)
+	}
+	if err := stream.SetRow("A1", header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &xlsxOutput{table: table, w: w, file: file, stream: stream, line: 1}, nil
+}
+
+func (o *xlsxOutput) writeRow(row csvExportRow) error {
+	o.line++
+	cell, _ := excelize.CoordinatesToCellName(1, o.line)
+	return o.stream.SetRow(cell, o.table.values(row))
+}
+
+func (o *xlsxOutput) close(summary csvSummary) error {
+	defer o.file.Close()
+	if err := o.stream.Flush(); err != nil {
+		return err
+	}
+	return o.file.Write(o.w)
+}
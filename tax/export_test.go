@@ -0,0 +1,147 @@
+package tax
+
+import (
+	"bytes"
+	"github.com/Rachatapon1994/assessment-tax/db"
+	"github.com/labstack/echo/v4"
+	"github.com/xuri/excelize/v2"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_getExportFormat(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		query   string
+		accept  string
+		want    string
+		wantErr bool
+	}{
+		{"Should return json by default", "", "", EXPORTJSON, false},
+		{"Should return csv from Accept header", "", "text/csv", EXPORTCSV, false},
+		{"Should return xlsx from Accept header", "", MIMEXLSX, EXPORTXLSX, false},
+		{"Should return ndjson from Accept header", "", "application/x-ndjson", EXPORTNDJSON, false},
+		{"Should prefer format query parameter over Accept header", "format=xlsx", "text/csv", EXPORTXLSX, false},
+		{"Should return error when format is unknown", "format=pdf", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/tax/calculations/upload-csv?"+tt.query, nil)
+			req.Header.Set(echo.HeaderAccept, tt.accept)
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+			got, err := getExportFormat(c)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getExportFormat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("getExportFormat() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandler_CalculationCsvHandler_export(t *testing.T) {
+	t.Parallel()
+	fileContent := "employeeId,totalIncome,wht,donation\nE001,500000,0,0\nE002,600000,40000,abc\n"
+	wantColumns := []string{"employeeId", "totalIncome", "wht", "donation", "tax", "taxRefund", "netIncome", "0-150,000", "150,001-500,000", "500,001-1,000,000", "1,000,001-2,000,000", "2,000,001 ขึ้นไป", "errors"}
+
+	t.Run("Should return csv with original and calculated columns", func(t *testing.T) {
+		c := mockPostTaxCalculationCsvContext("format=csv", "taxFile", "taxes.csv", fileContent)
+		h := &Handler{Allowances: db.NewMemoryAllowanceRepository()}
+
+		if err := h.CalculationCsvHandler(c.c); err != nil {
+			t.Errorf("Handler.CalculationCsvHandler() error = %v", err)
+		}
+
+		want := "employeeId,totalIncome,wht,donation,tax,taxRefund,netIncome,\"0-150,000\",\"150,001-500,000\",\"500,001-1,000,000\",\"1,000,001-2,000,000\",\"2,000,001 ขึ้นไป\",errors\n" +
+			"E001,500000,0,0,29000,0,471000,0,29000,0,0,0,\n" +
+			"E002,600000,40000,abc,,,,,,,,,donation: Value must be a number\n"
+		if got := c.r.Body.String(); got != want {
+			t.Errorf("expected (%v), got (%v)", want, got)
+		}
+		if got := c.r.Header().Get(echo.HeaderContentType); got != "text/csv; charset=utf-8" {
+			t.Errorf("expected content type text/csv, got (%v)", got)
+		}
+	})
+
+	t.Run("Should stream one json object per line when ndjson is requested", func(t *testing.T) {
+		c := mockPostTaxCalculationCsvContext("format=ndjson", "taxFile", "taxes.csv", fileContent)
+		h := &Handler{Allowances: db.NewMemoryAllowanceRepository()}
+
+		if err := h.CalculationCsvHandler(c.c); err != nil {
+			t.Errorf("Handler.CalculationCsvHandler() error = %v", err)
+		}
+
+		want := `{"row":2,"result":{"employeeId":"E001","totalIncome":500000,"tax":29000,"taxRefund":0}}` + "\n" +
+			`{"row":3,"errors":[{"row":3,"column":"donation","value":"abc","reason":"Value must be a number"}]}` + "\n"
+		if got := c.r.Body.String(); got != want {
+			t.Errorf("expected (%v), got (%v)", want, got)
+		}
+	})
+
+	t.Run("Should return xlsx when requested by Accept header", func(t *testing.T) {
+		c := mockPostTaxCalculationCsvContext("", "taxFile", "taxes.csv", fileContent)
+		c.c.Request().Header.Set(echo.HeaderAccept, MIMEXLSX)
+		h := &Handler{Allowances: db.NewMemoryAllowanceRepository()}
+
+		if err := h.CalculationCsvHandler(c.c); err != nil {
+			t.Errorf("Handler.CalculationCsvHandler() error = %v", err)
+		}
+
+		file, err := excelize.OpenReader(bytes.NewReader(c.r.Body.Bytes()))
+		if err != nil {
+			t.Fatalf("unable to open xlsx: %v", err)
+		}
+		defer file.Close()
+		rows, err := file.GetRows(EXPORTSHEETNAME)
+		if err != nil {
+			t.Fatalf("unable to read xlsx rows: %v", err)
+		}
+		if !reflect.DeepEqual(rows[0], wantColumns) {
+			t.Errorf("expected (%v), got (%v)", wantColumns, rows[0])
+		}
+		if want := []string{"E001", "500000", "0", "0", "29000", "0", "471000", "0", "29000", "0", "0", "0"}; !reflect.DeepEqual(rows[1], want) {
+			t.Errorf("expected (%v), got (%v)", want, rows[1])
+		}
+		if len(rows) != 3 {
+			t.Errorf("expected 3 rows, got (%v)", len(rows))
+		}
+	})
+
+	t.Run("Should keep original cells unchanged in csv and xlsx", func(t *testing.T) {
+		fileContent := "employeeId,totalIncome,wht\n000123,500000,1e3\n"
+		want := []string{"000123", "500000", "1e3", "28000", "0", "471000", "0", "29000", "0", "0", "0"}
+		for _, format := range []string{EXPORTCSV, EXPORTXLSX} {
+			c := mockPostTaxCalculationCsvContext("format="+format, "taxFile", "taxes.csv", fileContent)
+			h := &Handler{Allowances: db.NewMemoryAllowanceRepository()}
+
+			if err := h.CalculationCsvHandler(c.c); err != nil {
+				t.Errorf("Handler.CalculationCsvHandler() error = %v", err)
+			}
+
+			var got []string
+			if format == EXPORTCSV {
+				got = strings.Split(strings.Split(c.r.Body.String(), "\n")[1], ",")
+				got = got[:len(got)-1]
+			} else {
+				file, err := excelize.OpenReader(bytes.NewReader(c.r.Body.Bytes()))
+				if err != nil {
+					t.Fatalf("unable to open xlsx: %v", err)
+				}
+				defer file.Close()
+				rows, err := file.GetRows(EXPORTSHEETNAME)
+				if err != nil {
+					t.Fatalf("unable to read xlsx rows: %v", err)
+				}
+				got = rows[1]
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("expected %v row (%v), got (%v)", format, want, got)
+			}
+		}
+	})
+}
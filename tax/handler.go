@@ -1,23 +1,42 @@
 package tax
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"github.com/Rachatapon1994/assessment-tax/db"
 	"github.com/Rachatapon1994/assessment-tax/util"
 	"github.com/labstack/echo/v4"
+	"log"
 	"math"
+	"mime/multipart"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
 var (
-	CSVFILEKEY  = "taxFile"
-	CSVFILENAME = "taxes.csv"
-	CSVHEADER   = []string{"totalIncome", "wht", "donation"}
+	CSVFILEKEY        = "taxFile"
+	CSVFILEEXTENSION  = ".csv"
+	XLSXFILEEXTENSION = ".xlsx"
+	UPLOADCSV         = "csv"
+	UPLOADXLSX        = "xlsx"
+
+	DEFAULTMAXUPLOADSIZE int64 = 512 << 20
+	DEFAULTMAXCSVROWS          = 1000000
+	DEFAULTMAXBATCHSIZE        = 1000
+	MULTIPARTOVERHEAD    int64 = 1 << 20
+
+	RETRYAFTERSECONDS = "5"
 )
 
 type (
 	Calculation struct {
+		TaxpayerId  string      `json:"taxpayerId,omitempty" validate:"max=64"`
+		TaxYear     int         `json:"taxYear,omitempty" validate:"gte=0"`
 		TotalIncome *float64    `json:"totalIncome" validate:"required,numeric,gte=0"`
 		Wht         *float64    `json:"wht" validate:"required,numeric,gte=0,ltefield=TotalIncome"`
 		Allowances  []Allowance `json:"allowances" validate:"dive"`
@@ -30,7 +49,16 @@ type (
 )
 
 type Handler struct {
-	DB *sql.DB
+	DB               *sql.DB
+	Allowances       db.AllowanceRepository
+	Jobs             *JobQueue
+	MaxUploadSize    int64
+	MaxJobUploadSize int64
+	MaxCsvRows       int
+	MaxBatchSize     int
+	BatchWorkers     int
+	IdempotencyTTL   time.Duration
+	History          bool
 }
 
 type Err struct {
@@ -41,10 +69,24 @@ func (e *Err) Error() string {
 	return e.Message
 }
 
+type UnavailableErr struct {
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"`
+}
+
+type CsvLimitErr struct {
+	Message string
+}
+
+func (e *CsvLimitErr) Error() string {
+	return e.Message
+}
+
 type Result struct {
-	Tax       float64    `json:"tax"`
-	TaxRefund float64    `json:"taxRefund"`
-	TaxLevel  []TaxLevel `json:"taxLevel"`
+	Tax           float64    `json:"tax"`
+	TaxRefund     float64    `json:"taxRefund"`
+	TaxLevel      []TaxLevel `json:"taxLevel"`
+	ConfigVersion string     `json:"configVersion"`
 }
 
 type TaxLevel struct {
@@ -53,15 +95,39 @@ type TaxLevel struct {
 }
 
 type CsvResult struct {
-	Taxes []CsvTaxesResult `json:"taxes"`
+	Taxes         []CsvTaxesResult `json:"taxes"`
+	ConfigVersion string           `json:"configVersion"`
 }
 
 type CsvTaxesResult struct {
+	EmployeeId  string  `json:"employeeId,omitempty"`
+	TaxYear     int     `json:"taxYear,omitempty"`
 	TotalIncome float64 `json:"totalIncome"`
 	Tax         float64 `json:"tax"`
 	TaxRefund   float64 `json:"taxRefund"`
 }
 
+type CsvRowsResult struct {
+	Rows          []CsvRowResult `json:"rows"`
+	SuccessCount  int            `json:"successCount"`
+	ErrorCount    int            `json:"errorCount"`
+	ConfigVersion string         `json:"configVersion"`
+}
+
+type CsvRowResult struct {
+	Row    int             `json:"row"`
+	Result *CsvTaxesResult `json:"result,omitempty"`
+	Errors []CsvRowError   `json:"errors,omitempty"`
+}
+
+type CsvRowError struct {
+	Row     int    `json:"row"`
+	Column  string `json:"column,omitempty"`
+	Value   string `json:"value"`
+	Reason  string `json:"reason"`
+	message string
+}
+
 func validateInput(c echo.Context, tc *Calculation) error {
 	if err := c.Bind(&tc); err != nil {
 		return &Err{Message: "Error when binding JSON"}
@@ -72,60 +138,191 @@ func validateInput(c echo.Context, tc *Calculation) error {
 	return nil
 }
 
-func (h *Handler) CalculationHandler(c echo.Context) error {
-	tc := Calculation{}
-	if err := validateInput(c, &tc); err != nil {
-		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+func calculateTax(tc Calculation, snapshot *Snapshot, taxpayer *db.Taxpayer) (Result, error) {
+	allowances := append(append(make([]Allowance, 0, len(tc.Allowances)+1), tc.Allowances...), Allowance{AllowanceType: PERSONAL})
+	deductors := append(setDeductors(allowances), setProfileDeductors(taxpayer)...)
+	calculator := &Calculator{TotalIncome: *tc.TotalIncome, Wht: *tc.Wht, Deductors: deductors, Snapshot: snapshot}
+	taxAmount, taxLevels, err := calculator.calculate()
+	if err != nil {
+		return Result{}, err
 	}
-	tc.Allowances = append(tc.Allowances, Allowance{AllowanceType: PERSONAL})
-	calculator := &Calculator{TotalIncome: *tc.TotalIncome, Wht: *tc.Wht, Deductors: setDeductors(tc.Allowances, h.DB)}
-	taxAmount, taxLevels := calculator.calculate()
-	var result Result
 	if math.Signbit(taxAmount) {
-		result = Result{TaxRefund: math.Abs(taxAmount), TaxLevel: taxLevels}
-	} else {
-		result = Result{Tax: taxAmount, TaxLevel: taxLevels}
+		return Result{TaxRefund: math.Abs(taxAmount), TaxLevel: taxLevels, ConfigVersion: snapshot.Version}, nil
 	}
-	return c.JSON(http.StatusOK, result)
+	return Result{Tax: taxAmount, TaxLevel: taxLevels, ConfigVersion: snapshot.Version}, nil
 }
 
-func (h *Handler) CalculationCsvHandler(c echo.Context) error {
-	var err error
-	fileForm, err := c.FormFile(CSVFILEKEY)
-	if err != nil {
-		return c.JSON(http.StatusBadRequest, Err{Message: fmt.Sprintf("No file key: %v in form-data", CSVFILEKEY)})
+func settingsUnavailable(c echo.Context, err error) error {
+	var missingErr *MissingAllowanceErr
+	if errors.As(err, &missingErr) {
+		return c.JSON(http.StatusServiceUnavailable, UnavailableErr{Message: missingErr.Error(), Retryable: false})
+	}
+	log.Println("can't load tax settings", err)
+	c.Response().Header().Set(echo.HeaderRetryAfter, RETRYAFTERSECONDS)
+	return c.JSON(http.StatusServiceUnavailable, UnavailableErr{Message: "Tax settings are temporarily unavailable", Retryable: true})
+}
+
+func (h *Handler) searchTaxpayer(ctx context.Context, taxpayerId string) (*db.Taxpayer, int, error) {
+	if taxpayerId == "" {
+		return nil, http.StatusOK, nil
 	}
-	if fileForm.Filename != CSVFILENAME {
-		return c.JSON(http.StatusBadRequest, Err{Message: fmt.Sprintf("File name must be %v", CSVFILENAME)})
+	if h.DB == nil {
+		return nil, http.StatusServiceUnavailable, &Err{Message: "Taxpayer profiles are not available"}
+	}
+	taxpayer, err := db.SearchTaxpayerById(ctx, h.DB, taxpayerId)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, http.StatusBadRequest, &Err{Message: fmt.Sprintf("Taxpayer %v does not exist", taxpayerId)}
 	}
-	csvBody, err := util.ReadCsvFile(fileForm, CSVHEADER)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+		return nil, http.StatusInternalServerError, err
 	}
-	csvTaxesResultList := make([]CsvTaxesResult, 0)
-	for _, bodys := range csvBody {
-		totalIncome, err := strconv.ParseFloat(bodys[0], 64)
+	return &taxpayer, http.StatusOK, nil
+}
+
+func (h *Handler) CalculationHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	return h.idempotent(c, false, func() (string, error) { return hashJsonBody(c) }, func() error {
+		tc := Calculation{}
+		if err := validateInput(c, &tc); err != nil {
+			return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+		}
+		taxpayer, status, err := h.searchTaxpayer(ctx, tc.TaxpayerId)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, Err{Message: fmt.Sprintf("Cannot convert CSV data to float64 : %v", err)})
+			return c.JSON(status, Err{Message: err.Error()})
 		}
-		wht, err := strconv.ParseFloat(bodys[1], 64)
+		snapshot, err := LoadSnapshot(ctx, h.Allowances)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, Err{Message: fmt.Sprintf("Cannot convert CSV data to float64 : %v", err)})
+			return settingsUnavailable(c, err)
 		}
-		donation, err := strconv.ParseFloat(bodys[2], 64)
+		result, err := calculateTax(tc, snapshot, taxpayer)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, Err{Message: fmt.Sprintf("Cannot convert CSV data to float64 : %v", err)})
+			return settingsUnavailable(c, err)
 		}
-		allowances := []Allowance{{AllowanceType: PERSONAL}, {AllowanceType: DONATION, Amount: &donation}}
-		calculator := &Calculator{TotalIncome: totalIncome, Wht: wht, Deductors: setDeductors(allowances, h.DB)}
-		taxAmount, _ := calculator.calculate()
-		var csvTaxesResult CsvTaxesResult
-		if math.Signbit(taxAmount) {
-			csvTaxesResult = CsvTaxesResult{TaxRefund: math.Abs(taxAmount), TotalIncome: totalIncome}
-		} else {
-			csvTaxesResult = CsvTaxesResult{Tax: taxAmount, TotalIncome: totalIncome}
+		if h.History {
+			id, err := saveCalculationHistory(ctx, h.DB, tc.TaxpayerId, tc.TaxYear, HISTORYSOURCEAPI, tc, result, snapshot.Version)
+			if err != nil {
+				log.Println("can't save calculation history", err)
+			} else {
+				c.Response().Header().Set(echo.HeaderLocation, fmt.Sprintf("/tax/calculations/%d", id))
+			}
 		}
-		csvTaxesResultList = append(csvTaxesResultList, csvTaxesResult)
+		return c.JSON(http.StatusOK, result)
+	})
+}
+
+func (h *Handler) getMaxUploadSize() int64 {
+	if h.MaxUploadSize > 0 {
+		return h.MaxUploadSize
+	}
+	return DEFAULTMAXUPLOADSIZE
+}
+
+func (h *Handler) getMaxJobUploadSize() int64 {
+	maxJobUploadSize := DEFAULTMAXJOBUPLOADSIZE
+	if h.MaxJobUploadSize > 0 {
+		maxJobUploadSize = h.MaxJobUploadSize
+	}
+	return min(maxJobUploadSize, h.getMaxUploadSize())
+}
+
+func (h *Handler) getMaxCsvRows() int {
+	if h.MaxCsvRows > 0 {
+		return h.MaxCsvRows
+	}
+	return DEFAULTMAXCSVROWS
+}
+
+func (h *Handler) getMaxBatchSize() int {
+	if h.MaxBatchSize > 0 {
+		return h.MaxBatchSize
+	}
+	return DEFAULTMAXBATCHSIZE
+}
+
+func getUploadFileType(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case CSVFILEEXTENSION:
+		return UPLOADCSV
+	case XLSXFILEEXTENSION:
+		return UPLOADXLSX
+	default:
+		return ""
+	}
+}
+
+func getCsvErrorStatus(err error) int {
+	var limitErr *CsvLimitErr
+	if errors.As(err, &limitErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+func (h *Handler) scanCsvFile(fileForm *multipart.FileHeader, settings csvSettings) (int, error) {
+	file, err := util.OpenCsvFile(fileForm)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return scanCsv(file, settings, h.getMaxCsvRows())
+}
+
+func (h *Handler) CalculationCsvHandler(c echo.Context) error {
+	maxUploadSize := h.getMaxUploadSize()
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxUploadSize+MULTIPARTOVERHEAD)
+	fileForm, err := c.FormFile(CSVFILEKEY)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) || (err == nil && fileForm.Size > maxUploadSize) {
+		return c.JSON(http.StatusRequestEntityTooLarge, Err{Message: fmt.Sprintf("File size exceeds maximum of %d bytes", maxUploadSize)})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: fmt.Sprintf("No file key: %v in form-data", CSVFILEKEY)})
+	}
+	fileType := getUploadFileType(fileForm.Filename)
+	if fileType == "" {
+		return c.JSON(http.StatusBadRequest, Err{Message: fmt.Sprintf("File name must have %v or %v extension", CSVFILEEXTENSION, XLSXFILEEXTENSION)})
+	}
+	return h.idempotent(c, true, func() (string, error) { return hashCsvFile(c, fileForm) }, func() error {
+		return h.calculateCsv(c, fileForm, fileType)
+	})
+}
+
+func (h *Handler) calculateCsv(c echo.Context, fileForm *multipart.FileHeader, fileType string) error {
+	ctx := c.Request().Context()
+	format, err := getExportFormat(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+	}
+	options, err := util.ParseCsvOptions(c.QueryParam("delimiter"), c.QueryParam("encoding"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+	}
+	strict, _ := strconv.ParseBool(c.QueryParam("strict"))
+	settings := csvSettings{strict: strict, options: options, format: format, workers: h.BatchWorkers, fileType: fileType, sheet: c.QueryParam("sheet"), xlsx: util.NewXlsxLimits(h.getMaxUploadSize(), h.getMaxCsvRows())}
+	if _, err := h.scanCsvFile(fileForm, settings); err != nil {
+		return c.JSON(getCsvErrorStatus(err), Err{Message: err.Error()})
+	}
+	if async, _ := strconv.ParseBool(c.QueryParam("async")); async {
+		return h.submitCsvJob(c, fileForm, settings)
+	}
+
+	snapshot, err := LoadSnapshot(ctx, h.Allowances)
+	if err != nil {
+		return settingsUnavailable(c, err)
+	}
+	if err := snapshot.Require(PERSONAL, DONATION, KRECEIPT); err != nil {
+		return settingsUnavailable(c, err)
+	}
+	file, err := util.OpenCsvFile(fileForm)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+	}
+	defer file.Close()
+	if h.History {
+		settings.history = newCsvHistory(ctx, h.DB, snapshot.Version)
 	}
-	return c.JSON(http.StatusOK, CsvResult{Taxes: csvTaxesResultList})
+	setAttachment(c, format)
+	c.Response().Header().Set(echo.HeaderContentType, getContentType(format))
+	c.Response().WriteHeader(http.StatusOK)
+	return streamCsv(c.Request().Context(), file, settings, snapshot, c.Response(), nil)
 }
@@ -6,14 +6,17 @@ import (
 	"encoding/json"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/Rachatapon1994/assessment-tax/config"
+	"github.com/Rachatapon1994/assessment-tax/db"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
+	"github.com/xuri/excelize/v2"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 type mockHandlerContext struct {
@@ -33,7 +36,7 @@ func mockPostTaxCalculationContext(body string) mockHandlerContext {
 	}
 }
 
-func mockPostTaxCalculationCsvContext(fieldName string, fileName string, fileContent string) mockHandlerContext {
+func mockPostTaxCalculationCsvContext(query string, fieldName string, fileName string, fileContent string) mockHandlerContext {
 	var buf bytes.Buffer
 	multipartWriter := multipart.NewWriter(&buf)
 	defer multipartWriter.Close()
@@ -43,7 +46,7 @@ func mockPostTaxCalculationCsvContext(fieldName string, fileName string, fileCon
 
 	e := echo.New()
 	e.Validator = &config.CustomValidator{Validator: validator.New(validator.WithRequiredStructEnabled())}
-	req := httptest.NewRequest(http.MethodPost, "/tax/calculations/upload-csv", &buf)
+	req := httptest.NewRequest(http.MethodPost, "/tax/calculations/upload-csv?"+query, &buf)
 	req.Header.Set("Content-Type", multipartWriter.FormDataContentType())
 	rec := httptest.NewRecorder()
 	return mockHandlerContext{
@@ -52,31 +55,12 @@ func mockPostTaxCalculationCsvContext(fieldName string, fileName string, fileCon
 	}
 }
 
-func mockHandlerDb(t *testing.T) *sql.DB {
-	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
-	mock.MatchExpectationsInOrder(false)
-	if err != nil {
-		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
-	}
-
-	rowsDonation := mock.NewRows([]string{"id", "allowance_type", "amount"}).
-		AddRow(2, "donation", 100000.00)
-
-	rowsPersonal := mock.NewRows([]string{"id", "allowance_type", "amount"}).
-		AddRow(1, "personal", 60000.00)
+var selectTaxpayerSql = "SELECT id, name, marital_status, children, disabled, parents_supported, created_at, updated_at FROM taxpayer WHERE id = $1"
 
-	rowsKReceipt := mock.NewRows([]string{"id", "allowance_type", "amount"}).
-		AddRow(1, "k-receipt", 50000.00)
-
-	SearchByTypeSql := "SELECT id, allowance_type, amount FROM allowance WHERE allowance_type = $1"
-	mock.ExpectQuery(SearchByTypeSql).WithArgs("personal").WillReturnRows(rowsPersonal)
-	mock.ExpectQuery(SearchByTypeSql).WithArgs("personal").WillReturnRows(rowsPersonal)
-	mock.ExpectQuery(SearchByTypeSql).WithArgs("personal").WillReturnRows(rowsPersonal)
-	mock.ExpectQuery(SearchByTypeSql).WithArgs("donation").WillReturnRows(rowsDonation)
-	mock.ExpectQuery(SearchByTypeSql).WithArgs("donation").WillReturnRows(rowsDonation)
-	mock.ExpectQuery(SearchByTypeSql).WithArgs("donation").WillReturnRows(rowsDonation)
-	mock.ExpectQuery(SearchByTypeSql).WithArgs("k-receipt").WillReturnRows(rowsKReceipt)
-	return db
+func mockTaxpayerRows(mock sqlmock.Sqlmock, maritalStatus string, children int, parentsSupported int, disabled bool) *sqlmock.Rows {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	return mock.NewRows([]string{"id", "name", "marital_status", "children", "disabled", "parents_supported", "created_at", "updated_at"}).
+		AddRow("T001", "", maritalStatus, children, disabled, parentsSupported, now, now)
 }
 
 func TestErr_Error(t *testing.T) {
@@ -143,7 +127,7 @@ func Test_validateInput(t *testing.T) {
 func TestHandler_CalculationHandler(t *testing.T) {
 	t.Parallel()
 	type fields struct {
-		DB *sql.DB
+		Allowances db.AllowanceRepository
 	}
 	type args struct {
 		c mockHandlerContext
@@ -165,21 +149,19 @@ func TestHandler_CalculationHandler(t *testing.T) {
 		wantResponseBody   interface{}
 		wantResponseStatus int
 	}{
-		{"Should return response with status 400 input failed when JSON data is not meet validator setup", fields{DB: mockHandlerDb(t)}, args{c: mockContext400WhenInputFieldsNotMeetValidator}, Err{Message: "Validation fields does not pass"}, 400},
-		{"Should return successful response when WHT = 0 and no allowance", fields{DB: mockHandlerDb(t)}, args{c: mockContextSuccessWhenWhtZeroAndNotAllowance}, Result{29000, 0, []TaxLevel{{"0-150,000", 0}, {"150,001-500,000", 29000}, {"500,001-1,000,000", 0}, {"1,000,001-2,000,000", 0}, {"2,000,001 ขึ้นไป", 0}}}, 200},
-		{"Should return successful response when WHT = 5000 and no allowance", fields{DB: mockHandlerDb(t)}, args{c: mockContextSuccessWhenWht5000AndNotAllowance}, Result{24000, 0, []TaxLevel{{"0-150,000", 0}, {"150,001-500,000", 29000}, {"500,001-1,000,000", 0}, {"1,000,001-2,000,000", 0}, {"2,000,001 ขึ้นไป", 0}}}, 200},
-		{"Should return successful response when WHT = 5000 and Donation = 10000", fields{DB: mockHandlerDb(t)}, args{c: mockContextSuccessWhenWht5000AndDonation10000}, Result{23000, 0, []TaxLevel{{"0-150,000", 0}, {"150,001-500,000", 28000}, {"500,001-1,000,000", 0}, {"1,000,001-2,000,000", 0}, {"2,000,001 ขึ้นไป", 0}}}, 200},
-		{"Should return successful response when WHT = 28000 and Donation = 10000", fields{DB: mockHandlerDb(t)}, args{c: mockContextSuccessWhenWht28000AndDonation10000}, Result{0, 0, []TaxLevel{{"0-150,000", 0}, {"150,001-500,000", 28000}, {"500,001-1,000,000", 0}, {"1,000,001-2,000,000", 0}, {"2,000,001 ขึ้นไป", 0}}}, 200},
-		{"Should return successful response when WHT = 28000 and Donation = 10000 and K-receipt = 20000", fields{DB: mockHandlerDb(t)}, args{c: mockContextSuccessWhenWht28000AndDonation10000AndKReceipt20000}, Result{0, 2000, []TaxLevel{{"0-150,000", 0}, {"150,001-500,000", 26000}, {"500,001-1,000,000", 0}, {"1,000,001-2,000,000", 0}, {"2,000,001 ขึ้นไป", 0}}}, 200},
-		{"Should return successful response when WHT = 30000 and Donation = 10000", fields{DB: mockHandlerDb(t)}, args{c: mockContextSuccessWhenWht30000AndDonation10000}, Result{0, 2000, []TaxLevel{{"0-150,000", 0}, {"150,001-500,000", 28000}, {"500,001-1,000,000", 0}, {"1,000,001-2,000,000", 0}, {"2,000,001 ขึ้นไป", 0}}}, 200},
-		{"Should return successful response when WHT = 30000 and Donation = 10000 and K-receipt = 50000", fields{DB: mockHandlerDb(t)}, args{c: mockContextSuccessWhenWht30000AndDonation10000AndKReceipt50000}, Result{0, 7000, []TaxLevel{{"0-150,000", 0}, {"150,001-500,000", 23000}, {"500,001-1,000,000", 0}, {"1,000,001-2,000,000", 0}, {"2,000,001 ขึ้นไป", 0}}}, 200},
+		{"Should return response with status 400 input failed when JSON data is not meet validator setup", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContext400WhenInputFieldsNotMeetValidator}, Err{Message: "Validation fields does not pass"}, 400},
+		{"Should return successful response when WHT = 0 and no allowance", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContextSuccessWhenWhtZeroAndNotAllowance}, Result{29000, 0, []TaxLevel{{"0-150,000", 0}, {"150,001-500,000", 29000}, {"500,001-1,000,000", 0}, {"1,000,001-2,000,000", 0}, {"2,000,001 ขึ้นไป", 0}}, mockSnapshot().Version}, 200},
+		{"Should return successful response when WHT = 5000 and no allowance", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContextSuccessWhenWht5000AndNotAllowance}, Result{24000, 0, []TaxLevel{{"0-150,000", 0}, {"150,001-500,000", 29000}, {"500,001-1,000,000", 0}, {"1,000,001-2,000,000", 0}, {"2,000,001 ขึ้นไป", 0}}, mockSnapshot().Version}, 200},
+		{"Should return successful response when WHT = 5000 and Donation = 10000", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContextSuccessWhenWht5000AndDonation10000}, Result{23000, 0, []TaxLevel{{"0-150,000", 0}, {"150,001-500,000", 28000}, {"500,001-1,000,000", 0}, {"1,000,001-2,000,000", 0}, {"2,000,001 ขึ้นไป", 0}}, mockSnapshot().Version}, 200},
+		{"Should return successful response when WHT = 28000 and Donation = 10000", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContextSuccessWhenWht28000AndDonation10000}, Result{0, 0, []TaxLevel{{"0-150,000", 0}, {"150,001-500,000", 28000}, {"500,001-1,000,000", 0}, {"1,000,001-2,000,000", 0}, {"2,000,001 ขึ้นไป", 0}}, mockSnapshot().Version}, 200},
+		{"Should return successful response when WHT = 28000 and Donation = 10000 and K-receipt = 20000", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContextSuccessWhenWht28000AndDonation10000AndKReceipt20000}, Result{0, 2000, []TaxLevel{{"0-150,000", 0}, {"150,001-500,000", 26000}, {"500,001-1,000,000", 0}, {"1,000,001-2,000,000", 0}, {"2,000,001 ขึ้นไป", 0}}, mockSnapshot().Version}, 200},
+		{"Should return successful response when WHT = 30000 and Donation = 10000", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContextSuccessWhenWht30000AndDonation10000}, Result{0, 2000, []TaxLevel{{"0-150,000", 0}, {"150,001-500,000", 28000}, {"500,001-1,000,000", 0}, {"1,000,001-2,000,000", 0}, {"2,000,001 ขึ้นไป", 0}}, mockSnapshot().Version}, 200},
+		{"Should return successful response when WHT = 30000 and Donation = 10000 and K-receipt = 50000", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContextSuccessWhenWht30000AndDonation10000AndKReceipt50000}, Result{0, 7000, []TaxLevel{{"0-150,000", 0}, {"150,001-500,000", 23000}, {"500,001-1,000,000", 0}, {"1,000,001-2,000,000", 0}, {"2,000,001 ขึ้นไป", 0}}, mockSnapshot().Version}, 200},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			defer tt.fields.DB.Close()
-
 			h := &Handler{
-				DB: tt.fields.DB,
+				Allowances: tt.fields.Allowances,
 			}
 
 			if err := h.CalculationHandler(tt.args.c.c); err != nil {
@@ -213,23 +195,23 @@ func TestHandler_CalculationHandler(t *testing.T) {
 	}
 }
 
-func TestHandler_CalculationCsvHandler(t *testing.T) {
+func TestHandler_CalculationCsvHandlerStrict(t *testing.T) {
 	t.Parallel()
 	type fields struct {
-		DB *sql.DB
+		Allowances db.AllowanceRepository
 	}
 	type args struct {
 		c mockHandlerContext
 	}
 
-	mockContextMultipartCsvSuccess := mockPostTaxCalculationCsvContext("taxFile", "taxes.csv", "totalIncome,wht,donation\n500000,0,0\n600000,40000,20000\n750000,50000,15000\n")
-	mockContextMultipartCsvErrorWhenCsvIsIncorrectFormat := mockPostTaxCalculationCsvContext("taxFile", "taxes.csv", "totalIncome,wht,donation\n5000000,0\n600000,40000,20000\n750000,50000,15000\n")
-	mockContextMultipartCsvErrorWhenFieldNameIsNotTaxFile := mockPostTaxCalculationCsvContext("taxFile1", "taxes.csv", "totalIncome,wht,donation\n500000,0,0\n600000,40000,20000\n750000,50000,15000\n")
-	mockContextMultipartCsvErrorWhenFileNameIsNotTaxesCsv := mockPostTaxCalculationCsvContext("taxFile", "taxes1.csv", "totalIncome,wht,donation\n500000,0,0\n600000,40000,20000\n750000,50000,15000\n")
-	mockContextMultipartCsvErrorWhenCsvHeaderIsInvalid := mockPostTaxCalculationCsvContext("taxFile", "taxes.csv", "totalIncome,wht,donation1\n500000,0,0\n600000,40000,20000\n750000,50000,15000\n")
-	mockContextMultipartCsvErrorWhenTotalIncomeIsNotNumber := mockPostTaxCalculationCsvContext("taxFile", "taxes.csv", "totalIncome,wht,donation\ndadsa,0,0\n600000,40000,20000\n750000,50000,15000\n")
-	mockContextMultipartCsvErrorWhenWhtIsNotNumber := mockPostTaxCalculationCsvContext("taxFile", "taxes.csv", "totalIncome,wht,donation\n500000,0,0\n600000,dsadas,20000\n750000,50000,15000\n")
-	mockContextMultipartCsvErrorWhenDonationIsNotNumber := mockPostTaxCalculationCsvContext("taxFile", "taxes.csv", "totalIncome,wht,donation\n500000,0,0\n600000,40000,20000\n750000,50000,dsadsa\n")
+	mockContextMultipartCsvSuccess := mockPostTaxCalculationCsvContext("strict=true", "taxFile", "taxes.csv", "totalIncome,wht,donation\n500000,0,0\n600000,40000,20000\n750000,50000,15000\n")
+	mockContextMultipartCsvErrorWhenCsvIsIncorrectFormat := mockPostTaxCalculationCsvContext("strict=true", "taxFile", "taxes.csv", "totalIncome,wht,donation\n5000000,0\n600000,40000,20000\n750000,50000,15000\n")
+	mockContextMultipartCsvErrorWhenFieldNameIsNotTaxFile := mockPostTaxCalculationCsvContext("strict=true", "taxFile1", "taxes.csv", "totalIncome,wht,donation\n500000,0,0\n600000,40000,20000\n750000,50000,15000\n")
+	mockContextMultipartCsvErrorWhenFileNameIsNotCsv := mockPostTaxCalculationCsvContext("strict=true", "taxFile", "taxes.txt", "totalIncome,wht,donation\n500000,0,0\n600000,40000,20000\n750000,50000,15000\n")
+	mockContextMultipartCsvErrorWhenCsvHeaderIsInvalid := mockPostTaxCalculationCsvContext("strict=true", "taxFile", "taxes.csv", "totalIncome,wht,donation1\n500000,0,0\n600000,40000,20000\n750000,50000,15000\n")
+	mockContextMultipartCsvErrorWhenTotalIncomeIsNotNumber := mockPostTaxCalculationCsvContext("strict=true", "taxFile", "taxes.csv", "totalIncome,wht,donation\ndadsa,0,0\n600000,40000,20000\n750000,50000,15000\n")
+	mockContextMultipartCsvErrorWhenWhtIsNotNumber := mockPostTaxCalculationCsvContext("strict=true", "taxFile", "taxes.csv", "totalIncome,wht,donation\n500000,0,0\n600000,dsadas,20000\n750000,50000,15000\n")
+	mockContextMultipartCsvErrorWhenDonationIsNotNumber := mockPostTaxCalculationCsvContext("strict=true", "taxFile", "taxes.csv", "totalIncome,wht,donation\n500000,0,0\n600000,40000,20000\n750000,50000,dsadsa\n")
 
 	tests := []struct {
 		name               string
@@ -238,21 +220,19 @@ func TestHandler_CalculationCsvHandler(t *testing.T) {
 		wantResponseBody   interface{}
 		wantResponseStatus int
 	}{
-		{"Should return successful response when csv is correct format", fields{DB: mockHandlerDb(t)}, args{c: mockContextMultipartCsvSuccess}, CsvResult{[]CsvTaxesResult{{500000, 29000, 0}, {600000, 10000, 0}, {750000, 22500, 0}}}, 200},
-		{"Should return unsuccessful response when csv is incorrect format", fields{DB: mockHandlerDb(t)}, args{c: mockContextMultipartCsvErrorWhenCsvIsIncorrectFormat}, Err{Message: "Error while reading CSV file : record on line 2: wrong number of fields"}, 400},
-		{"Should return unsuccessful response when field name is not taxFile", fields{DB: mockHandlerDb(t)}, args{c: mockContextMultipartCsvErrorWhenFieldNameIsNotTaxFile}, Err{Message: "No file key: taxFile in form-data"}, 400},
-		{"Should return unsuccessful response when file name is not taxes.csv", fields{DB: mockHandlerDb(t)}, args{c: mockContextMultipartCsvErrorWhenFileNameIsNotTaxesCsv}, Err{Message: "File name must be taxes.csv"}, 400},
-		{"Should return unsuccessful response when csv header is invalid", fields{DB: mockHandlerDb(t)}, args{c: mockContextMultipartCsvErrorWhenCsvHeaderIsInvalid}, Err{Message: "CSV header doesn't matches with validator : totalIncome, wht, donation"}, 400},
-		{"Should return unsuccessful response when total income is not number", fields{DB: mockHandlerDb(t)}, args{c: mockContextMultipartCsvErrorWhenTotalIncomeIsNotNumber}, Err{Message: "Cannot convert CSV data to float64 : strconv.ParseFloat: parsing \"dadsa\": invalid syntax"}, 400},
-		{"Should return unsuccessful response when wht is not number", fields{DB: mockHandlerDb(t)}, args{c: mockContextMultipartCsvErrorWhenWhtIsNotNumber}, Err{Message: "Cannot convert CSV data to float64 : strconv.ParseFloat: parsing \"dsadas\": invalid syntax"}, 400},
-		{"Should return unsuccessful response when donation is not number", fields{DB: mockHandlerDb(t)}, args{c: mockContextMultipartCsvErrorWhenDonationIsNotNumber}, Err{Message: "Cannot convert CSV data to float64 : strconv.ParseFloat: parsing \"dsadsa\": invalid syntax"}, 400},
+		{"Should return successful response when csv is correct format", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContextMultipartCsvSuccess}, CsvResult{[]CsvTaxesResult{{TotalIncome: 500000, Tax: 29000, TaxRefund: 0}, {TotalIncome: 600000, Tax: 0, TaxRefund: 2000}, {TotalIncome: 750000, Tax: 11250, TaxRefund: 0}}, mockSnapshot().Version}, 200},
+		{"Should return unsuccessful response when csv is incorrect format", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContextMultipartCsvErrorWhenCsvIsIncorrectFormat}, Err{Message: "Error while reading CSV file : record on line 2: wrong number of fields"}, 400},
+		{"Should return unsuccessful response when field name is not taxFile", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContextMultipartCsvErrorWhenFieldNameIsNotTaxFile}, Err{Message: "No file key: taxFile in form-data"}, 400},
+		{"Should return unsuccessful response when file name does not have csv or xlsx extension", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContextMultipartCsvErrorWhenFileNameIsNotCsv}, Err{Message: "File name must have .csv or .xlsx extension"}, 400},
+		{"Should return unsuccessful response when csv header is invalid", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContextMultipartCsvErrorWhenCsvHeaderIsInvalid}, Err{Message: "CSV header contains unknown column : donation1"}, 400},
+		{"Should return unsuccessful response when total income is not number", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContextMultipartCsvErrorWhenTotalIncomeIsNotNumber}, Err{Message: "Cannot convert CSV data to float64 : strconv.ParseFloat: parsing \"dadsa\": invalid syntax"}, 400},
+		{"Should return unsuccessful response when wht is not number", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContextMultipartCsvErrorWhenWhtIsNotNumber}, Err{Message: "Cannot convert CSV data to float64 : strconv.ParseFloat: parsing \"dsadas\": invalid syntax"}, 400},
+		{"Should return unsuccessful response when donation is not number", fields{Allowances: db.NewMemoryAllowanceRepository()}, args{c: mockContextMultipartCsvErrorWhenDonationIsNotNumber}, Err{Message: "Cannot convert CSV data to float64 : strconv.ParseFloat: parsing \"dsadsa\": invalid syntax"}, 400},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			defer tt.fields.DB.Close()
-
 			h := &Handler{
-				DB: tt.fields.DB,
+				Allowances: tt.fields.Allowances,
 			}
 
 			if err := h.CalculationCsvHandler(tt.args.c.c); err != nil {
@@ -285,3 +265,298 @@ func TestHandler_CalculationCsvHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestHandler_CalculationCsvHandler(t *testing.T) {
+	t.Parallel()
+	type args struct {
+		c mockHandlerContext
+	}
+
+	mockContextMultipartCsvSuccess := mockPostTaxCalculationCsvContext("", "taxFile", "taxes.csv", "totalIncome,wht,donation\n500000,0,0\n600000,40000,20000\n750000,50000,15000\n")
+	mockContextMultipartCsvWithExtendedColumns := mockPostTaxCalculationCsvContext("", "taxFile", "taxes.csv", "employeeId,k-receipt,wht,totalIncome,taxYear,donation\nE001,20000,28000,500000,2567,10000\nE002,,0,500000,,\nE003,0,0,500000,twenty,0\n")
+	mockContextMultipartCsvFromExcel := mockPostTaxCalculationCsvContext("", "taxFile", "payroll-2567.CSV", "\xEF\xBB\xBFtotalIncome;wht;donation\n\" 1,200,000.00 \";0; 0\n")
+	mockContextMultipartCsvWithInvalidRows := mockPostTaxCalculationCsvContext("", "taxFile", "taxes.csv", "totalIncome,wht,donation\n500000,0,0\ndadsa,dsadas,20000\n5000000,0\n750000,50000,15000\n")
+
+	tests := []struct {
+		name               string
+		args               args
+		wantResponseBody   CsvRowsResult
+		wantResponseStatus int
+	}{
+		{"Should return result for every row when csv is correct format", args{c: mockContextMultipartCsvSuccess}, CsvRowsResult{
+			Rows: []CsvRowResult{
+				{Row: 2, Result: &CsvTaxesResult{TotalIncome: 500000, Tax: 29000, TaxRefund: 0}},
+				{Row: 3, Result: &CsvTaxesResult{TotalIncome: 600000, Tax: 0, TaxRefund: 2000}},
+				{Row: 4, Result: &CsvTaxesResult{TotalIncome: 750000, Tax: 11250, TaxRefund: 0}},
+			},
+			SuccessCount:  3,
+			ConfigVersion: mockSnapshot().Version,
+		}, 200},
+		{"Should map allowance and optional columns in any order", args{c: mockContextMultipartCsvWithExtendedColumns}, CsvRowsResult{
+			Rows: []CsvRowResult{
+				{Row: 2, Result: &CsvTaxesResult{EmployeeId: "E001", TaxYear: 2567, TotalIncome: 500000, TaxRefund: 2000}},
+				{Row: 3, Result: &CsvTaxesResult{EmployeeId: "E002", TotalIncome: 500000, Tax: 29000}},
+				{Row: 4, Errors: []CsvRowError{{Row: 4, Column: "taxYear", Value: "twenty", Reason: "Value must be an integer"}}},
+			},
+			SuccessCount:  2,
+			ErrorCount:    1,
+			ConfigVersion: mockSnapshot().Version,
+		}, 200},
+		{"Should accept BOM, semicolon delimiter and thousands separators from any csv file name", args{c: mockContextMultipartCsvFromExcel}, CsvRowsResult{
+			Rows:          []CsvRowResult{{Row: 2, Result: &CsvTaxesResult{TotalIncome: 1200000, Tax: 138000}}},
+			SuccessCount:  1,
+			ConfigVersion: mockSnapshot().Version,
+		}, 200},
+		{"Should return per-row errors and still calculate valid rows", args{c: mockContextMultipartCsvWithInvalidRows}, CsvRowsResult{
+			Rows: []CsvRowResult{
+				{Row: 2, Result: &CsvTaxesResult{TotalIncome: 500000, Tax: 29000, TaxRefund: 0}},
+				{Row: 3, Errors: []CsvRowError{
+					{Row: 3, Column: "totalIncome", Value: "dadsa", Reason: "Value must be a number"},
+					{Row: 3, Column: "wht", Value: "dsadas", Reason: "Value must be a number"},
+				}},
+				{Row: 4, Errors: []CsvRowError{{Row: 4, Value: "5000000,0", Reason: "Expected 3 columns but got 2"}}},
+				{Row: 5, Result: &CsvTaxesResult{TotalIncome: 750000, Tax: 11250, TaxRefund: 0}},
+			},
+			SuccessCount:  2,
+			ErrorCount:    2,
+			ConfigVersion: mockSnapshot().Version,
+		}, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Handler{Allowances: db.NewMemoryAllowanceRepository()}
+
+			if err := h.CalculationCsvHandler(tt.args.c.c); err != nil {
+				t.Errorf("Handler.CalculationCsvHandler() error = %v", err)
+			}
+
+			result := CsvRowsResult{}
+			if err := json.Unmarshal(tt.args.c.r.Body.Bytes(), &result); err != nil {
+				t.Errorf("unable to unmarshal json: %v", err)
+			}
+			if !reflect.DeepEqual(result, tt.wantResponseBody) {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseBody, result)
+			}
+			if tt.args.c.r.Code != tt.wantResponseStatus {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseStatus, tt.args.c.r.Code)
+			}
+		})
+	}
+}
+
+func TestHandler_CalculationCsvHandler_limits(t *testing.T) {
+	t.Parallel()
+	fileContent := "totalIncome,wht,donation\n500000,0,0\n600000,40000,20000\n750000,50000,15000\n"
+	tests := []struct {
+		name               string
+		handler            *Handler
+		wantResponseBody   Err
+		wantResponseStatus int
+	}{
+		{"Should return status 413 when file is larger than maximum upload size", &Handler{MaxUploadSize: 10}, Err{Message: "File size exceeds maximum of 10 bytes"}, 413},
+		{"Should return status 413 when file has more rows than maximum", &Handler{MaxCsvRows: 2}, Err{Message: "CSV file exceeds maximum of 2 rows"}, 413},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := mockPostTaxCalculationCsvContext("", "taxFile", "taxes.csv", fileContent)
+			if err := tt.handler.CalculationCsvHandler(c.c); err != nil {
+				t.Errorf("Handler.CalculationCsvHandler() error = %v", err)
+			}
+			result := Err{}
+			if err := json.Unmarshal(c.r.Body.Bytes(), &result); err != nil {
+				t.Errorf("unable to unmarshal json: %v", err)
+			}
+			if !reflect.DeepEqual(result, tt.wantResponseBody) {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseBody, result)
+			}
+			if c.r.Code != tt.wantResponseStatus {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseStatus, c.r.Code)
+			}
+		})
+	}
+}
+
+func mockXlsxContent(t *testing.T, sheet string, rows [][]interface{}) string {
+	file := excelize.NewFile()
+	defer file.Close()
+	file.SetSheetName("Sheet1", sheet)
+	for i, row := range rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		if err := file.SetSheetRow(sheet, cell, &row); err != nil {
+			t.Fatalf("unable to write xlsx row: %v", err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := file.Write(&buf); err != nil {
+		t.Fatalf("unable to write xlsx file: %v", err)
+	}
+	return buf.String()
+}
+
+func TestHandler_CalculationCsvHandler_xlsx(t *testing.T) {
+	t.Parallel()
+	csvContent := "totalIncome,wht,donation\n500000,0,0\n600000,40000,20000\n750000,50000,15000\n"
+	xlsxContent := mockXlsxContent(t, "taxes", [][]interface{}{
+		{"totalIncome", "wht", "donation"},
+		{500000, 0, 0},
+		{600000, 40000, 20000},
+		{750000, 50000, 15000},
+	})
+	tests := []struct {
+		name               string
+		query              string
+		wantResponseStatus int
+		wantResponseBody   string
+	}{
+		{"Should return same strict result as CSV upload", "strict=true", 200, ""},
+		{"Should return same partial result as CSV upload", "", 200, ""},
+		{"Should read named sheet", "strict=true&sheet=taxes", 200, ""},
+		{"Should return status 400 when sheet does not exist", "sheet=missing", 400, `{"message":"XLSX file does not contain sheet : missing"}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.wantResponseBody
+			if want == "" {
+				csvContext := mockPostTaxCalculationCsvContext(tt.query, "taxFile", "taxes.csv", csvContent)
+				if err := (&Handler{Allowances: db.NewMemoryAllowanceRepository()}).CalculationCsvHandler(csvContext.c); err != nil {
+					t.Errorf("Handler.CalculationCsvHandler() error = %v", err)
+				}
+				want = csvContext.r.Body.String()
+			}
+
+			c := mockPostTaxCalculationCsvContext(tt.query, "taxFile", "taxes.XLSX", xlsxContent)
+			if err := (&Handler{Allowances: db.NewMemoryAllowanceRepository()}).CalculationCsvHandler(c.c); err != nil {
+				t.Errorf("Handler.CalculationCsvHandler() error = %v", err)
+			}
+			if c.r.Body.String() != want {
+				t.Errorf("expected (%v), got (%v)", want, c.r.Body.String())
+			}
+			if c.r.Code != tt.wantResponseStatus {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseStatus, c.r.Code)
+			}
+		})
+	}
+}
+
+func TestHandler_CalculationHandler_taxpayer(t *testing.T) {
+	t.Parallel()
+	levels := func(tax float64) []TaxLevel {
+		return []TaxLevel{{"0-150,000", 0}, {"150,001-500,000", tax}, {"500,001-1,000,000", 0}, {"1,000,001-2,000,000", 0}, {"2,000,001 ขึ้นไป", 0}}
+	}
+	tests := []struct {
+		name               string
+		body               string
+		setup              func(mock sqlmock.Sqlmock)
+		wantResponseBody   interface{}
+		wantResponseStatus int
+	}{
+		{"Should add spouse, children and parents allowances from taxpayer profile", `{"taxpayerId":"T001","totalIncome":500000.0,"wht":0.0}`, func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectTaxpayerSql).WithArgs("T001").WillReturnRows(mockTaxpayerRows(mock, "married", 2, 1, false))
+		}, Result{Tax: 14000, TaxLevel: levels(14000), ConfigVersion: mockSnapshot().Version}, 200},
+		{"Should combine request allowances with disability allowance from taxpayer profile", `{"taxpayerId":"T001","totalIncome":500000.0,"wht":0.0,"allowances":[{"allowanceType":"donation","amount":10000.0}]}`, func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectTaxpayerSql).WithArgs("T001").WillReturnRows(mockTaxpayerRows(mock, "single", 0, 0, true))
+		}, Result{Tax: 22000, TaxLevel: levels(22000), ConfigVersion: mockSnapshot().Version}, 200},
+		{"Should return status 400 when taxpayer does not exist", `{"taxpayerId":"T404","totalIncome":500000.0,"wht":0.0}`, func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectTaxpayerSql).WithArgs("T404").WillReturnError(sql.ErrNoRows)
+		}, Err{Message: "Taxpayer T404 does not exist"}, 400},
+		{"Should return status 500 when taxpayer cannot be searched", `{"taxpayerId":"T001","totalIncome":500000.0,"wht":0.0}`, func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectTaxpayerSql).WithArgs("T001").WillReturnError(sql.ErrConnDone)
+		}, Err{Message: sql.ErrConnDone.Error()}, 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DB, mock := mockIdempotencyDb(t, tt.setup)
+			defer DB.Close()
+			c := mockPostTaxCalculationContext(tt.body)
+			h := &Handler{DB: DB, Allowances: db.NewMemoryAllowanceRepository()}
+
+			if err := h.CalculationHandler(c.c); err != nil {
+				t.Errorf("Handler.CalculationHandler() error = %v", err)
+			}
+			var result interface{}
+			if tt.wantResponseStatus == 200 {
+				calculation := Result{}
+				json.Unmarshal(c.r.Body.Bytes(), &calculation)
+				result = calculation
+			} else {
+				message := Err{}
+				json.Unmarshal(c.r.Body.Bytes(), &message)
+				result = message
+			}
+			if !reflect.DeepEqual(result, tt.wantResponseBody) {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseBody, result)
+			}
+			if c.r.Code != tt.wantResponseStatus {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseStatus, c.r.Code)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestHandler_CalculationHandler_memory(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name               string
+		body               string
+		wantResponseBody   string
+		wantResponseStatus int
+	}{
+		{"Should calculate tax with in-memory allowance settings", `{"totalIncome":500000.0,"wht":0.0,"allowances":[{"allowanceType":"k-receipt","amount":200000.0}]}`, "\"tax\":24000", 200},
+		{"Should return status 503 when taxpayer profiles are not available", `{"taxpayerId":"T001","totalIncome":500000.0,"wht":0.0}`, "Taxpayer profiles are not available", 503},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := mockPostTaxCalculationContext(tt.body)
+			h := &Handler{Allowances: db.NewMemoryAllowanceRepository()}
+
+			if err := h.CalculationHandler(c.c); err != nil {
+				t.Errorf("Handler.CalculationHandler() error = %v", err)
+			}
+			if got := c.r.Body.String(); !strings.Contains(got, tt.wantResponseBody) {
+				t.Errorf("expected body containing (%v), got (%v)", tt.wantResponseBody, got)
+			}
+			if c.r.Code != tt.wantResponseStatus {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseStatus, c.r.Code)
+			}
+		})
+	}
+}
+
+func TestHandler_CalculationHandler_settingsUnavailable(t *testing.T) {
+	t.Parallel()
+	body := `{"totalIncome":500000.0,"wht":0.0,"allowances":[{"allowanceType":"donation","amount":1000.0}]}`
+	tests := []struct {
+		name               string
+		allowances         db.AllowanceRepository
+		wantResponseBody   UnavailableErr
+		wantRetryAfter     string
+		wantResponseStatus int
+	}{
+		{"Should return retryable status 503 when loading allowances fails", &settingsAllowanceRepository{err: sql.ErrConnDone}, UnavailableErr{Message: "Tax settings are temporarily unavailable", Retryable: true}, RETRYAFTERSECONDS, 503},
+		{"Should return status 503 when allowance type is not configured", &settingsAllowanceRepository{allowances: mockDbAllowances()[:1], taxLevels: mockDbTaxLevels()}, UnavailableErr{Message: "Allowance type donation is not configured", Retryable: false}, "", 503},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := mockPostTaxCalculationContext(body)
+			h := &Handler{Allowances: tt.allowances}
+
+			if err := h.CalculationHandler(c.c); err != nil {
+				t.Errorf("Handler.CalculationHandler() error = %v", err)
+			}
+			got := UnavailableErr{}
+			json.Unmarshal(c.r.Body.Bytes(), &got)
+			if got != tt.wantResponseBody {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseBody, got)
+			}
+			if c.r.Code != tt.wantResponseStatus {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseStatus, c.r.Code)
+			}
+			if got := c.r.Header().Get(echo.HeaderRetryAfter); got != tt.wantRetryAfter {
+				t.Errorf("expected Retry-After (%v), got (%v)", tt.wantRetryAfter, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package tax
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Rachatapon1994/assessment-tax/db"
+	mw "github.com/Rachatapon1994/assessment-tax/middleware"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	HISTORYSOURCEAPI       = "api"
+	HISTORYSOURCECSV       = "csv"
+	DEFAULTHISTORYPAGESIZE = 20
+	MAXHISTORYPAGESIZE     = 100
+)
+
+type CalculationHistoryPage struct {
+	Items    []db.CalculationHistory `json:"items"`
+	Total    int                     `json:"total"`
+	Page     int                     `json:"page"`
+	PageSize int                     `json:"pageSize"`
+}
+
+type csvHistoryOutput struct {
+	*CsvTaxesResult
+	TaxLevel []TaxLevel `json:"taxLevel"`
+}
+
+func saveCalculationHistory(ctx context.Context, DB *sql.DB, taxpayerId string, taxYear int, source string, input interface{}, output interface{}, configVersion string) (int, error) {
+	inputJson, err := json.Marshal(input)
+	if err != nil {
+		return 0, err
+	}
+	outputJson, err := json.Marshal(output)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	if taxYear <= 0 {
+		taxYear = now.Year()
+	}
+	history := &db.CalculationHistory{TaxpayerId: taxpayerId, TaxYear: taxYear, Source: source, Input: inputJson, Output: outputJson, ConfigVersion: configVersion, CreatedAt: now}
+	if err := history.Insert(ctx, DB); err != nil {
+		return 0, err
+	}
+	return history.Id, nil
+}
+
+func newCsvHistory(ctx context.Context, DB *sql.DB, configVersion string) func(header []string, row csvExportRow) {
+	return func(header []string, row csvExportRow) {
+		input := make(map[string]string)
+		for i, column := range header {
+			if i < len(row.fields) {
+				input[column] = row.fields[i]
+			}
+		}
+		output := csvHistoryOutput{CsvTaxesResult: row.result, TaxLevel: row.taxLevels}
+		if _, err := saveCalculationHistory(ctx, DB, row.result.EmployeeId, row.result.TaxYear, HISTORYSOURCECSV, input, output, configVersion); err != nil {
+			log.Println("can't save calculation history of row", row.line, err)
+		}
+	}
+}
+
+func getPositiveQueryParam(c echo.Context, name string, defaultValue int, message string) (int, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	result, err := strconv.Atoi(value)
+	if err != nil || result <= 0 {
+		return 0, &Err{Message: message}
+	}
+	return result, nil
+}
+
+func (h *Handler) CalculationHistoryHandler(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: "Calculation id must be a number"})
+	}
+	history, err := db.SearchCalculationHistoryById(c.Request().Context(), h.DB, id)
+	if errors.Is(err, db.ErrNotFound) {
+		return c.JSON(http.StatusNotFound, Err{Message: "Calculation not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+	}
+	return c.JSON(http.StatusOK, history)
+}
+
+func (h *Handler) CalculationHistoryListHandler(c echo.Context) error {
+	taxYear, err := getPositiveQueryParam(c, "year", 0, "Year must be a positive number")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+	}
+	page, err := getPositiveQueryParam(c, "page", 1, "Page must be a positive number")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+	}
+	pageSizeMessage := fmt.Sprintf("Page size must be between 1 and %d", MAXHISTORYPAGESIZE)
+	pageSize, err := getPositiveQueryParam(c, "pageSize", DEFAULTHISTORYPAGESIZE, pageSizeMessage)
+	if err == nil && pageSize > MAXHISTORYPAGESIZE {
+		err = &Err{Message: pageSizeMessage}
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+	}
+	if page-1 > math.MaxInt32/pageSize {
+		return c.JSON(http.StatusBadRequest, Err{Message: "Page is too large"})
+	}
+
+	filter := db.CalculationHistoryFilter{TaxpayerId: c.QueryParam("taxpayerId"), TaxYear: taxYear, Limit: pageSize, Offset: (page - 1) * pageSize}
+	items, total, err := db.SearchCalculationHistory(c.Request().Context(), h.DB, filter)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+	}
+	return c.JSON(http.StatusOK, CalculationHistoryPage{Items: items, Total: total, Page: page, PageSize: pageSize})
+}
+
+func (h *Handler) RegisterHistory(g *echo.Group) {
+	auth := middleware.BasicAuth(mw.Authenticate())
+	g.GET("/calculations", h.CalculationHistoryListHandler, auth)
+	g.GET("/calculations/:id", h.CalculationHistoryHandler, auth)
+}
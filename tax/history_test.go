@@ -0,0 +1,256 @@
+package tax
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Rachatapon1994/assessment-tax/db"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var (
+	insertCalculationHistorySql = "INSERT INTO calculation_history (taxpayer_id, tax_year, source, input, output, config_version, created_at) VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING id"
+	calculationHistoryColumns   = []string{"id", "taxpayer_id", "tax_year", "source", "input", "output", "config_version", "created_at"}
+)
+
+func mockHistoryRows(mock sqlmock.Sqlmock, createdAt time.Time) *sqlmock.Rows {
+	return mock.NewRows(calculationHistoryColumns).AddRow(1, "T001", 2024, "api", []byte(`{"totalIncome":500000}`), []byte(`{"tax":29000}`), "abc", createdAt)
+}
+
+func mockGetCalculationHistoryContext(query string, id string) mockHandlerContext {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/tax/calculations"+query, nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	if id != "" {
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+	}
+	return mockHandlerContext{c, rec}
+}
+
+func TestHandler_CalculationHandler_history(t *testing.T) {
+	t.Parallel()
+	DB, mock := mockIdempotencyDb(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(selectTaxpayerSql).WithArgs("T001").WillReturnRows(mockTaxpayerRows(mock, "single", 0, 0, false))
+		mock.ExpectQuery(insertCalculationHistorySql).
+			WithArgs("T001", 2023, "api", `{"taxpayerId":"T001","taxYear":2023,"totalIncome":500000,"wht":0,"allowances":null}`, sqlmock.AnyArg(), mockSnapshot().Version, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+	})
+	defer DB.Close()
+	c := mockPostTaxCalculationContext(`{"taxpayerId":"T001","taxYear":2023,"totalIncome":500000.0,"wht":0.0}`)
+	h := &Handler{DB: DB, Allowances: db.NewMemoryAllowanceRepository(), History: true}
+
+	t.Run("Should save calculation history and return its location", func(t *testing.T) {
+		if err := h.CalculationHandler(c.c); err != nil {
+			t.Errorf("Handler.CalculationHandler() error = %v", err)
+		}
+		if got := c.r.Header().Get(echo.HeaderLocation); got != "/tax/calculations/12" {
+			t.Errorf("expected Location /tax/calculations/12, got (%v)", got)
+		}
+		if c.r.Code != http.StatusOK {
+			t.Errorf("expected (%v), got (%v)", http.StatusOK, c.r.Code)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestHandler_CalculationCsvHandler_history(t *testing.T) {
+	t.Parallel()
+	DB, mock := mockIdempotencyDb(t, func(mock sqlmock.Sqlmock) {
+		mockContentHashIdempotency(mock, "/tax/calculations/upload-csv")
+		mock.ExpectQuery(insertCalculationHistorySql).
+			WithArgs("E001", 2024, "csv", `{"employeeId":"E001","taxYear":"2024","totalIncome":"500000","wht":"0"}`, sqlmock.AnyArg(), mockSnapshot().Version, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mockCompleteContentHashIdempotency(mock, "/tax/calculations/upload-csv")
+	})
+	defer DB.Close()
+	c := mockPostTaxCalculationCsvContext("", "taxFile", "taxes.csv", "employeeId,taxYear,totalIncome,wht\nE001,2024,500000,0\nE002,2024,abc,0\n")
+	h := &Handler{DB: DB, Allowances: db.NewMemoryAllowanceRepository(), History: true}
+
+	t.Run("Should save calculation history of successful rows only", func(t *testing.T) {
+		if err := h.CalculationCsvHandler(c.c); err != nil {
+			t.Errorf("Handler.CalculationCsvHandler() error = %v", err)
+		}
+		if c.r.Code != http.StatusOK {
+			t.Errorf("expected (%v), got (%v)", http.StatusOK, c.r.Code)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestHandler_CalculationHistoryHandler(t *testing.T) {
+	t.Parallel()
+	createdAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	selectSql := "SELECT id, taxpayer_id, tax_year, source, input, output, config_version, created_at FROM calculation_history WHERE id = $1"
+	tests := []struct {
+		name               string
+		id                 string
+		setup              func(mock sqlmock.Sqlmock)
+		wantResponseStatus int
+	}{
+		{"Should return status 400 when id is not a number", "abc", func(mock sqlmock.Sqlmock) {}, 400},
+		{"Should return status 404 when calculation does not exist", "2", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectSql).WithArgs(2).WillReturnError(sql.ErrNoRows)
+		}, 404},
+		{"Should return status 500 when calculation cannot be searched", "3", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectSql).WithArgs(3).WillReturnError(sql.ErrConnDone)
+		}, 500},
+		{"Should return calculation when id exists", "1", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectSql).WithArgs(1).WillReturnRows(mockHistoryRows(mock, createdAt))
+		}, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DB, mock := mockIdempotencyDb(t, tt.setup)
+			defer DB.Close()
+			c := mockGetCalculationHistoryContext("/"+tt.id, tt.id)
+			h := &Handler{DB: DB}
+
+			if err := h.CalculationHistoryHandler(c.c); err != nil {
+				t.Errorf("Handler.CalculationHistoryHandler() error = %v", err)
+			}
+			if c.r.Code != tt.wantResponseStatus {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseStatus, c.r.Code)
+			}
+			if tt.wantResponseStatus == 200 {
+				result := db.CalculationHistory{}
+				if err := json.Unmarshal(c.r.Body.Bytes(), &result); err != nil {
+					t.Errorf("unable to unmarshal json: %v", err)
+				}
+				want := db.CalculationHistory{Id: 1, TaxpayerId: "T001", TaxYear: 2024, Source: "api", Input: json.RawMessage(`{"totalIncome":500000}`), Output: json.RawMessage(`{"tax":29000}`), ConfigVersion: "abc", CreatedAt: createdAt}
+				if !reflect.DeepEqual(result, want) {
+					t.Errorf("expected (%v), got (%v)", want, result)
+				}
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestHandler_CalculationHistoryListHandler(t *testing.T) {
+	t.Parallel()
+	createdAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name               string
+		query              string
+		setup              func(mock sqlmock.Sqlmock)
+		wantResponseBody   interface{}
+		wantResponseStatus int
+	}{
+		{"Should return status 400 when year is not a number", "?year=abc", func(mock sqlmock.Sqlmock) {}, Err{Message: "Year must be a positive number"}, 400},
+		{"Should return status 400 when page is not positive", "?page=0", func(mock sqlmock.Sqlmock) {}, Err{Message: "Page must be a positive number"}, 400},
+		{"Should return status 400 when page offset overflows", "?page=9223372036854775807&pageSize=100", func(mock sqlmock.Sqlmock) {}, Err{Message: "Page is too large"}, 400},
+		{"Should return status 400 when page size is too large", "?pageSize=101", func(mock sqlmock.Sqlmock) {}, Err{Message: "Page size must be between 1 and 100"}, 400},
+		{"Should return first page with default page size", "", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery("SELECT COUNT(*) FROM calculation_history").WillReturnRows(mock.NewRows([]string{"count"}).AddRow(1))
+			mock.ExpectQuery("SELECT id, taxpayer_id, tax_year, source, input, output, config_version, created_at FROM calculation_history ORDER BY id DESC LIMIT $1 OFFSET $2").
+				WithArgs(20, 0).WillReturnRows(mockHistoryRows(mock, createdAt))
+		}, CalculationHistoryPage{Items: []db.CalculationHistory{{Id: 1, TaxpayerId: "T001", TaxYear: 2024, Source: "api", Input: json.RawMessage(`{"totalIncome":500000}`), Output: json.RawMessage(`{"tax":29000}`), ConfigVersion: "abc", CreatedAt: createdAt}}, Total: 1, Page: 1, PageSize: 20}, 200},
+		{"Should filter by taxpayer and year with pagination", "?taxpayerId=T001&year=2024&page=3&pageSize=5", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery("SELECT COUNT(*) FROM calculation_history WHERE taxpayer_id = $1 AND tax_year = $2").WithArgs("T001", 2024).WillReturnRows(mock.NewRows([]string{"count"}).AddRow(11))
+			mock.ExpectQuery("SELECT id, taxpayer_id, tax_year, source, input, output, config_version, created_at FROM calculation_history WHERE taxpayer_id = $1 AND tax_year = $2 ORDER BY id DESC LIMIT $3 OFFSET $4").
+				WithArgs("T001", 2024, 5, 10).WillReturnRows(mock.NewRows(calculationHistoryColumns))
+		}, CalculationHistoryPage{Items: []db.CalculationHistory{}, Total: 11, Page: 3, PageSize: 5}, 200},
+		{"Should return status 500 when calculations cannot be searched", "", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery("SELECT COUNT(*) FROM calculation_history").WillReturnError(sql.ErrConnDone)
+		}, Err{Message: sql.ErrConnDone.Error()}, 500},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DB, mock := mockIdempotencyDb(t, tt.setup)
+			defer DB.Close()
+			c := mockGetCalculationHistoryContext(tt.query, "")
+			h := &Handler{DB: DB}
+
+			if err := h.CalculationHistoryListHandler(c.c); err != nil {
+				t.Errorf("Handler.CalculationHistoryListHandler() error = %v", err)
+			}
+			if c.r.Code != tt.wantResponseStatus {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseStatus, c.r.Code)
+			}
+			var result interface{}
+			if tt.wantResponseStatus == 200 {
+				page := CalculationHistoryPage{}
+				json.Unmarshal(c.r.Body.Bytes(), &page)
+				result = page
+			} else {
+				message := Err{}
+				json.Unmarshal(c.r.Body.Bytes(), &message)
+				result = message
+			}
+			if !reflect.DeepEqual(result, tt.wantResponseBody) {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseBody, result)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestHandler_RegisterHistory(t *testing.T) {
+	os.Setenv("ADMIN_USERNAME", "admin")
+	os.Setenv("ADMIN_PASSWORD", "secret")
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"Should return status 401 when listing calculations without credentials", "/tax/calculations"},
+		{"Should return status 401 when getting calculation without credentials", "/tax/calculations/1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DB, mock := mockIdempotencyDb(t, func(mock sqlmock.Sqlmock) {})
+			defer DB.Close()
+			e := echo.New()
+			h := &Handler{DB: DB}
+			h.RegisterHistory(e.Group("/tax"))
+			rec := httptest.NewRecorder()
+
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("expected (%v), got (%v)", http.StatusUnauthorized, rec.Code)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unexpected database access: %v", err)
+			}
+		})
+	}
+
+	t.Run("Should serve calculation when credentials are valid", func(t *testing.T) {
+		DB, mock := mockIdempotencyDb(t, func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery("SELECT id, taxpayer_id, tax_year, source, input, output, config_version, created_at FROM calculation_history WHERE id = $1").
+				WithArgs(1).WillReturnRows(mockHistoryRows(mock, time.Now()))
+		})
+		defer DB.Close()
+		e := echo.New()
+		h := &Handler{DB: DB}
+		h.RegisterHistory(e.Group("/tax"))
+		req := httptest.NewRequest(http.MethodGet, "/tax/calculations/1", nil)
+		req.SetBasicAuth("admin", "secret")
+		rec := httptest.NewRecorder()
+
+		e.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected (%v), got (%v)", http.StatusOK, rec.Code)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
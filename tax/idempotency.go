@@ -0,0 +1,167 @@
+package tax
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Rachatapon1994/assessment-tax/db"
+	"github.com/Rachatapon1994/assessment-tax/util"
+	"github.com/labstack/echo/v4"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"time"
+)
+
+var (
+	IDEMPOTENCYKEYHEADER      = "Idempotency-Key"
+	IDEMPOTENCYREPLAYEDHEADER = "Idempotent-Replayed"
+	DEFAULTIDEMPOTENCYTTL     = 24 * time.Hour
+	MAXIDEMPOTENCYKEYLENGTH   = 255
+	MAXIDEMPOTENTBODYSIZE     = 32 << 20
+	IDEMPOTENCYRESERVATION    = 10 * time.Minute
+	CONTENTHASHKEYPREFIX      = "content:"
+
+	MAXJSONBODYSIZE int64 = 1 << 20
+)
+
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (r *idempotencyRecorder) Write(b []byte) (int, error) {
+	if !r.overflow {
+		if r.body.Len()+len(b) > MAXIDEMPOTENTBODYSIZE {
+			r.overflow = true
+			r.body.Reset()
+		} else {
+			r.body.Write(b)
+		}
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (h *Handler) getIdempotencyTTL() time.Duration {
+	if h.IdempotencyTTL > 0 {
+		return h.IdempotencyTTL
+	}
+	return DEFAULTIDEMPOTENCYTTL
+}
+
+func hashRequest(parts ...[]byte) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintf(hash, "%d:", len(part))
+		hash.Write(part)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func hashJsonBody(c echo.Context) (string, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Response(), c.Request().Body, MAXJSONBODYSIZE))
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return "", err
+	}
+	if err != nil {
+		return "", &Err{Message: "Error when binding JSON"}
+	}
+	c.Request().Body = io.NopCloser(bytes.NewReader(body))
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, body); err == nil {
+		body = compact.Bytes()
+	}
+	return hashRequest([]byte(c.Request().Header.Get(echo.HeaderAccept)), body), nil
+}
+
+func hashCsvFile(c echo.Context, fileForm *multipart.FileHeader) (string, error) {
+	file, err := util.OpenCsvFile(fileForm)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	fileHash := sha256.New()
+	if _, err := io.Copy(fileHash, file); err != nil {
+		return "", &Err{Message: fmt.Sprintf("Error while reading CSV file : %v", err)}
+	}
+	return hashRequest([]byte(c.QueryParams().Encode()), []byte(c.Request().Header.Get(echo.HeaderAccept)), fileHash.Sum(nil)), nil
+}
+
+func (h *Handler) idempotent(c echo.Context, byContent bool, hash func() (string, error), next func() error) error {
+	ctx := c.Request().Context()
+	key := c.Request().Header.Get(IDEMPOTENCYKEYHEADER)
+	if (key == "" && !byContent) || h.DB == nil {
+		return next()
+	}
+	if len(key) > MAXIDEMPOTENCYKEYLENGTH {
+		return c.JSON(http.StatusBadRequest, Err{Message: fmt.Sprintf("%v must not exceed %d characters", IDEMPOTENCYKEYHEADER, MAXIDEMPOTENCYKEYLENGTH)})
+	}
+	requestHash, err := hash()
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return c.JSON(http.StatusRequestEntityTooLarge, Err{Message: fmt.Sprintf("Request body exceeds maximum of %d bytes", maxBytesErr.Limit)})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+	}
+	if key == "" {
+		requestedBy, _, _ := c.Request().BasicAuth()
+		key = CONTENTHASHKEYPREFIX + hashRequest([]byte(requestedBy), []byte(requestHash))
+	}
+	endpoint := c.Request().URL.Path
+	now := time.Now()
+	record := &db.IdempotencyKey{Key: key, Endpoint: endpoint, RequestHash: requestHash, CreatedAt: now, ExpiresAt: now.Add(IDEMPOTENCYRESERVATION)}
+	reserved, err := record.Reserve(ctx, h.DB)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+	}
+	if !reserved {
+		stored, err := db.SearchIdempotencyKey(ctx, h.DB, endpoint, key, now)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+		}
+		if stored.RequestHash != requestHash {
+			return c.JSON(http.StatusUnprocessableEntity, Err{Message: fmt.Sprintf("%v is already used with a different request", IDEMPOTENCYKEYHEADER)})
+		}
+		if stored.Status != db.COMPLETED {
+			c.Response().Header().Set(echo.HeaderRetryAfter, RETRYAFTERSECONDS)
+			return c.JSON(http.StatusConflict, Err{Message: fmt.Sprintf("A request with this %v is still in progress", IDEMPOTENCYKEYHEADER)})
+		}
+		c.Response().Header().Set(IDEMPOTENCYREPLAYEDHEADER, "true")
+		return c.Blob(stored.StatusCode, stored.ContentType, stored.Body)
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	recorder := &idempotencyRecorder{ResponseWriter: c.Response().Writer}
+	c.Response().Writer = recorder
+	err = next()
+	if err != nil || c.Response().Status >= http.StatusInternalServerError || recorder.overflow {
+		if err := db.ReleaseIdempotencyKey(ctx, h.DB, endpoint, key); err != nil {
+			log.Println("can't release idempotency key", key, err)
+		}
+		return err
+	}
+	now = time.Now()
+	record.StatusCode = c.Response().Status
+	record.ContentType = c.Response().Header().Get(echo.HeaderContentType)
+	record.Body = recorder.body.Bytes()
+	record.ExpiresAt = now.Add(h.getIdempotencyTTL())
+	if err := db.DeleteExpiredIdempotencyKeys(ctx, h.DB, now); err != nil {
+		log.Println("can't delete expired idempotency keys", err)
+	}
+	if err := record.Complete(ctx, h.DB); err != nil {
+		log.Println("can't save idempotency key", key, err)
+	}
+	return nil
+}
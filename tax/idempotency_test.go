@@ -0,0 +1,235 @@
+package tax
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Rachatapon1994/assessment-tax/db"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	selectIdempotencyKeySql   = "SELECT key, endpoint, request_hash, status, status_code, content_type, body, created_at, expires_at FROM idempotency_key WHERE key = $1 AND endpoint = $2 AND expires_at > $3"
+	reserveIdempotencyKeySql  = "INSERT INTO idempotency_key (key, endpoint, request_hash, status, status_code, content_type, body, created_at, expires_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9) ON CONFLICT (key, endpoint) DO UPDATE SET request_hash = EXCLUDED.request_hash, status = EXCLUDED.status, status_code = EXCLUDED.status_code, content_type = EXCLUDED.content_type, body = EXCLUDED.body, created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at WHERE idempotency_key.expires_at <= EXCLUDED.created_at"
+	completeIdempotencyKeySql = "UPDATE idempotency_key SET status = $1, status_code = $2, content_type = $3, body = $4, expires_at = $5 WHERE key = $6 AND endpoint = $7 AND status = $8"
+	releaseIdempotencyKeySql  = "DELETE FROM idempotency_key WHERE key = $1 AND endpoint = $2 AND status = $3"
+)
+
+func mockIdempotencyKeyRows(mock sqlmock.Sqlmock, endpoint string, requestHash string, status string, body string) *sqlmock.Rows {
+	now := time.Now()
+	return mock.NewRows([]string{"key", "endpoint", "request_hash", "status", "status_code", "content_type", "body", "created_at", "expires_at"}).
+		AddRow("key-1", endpoint, requestHash, status, 200, "application/json", []byte(body), now, now.Add(DEFAULTIDEMPOTENCYTTL))
+}
+
+func mockReserveIdempotencyKey(mock sqlmock.Sqlmock, endpoint string, rowsAffected int64) {
+	mock.ExpectExec(reserveIdempotencyKeySql).WithArgs("key-1", endpoint, sqlmock.AnyArg(), "running", 0, "", []byte{}, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, rowsAffected))
+}
+
+func mockContentHashIdempotency(mock sqlmock.Sqlmock, endpoint string) {
+	mock.ExpectExec(reserveIdempotencyKeySql).WithArgs(sqlmock.AnyArg(), endpoint, sqlmock.AnyArg(), "running", 0, "", []byte{}, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func mockCompleteContentHashIdempotency(mock sqlmock.Sqlmock, endpoint string) {
+	mock.ExpectExec("DELETE FROM idempotency_key WHERE expires_at <= $1").WithArgs(sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(completeIdempotencyKeySql).WithArgs("completed", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), endpoint, "running").WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func mockIdempotencyDb(t *testing.T, setup func(mock sqlmock.Sqlmock)) (*sql.DB, sqlmock.Sqlmock) {
+	DB, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	setup(mock)
+	return DB, mock
+}
+
+func Test_hashJsonBody(t *testing.T) {
+	t.Parallel()
+	compact := mockPostTaxCalculationContext(`{"totalIncome":500000.0,"wht":0.0}`)
+	indented := mockPostTaxCalculationContext("{\n  \"totalIncome\": 500000.0,\n  \"wht\": 0.0\n}")
+	different := mockPostTaxCalculationContext(`{"totalIncome":500000.0,"wht":1.0}`)
+	compactHash, _ := hashJsonBody(compact.c)
+	indentedHash, _ := hashJsonBody(indented.c)
+	differentHash, _ := hashJsonBody(different.c)
+	if compactHash != indentedHash {
+		t.Errorf("expected formatting to be ignored, got (%v) and (%v)", compactHash, indentedHash)
+	}
+	if compactHash == differentHash {
+		t.Errorf("expected different request to have different hash")
+	}
+	tooLarge := mockPostTaxCalculationContext(`{"totalIncome":500000.0,"wht":0.0,"taxpayerId":"` + strings.Repeat("1", int(MAXJSONBODYSIZE)) + `"}`)
+	var maxBytesErr *http.MaxBytesError
+	if _, err := hashJsonBody(tooLarge.c); !errors.As(err, &maxBytesErr) {
+		t.Errorf("expected body larger than %d bytes to return max bytes error, got (%v)", MAXJSONBODYSIZE, err)
+	}
+	tc := Calculation{}
+	if err := compact.c.Bind(&tc); err != nil || *tc.TotalIncome != 500000.0 {
+		t.Errorf("expected request body to be readable after hashing, got error (%v)", err)
+	}
+}
+
+func Test_hashCsvFile(t *testing.T) {
+	t.Parallel()
+	hash := func(c mockHandlerContext) string {
+		fileForm, _ := c.c.FormFile(CSVFILEKEY)
+		result, _ := hashCsvFile(c.c, fileForm)
+		return result
+	}
+	first := hash(mockPostTaxCalculationCsvContext("strict=true", CSVFILEKEY, "taxes.csv", "totalIncome,wht\n500000,0\n"))
+	second := hash(mockPostTaxCalculationCsvContext("strict=true", CSVFILEKEY, "copy.csv", "totalIncome,wht\n500000,0\n"))
+	otherQuery := hash(mockPostTaxCalculationCsvContext("strict=false", CSVFILEKEY, "taxes.csv", "totalIncome,wht\n500000,0\n"))
+	otherContent := hash(mockPostTaxCalculationCsvContext("strict=true", CSVFILEKEY, "taxes.csv", "totalIncome,wht\n500000,1\n"))
+	if first != second {
+		t.Errorf("expected same file content to have same hash, got (%v) and (%v)", first, second)
+	}
+	if first == otherQuery || first == otherContent {
+		t.Errorf("expected different query or content to have different hash")
+	}
+}
+
+func TestHandler_CalculationHandler_idempotency(t *testing.T) {
+	t.Parallel()
+	body := `{"totalIncome":500000.0,"wht":0.0}`
+	requestHash, _ := hashJsonBody(mockPostTaxCalculationContext(body).c)
+	stored := `{"tax":1,"taxRefund":0,"taxLevel":[],"configVersion":"stored"}`
+	tests := []struct {
+		name               string
+		key                string
+		setup              func(mock sqlmock.Sqlmock)
+		wantResponseStatus int
+		wantResponseBody   string
+		wantReplayed       string
+		allowances         db.AllowanceRepository
+	}{
+		{"Should calculate without storing when no Idempotency-Key is sent", "", func(mock sqlmock.Sqlmock) {
+		}, 200, "", "", db.NewMemoryAllowanceRepository()},
+		{"Should reserve key before calculating and store response when Idempotency-Key is new", "key-1", func(mock sqlmock.Sqlmock) {
+			mockReserveIdempotencyKey(mock, "/tax/calculations", 1)
+			mock.ExpectExec("DELETE FROM idempotency_key WHERE expires_at <= $1").WithArgs(sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(completeIdempotencyKeySql).WithArgs("completed", 200, "application/json", sqlmock.AnyArg(), sqlmock.AnyArg(), "key-1", "/tax/calculations", "running").WillReturnResult(sqlmock.NewResult(0, 1))
+		}, 200, "", "", db.NewMemoryAllowanceRepository()},
+		{"Should release reserved key when calculation fails", "key-1", func(mock sqlmock.Sqlmock) {
+			mockReserveIdempotencyKey(mock, "/tax/calculations", 1)
+			mock.ExpectExec(releaseIdempotencyKeySql).WithArgs("key-1", "/tax/calculations", "running").WillReturnResult(sqlmock.NewResult(0, 1))
+		}, 503, "", "", &settingsAllowanceRepository{err: sql.ErrConnDone}},
+		{"Should replay stored response when Idempotency-Key is retried", "key-1", func(mock sqlmock.Sqlmock) {
+			mockReserveIdempotencyKey(mock, "/tax/calculations", 0)
+			mock.ExpectQuery(selectIdempotencyKeySql).WithArgs("key-1", "/tax/calculations", sqlmock.AnyArg()).WillReturnRows(mockIdempotencyKeyRows(mock, "/tax/calculations", requestHash, "completed", stored))
+		}, 200, stored, "true", db.NewMemoryAllowanceRepository()},
+		{"Should return status 409 when request with Idempotency-Key is still in progress", "key-1", func(mock sqlmock.Sqlmock) {
+			mockReserveIdempotencyKey(mock, "/tax/calculations", 0)
+			mock.ExpectQuery(selectIdempotencyKeySql).WithArgs("key-1", "/tax/calculations", sqlmock.AnyArg()).WillReturnRows(mockIdempotencyKeyRows(mock, "/tax/calculations", requestHash, "running", ""))
+		}, 409, `{"message":"A request with this Idempotency-Key is still in progress"}` + "\n", "", db.NewMemoryAllowanceRepository()},
+		{"Should return status 422 when Idempotency-Key is reused with different request", "key-1", func(mock sqlmock.Sqlmock) {
+			mockReserveIdempotencyKey(mock, "/tax/calculations", 0)
+			mock.ExpectQuery(selectIdempotencyKeySql).WithArgs("key-1", "/tax/calculations", sqlmock.AnyArg()).WillReturnRows(mockIdempotencyKeyRows(mock, "/tax/calculations", "other", "completed", stored))
+		}, 422, `{"message":"Idempotency-Key is already used with a different request"}` + "\n", "", db.NewMemoryAllowanceRepository()},
+		{"Should return status 500 when Idempotency-Key cannot be reserved", "key-1", func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(reserveIdempotencyKeySql).WillReturnError(sql.ErrConnDone)
+		}, 500, `{"message":"sql: connection is already closed"}` + "\n", "", db.NewMemoryAllowanceRepository()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DB, mock := mockIdempotencyDb(t, tt.setup)
+			defer DB.Close()
+			c := mockPostTaxCalculationContext(body)
+			if tt.key != "" {
+				c.c.Request().Header.Set(IDEMPOTENCYKEYHEADER, tt.key)
+			}
+			h := &Handler{DB: DB, Allowances: tt.allowances}
+
+			if err := h.CalculationHandler(c.c); err != nil {
+				t.Errorf("Handler.CalculationHandler() error = %v", err)
+			}
+
+			if c.r.Code != tt.wantResponseStatus {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseStatus, c.r.Code)
+			}
+			if tt.wantResponseBody != "" && c.r.Body.String() != tt.wantResponseBody {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseBody, c.r.Body.String())
+			}
+			if got := c.r.Header().Get(IDEMPOTENCYREPLAYEDHEADER); got != tt.wantReplayed {
+				t.Errorf("expected %v header (%v), got (%v)", IDEMPOTENCYREPLAYEDHEADER, tt.wantReplayed, got)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestHandler_CalculationCsvHandler_idempotency(t *testing.T) {
+	t.Parallel()
+	content := "totalIncome,wht\n500000,0\n"
+	hashContext := mockPostTaxCalculationCsvContext("strict=true", CSVFILEKEY, "taxes.csv", content)
+	fileForm, _ := hashContext.c.FormFile(CSVFILEKEY)
+	requestHash, _ := hashCsvFile(hashContext.c, fileForm)
+	stored := `{"configVersion":"stored","taxes":[{"totalIncome":500000,"tax":29000,"taxRefund":0}]}`
+
+	DB, mock := mockIdempotencyDb(t, func(mock sqlmock.Sqlmock) {
+		mockReserveIdempotencyKey(mock, "/tax/calculations/upload-csv", 0)
+		mock.ExpectQuery(selectIdempotencyKeySql).WithArgs("key-1", "/tax/calculations/upload-csv", sqlmock.AnyArg()).WillReturnRows(mockIdempotencyKeyRows(mock, "/tax/calculations/upload-csv", requestHash, "completed", stored))
+	})
+	defer DB.Close()
+	c := mockPostTaxCalculationCsvContext("strict=true", CSVFILEKEY, "taxes.csv", content)
+	c.c.Request().Header.Set(IDEMPOTENCYKEYHEADER, "key-1")
+	h := &Handler{DB: DB}
+
+	t.Run("Should replay stored response when the same file is uploaded again", func(t *testing.T) {
+		if err := h.CalculationCsvHandler(c.c); err != nil {
+			t.Errorf("Handler.CalculationCsvHandler() error = %v", err)
+		}
+		if c.r.Code != http.StatusOK {
+			t.Errorf("expected (%v), got (%v)", http.StatusOK, c.r.Code)
+		}
+		if c.r.Body.String() != stored {
+			t.Errorf("expected (%v), got (%v)", stored, c.r.Body.String())
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestHandler_CalculationCsvHandler_contentHash(t *testing.T) {
+	t.Parallel()
+	content := "totalIncome,wht\n500000,0\n"
+	hashContext := mockPostTaxCalculationCsvContext("strict=true", CSVFILEKEY, "taxes.csv", content)
+	fileForm, _ := hashContext.c.FormFile(CSVFILEKEY)
+	requestHash, _ := hashCsvFile(hashContext.c, fileForm)
+	key := CONTENTHASHKEYPREFIX + hashRequest([]byte("admin"), []byte(requestHash))
+	stored := `{"configVersion":"stored","taxes":[{"totalIncome":500000,"tax":29000,"taxRefund":0}]}`
+
+	DB, mock := mockIdempotencyDb(t, func(mock sqlmock.Sqlmock) {
+		mock.ExpectExec(reserveIdempotencyKeySql).WithArgs(key, "/tax/calculations/upload-csv", requestHash, "running", 0, "", []byte{}, sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(selectIdempotencyKeySql).WithArgs(key, "/tax/calculations/upload-csv", sqlmock.AnyArg()).WillReturnRows(mockIdempotencyKeyRows(mock, "/tax/calculations/upload-csv", requestHash, "completed", stored))
+	})
+	defer DB.Close()
+	c := mockPostTaxCalculationCsvContext("strict=true", CSVFILEKEY, "copy.csv", content)
+	c.c.Request().SetBasicAuth("admin", "secret")
+	h := &Handler{DB: DB}
+
+	t.Run("Should replay stored response when the same file is uploaded again without Idempotency-Key", func(t *testing.T) {
+		if err := h.CalculationCsvHandler(c.c); err != nil {
+			t.Errorf("Handler.CalculationCsvHandler() error = %v", err)
+		}
+		if c.r.Code != http.StatusOK {
+			t.Errorf("expected (%v), got (%v)", http.StatusOK, c.r.Code)
+		}
+		if c.r.Body.String() != stored {
+			t.Errorf("expected (%v), got (%v)", stored, c.r.Body.String())
+		}
+		if got := c.r.Header().Get(IDEMPOTENCYREPLAYEDHEADER); got != "true" {
+			t.Errorf("expected %v header (true), got (%v)", IDEMPOTENCYREPLAYEDHEADER, got)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
@@ -0,0 +1,293 @@
+package tax
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/Rachatapon1994/assessment-tax/db"
+	mw "github.com/Rachatapon1994/assessment-tax/middleware"
+	"github.com/Rachatapon1994/assessment-tax/util"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+var (
+	DEFAULTJOBWORKERS      = 2
+	DEFAULTJOBPOLLINTERVAL = 5 * time.Second
+	DEFAULTJOBLEASE        = time.Minute
+	JOBPROGRESSINTERVAL    = 500
+
+	DEFAULTMAXJOBUPLOADSIZE int64 = 32 << 20
+	DEFAULTMAXJOBRESULTSIZE int64 = 128 << 20
+)
+
+type JobQueue struct {
+	DB            *sql.DB
+	Dialect       db.Dialect
+	Allowances    db.AllowanceRepository
+	Workers       int
+	WorkerId      string
+	Lease         time.Duration
+	MaxUploadSize int64
+	MaxResultSize int64
+	MaxCsvRows    int
+	PollInterval  time.Duration
+	BatchWorkers  int
+	History       bool
+	QueryTimeout  time.Duration
+	wake          chan struct{}
+}
+
+func NewJobQueue(DB *sql.DB, dialect db.Dialect, allowances db.AllowanceRepository, workers int) *JobQueue {
+	if workers <= 0 {
+		workers = DEFAULTJOBWORKERS
+	}
+	return &JobQueue{DB: DB, Dialect: dialect, Allowances: allowances, Workers: workers, WorkerId: newWorkerId(), Lease: DEFAULTJOBLEASE, MaxUploadSize: DEFAULTMAXJOBUPLOADSIZE, MaxResultSize: DEFAULTMAXJOBRESULTSIZE, MaxCsvRows: DEFAULTMAXCSVROWS, PollInterval: DEFAULTJOBPOLLINTERVAL, wake: make(chan struct{}, 1)}
+}
+
+func randomHex(size int) string {
+	value := make([]byte, size)
+	rand.Read(value)
+	return hex.EncodeToString(value)
+}
+
+func newWorkerId() string {
+	hostname, _ := os.Hostname()
+	return hostname + "-" + randomHex(8)
+}
+
+func (q *JobQueue) Start(ctx context.Context) {
+	ctx = db.WithQueryTimeout(ctx, q.QueryTimeout)
+	for i := 0; i < q.Workers; i++ {
+		go q.work(ctx)
+	}
+	q.Notify()
+}
+
+func (q *JobQueue) Notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *JobQueue) work(ctx context.Context) {
+	ticker := time.NewTicker(q.PollInterval)
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		job, err := db.ClaimJob(ctx, q.DB, q.Dialect, q.WorkerId, now, now.Add(q.Lease))
+		if err == nil {
+			q.process(ctx, job)
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) && ctx.Err() == nil {
+			log.Println("can't claim calculation job", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (q *JobQueue) process(ctx context.Context, job db.Job) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	go q.renewLease(jobCtx, cancel, job.Id)
+	output, err := q.run(jobCtx, job)
+	leaseLost := jobCtx.Err() != nil
+	cancel()
+	if leaseLost {
+		return
+	}
+	if err != nil {
+		if err := db.FailJob(ctx, q.DB, job.Id, q.WorkerId, err.Error(), time.Now()); err != nil {
+			log.Println("can't update calculation job", job.Id, err)
+		}
+		return
+	}
+	if err := db.CompleteJob(ctx, q.DB, job.Id, q.WorkerId, getContentType(job.Format), output, time.Now()); err != nil {
+		log.Println("can't update calculation job", job.Id, err)
+	}
+}
+
+func (q *JobQueue) renewLease(ctx context.Context, cancel context.CancelFunc, id int) {
+	ticker := time.NewTicker(q.Lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		renewed, err := db.RenewJobLease(ctx, q.DB, id, q.WorkerId, time.Now().Add(q.Lease))
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Println("can't renew lease of calculation job", id, err)
+			}
+			continue
+		}
+		if !renewed {
+			log.Println("lease of calculation job was taken over by another worker", id)
+			cancel()
+			return
+		}
+	}
+}
+
+func (q *JobQueue) run(ctx context.Context, job db.Job) ([]byte, error) {
+	options, err := util.ParseCsvOptions(job.Delimiter, job.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	settings := csvSettings{strict: job.Strict, options: options, format: job.Format, workers: q.BatchWorkers, fileType: job.FileType, sheet: job.Sheet, xlsx: util.NewXlsxLimits(q.MaxUploadSize, q.MaxCsvRows)}
+	totalRows, err := scanCsv(bytes.NewReader(job.File), settings, q.MaxCsvRows)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := LoadSnapshot(ctx, q.Allowances)
+	if err != nil {
+		return nil, err
+	}
+	if q.History {
+		settings.history = newCsvHistory(ctx, q.DB, snapshot.Version)
+	}
+	if err := db.UpdateJobProgress(ctx, q.DB, job.Id, totalRows, 0); err != nil {
+		return nil, err
+	}
+	output := &jobResultBuffer{max: q.MaxResultSize}
+	err = streamCsv(ctx, bytes.NewReader(job.File), settings, snapshot, output, func(processed int) {
+		if processed%JOBPROGRESSINTERVAL == 0 {
+			db.UpdateJobProgress(ctx, q.DB, job.Id, totalRows, processed)
+		}
+	})
+	if output.err != nil {
+		return nil, output.err
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := db.UpdateJobProgress(ctx, q.DB, job.Id, totalRows, totalRows); err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
+
+type jobResultBuffer struct {
+	bytes.Buffer
+	max int64
+	err error
+}
+
+func (b *jobResultBuffer) Write(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.max > 0 && int64(b.Len()+len(p)) > b.max {
+		b.err = fmt.Errorf("Job result exceeds maximum of %d bytes", b.max)
+		return 0, b.err
+	}
+	return b.Buffer.Write(p)
+}
+
+func (h *Handler) submitCsvJob(c echo.Context, fileForm *multipart.FileHeader, settings csvSettings) error {
+	if h.Jobs == nil {
+		return c.JSON(http.StatusServiceUnavailable, Err{Message: "Asynchronous calculation is not available"})
+	}
+	maxJobUploadSize := h.getMaxJobUploadSize()
+	if fileForm.Size > maxJobUploadSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, Err{Message: fmt.Sprintf("File size exceeds maximum of %d bytes for asynchronous calculation", maxJobUploadSize)})
+	}
+	file, err := util.OpenCsvFile(fileForm)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+	}
+	defer file.Close()
+	content, err := io.ReadAll(io.LimitReader(file, maxJobUploadSize+1))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: fmt.Sprintf("Error while reading CSV file : %v", err)})
+	}
+	if int64(len(content)) > maxJobUploadSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, Err{Message: fmt.Sprintf("File size exceeds maximum of %d bytes for asynchronous calculation", maxJobUploadSize)})
+	}
+	requestedBy, _, _ := c.Request().BasicAuth()
+	job := &db.Job{PublicId: randomHex(16), RequestedBy: requestedBy, Status: db.PENDING, Strict: settings.strict, Format: settings.format, Delimiter: c.QueryParam("delimiter"), Encoding: c.QueryParam("encoding"), FileType: settings.fileType, Sheet: settings.sheet, File: content, CreatedAt: time.Now()}
+	if err := job.Insert(c.Request().Context(), h.DB); err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+	}
+	h.Jobs.Notify()
+	c.Response().Header().Set(echo.HeaderLocation, "/tax/jobs/"+job.PublicId)
+	return c.JSON(http.StatusAccepted, job)
+}
+
+func (h *Handler) searchJob(c echo.Context) (db.Job, int, error) {
+	job, err := db.SearchJobByPublicId(c.Request().Context(), h.DB, c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return db.Job{}, http.StatusNotFound, &Err{Message: "Job not found"}
+	}
+	if err != nil {
+		return db.Job{}, http.StatusInternalServerError, err
+	}
+	if username, _, _ := c.Request().BasicAuth(); job.RequestedBy != username {
+		return db.Job{}, http.StatusNotFound, &Err{Message: "Job not found"}
+	}
+	return job, http.StatusOK, nil
+}
+
+func (h *Handler) JobHandler(c echo.Context) error {
+	job, status, err := h.searchJob(c)
+	if err != nil {
+		return c.JSON(status, Err{Message: err.Error()})
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+func (h *Handler) JobResultHandler(c echo.Context) error {
+	ctx := c.Request().Context()
+	job, status, err := h.searchJob(c)
+	if err != nil {
+		return c.JSON(status, Err{Message: err.Error()})
+	}
+	switch job.Status {
+	case db.COMPLETED:
+	case db.FAILED:
+		return c.JSON(http.StatusUnprocessableEntity, Err{Message: job.Error})
+	default:
+		return c.JSON(http.StatusConflict, Err{Message: "Job is " + job.Status})
+	}
+	contentType, result, err := db.SearchJobResult(ctx, h.DB, job.Id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+	}
+	setAttachment(c, job.Format)
+	return c.Blob(http.StatusOK, contentType, result)
+}
+
+func AsyncCsvAuth() echo.MiddlewareFunc {
+	return middleware.BasicAuthWithConfig(middleware.BasicAuthConfig{
+		Skipper: func(c echo.Context) bool {
+			async, _ := strconv.ParseBool(c.QueryParam("async"))
+			return !async
+		},
+		Validator: mw.Authenticate(),
+	})
+}
+
+func (h *Handler) RegisterJobs(g *echo.Group) {
+	auth := middleware.BasicAuth(mw.Authenticate())
+	g.GET("/jobs/:id", h.JobHandler, auth)
+	g.GET("/jobs/:id/result", h.JobResultHandler, auth)
+}
@@ -0,0 +1,304 @@
+package tax
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Rachatapon1994/assessment-tax/db"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var selectJobSql = "SELECT id, public_id, requested_by, status, strict, format, delimiter, encoding, file_type, sheet, total_rows, processed_rows, error, created_at, started_at, completed_at FROM calculation_job WHERE public_id = $1"
+
+func mockJobDb(t *testing.T, setup func(mock sqlmock.Sqlmock)) (*sql.DB, sqlmock.Sqlmock) {
+	DB, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	setup(mock)
+	return DB, mock
+}
+
+func mockJobRows(mock sqlmock.Sqlmock, status string, jobError interface{}) *sqlmock.Rows {
+	createdAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	return mock.NewRows([]string{"id", "public_id", "requested_by", "status", "strict", "format", "delimiter", "encoding", "file_type", "sheet", "total_rows", "processed_rows", "error", "created_at", "started_at", "completed_at"}).
+		AddRow(1, "f3a9", "admin", status, false, "csv", "", "", "csv", "", 3, 3, jobError, createdAt, createdAt, nil)
+}
+
+func mockGetJobContext(path string, id string) mockHandlerContext {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.SetBasicAuth("admin", "secret")
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+	return mockHandlerContext{c, rec}
+}
+
+func TestHandler_CalculationCsvHandler_async(t *testing.T) {
+	t.Parallel()
+	fileContent := "totalIncome,wht,donation\n500000,0,0\n"
+
+	t.Run("Should return status 503 when job queue is not configured", func(t *testing.T) {
+		c := mockPostTaxCalculationCsvContext("async=true", "taxFile", "taxes.csv", fileContent)
+		h := &Handler{}
+		if err := h.CalculationCsvHandler(c.c); err != nil {
+			t.Errorf("Handler.CalculationCsvHandler() error = %v", err)
+		}
+		if c.r.Code != http.StatusServiceUnavailable {
+			t.Errorf("expected (%v), got (%v)", http.StatusServiceUnavailable, c.r.Code)
+		}
+	})
+
+	t.Run("Should return status 413 when file is larger than maximum asynchronous upload size", func(t *testing.T) {
+		c := mockPostTaxCalculationCsvContext("async=true", "taxFile", "taxes.csv", fileContent)
+		h := &Handler{Jobs: NewJobQueue(nil, db.POSTGRES, db.NewMemoryAllowanceRepository(), 1), MaxJobUploadSize: 10}
+		if err := h.CalculationCsvHandler(c.c); err != nil {
+			t.Errorf("Handler.CalculationCsvHandler() error = %v", err)
+		}
+		result := Err{}
+		if err := json.Unmarshal(c.r.Body.Bytes(), &result); err != nil {
+			t.Errorf("unable to unmarshal json: %v", err)
+		}
+		if want := "File size exceeds maximum of 10 bytes for asynchronous calculation"; result.Message != want {
+			t.Errorf("expected (%v), got (%v)", want, result.Message)
+		}
+		if c.r.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("expected (%v), got (%v)", http.StatusRequestEntityTooLarge, c.r.Code)
+		}
+	})
+
+	t.Run("Should persist job and return status 202 with job id", func(t *testing.T) {
+		DB, mock := mockJobDb(t, func(mock sqlmock.Sqlmock) {
+			mockContentHashIdempotency(mock, "/tax/calculations/upload-csv")
+			mock.ExpectQuery("INSERT INTO calculation_job (public_id, requested_by, status, strict, format, delimiter, encoding, file_type, sheet, file, created_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11) RETURNING id").
+				WithArgs(sqlmock.AnyArg(), "admin", "pending", true, "csv", "", "", "csv", "", []byte(fileContent), sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+			mockCompleteContentHashIdempotency(mock, "/tax/calculations/upload-csv")
+		})
+		defer DB.Close()
+		c := mockPostTaxCalculationCsvContext("async=true&strict=true&format=csv", "taxFile", "taxes.csv", fileContent)
+		c.c.Request().SetBasicAuth("admin", "secret")
+		h := &Handler{DB: DB, Jobs: NewJobQueue(DB, db.POSTGRES, db.NewMemoryAllowanceRepository(), 1)}
+
+		if err := h.CalculationCsvHandler(c.c); err != nil {
+			t.Errorf("Handler.CalculationCsvHandler() error = %v", err)
+		}
+
+		result := db.Job{}
+		if err := json.Unmarshal(c.r.Body.Bytes(), &result); err != nil {
+			t.Errorf("unable to unmarshal json: %v", err)
+		}
+		if len(result.PublicId) != 32 || result.RequestedBy != "admin" || result.Status != db.PENDING {
+			t.Errorf("expected pending job with unguessable id, got (%v)", result)
+		}
+		if got := c.r.Header().Get(echo.HeaderLocation); got != "/tax/jobs/"+result.PublicId {
+			t.Errorf("expected Location /tax/jobs/%v, got (%v)", result.PublicId, got)
+		}
+		if c.r.Code != http.StatusAccepted {
+			t.Errorf("expected (%v), got (%v)", http.StatusAccepted, c.r.Code)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestHandler_JobHandler(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name               string
+		id                 string
+		setup              func(mock sqlmock.Sqlmock)
+		wantResponseStatus int
+	}{
+		{"Should return status 404 when job does not exist", "b7c2", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectJobSql).WithArgs("b7c2").WillReturnError(sql.ErrNoRows)
+		}, 404},
+		{"Should return status 404 when job belongs to another user", "f3a9", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectJobSql).WithArgs("f3a9").WillReturnRows(mock.NewRows([]string{"id", "public_id", "requested_by", "status", "strict", "format", "delimiter", "encoding", "file_type", "sheet", "total_rows", "processed_rows", "error", "created_at", "started_at", "completed_at"}).
+				AddRow(1, "f3a9", "approver", "running", false, "csv", "", "", "csv", "", 3, 3, nil, time.Now(), nil, nil))
+		}, 404},
+		{"Should return job progress", "f3a9", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectJobSql).WithArgs("f3a9").WillReturnRows(mockJobRows(mock, "running", nil))
+		}, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DB, _ := mockJobDb(t, tt.setup)
+			defer DB.Close()
+			c := mockGetJobContext("/tax/jobs/"+tt.id, tt.id)
+			h := &Handler{DB: DB}
+
+			if err := h.JobHandler(c.c); err != nil {
+				t.Errorf("Handler.JobHandler() error = %v", err)
+			}
+			if c.r.Code != tt.wantResponseStatus {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseStatus, c.r.Code)
+			}
+		})
+	}
+}
+
+func TestHandler_JobResultHandler(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name               string
+		setup              func(mock sqlmock.Sqlmock)
+		wantResponseBody   string
+		wantResponseStatus int
+	}{
+		{"Should return status 409 when job is still running", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectJobSql).WithArgs("f3a9").WillReturnRows(mockJobRows(mock, "running", nil))
+		}, "{\"message\":\"Job is running\"}\n", 409},
+		{"Should return status 422 with job error when job failed", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectJobSql).WithArgs("f3a9").WillReturnRows(mockJobRows(mock, "failed", "CSV header contains unknown column : donation1"))
+		}, "{\"message\":\"CSV header contains unknown column : donation1\"}\n", 422},
+		{"Should return stored output when job completed", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectJobSql).WithArgs("f3a9").WillReturnRows(mockJobRows(mock, "completed", nil))
+			mock.ExpectQuery("SELECT content_type, result FROM calculation_job WHERE id = $1").WithArgs(1).
+				WillReturnRows(mock.NewRows([]string{"content_type", "result"}).AddRow("text/csv; charset=utf-8", []byte("totalIncome,wht\n")))
+		}, "totalIncome,wht\n", 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DB, _ := mockJobDb(t, tt.setup)
+			defer DB.Close()
+			c := mockGetJobContext("/tax/jobs/f3a9/result", "f3a9")
+			h := &Handler{DB: DB}
+
+			if err := h.JobResultHandler(c.c); err != nil {
+				t.Errorf("Handler.JobResultHandler() error = %v", err)
+			}
+			if got := c.r.Body.String(); got != tt.wantResponseBody {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseBody, got)
+			}
+			if c.r.Code != tt.wantResponseStatus {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseStatus, c.r.Code)
+			}
+		})
+	}
+}
+
+func TestHandler_RegisterJobs(t *testing.T) {
+	os.Setenv("ADMIN_USERNAME", "admin")
+	os.Setenv("ADMIN_PASSWORD", "secret")
+	for _, path := range []string{"/tax/jobs/f3a9", "/tax/jobs/f3a9/result"} {
+		t.Run("Should return status 401 without credentials for "+path, func(t *testing.T) {
+			DB, mock := mockJobDb(t, func(mock sqlmock.Sqlmock) {})
+			defer DB.Close()
+			e := echo.New()
+			h := &Handler{DB: DB}
+			h.RegisterJobs(e.Group("/tax"))
+			rec := httptest.NewRecorder()
+
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("expected (%v), got (%v)", http.StatusUnauthorized, rec.Code)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unexpected database access: %v", err)
+			}
+		})
+	}
+}
+
+func TestAsyncCsvAuth(t *testing.T) {
+	os.Setenv("ADMIN_USERNAME", "admin")
+	os.Setenv("ADMIN_PASSWORD", "secret")
+	tests := []struct {
+		name           string
+		query          string
+		auth           bool
+		wantStatusCode int
+	}{
+		{"Should allow synchronous upload without credentials", "", false, http.StatusOK},
+		{"Should return status 401 for asynchronous upload without credentials", "?async=true", false, http.StatusUnauthorized},
+		{"Should allow asynchronous upload with credentials", "?async=true", true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.POST("/tax/calculations/upload-csv", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, AsyncCsvAuth())
+			req := httptest.NewRequest(http.MethodPost, "/tax/calculations/upload-csv"+tt.query, nil)
+			if tt.auth {
+				req.SetBasicAuth("admin", "secret")
+			}
+			rec := httptest.NewRecorder()
+
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatusCode {
+				t.Errorf("expected (%v), got (%v)", tt.wantStatusCode, rec.Code)
+			}
+		})
+	}
+}
+
+func TestJobQueue_process(t *testing.T) {
+	t.Parallel()
+	updateProgressSql := "UPDATE calculation_job SET total_rows = $1, processed_rows = $2 WHERE id = $3"
+	tests := []struct {
+		name          string
+		job           db.Job
+		maxCsvRows    int
+		maxResultSize int64
+		setup         func(mock sqlmock.Sqlmock)
+	}{
+		{"Should store calculated output when job succeeds", db.Job{Id: 1, Format: "json", File: []byte("totalIncome,wht\n500000,0\n")}, 0, 0, func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(updateProgressSql).WithArgs(1, 0, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec(updateProgressSql).WithArgs(1, 1, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+			result := []byte(`{"configVersion":"` + mockSnapshot().Version + `","rows":[{"row":2,"result":{"totalIncome":500000,"tax":29000,"taxRefund":0}}],"successCount":1,"errorCount":0}` + "\n")
+			mock.ExpectExec("UPDATE calculation_job SET status = $1, content_type = $2, result = $3, completed_at = $4, locked_until = NULL WHERE id = $5 AND worker_id = $6").
+				WithArgs("completed", "application/json", result, sqlmock.AnyArg(), 1, "worker-1").WillReturnResult(sqlmock.NewResult(0, 1))
+		}},
+		{"Should mark job as failed when result exceeds limit", db.Job{Id: 4, Format: "json", File: []byte("totalIncome,wht\n500000,0\n")}, 0, 10, func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(updateProgressSql).WithArgs(1, 0, 4).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectExec("UPDATE calculation_job SET status = $1, error = $2, completed_at = $3, locked_until = NULL WHERE id = $4 AND worker_id = $5").
+				WithArgs("failed", "Job result exceeds maximum of 10 bytes", sqlmock.AnyArg(), 4, "worker-1").WillReturnResult(sqlmock.NewResult(0, 1))
+		}},
+		{"Should mark job as failed when strict row is invalid", db.Job{Id: 2, Strict: true, Format: "json", File: []byte("totalIncome,wht\nabc,0\n")}, 0, 0, func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec("UPDATE calculation_job SET status = $1, error = $2, completed_at = $3, locked_until = NULL WHERE id = $4 AND worker_id = $5").
+				WithArgs("failed", "Cannot convert CSV data to float64 : strconv.ParseFloat: parsing \"abc\": invalid syntax", sqlmock.AnyArg(), 2, "worker-1").WillReturnResult(sqlmock.NewResult(0, 1))
+		}},
+		{"Should mark job as failed when rows exceed limit", db.Job{Id: 3, Format: "json", File: []byte("totalIncome,wht\n500000,0\n600000,0\n")}, 1, 0, func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec("UPDATE calculation_job SET status = $1, error = $2, completed_at = $3, locked_until = NULL WHERE id = $4 AND worker_id = $5").
+				WithArgs("failed", "CSV file exceeds maximum of 1 rows", sqlmock.AnyArg(), 3, "worker-1").WillReturnResult(sqlmock.NewResult(0, 1))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DB, mock := mockJobDb(t, tt.setup)
+			defer DB.Close()
+
+			queue := NewJobQueue(DB, db.POSTGRES, db.NewMemoryAllowanceRepository(), 1)
+			queue.WorkerId = "worker-1"
+			if tt.maxCsvRows > 0 {
+				queue.MaxCsvRows = tt.maxCsvRows
+			}
+			if tt.maxResultSize > 0 {
+				queue.MaxResultSize = tt.maxResultSize
+			}
+			queue.process(context.Background(), tt.job)
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestNewJobQueue(t *testing.T) {
+	t.Parallel()
+	if got := NewJobQueue(nil, db.POSTGRES, db.NewMemoryAllowanceRepository(), 0); !reflect.DeepEqual(got.Workers, DEFAULTJOBWORKERS) {
+		t.Errorf("NewJobQueue() workers = %v, want %v", got.Workers, DEFAULTJOBWORKERS)
+	}
+}
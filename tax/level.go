@@ -1,6 +1,10 @@
 package tax
 
-import "math"
+import (
+	"github.com/Rachatapon1994/assessment-tax/db"
+	"github.com/shopspring/decimal"
+	"math"
+)
 
 type Level struct {
 	Name         string
@@ -10,12 +14,16 @@ type Level struct {
 	MaxDeduction float64
 }
 
-func getLevels() []Level {
-	return []Level{
-		{"0-150,000", 0, 150000, 0, 0},
-		{"150,001-500,000", 150001, 500000, 10, 35000},
-		{"500,001-1,000,000", 500001, 1000000, 15, 75000},
-		{"1,000,001-2,000,000", 1000001, 2000000, 20, 200000},
-		{"2,000,001 ขึ้นไป", 2000001, math.MaxFloat64, 35, math.MaxFloat64},
+func getLevels(taxLevels []db.TaxLevel) []Level {
+	result := make([]Level, 0)
+	for _, taxLevel := range taxLevels {
+		if taxLevel.EndAmount == nil {
+			result = append(result, Level{taxLevel.Name, taxLevel.StartAmount, math.MaxFloat64, taxLevel.Percentage, math.MaxFloat64})
+			continue
+		}
+		rangeValue := decimal.NewFromFloat(*taxLevel.EndAmount).Sub(decimal.NewFromFloat(taxLevel.StartAmount)).Add(decimal.NewFromFloat(1))
+		maxDeduction, _ := rangeValue.Mul(decimal.NewFromFloat(taxLevel.Percentage).Div(decimal.NewFromFloat(100))).Float64()
+		result = append(result, Level{taxLevel.Name, taxLevel.StartAmount, *taxLevel.EndAmount, taxLevel.Percentage, maxDeduction})
 	}
+	return result
 }
@@ -1,11 +1,40 @@
 package tax
 
 import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Rachatapon1994/assessment-tax/db"
 	"math"
 	"reflect"
 	"testing"
 )
 
+func mockDbTaxLevels() []db.TaxLevel {
+	endAmounts := []float64{150000, 500000, 1000000, 2000000}
+	return []db.TaxLevel{
+		{Id: 1, Name: "0-150,000", StartAmount: 0, EndAmount: &endAmounts[0], Percentage: 0},
+		{Id: 2, Name: "150,001-500,000", StartAmount: 150001, EndAmount: &endAmounts[1], Percentage: 10},
+		{Id: 3, Name: "500,001-1,000,000", StartAmount: 500001, EndAmount: &endAmounts[2], Percentage: 15},
+		{Id: 4, Name: "1,000,001-2,000,000", StartAmount: 1000001, EndAmount: &endAmounts[3], Percentage: 20},
+		{Id: 5, Name: "2,000,001 ขึ้นไป", StartAmount: 2000001, EndAmount: nil, Percentage: 35},
+	}
+}
+
+func mockTaxLevelRows(mock sqlmock.Sqlmock) *sqlmock.Rows {
+	rows := mock.NewRows([]string{"id", "name", "start_amount", "end_amount", "percentage"})
+	for _, taxLevel := range mockDbTaxLevels() {
+		if taxLevel.EndAmount == nil {
+			rows.AddRow(taxLevel.Id, taxLevel.Name, taxLevel.StartAmount, nil, taxLevel.Percentage)
+		} else {
+			rows.AddRow(taxLevel.Id, taxLevel.Name, taxLevel.StartAmount, *taxLevel.EndAmount, taxLevel.Percentage)
+		}
+	}
+	return rows
+}
+
+func mockLevels() []Level {
+	return getLevels(mockDbTaxLevels())
+}
+
 func Test_getLevels(t *testing.T) {
 	t.Parallel()
 	tests := []struct {
@@ -22,7 +51,7 @@ func Test_getLevels(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := getLevels(); !reflect.DeepEqual(got, tt.want) {
+			if got := getLevels(mockDbTaxLevels()); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("getLevels() = %v, want %v", got, tt.want)
 			}
 		})
@@ -0,0 +1,84 @@
+package tax
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"sync"
+)
+
+type poolTask[T any, R any] struct {
+	input  T
+	result chan R
+}
+
+func getWorkers(workers int) int {
+	if workers > 0 {
+		return workers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+func runOrdered[T any, R any](ctx context.Context, workers int, next func() (T, error), work func(T) R, emit func(R) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	workers = getWorkers(workers)
+	tasks := make(chan poolTask[T, R], workers)
+	pending := make(chan chan R, workers*2)
+	producerErr := make(chan error, 1)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for task := range tasks {
+				task.result <- work(task.input)
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(pending)
+		defer close(tasks)
+		for {
+			input, err := next()
+			if err == io.EOF {
+				producerErr <- nil
+				return
+			}
+			if err != nil {
+				producerErr <- err
+				return
+			}
+			result := make(chan R, 1)
+			select {
+			case pending <- result:
+			case <-ctx.Done():
+				producerErr <- ctx.Err()
+				return
+			}
+			select {
+			case tasks <- poolTask[T, R]{input: input, result: result}:
+			case <-ctx.Done():
+				producerErr <- ctx.Err()
+				return
+			}
+		}
+	}()
+
+	for result := range pending {
+		select {
+		case output := <-result:
+			if err := emit(output); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return <-producerErr
+}
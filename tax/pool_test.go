@@ -0,0 +1,91 @@
+package tax
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func mockPoolSource(count int) func() (int, error) {
+	index := 0
+	return func() (int, error) {
+		if index >= count {
+			return 0, io.EOF
+		}
+		index++
+		return index, nil
+	}
+}
+
+func Test_runOrdered(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		workers int
+		count   int
+	}{
+		{"Should keep input order with a single worker", 1, 20},
+		{"Should keep input order with many workers", 8, 200},
+		{"Should use default workers when workers is not set", 0, 50},
+		{"Should emit nothing for empty input", 4, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := make([]int, 0)
+			for i := 1; i <= tt.count; i++ {
+				want = append(want, i*10)
+			}
+			got := make([]int, 0)
+			err := runOrdered(context.Background(), tt.workers, mockPoolSource(tt.count), func(i int) int {
+				time.Sleep(time.Duration((tt.count-i)%5) * time.Millisecond)
+				return i * 10
+			}, func(result int) error {
+				got = append(got, result)
+				return nil
+			})
+			if err != nil {
+				t.Errorf("runOrdered() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("expected (%v), got (%v)", want, got)
+			}
+		})
+	}
+}
+
+func Test_runOrdered_stop(t *testing.T) {
+	t.Parallel()
+	emitErr := errors.New("emit failed")
+	sourceErr := errors.New("source failed")
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		next    func() (int, error)
+		emitErr error
+		wantErr error
+	}{
+		{"Should stop when emit returns error", context.Background(), mockPoolSource(100), emitErr, emitErr},
+		{"Should return source error", context.Background(), func() (int, error) { return 0, sourceErr }, nil, sourceErr},
+		{"Should stop when context is cancelled", cancelled, mockPoolSource(1000000), nil, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			emitted := 0
+			err := runOrdered(tt.ctx, 4, tt.next, func(i int) int { return i }, func(result int) error {
+				emitted++
+				return tt.emitErr
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("runOrdered() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.emitErr != nil && emitted != 1 {
+				t.Errorf("expected emit to be called once, got (%v)", emitted)
+			}
+		})
+	}
+}
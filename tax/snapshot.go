@@ -0,0 +1,103 @@
+package tax
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/Rachatapon1994/assessment-tax/db"
+	"sort"
+)
+
+type Snapshot struct {
+	Version    string
+	allowances map[string]float64
+	levels     []Level
+}
+
+type MissingAllowanceErr struct {
+	AllowanceType string
+}
+
+func (e *MissingAllowanceErr) Error() string {
+	return fmt.Sprintf("Allowance type %v is not configured", e.AllowanceType)
+}
+
+func (e *MissingAllowanceErr) Unwrap() error {
+	return db.ErrNotFound
+}
+
+type snapshotAllowance struct {
+	AllowanceType string  `json:"allowanceType"`
+	Amount        float64 `json:"amount"`
+}
+
+type snapshotTaxLevel struct {
+	Name        string   `json:"name"`
+	StartAmount float64  `json:"startAmount"`
+	EndAmount   *float64 `json:"endAmount"`
+	Percentage  float64  `json:"percentage"`
+}
+
+func newSnapshot(allowances []db.Allowance, taxLevels []db.TaxLevel) *Snapshot {
+	snapshot := &Snapshot{allowances: make(map[string]float64), levels: getLevels(taxLevels)}
+	content := struct {
+		Allowances []snapshotAllowance `json:"allowances"`
+		TaxLevels  []snapshotTaxLevel  `json:"taxLevels"`
+	}{make([]snapshotAllowance, 0), make([]snapshotTaxLevel, 0)}
+
+	for _, allowance := range allowances {
+		snapshot.allowances[allowance.AllowanceType] = allowance.Amount
+		content.Allowances = append(content.Allowances, snapshotAllowance{allowance.AllowanceType, allowance.Amount})
+	}
+	sort.Slice(content.Allowances, func(i, j int) bool {
+		return content.Allowances[i].AllowanceType < content.Allowances[j].AllowanceType
+	})
+	for _, taxLevel := range taxLevels {
+		content.TaxLevels = append(content.TaxLevels, snapshotTaxLevel{taxLevel.Name, taxLevel.StartAmount, taxLevel.EndAmount, taxLevel.Percentage})
+	}
+
+	body, _ := json.Marshal(content)
+	hash := sha256.Sum256(body)
+	snapshot.Version = hex.EncodeToString(hash[:8])
+	return snapshot
+}
+
+func LoadSnapshot(ctx context.Context, repository db.AllowanceRepository) (*Snapshot, error) {
+	allowances, taxLevels, err := repository.Settings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newSnapshot(allowances, taxLevels), nil
+}
+
+func (s *Snapshot) Amount(allowanceType string) (float64, error) {
+	amount, ok := s.allowances[allowanceType]
+	if !ok {
+		return 0, &MissingAllowanceErr{AllowanceType: allowanceType}
+	}
+	return amount, nil
+}
+
+func (s *Snapshot) Require(allowanceTypes ...string) error {
+	for _, allowanceType := range allowanceTypes {
+		if _, err := s.Amount(allowanceType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Snapshot) Levels() []Level {
+	return append(make([]Level, 0, len(s.levels)), s.levels...)
+}
+
+func (s *Snapshot) AllowanceTypes() []string {
+	result := make([]string, 0, len(s.allowances))
+	for allowanceType := range s.allowances {
+		result = append(result, allowanceType)
+	}
+	sort.Strings(result)
+	return result
+}
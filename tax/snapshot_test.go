@@ -0,0 +1,150 @@
+package tax
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Rachatapon1994/assessment-tax/db"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func mockDbAllowances() []db.Allowance {
+	return []db.Allowance{
+		{Id: 1, AllowanceType: "personal", Amount: 60000.00},
+		{Id: 2, AllowanceType: "donation", Amount: 100000.00},
+		{Id: 3, AllowanceType: "k-receipt", Amount: 50000.00},
+		{Id: 4, AllowanceType: "spouse", Amount: 60000.00},
+		{Id: 5, AllowanceType: "child", Amount: 30000.00},
+		{Id: 6, AllowanceType: "parent", Amount: 30000.00},
+		{Id: 7, AllowanceType: "disability", Amount: 60000.00},
+	}
+}
+
+func mockAllowanceRows(mock sqlmock.Sqlmock) *sqlmock.Rows {
+	rows := mock.NewRows([]string{"id", "allowance_type", "amount", "version"})
+	for _, allowance := range mockDbAllowances() {
+		rows.AddRow(allowance.Id, allowance.AllowanceType, allowance.Amount, allowance.Version)
+	}
+	return rows
+}
+
+type settingsAllowanceRepository struct {
+	db.AllowanceRepository
+	allowances []db.Allowance
+	taxLevels  []db.TaxLevel
+	err        error
+}
+
+func (r *settingsAllowanceRepository) Settings(ctx context.Context) ([]db.Allowance, []db.TaxLevel, error) {
+	return r.allowances, r.taxLevels, r.err
+}
+
+func mockSnapshotExpectations(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, allowance_type, amount, version FROM allowance").WillReturnRows(mockAllowanceRows(mock))
+	mock.ExpectQuery("SELECT id, name, start_amount, end_amount, percentage FROM tax_level ORDER BY start_amount").WillReturnRows(mockTaxLevelRows(mock))
+	mock.ExpectCommit()
+}
+
+func mockSnapshot() *Snapshot {
+	return newSnapshot(mockDbAllowances(), mockDbTaxLevels())
+}
+
+func Test_newSnapshot(t *testing.T) {
+	t.Parallel()
+	reordered := mockDbAllowances()
+	slices.Reverse(reordered)
+	changed := mockDbAllowances()
+	changed[0].Amount = 70000
+
+	tests := []struct {
+		name        string
+		allowances  []db.Allowance
+		wantSameVer bool
+	}{
+		{"Should keep the same version when allowances are returned in a different order", reordered, true},
+		{"Should change version when an allowance amount changes", changed, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newSnapshot(tt.allowances, mockDbTaxLevels())
+			if (got.Version == mockSnapshot().Version) != tt.wantSameVer {
+				t.Errorf("newSnapshot() version = %v, base version %v, want same %v", got.Version, mockSnapshot().Version, tt.wantSameVer)
+			}
+		})
+	}
+}
+
+func TestSnapshot_accessors(t *testing.T) {
+	t.Parallel()
+	snapshot := mockSnapshot()
+	if got, err := snapshot.Amount(PERSONAL); got != 60000 || err != nil {
+		t.Errorf("Snapshot.Amount() = %v, %v, want %v", got, err, 60000)
+	}
+	if _, err := snapshot.Amount("insurance"); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("Snapshot.Amount() error = %v, want %v", err, db.ErrNotFound)
+	}
+	if err := snapshot.Require(PERSONAL, "insurance"); err == nil || err.Error() != "Allowance type insurance is not configured" {
+		t.Errorf("Snapshot.Require() error = %v", err)
+	}
+	if got := snapshot.AllowanceTypes(); !reflect.DeepEqual(got, []string{"child", "disability", "donation", "k-receipt", "parent", "personal", "spouse"}) {
+		t.Errorf("Snapshot.AllowanceTypes() = %v", got)
+	}
+	levels := snapshot.Levels()
+	levels[0].Percentage = 99
+	if snapshot.Levels()[0].Percentage != 0 {
+		t.Errorf("Snapshot.Levels() should return a copy")
+	}
+}
+
+func TestLoadSnapshot(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		setup   func(mock sqlmock.Sqlmock)
+		want    *Snapshot
+		wantErr error
+	}{
+		{"Should load allowances and tax levels in a single transaction", mockSnapshotExpectations, mockSnapshot(), nil},
+		{"Should return error when loading tax levels fails", func(mock sqlmock.Sqlmock) {
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT id, allowance_type, amount, version FROM allowance").WillReturnRows(mockAllowanceRows(mock))
+			mock.ExpectQuery("SELECT id, name, start_amount, end_amount, percentage FROM tax_level ORDER BY start_amount").WillReturnError(sql.ErrConnDone)
+			mock.ExpectRollback()
+		}, nil, sql.ErrConnDone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DB, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer DB.Close()
+			tt.setup(mock)
+
+			got, err := LoadSnapshot(context.Background(), db.NewSQLAllowanceRepository(DB))
+			if err != tt.wantErr {
+				t.Errorf("LoadSnapshot() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadSnapshot() = %v, want %v", got, tt.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+
+	t.Run("Should load default settings from in-memory repository", func(t *testing.T) {
+		got, err := LoadSnapshot(context.Background(), db.NewMemoryAllowanceRepository())
+		if err != nil {
+			t.Errorf("LoadSnapshot() error = %v", err)
+		}
+		if amount, _ := got.Amount(PERSONAL); amount != 60000 || len(got.Levels()) != 5 {
+			t.Errorf("LoadSnapshot() = %v, want default settings", got)
+		}
+	})
+}
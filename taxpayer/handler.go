@@ -0,0 +1,132 @@
+package taxpayer
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/Rachatapon1994/assessment-tax/db"
+	mw "github.com/Rachatapon1994/assessment-tax/middleware"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"net/http"
+	"time"
+)
+
+type Handler struct {
+	DB *sql.DB
+}
+
+type Err struct {
+	Message string `json:"message"`
+}
+
+func (e *Err) Error() string {
+	return e.Message
+}
+
+type Profile struct {
+	Id               string `json:"id" validate:"max=64"`
+	Name             string `json:"name" validate:"max=200"`
+	MaritalStatus    string `json:"maritalStatus" validate:"required,oneof=single married"`
+	Children         int    `json:"children" validate:"gte=0"`
+	Disabled         bool   `json:"disabled"`
+	ParentsSupported int    `json:"parentsSupported" validate:"gte=0,lte=4"`
+}
+
+func validateInput(c echo.Context, p *Profile) error {
+	if err := c.Bind(p); err != nil {
+		return &Err{Message: "Error when binding JSON"}
+	}
+	if err := c.Validate(p); err != nil {
+		return &Err{Message: err.Error()}
+	}
+	return nil
+}
+
+func (p Profile) toTaxpayer(now time.Time) *db.Taxpayer {
+	return &db.Taxpayer{
+		Id:               p.Id,
+		Name:             p.Name,
+		MaritalStatus:    p.MaritalStatus,
+		Children:         p.Children,
+		Disabled:         p.Disabled,
+		ParentsSupported: p.ParentsSupported,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+}
+
+func (h *Handler) CreateHandler(c echo.Context) error {
+	p := Profile{}
+	if err := validateInput(c, &p); err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+	}
+	if p.Id == "" {
+		return c.JSON(http.StatusBadRequest, Err{Message: "Taxpayer id is required"})
+	}
+	taxpayer := p.toTaxpayer(time.Now())
+	err := taxpayer.Insert(c.Request().Context(), h.DB)
+	if errors.Is(err, db.ErrTaxpayerExists) {
+		return c.JSON(http.StatusConflict, Err{Message: "Taxpayer already exists"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+	}
+	c.Response().Header().Set(echo.HeaderLocation, "/taxpayers/"+taxpayer.Id)
+	return c.JSON(http.StatusCreated, taxpayer)
+}
+
+func (h *Handler) ListHandler(c echo.Context) error {
+	taxpayers, err := db.SearchAllTaxpayer(c.Request().Context(), h.DB)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+	}
+	return c.JSON(http.StatusOK, taxpayers)
+}
+
+func (h *Handler) GetHandler(c echo.Context) error {
+	taxpayer, err := db.SearchTaxpayerById(c.Request().Context(), h.DB, c.Param("id"))
+	if errors.Is(err, db.ErrNotFound) {
+		return c.JSON(http.StatusNotFound, Err{Message: "Taxpayer not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+	}
+	return c.JSON(http.StatusOK, taxpayer)
+}
+
+func (h *Handler) UpdateHandler(c echo.Context) error {
+	p := Profile{}
+	if err := validateInput(c, &p); err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: err.Error()})
+	}
+	p.Id = c.Param("id")
+	taxpayer := p.toTaxpayer(time.Now())
+	err := taxpayer.Update(c.Request().Context(), h.DB)
+	if errors.Is(err, db.ErrNotFound) {
+		return c.JSON(http.StatusNotFound, Err{Message: "Taxpayer not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+	}
+	return c.JSON(http.StatusOK, taxpayer)
+}
+
+func (h *Handler) DeleteHandler(c echo.Context) error {
+	deleted, err := db.DeleteTaxpayer(c.Request().Context(), h.DB, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+	}
+	if !deleted {
+		return c.JSON(http.StatusNotFound, Err{Message: "Taxpayer not found"})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) Register(g *echo.Group) {
+	g.Use(middleware.BasicAuth(mw.Authenticate()))
+	g.POST("", h.CreateHandler)
+	g.GET("", h.ListHandler)
+	g.GET("/:id", h.GetHandler)
+	g.PUT("/:id", h.UpdateHandler)
+	g.DELETE("/:id", h.DeleteHandler)
+}
@@ -0,0 +1,281 @@
+package taxpayer
+
+import (
+	"database/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Rachatapon1994/assessment-tax/config"
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	insertTaxpayerSql = "INSERT INTO taxpayer (id, name, marital_status, children, disabled, parents_supported, created_at, updated_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8) ON CONFLICT (id) DO NOTHING"
+	updateTaxpayerSql = "UPDATE taxpayer SET name = $1, marital_status = $2, children = $3, disabled = $4, parents_supported = $5, updated_at = $6 WHERE id = $7 RETURNING created_at"
+	selectTaxpayerSql = "SELECT id, name, marital_status, children, disabled, parents_supported, created_at, updated_at FROM taxpayer WHERE id = $1"
+	deleteTaxpayerSql = "DELETE FROM taxpayer WHERE id = $1"
+	taxpayerColumns   = []string{"id", "name", "marital_status", "children", "disabled", "parents_supported", "created_at", "updated_at"}
+)
+
+type mockHandlerContext struct {
+	c echo.Context
+	r *httptest.ResponseRecorder
+}
+
+func mockTaxpayerContext(method string, id string, body string) mockHandlerContext {
+	e := echo.New()
+	e.Validator = &config.CustomValidator{Validator: validator.New(validator.WithRequiredStructEnabled())}
+	req := httptest.NewRequest(method, "/taxpayers/"+id, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	if id != "" {
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+	}
+	return mockHandlerContext{c, rec}
+}
+
+func mockTaxpayerDb(t *testing.T, setup func(mock sqlmock.Sqlmock)) (*sql.DB, sqlmock.Sqlmock) {
+	DB, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	setup(mock)
+	return DB, mock
+}
+
+func TestHandler_CreateHandler(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name               string
+		body               string
+		setup              func(mock sqlmock.Sqlmock)
+		wantResponseStatus int
+	}{
+		{"Should return status 400 when marital status is invalid", `{"id":"T1","maritalStatus":"divorced"}`, func(mock sqlmock.Sqlmock) {}, 400},
+		{"Should return status 400 when parents supported is more than 4", `{"id":"T1","maritalStatus":"single","parentsSupported":5}`, func(mock sqlmock.Sqlmock) {}, 400},
+		{"Should return status 400 when id is empty", `{"maritalStatus":"single"}`, func(mock sqlmock.Sqlmock) {}, 400},
+		{"Should return status 409 when taxpayer already exists", `{"id":"T1","maritalStatus":"single"}`, func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(insertTaxpayerSql).WithArgs("T1", "", "single", 0, false, 0, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 0))
+		}, 409},
+		{"Should return status 500 when insert fails", `{"id":"T1","maritalStatus":"single"}`, func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(insertTaxpayerSql).WithArgs("T1", "", "single", 0, false, 0, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnError(sql.ErrConnDone)
+		}, 500},
+		{"Should return status 201 when taxpayer is created", `{"id":"T1","name":"Somchai","maritalStatus":"married","children":2,"parentsSupported":1}`, func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(insertTaxpayerSql).WithArgs("T1", "Somchai", "married", 2, false, 1, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		}, 201},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DB, mock := mockTaxpayerDb(t, tt.setup)
+			defer DB.Close()
+			c := mockTaxpayerContext(http.MethodPost, "", tt.body)
+			h := &Handler{DB: DB}
+
+			if err := h.CreateHandler(c.c); err != nil {
+				t.Errorf("Handler.CreateHandler() error = %v", err)
+			}
+			if c.r.Code != tt.wantResponseStatus {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseStatus, c.r.Code)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestHandler_GetHandler(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name               string
+		setup              func(mock sqlmock.Sqlmock)
+		wantResponseBody   string
+		wantResponseStatus int
+	}{
+		{"Should return status 404 when taxpayer does not exist", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectTaxpayerSql).WithArgs("T1").WillReturnError(sql.ErrNoRows)
+		}, "{\"message\":\"Taxpayer not found\"}\n", 404},
+		{"Should return taxpayer profile", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectTaxpayerSql).WithArgs("T1").WillReturnRows(mock.NewRows(taxpayerColumns).AddRow("T1", "", "single", 0, true, 0, now, now))
+		}, "{\"id\":\"T1\",\"maritalStatus\":\"single\",\"children\":0,\"disabled\":true,\"parentsSupported\":0,\"createdAt\":\"2024-05-01T10:00:00Z\",\"updatedAt\":\"2024-05-01T10:00:00Z\"}\n", 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DB, _ := mockTaxpayerDb(t, tt.setup)
+			defer DB.Close()
+			c := mockTaxpayerContext(http.MethodGet, "T1", "")
+			h := &Handler{DB: DB}
+
+			if err := h.GetHandler(c.c); err != nil {
+				t.Errorf("Handler.GetHandler() error = %v", err)
+			}
+			if got := c.r.Body.String(); got != tt.wantResponseBody {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseBody, got)
+			}
+			if c.r.Code != tt.wantResponseStatus {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseStatus, c.r.Code)
+			}
+		})
+	}
+}
+
+func TestHandler_ListHandler(t *testing.T) {
+	t.Parallel()
+	DB, mock := mockTaxpayerDb(t, func(mock sqlmock.Sqlmock) {
+		now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+		mock.ExpectQuery("SELECT id, name, marital_status, children, disabled, parents_supported, created_at, updated_at FROM taxpayer ORDER BY id").
+			WillReturnRows(mock.NewRows(taxpayerColumns).AddRow("T1", "", "single", 0, false, 0, now, now).AddRow("T2", "", "married", 1, false, 0, now, now))
+	})
+	defer DB.Close()
+	c := mockTaxpayerContext(http.MethodGet, "", "")
+	h := &Handler{DB: DB}
+
+	t.Run("Should return all taxpayer profiles", func(t *testing.T) {
+		if err := h.ListHandler(c.c); err != nil {
+			t.Errorf("Handler.ListHandler() error = %v", err)
+		}
+		if c.r.Code != http.StatusOK {
+			t.Errorf("expected (%v), got (%v)", http.StatusOK, c.r.Code)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestHandler_UpdateHandler(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name               string
+		body               string
+		setup              func(mock sqlmock.Sqlmock)
+		wantResponseStatus int
+	}{
+		{"Should return status 400 when children is negative", `{"maritalStatus":"single","children":-1}`, func(mock sqlmock.Sqlmock) {}, 400},
+		{"Should return status 404 when taxpayer does not exist", `{"maritalStatus":"single"}`, func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(updateTaxpayerSql).WithArgs("", "single", 0, false, 0, sqlmock.AnyArg(), "T1").WillReturnError(sql.ErrNoRows)
+		}, 404},
+		{"Should use id from path when updating taxpayer", `{"id":"T9","maritalStatus":"married","children":1}`, func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(updateTaxpayerSql).WithArgs("", "married", 1, false, 0, sqlmock.AnyArg(), "T1").
+				WillReturnRows(mock.NewRows([]string{"created_at"}).AddRow(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)))
+		}, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DB, mock := mockTaxpayerDb(t, tt.setup)
+			defer DB.Close()
+			c := mockTaxpayerContext(http.MethodPut, "T1", tt.body)
+			h := &Handler{DB: DB}
+
+			if err := h.UpdateHandler(c.c); err != nil {
+				t.Errorf("Handler.UpdateHandler() error = %v", err)
+			}
+			if c.r.Code != tt.wantResponseStatus {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseStatus, c.r.Code)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestHandler_DeleteHandler(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name               string
+		setup              func(mock sqlmock.Sqlmock)
+		wantResponseStatus int
+	}{
+		{"Should return status 404 when taxpayer does not exist", func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(deleteTaxpayerSql).WithArgs("T1").WillReturnResult(sqlmock.NewResult(0, 0))
+		}, 404},
+		{"Should return status 500 when delete fails", func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(deleteTaxpayerSql).WithArgs("T1").WillReturnError(sql.ErrConnDone)
+		}, 500},
+		{"Should return status 204 when taxpayer is deleted", func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(deleteTaxpayerSql).WithArgs("T1").WillReturnResult(sqlmock.NewResult(0, 1))
+		}, 204},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DB, _ := mockTaxpayerDb(t, tt.setup)
+			defer DB.Close()
+			c := mockTaxpayerContext(http.MethodDelete, "T1", "")
+			h := &Handler{DB: DB}
+
+			if err := h.DeleteHandler(c.c); err != nil {
+				t.Errorf("Handler.DeleteHandler() error = %v", err)
+			}
+			if c.r.Code != tt.wantResponseStatus {
+				t.Errorf("expected (%v), got (%v)", tt.wantResponseStatus, c.r.Code)
+			}
+		})
+	}
+}
+
+func TestHandler_Register(t *testing.T) {
+	os.Setenv("ADMIN_USERNAME", "admin")
+	os.Setenv("ADMIN_PASSWORD", "secret")
+	tests := []struct {
+		name   string
+		method string
+		path   string
+	}{
+		{"Should return status 401 when creating taxpayer without credentials", http.MethodPost, "/taxpayers"},
+		{"Should return status 401 when listing taxpayers without credentials", http.MethodGet, "/taxpayers"},
+		{"Should return status 401 when getting taxpayer without credentials", http.MethodGet, "/taxpayers/T1"},
+		{"Should return status 401 when updating taxpayer without credentials", http.MethodPut, "/taxpayers/T1"},
+		{"Should return status 401 when deleting taxpayer without credentials", http.MethodDelete, "/taxpayers/T1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			DB, mock := mockTaxpayerDb(t, func(mock sqlmock.Sqlmock) {})
+			defer DB.Close()
+			e := echo.New()
+			h := &Handler{DB: DB}
+			h.Register(e.Group("/taxpayers"))
+			rec := httptest.NewRecorder()
+
+			e.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, strings.NewReader("{}")))
+
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("expected (%v), got (%v)", http.StatusUnauthorized, rec.Code)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unexpected database access: %v", err)
+			}
+		})
+	}
+
+	t.Run("Should serve taxpayer when credentials are valid", func(t *testing.T) {
+		DB, mock := mockTaxpayerDb(t, func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(selectTaxpayerSql).WithArgs("T1").WillReturnRows(sqlmock.NewRows(taxpayerColumns).
+				AddRow("T1", "Somchai", "single", 0, false, 0, time.Now(), time.Now()))
+		})
+		defer DB.Close()
+		e := echo.New()
+		h := &Handler{DB: DB}
+		h.Register(e.Group("/taxpayers"))
+		req := httptest.NewRequest(http.MethodGet, "/taxpayers/T1", nil)
+		req.SetBasicAuth("admin", "secret")
+		rec := httptest.NewRecorder()
+
+		e.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected (%v), got (%v)", http.StatusOK, rec.Code)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
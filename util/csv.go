@@ -1,35 +1,196 @@
 package util
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/csv"
 	"errors"
 	"fmt"
+	"golang.org/x/text/encoding/charmap"
+	"io"
 	"mime/multipart"
+	"slices"
 	"strings"
+	"unicode/utf8"
 )
 
-func ReadCsvFile(fileHeader *multipart.FileHeader, csvHeader []string) ([][]string, error) {
+var (
+	UTF8          = "utf-8"
+	WINDOWS874    = "windows-874"
+	TIS620        = "tis-620"
+	CSVDELIMITERS = []rune{',', ';', '\t', '|'}
+	CSVPEEKSIZE   = 4096
+	utf8BOM       = []byte{0xEF, 0xBB, 0xBF}
+)
+
+type CsvSchema struct {
+	Required []string
+	Optional []string
+}
+
+type CsvOptions struct {
+	Delimiter rune
+	Encoding  string
+}
+
+type CsvRecord struct {
+	Line   int
+	Fields []string
+}
+
+type CsvReader struct {
+	Header []string
+	next   func() (CsvRecord, error)
+	close  func() error
+}
+
+func (s CsvSchema) Validate(header []string) error {
+	seen := make(map[string]bool)
+	for _, column := range header {
+		if !slices.Contains(s.Required, column) && !slices.Contains(s.Optional, column) {
+			return errors.New(fmt.Sprintf("CSV header contains unknown column : %v", column))
+		}
+		if seen[column] {
+			return errors.New(fmt.Sprintf("CSV header contains duplicate column : %v", column))
+		}
+		seen[column] = true
+	}
+	for _, column := range s.Required {
+		if !seen[column] {
+			return errors.New(fmt.Sprintf("CSV header is missing required column : %v", column))
+		}
+	}
+	return nil
+}
+
+func OpenCsvFile(fileHeader *multipart.FileHeader) (multipart.File, error) {
 	file, err := fileHeader.Open()
 	if err != nil {
 		return nil, errors.New(fmt.Sprintf("Error while opening CSV file : %v", err))
 	}
-	defer file.Close()
-	records, err := csv.NewReader(file).ReadAll()
-	if err != nil {
+	return file, nil
+}
+
+func ParseCsvOptions(delimiter string, encoding string) (CsvOptions, error) {
+	options := CsvOptions{}
+	switch delimiter {
+	case "":
+	case "tab", "\t":
+		options.Delimiter = '\t'
+	default:
+		runes := []rune(delimiter)
+		if len(runes) != 1 || !slices.Contains(CSVDELIMITERS, runes[0]) {
+			return CsvOptions{}, errors.New("Delimiter must be one of , ; | tab")
+		}
+		options.Delimiter = runes[0]
+	}
+	switch encoding = strings.ToLower(encoding); encoding {
+	case "", UTF8:
+		options.Encoding = encoding
+	case WINDOWS874, TIS620:
+		options.Encoding = WINDOWS874
+	default:
+		return CsvOptions{}, errors.New(fmt.Sprintf("Encoding must be one of %v, %v, %v", UTF8, WINDOWS874, TIS620))
+	}
+	return options, nil
+}
+
+func isUtf8(content []byte, complete bool) bool {
+	for len(content) > 0 {
+		r, size := utf8.DecodeRune(content)
+		if r == utf8.RuneError && size == 1 {
+			return !complete && !utf8.FullRune(content)
+		}
+		content = content[size:]
+	}
+	return true
+}
+
+func detectDelimiter(content string) rune {
+	firstLine, _, _ := strings.Cut(content, "\n")
+	counts := make(map[rune]int)
+	quoted := false
+	for _, r := range firstLine {
+		if r == '"' {
+			quoted = !quoted
+		} else if !quoted && slices.Contains(CSVDELIMITERS, r) {
+			counts[r]++
+		}
+	}
+	result := ','
+	for _, delimiter := range CSVDELIMITERS {
+		if counts[delimiter] > counts[result] {
+			result = delimiter
+		}
+	}
+	return result
+}
+
+func NewCsvReader(reader io.Reader, schema CsvSchema, strict bool, options CsvOptions) (*CsvReader, error) {
+	buffered := bufio.NewReaderSize(reader, CSVPEEKSIZE)
+	peek, err := buffered.Peek(CSVPEEKSIZE)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
 		return nil, errors.New(fmt.Sprintf("Error while reading CSV file : %v", err))
 	}
+	complete := err == io.EOF
+	if bytes.HasPrefix(peek, utf8BOM) {
+		buffered.Discard(len(utf8BOM))
+		peek = peek[len(utf8BOM):]
+	}
+
+	encoding := options.Encoding
+	if encoding == "" {
+		encoding = UTF8
+		if !isUtf8(peek, complete) {
+			encoding = WINDOWS874
+		}
+	}
+	var decoded io.Reader = buffered
+	sample := string(peek)
+	if encoding == WINDOWS874 {
+		decoder := charmap.Windows874.NewDecoder()
+		decoded = decoder.Reader(buffered)
+		sample, _ = decoder.String(string(peek))
+	}
 
-	bodyRecord := make([][]string, 0)
-	for i, line := range records {
-		if i == 0 {
-			for k, column := range line {
-				if column != csvHeader[k] {
-					return nil, errors.New(fmt.Sprintf("CSV header doesn't matches with validator : %v", strings.Join(csvHeader, ", ")))
-				}
-			}
-		} else {
-			bodyRecord = append(bodyRecord, line)
+	csvReader := csv.NewReader(decoded)
+	csvReader.Comma = options.Delimiter
+	if csvReader.Comma == 0 {
+		csvReader.Comma = detectDelimiter(sample)
+	}
+	if !strict {
+		csvReader.FieldsPerRecord = -1
+	}
+	header, err := csvReader.Read()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error while reading CSV file : %v", err))
+	}
+	for i, column := range header {
+		header[i] = strings.TrimSpace(column)
+	}
+	if err := schema.Validate(header); err != nil {
+		return nil, err
+	}
+	return &CsvReader{Header: header, next: func() (CsvRecord, error) {
+		line, err := csvReader.Read()
+		if err == io.EOF {
+			return CsvRecord{}, err
+		}
+		if err != nil {
+			return CsvRecord{}, errors.New(fmt.Sprintf("Error while reading CSV file : %v", err))
 		}
+		lineNumber, _ := csvReader.FieldPos(0)
+		return CsvRecord{Line: lineNumber, Fields: line}, nil
+	}}, nil
+}
+
+func (r *CsvReader) Next() (CsvRecord, error) {
+	return r.next()
+}
+
+func (r *CsvReader) Close() error {
+	if r.close == nil {
+		return nil
 	}
-	return bodyRecord, nil
+	return r.close()
 }
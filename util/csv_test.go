@@ -5,6 +5,8 @@ import (
 	"github.com/Rachatapon1994/assessment-tax/config"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
+	"golang.org/x/text/encoding/charmap"
+	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
@@ -12,17 +14,32 @@ import (
 	"testing"
 )
 
-var CSVHEADER = []string{"totalIncome", "wht", "donation"}
+var CSVSCHEMA = CsvSchema{Required: []string{"totalIncome", "wht"}, Optional: []string{"donation", "k-receipt"}}
 
 type mockHandlerContext struct {
 	c echo.Context
 	r *httptest.ResponseRecorder
 }
 
-func MockCalculationCsvHandler(c echo.Context) ([][]string, error) {
+func mockReadCsvFile(c echo.Context, strict bool) ([]CsvRecord, error) {
 	fileForm, _ := c.FormFile("taxFile")
-	csvBody, err := ReadCsvFile(fileForm, CSVHEADER)
-	return csvBody, err
+	file, _ := OpenCsvFile(fileForm)
+	defer file.Close()
+	reader, err := NewCsvReader(file, CSVSCHEMA, strict, CsvOptions{})
+	if err != nil {
+		return nil, err
+	}
+	records := make([]CsvRecord, 0)
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
 }
 
 func mockContextMultipart(fieldName string, fileName string, fileContent string) mockHandlerContext {
@@ -44,35 +61,140 @@ func mockContextMultipart(fieldName string, fileName string, fileContent string)
 	}
 }
 
-func TestReadCsvFile(t *testing.T) {
-
+func TestCsvReader(t *testing.T) {
 	mockContextMultipartCsvSuccess := mockContextMultipart("taxFile", "taxes.csv", "totalIncome,wht,donation\n500000,0,0\n600000,40000,20000\n750000,50000,15000\n")
 	mockContextMultipartCsvErrorWhenCsvIsIncorrectFormat := mockContextMultipart("taxFile", "taxes.csv", "totalIncome,wht,donation\n5000000,0\n600000,40000,20000\n750000,50000,15000\n")
 	mockContextMultipartCsvErrorWhenCsvHeaderIsInvalid := mockContextMultipart("taxFile", "taxes.csv", "totalIncome,wht,donation1\n500000,0,0\n600000,40000,20000\n750000,50000,15000\n")
+	mockContextMultipartCsvWithShortRow := mockContextMultipart("taxFile", "taxes.csv", "totalIncome,wht,donation\n500000,0,0\n5000000,0\n")
 
 	type args struct {
-		c         mockHandlerContext
-		csvHeader []string
+		c      mockHandlerContext
+		strict bool
 	}
 	tests := []struct {
 		name    string
 		args    args
-		want    [][]string
+		want    []CsvRecord
 		wantErr bool
 	}{
-		{"Should return response when csv is correct format", args{csvHeader: CSVHEADER, c: mockContextMultipartCsvSuccess}, [][]string{{"500000", "0", "0"}, {"600000", "40000", "20000"}, {"750000", "50000", "15000"}}, false},
-		{"Should return error response when csv is incorrect format", args{csvHeader: CSVHEADER, c: mockContextMultipartCsvErrorWhenCsvIsIncorrectFormat}, nil, true},
-		{"Should return error response when csv header is invalid", args{csvHeader: CSVHEADER, c: mockContextMultipartCsvErrorWhenCsvHeaderIsInvalid}, nil, true},
+		{"Should return response when csv is correct format", args{c: mockContextMultipartCsvSuccess, strict: true}, []CsvRecord{{2, []string{"500000", "0", "0"}}, {3, []string{"600000", "40000", "20000"}}, {4, []string{"750000", "50000", "15000"}}}, false},
+		{"Should return error response when csv is incorrect format", args{c: mockContextMultipartCsvErrorWhenCsvIsIncorrectFormat, strict: true}, nil, true},
+		{"Should return error response when csv header is invalid", args{c: mockContextMultipartCsvErrorWhenCsvHeaderIsInvalid, strict: true}, nil, true},
+		{"Should return records with line number and keep rows with wrong number of fields", args{c: mockContextMultipartCsvWithShortRow}, []CsvRecord{{2, []string{"500000", "0", "0"}}, {3, []string{"5000000", "0"}}}, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := MockCalculationCsvHandler(tt.args.c.c)
+			got, err := mockReadCsvFile(tt.args.c.c, tt.args.strict)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("ReadCsvFile() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("CsvReader.Next() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CsvReader.Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCsvSchema_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  []string
+		wantErr string
+	}{
+		{"Should accept required and optional columns in any order", []string{"k-receipt", "wht", "totalIncome", "donation"}, ""},
+		{"Should accept only required columns", []string{"totalIncome", "wht"}, ""},
+		{"Should reject unknown column", []string{"totalIncome", "wht", "donation1"}, "CSV header contains unknown column : donation1"},
+		{"Should reject duplicate column", []string{"totalIncome", "wht", "wht"}, "CSV header contains duplicate column : wht"},
+		{"Should reject missing required column", []string{"totalIncome", "donation"}, "CSV header is missing required column : wht"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CSVSCHEMA.Validate(tt.header)
+			if (err == nil && tt.wantErr != "") || (err != nil && err.Error() != tt.wantErr) {
+				t.Errorf("CsvSchema.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func readAllCsv(t *testing.T, content []byte, options CsvOptions) ([]string, []CsvRecord, error) {
+	reader, err := NewCsvReader(bytes.NewReader(content), CSVSCHEMA, false, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	records := make([]CsvRecord, 0)
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return reader.Header, records, nil
+		}
+		if err != nil {
+			t.Fatalf("CsvReader.Next() error = %v", err)
+		}
+		records = append(records, record)
+	}
+}
+
+func TestNewCsvReader_detection(t *testing.T) {
+	thai, _ := charmap.Windows874.NewEncoder().String("totalIncome;wht;donation\n500000;0;\"ภาษี\"\n")
+	tests := []struct {
+		name       string
+		content    []byte
+		options    CsvOptions
+		wantHeader []string
+		want       []CsvRecord
+	}{
+		{"Should skip UTF-8 BOM", append([]byte{0xEF, 0xBB, 0xBF}, []byte("totalIncome,wht\n500000,0\n")...), CsvOptions{}, []string{"totalIncome", "wht"}, []CsvRecord{{2, []string{"500000", "0"}}}},
+		{"Should detect semicolon delimiter and keep quoted thousands separator", []byte("totalIncome ; wht\n\"1,200,000.00\";0\n"), CsvOptions{}, []string{"totalIncome", "wht"}, []CsvRecord{{2, []string{"1,200,000.00", "0"}}}},
+		{"Should detect tab delimiter", []byte("totalIncome\twht\n500000\t0\n"), CsvOptions{}, []string{"totalIncome", "wht"}, []CsvRecord{{2, []string{"500000", "0"}}}},
+		{"Should decode Windows-874 content", []byte(thai), CsvOptions{}, []string{"totalIncome", "wht", "donation"}, []CsvRecord{{2, []string{"500000", "0", "ภาษี"}}}},
+		{"Should use delimiter override", []byte("totalIncome|wht,x\n500000|0,1\n"), CsvOptions{Delimiter: '|'}, []string{"totalIncome", "wht,x"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, got, err := readAllCsv(t, tt.content, tt.options)
+			if tt.want == nil {
+				if err == nil {
+					t.Errorf("NewCsvReader() expected header error, got header %v", header)
+				}
 				return
 			}
+			if err != nil {
+				t.Fatalf("NewCsvReader() error = %v", err)
+			}
+			if !reflect.DeepEqual(header, tt.wantHeader) {
+				t.Errorf("NewCsvReader() header = %v, want %v", header, tt.wantHeader)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CsvReader.Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCsvOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		delimiter string
+		encoding  string
+		want      CsvOptions
+		wantErr   bool
+	}{
+		{"Should return auto detection when no override is given", "", "", CsvOptions{}, false},
+		{"Should accept tab delimiter and TIS-620 encoding", "tab", "TIS-620", CsvOptions{Delimiter: '\t', Encoding: WINDOWS874}, false},
+		{"Should accept semicolon delimiter", ";", "utf-8", CsvOptions{Delimiter: ';', Encoding: UTF8}, false},
+		{"Should reject unsupported delimiter", "::", "", CsvOptions{}, true},
+		{"Should reject unsupported encoding", "", "latin-1", CsvOptions{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCsvOptions(tt.delimiter, tt.encoding)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseCsvOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("ReadCsvFile() = %v, want %v", got, tt.want)
+				t.Errorf("ParseCsvOptions() = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -0,0 +1,99 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xuri/excelize/v2"
+	"io"
+	"strings"
+)
+
+var (
+	XLSXMAXCOMPRESSIONRATIO int64 = 100
+	XLSXMAXROWSIZE          int64 = 4 << 10
+)
+
+type XlsxLimits struct {
+	UnzipSize    int64
+	UnzipXMLSize int64
+}
+
+func NewXlsxLimits(maxUploadSize int64, maxRows int) XlsxLimits {
+	limits := XlsxLimits{UnzipSize: maxUploadSize * XLSXMAXCOMPRESSIONRATIO, UnzipXMLSize: int64(maxRows+1) * XLSXMAXROWSIZE}
+	if limits.UnzipXMLSize > limits.UnzipSize {
+		limits.UnzipXMLSize = limits.UnzipSize
+	}
+	return limits
+}
+
+func NewXlsxReader(reader io.Reader, schema CsvSchema, sheet string, limits XlsxLimits) (*CsvReader, error) {
+	file, err := excelize.OpenReader(reader, excelize.Options{UnzipSizeLimit: limits.UnzipSize, UnzipXMLSizeLimit: limits.UnzipXMLSize})
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error while reading XLSX file : %v", err))
+	}
+	if sheet == "" {
+		sheets := file.GetSheetList()
+		if len(sheets) == 0 {
+			file.Close()
+			return nil, errors.New("XLSX file does not contain any sheet")
+		}
+		sheet = sheets[0]
+	} else if index, _ := file.GetSheetIndex(sheet); index < 0 {
+		file.Close()
+		return nil, errors.New(fmt.Sprintf("XLSX file does not contain sheet : %v", sheet))
+	}
+	rows, err := file.Rows(sheet)
+	if err != nil {
+		file.Close()
+		return nil, errors.New(fmt.Sprintf("Error while reading XLSX file : %v", err))
+	}
+	closeFile := func() error {
+		rows.Close()
+		return file.Close()
+	}
+
+	line := 0
+	read := func() (CsvRecord, error) {
+		for rows.Next() {
+			line++
+			fields, err := rows.Columns(excelize.Options{RawCellValue: true})
+			if err != nil {
+				return CsvRecord{}, errors.New(fmt.Sprintf("Error while reading XLSX file : %v", err))
+			}
+			if strings.TrimSpace(strings.Join(fields, "")) != "" {
+				return CsvRecord{Line: line, Fields: fields}, nil
+			}
+		}
+		if err := rows.Error(); err != nil {
+			return CsvRecord{}, errors.New(fmt.Sprintf("Error while reading XLSX file : %v", err))
+		}
+		return CsvRecord{}, io.EOF
+	}
+
+	headerRecord, err := read()
+	if err != nil {
+		closeFile()
+		if err == io.EOF {
+			return nil, errors.New(fmt.Sprintf("Error while reading XLSX file : sheet %v is empty", sheet))
+		}
+		return nil, err
+	}
+	header := headerRecord.Fields
+	for i, column := range header {
+		header[i] = strings.TrimSpace(column)
+	}
+	if err := schema.Validate(header); err != nil {
+		closeFile()
+		return nil, err
+	}
+	return &CsvReader{Header: header, close: closeFile, next: func() (CsvRecord, error) {
+		record, err := read()
+		if err != nil {
+			return CsvRecord{}, err
+		}
+		for len(record.Fields) < len(header) {
+			record.Fields = append(record.Fields, "")
+		}
+		return record, nil
+	}}, nil
+}
@@ -0,0 +1,155 @@
+package util
+
+import (
+	"archive/zip"
+	"bytes"
+	"github.com/xuri/excelize/v2"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func mockXlsxFile(t *testing.T, sheets map[string][][]interface{}, order ...string) []byte {
+	file := excelize.NewFile()
+	defer file.Close()
+	for i, name := range order {
+		if i == 0 {
+			file.SetSheetName("Sheet1", name)
+		} else {
+			file.NewSheet(name)
+		}
+		for r, row := range sheets[name] {
+			cell, _ := excelize.CoordinatesToCellName(1, r+1)
+			if err := file.SetSheetRow(name, cell, &row); err != nil {
+				t.Fatalf("unable to write xlsx row: %v", err)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := file.Write(&buf); err != nil {
+		t.Fatalf("unable to write xlsx file: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func mockXlsxWithoutSheets(t *testing.T, content []byte) []byte {
+	source, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatalf("unable to read xlsx file: %v", err)
+	}
+	var buf bytes.Buffer
+	target := zip.NewWriter(&buf)
+	for _, entry := range source.File {
+		file, _ := entry.Open()
+		data, _ := io.ReadAll(file)
+		file.Close()
+		if entry.Name == "xl/workbook.xml" {
+			data = regexp.MustCompile(`<sheets>.*</sheets>`).ReplaceAll(data, []byte("<sheets></sheets>"))
+		}
+		writer, _ := target.Create(entry.Name)
+		writer.Write(data)
+	}
+	if err := target.Close(); err != nil {
+		t.Fatalf("unable to write xlsx file: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func readAllXlsx(content []byte, sheet string, limits XlsxLimits) ([]string, []CsvRecord, error) {
+	reader, err := NewXlsxReader(bytes.NewReader(content), CSVSCHEMA, sheet, limits)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+	records := make([]CsvRecord, 0)
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return reader.Header, records, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		records = append(records, record)
+	}
+}
+
+func TestNewXlsxReader(t *testing.T) {
+	t.Parallel()
+	content := mockXlsxFile(t, map[string][][]interface{}{
+		"summary": {{"note"}, {"ignored"}},
+		"taxes": {
+			{" totalIncome ", "wht", "donation"},
+			{500000, 0, 0},
+			{},
+			{750000.5, 5000},
+			{"abc", 0, 0, "extra"},
+		},
+		"empty": {},
+	}, "summary", "taxes", "empty")
+	limits := NewXlsxLimits(1<<20, 100)
+
+	tests := []struct {
+		name        string
+		content     []byte
+		sheet       string
+		limits      XlsxLimits
+		wantHeader  []string
+		wantRecords []CsvRecord
+		wantErr     string
+	}{
+		{"Should read named sheet with padded rows and blank rows skipped", content, "taxes", limits, []string{"totalIncome", "wht", "donation"}, []CsvRecord{
+			{Line: 2, Fields: []string{"500000", "0", "0"}},
+			{Line: 4, Fields: []string{"750000.5", "5000", ""}},
+			{Line: 5, Fields: []string{"abc", "0", "0", "extra"}},
+		}, ""},
+		{"Should validate header of first sheet when sheet is not set", content, "", limits, nil, nil, "CSV header contains unknown column : note"},
+		{"Should return error when sheet does not exist", content, "missing", limits, nil, nil, "XLSX file does not contain sheet : missing"},
+		{"Should return error when workbook has no sheet", mockXlsxWithoutSheets(t, content), "", limits, nil, nil, "XLSX file does not contain any sheet"},
+		{"Should return error when sheet is empty", content, "empty", limits, nil, nil, "Error while reading XLSX file : sheet empty is empty"},
+		{"Should return error when file is not xlsx", []byte("totalIncome,wht\n500000,0\n"), "", limits, nil, nil, "Error while reading XLSX file : zip: not a valid zip file"},
+		{"Should return error when unzipped file exceeds the size limit", content, "taxes", NewXlsxLimits(10, 100), nil, nil, "Error while reading XLSX file : unzip size exceeds the 1000 bytes limit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, records, err := readAllXlsx(tt.content, tt.sheet, tt.limits)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Errorf("NewXlsxReader() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewXlsxReader() error = %v", err)
+			}
+			if !reflect.DeepEqual(header, tt.wantHeader) {
+				t.Errorf("NewXlsxReader() header = %v, want %v", header, tt.wantHeader)
+			}
+			if !reflect.DeepEqual(records, tt.wantRecords) {
+				t.Errorf("NewXlsxReader() records = %v, want %v", records, tt.wantRecords)
+			}
+		})
+	}
+}
+
+func TestNewXlsxLimits(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name          string
+		maxUploadSize int64
+		maxRows       int
+		want          XlsxLimits
+	}{
+		{"Should bound unzipped size by upload size and worksheet size by rows", 1 << 20, 100, XlsxLimits{UnzipSize: 100 << 20, UnzipXMLSize: 101 * 4 << 10}},
+		{"Should not allow worksheet size above unzipped size", 1 << 10, 1000, XlsxLimits{UnzipSize: 100 << 10, UnzipXMLSize: 100 << 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewXlsxLimits(tt.maxUploadSize, tt.maxRows); got != tt.want {
+				t.Errorf("NewXlsxLimits() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}